@@ -93,4 +93,20 @@ func (c *Client) Discovery() rovyapi.DiscoveryAPI {
 	return (*DiscoveryClient)(c)
 }
 
+func (c *Client) Stats() rovyapi.StatsAPI {
+	return (*StatsClient)(c)
+}
+
+func (c *Client) Logging() rovyapi.LoggingAPI {
+	return (*LoggingClient)(c)
+}
+
+func (c *Client) Policy() rovyapi.PolicyAPI {
+	return (*PolicyClient)(c)
+}
+
+func (c *Client) Petname() rovyapi.PetnameAPI {
+	return (*PetnameClient)(c)
+}
+
 var _ rovyapi.NodeAPI = &Client{}
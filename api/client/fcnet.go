@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 
@@ -35,15 +36,183 @@ func (c *FcnetClient) Start(tunfd *os.File) error {
 	if err != nil {
 		return fmt.Errorf("params: %s", err)
 	}
-	// TODO: check for status code
-	_, err = c.http.Post("http://unix/v0/fcnet/start", "application/json", bytes.NewReader(body))
+	res, err := c.http.Post("http://unix/v0/fcnet/start", "application/json", bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("http: %s", err)
 	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http: %s", res.Status)
+	}
 
 	return nil
 }
 
+func (c *FcnetClient) Stop() error {
+	res, err := c.http.Post("http://unix/v0/fcnet/stop", "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("http: %s", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http: %s", res.Status)
+	}
+	return nil
+}
+
+func (c *FcnetClient) Status() (fs rovyapi.FcnetStatus, err error) {
+	res, err := c.http.Get("http://unix/v0/fcnet/status")
+	if err != nil {
+		return fs, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fs, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&fs); err != nil {
+		return fs, err
+	}
+	return fs, err
+}
+
+func (c *FcnetClient) Firewall() (fw rovyapi.FcnetFirewall, err error) {
+	res, err := c.http.Get("http://unix/v0/fcnet/firewall")
+	if err != nil {
+		return fw, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fw, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&fw); err != nil {
+		return fw, err
+	}
+	return fw, err
+}
+
+func (c *FcnetClient) SetFirewall(params rovyapi.FcnetFirewall) (fw rovyapi.FcnetFirewall, err error) {
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return fw, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/fcnet/firewall/set", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return fw, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fw, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&fw); err != nil {
+		return fw, err
+	}
+	return fw, err
+}
+
+func (c *FcnetClient) DNS() (fd rovyapi.FcnetDNS, err error) {
+	res, err := c.http.Get("http://unix/v0/fcnet/dns")
+	if err != nil {
+		return fd, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fd, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&fd); err != nil {
+		return fd, err
+	}
+	return fd, err
+}
+
+func (c *FcnetClient) SetDNS(params rovyapi.FcnetDNS) (fd rovyapi.FcnetDNS, err error) {
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return fd, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/fcnet/dns/set", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return fd, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fd, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&fd); err != nil {
+		return fd, err
+	}
+	return fd, err
+}
+
+func (c *FcnetClient) IPv4() (f4 rovyapi.FcnetIPv4, err error) {
+	res, err := c.http.Get("http://unix/v0/fcnet/ipv4")
+	if err != nil {
+		return f4, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return f4, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&f4); err != nil {
+		return f4, err
+	}
+	return f4, err
+}
+
+func (c *FcnetClient) SetIPv4(params rovyapi.FcnetIPv4) (f4 rovyapi.FcnetIPv4, err error) {
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return f4, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/fcnet/ipv4/set", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return f4, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return f4, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&f4); err != nil {
+		return f4, err
+	}
+	return f4, err
+}
+
+func (c *FcnetClient) Exit() (fe rovyapi.FcnetExit, err error) {
+	res, err := c.http.Get("http://unix/v0/fcnet/exit")
+	if err != nil {
+		return fe, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fe, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&fe); err != nil {
+		return fe, err
+	}
+	return fe, err
+}
+
+func (c *FcnetClient) SetExit(params rovyapi.FcnetExit) (fe rovyapi.FcnetExit, err error) {
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return fe, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/fcnet/exit/set", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return fe, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fe, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&fe); err != nil {
+		return fe, err
+	}
+	return fe, err
+}
+
 func (c *FcnetClient) NodeAPI() rovyapi.NodeAPI {
 	return (*Client)(c)
 }
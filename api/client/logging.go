@@ -0,0 +1,47 @@
+package rovyapic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	rovyapi "go.rovy.net/api"
+)
+
+type LoggingClient Client
+
+func (c *LoggingClient) Levels() (levels []rovyapi.LogLevel, err error) {
+	res, err := c.http.Get("http://unix/v0/logging/levels")
+	if err != nil {
+		return levels, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return levels, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&levels); err != nil {
+		return levels, err
+	}
+	return levels, err
+}
+
+func (c *LoggingClient) SetLevel(params rovyapi.LogLevel) (ll rovyapi.LogLevel, err error) {
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return ll, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/logging/setlevel", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return ll, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return ll, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&ll); err != nil {
+		return ll, err
+	}
+	return ll, err
+}
@@ -12,8 +12,19 @@ import (
 
 type PeerClient Client
 
-func (c *PeerClient) Status() (rovyapi.PeerStatus, error) {
-	return rovyapi.PeerStatus{}, nil
+func (c *PeerClient) Status() (ps rovyapi.PeerStatus, err error) {
+	res, err := c.http.Get("http://unix/v0/peer/status")
+	if err != nil {
+		return ps, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return ps, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&ps); err != nil {
+		return ps, err
+	}
+	return ps, err
 }
 
 func (c *PeerClient) Listen(ma rovy.Multiaddr) (pl rovyapi.PeerListener, err error) {
@@ -37,8 +48,67 @@ func (c *PeerClient) Listen(ma rovy.Multiaddr) (pl rovyapi.PeerListener, err err
 	return pl, err
 }
 
-func (c *PeerClient) Connect(ma rovy.Multiaddr) (rovyapi.PeerInfo, error) {
-	return rovyapi.PeerInfo{}, nil
+func (c *PeerClient) Close(ma rovy.Multiaddr) (pl rovyapi.PeerListener, err error) {
+	params := struct{ Addr rovy.Multiaddr }{ma}
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return pl, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/peer/close", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return pl, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return pl, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&pl); err != nil {
+		return pl, err
+	}
+	return pl, err
+}
+
+func (c *PeerClient) Connect(ma rovy.Multiaddr) (pi rovyapi.PeerInfo, err error) {
+	params := struct{ Addr rovy.Multiaddr }{ma}
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return pi, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/peer/connect", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return pi, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return pi, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&pi); err != nil {
+		return pi, err
+	}
+	return pi, err
+}
+
+func (c *PeerClient) Disconnect(ma rovy.Multiaddr) (pi rovyapi.PeerInfo, err error) {
+	params := struct{ Addr rovy.Multiaddr }{ma}
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return pi, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/peer/disconnect", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return pi, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return pi, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&pi); err != nil {
+		return pi, err
+	}
+	return pi, err
 }
 
 func (c *PeerClient) NodeAPI() rovyapi.NodeAPI {
@@ -0,0 +1,64 @@
+package rovyapic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	rovyapi "go.rovy.net/api"
+)
+
+type PetnameClient Client
+
+func (c *PetnameClient) List() (petnames []rovyapi.Petname, err error) {
+	res, err := c.http.Get("http://unix/v0/petname/list")
+	if err != nil {
+		return petnames, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return petnames, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&petnames); err != nil {
+		return petnames, err
+	}
+	return petnames, err
+}
+
+func (c *PetnameClient) Add(params rovyapi.Petname) (pn rovyapi.Petname, err error) {
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return pn, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/petname/add", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return pn, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return pn, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&pn); err != nil {
+		return pn, err
+	}
+	return pn, err
+}
+
+func (c *PetnameClient) Remove(name string) error {
+	params := struct{ Name string }{name}
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.http.Post("http://unix/v0/petname/remove", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http: %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package rovyapic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	rovyapi "go.rovy.net/api"
+)
+
+type PolicyClient Client
+
+func (c *PolicyClient) List() (policies []rovyapi.PeerPolicy, err error) {
+	res, err := c.http.Get("http://unix/v0/policy/list")
+	if err != nil {
+		return policies, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return policies, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&policies); err != nil {
+		return policies, err
+	}
+	return policies, err
+}
+
+func (c *PolicyClient) Set(params rovyapi.PeerPolicy) (pp rovyapi.PeerPolicy, err error) {
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return pp, err
+	}
+
+	res, err := c.http.Post("http://unix/v0/policy/set", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return pp, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return pp, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&pp); err != nil {
+		return pp, err
+	}
+	return pp, err
+}
+
+func (c *PolicyClient) Remove(name string) error {
+	params := struct{ Name string }{name}
+	reqbody, err := json.Marshal(&params)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.http.Post("http://unix/v0/policy/remove", "application/json", bytes.NewReader(reqbody))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http: %s", res.Status)
+	}
+	return nil
+}
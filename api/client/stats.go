@@ -0,0 +1,26 @@
+package rovyapic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	rovyapi "go.rovy.net/api"
+)
+
+type StatsClient Client
+
+func (c *StatsClient) Queues() (qs []rovyapi.QueueStats, err error) {
+	res, err := c.http.Get("http://unix/v0/stats/queues")
+	if err != nil {
+		return qs, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return qs, fmt.Errorf("http: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&qs); err != nil {
+		return qs, err
+	}
+	return qs, err
+}
@@ -25,7 +25,9 @@ func DefaultConfig() *Config {
 		Fcnet: Fcnet{
 			Enabled: true,
 			// Backend: "nm",
-			Ifname: "rovy0",
+			Ifname:     "rovy0",
+			AllowPorts: []string{"icmp"},
+			AllowPeers: []rovy.PeerID{},
 		},
 		Discovery: Discovery{
 			LinkLocal: LinkLocal{
@@ -48,16 +50,77 @@ type Config struct {
 	Peer      Peer
 	Fcnet     Fcnet
 	Discovery Discovery
+	Queues    map[string]int // queue name => size, e.g. lowerRecv = 4096
+	Metrics   Metrics
+	Logging   map[string]string // subsystem => level, e.g. session = "debug"
+	Policies  []Policy
 }
 
 type Peer struct {
 	Listen  []rovy.Multiaddr
 	Connect []rovy.Multiaddr
+	// UDPBackend is "std" or "io_uring", which falls back to "std" if it's unsupported.
+	UDPBackend string
 }
 
+// Fcnet's firewall drops unsolicited inbound packets.
+// AllowPorts lets them in by port, e.g. "tcp/22", "udp/53", or "icmp" for ping,
+// and AllowPeers lets in everything from the given peers.
+//
+// DNSUpstreams are the resolvers that fc00::1 forwards names outside of .rovy to,
+// e.g. "[2620:fe::fe]:53". Without them, only .rovy names can be resolved.
 type Fcnet struct {
-	Enabled bool
-	Ifname  string
+	Enabled      bool
+	Ifname       string
+	AllowPorts   []string
+	AllowPeers   []rovy.PeerID
+	DNSUpstreams []string
+	IPv4         FcnetIPv4
+	Exit         FcnetExit
+}
+
+// FcnetIPv4 carries IPv4 between trusted Peers, who advertise their addresses to each other.
+// Addr is this node's address on the TUN device, e.g. "100.64.0.1",
+// and Routes are the prefixes routed into the TUN device.
+// Exports are prefixes behind this node that the peers may reach,
+// optionally limited to ports, e.g. "192.168.1.0/24 tcp/80 udp/53".
+type FcnetIPv4 struct {
+	Addr    netip.Addr
+	Routes  []netip.Prefix
+	Exports []string
+	Peers   []rovy.PeerID
+}
+
+// FcnetExit carries IPv6 traffic between fc00::/8 and the Internet.
+// With Serve, this node is an exit for the Clients, and the kernel is expected
+// to forward and masquerade their traffic. Exits are the peers this node uses as exit,
+// in order of preference, and Routes the prefixes that go through the exit, e.g. "2000::/3".
+// Exits only carry traffic for global unicast addresses, so Routes have to be within 2000::/3.
+// The addresses of the connections to the exits must not be within Routes.
+// For IPv4, the exit exports "0.0.0.0/0" and the client routes it, see FcnetIPv4.
+type FcnetExit struct {
+	Serve   bool
+	Clients []rovy.PeerID
+	Exits   []rovy.PeerID
+	Routes  []netip.Prefix
+}
+
+// Policy decides which upper codecs and fcnet ports a group of peers may reach.
+// It applies to all peers if Peers is empty. Codecs are e.g. "fcnet" or "0x42004",
+// ports are e.g. "tcp/22", and "*" allows everything.
+// Codecs are opt-in: peers can reach every codec, unless a policy that applies
+// to them lists codecs, then they can only reach the codecs those policies allow.
+type Policy struct {
+	Name   string
+	Peers  []rovy.PeerID
+	Codecs []string
+	Ports  []string
+}
+
+// Metrics is always served at /metrics on the API socket.
+// Listen additionally serves it on a TCP address, e.g. "[::1]:9312".
+type Metrics struct {
+	Listen string
 }
 
 type Discovery struct {
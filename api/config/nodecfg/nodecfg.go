@@ -3,6 +3,8 @@ package rnodecfg
 import (
 	"fmt"
 	"log"
+	"net/netip"
+	"sort"
 	"time"
 
 	rovy "go.rovy.net"
@@ -18,6 +20,18 @@ type NodeConfig struct {
 }
 
 func (nc *NodeConfig) ConfigureAll(cfg *rconfig.Config, node *rnode.Node) error {
+	if err := nc.ConfigureLogging(cfg); err != nil {
+		return fmt.Errorf("error configuring logging: %s", err)
+	}
+
+	if err := node.SetUDPBackend(cfg.Peer.UDPBackend); err != nil {
+		return fmt.Errorf("error configuring peering: %s", err)
+	}
+
+	if err := nc.ConfigurePolicies(cfg); err != nil {
+		return fmt.Errorf("error configuring policies: %s", err)
+	}
+
 	if err := nc.ConfigurePeering(cfg); err != nil {
 		return fmt.Errorf("error configuring peering: %s", err)
 	}
@@ -33,6 +47,51 @@ func (nc *NodeConfig) ConfigureAll(cfg *rconfig.Config, node *rnode.Node) error
 	return nil
 }
 
+// ConfigurePolicies sets the policies before there's any traffic from peers.
+func (nc *NodeConfig) ConfigurePolicies(cfg *rconfig.Config) error {
+	for _, p := range cfg.Policies {
+		pp := rapi.PeerPolicy{Name: p.Name, Peers: p.Peers, Codecs: p.Codecs, Ports: p.Ports}
+		if _, err := nc.API.Policy().Set(pp); err != nil {
+			return fmt.Errorf("policy %s: %s", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// ConfigureQueues sets the sizes of the node's queues,
+// which has to happen before the node is started.
+func (nc *NodeConfig) ConfigureQueues(cfg *rconfig.Config, node *rnode.Node) error {
+	names := make([]string, 0, len(cfg.Queues))
+	for name := range cfg.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := node.SetQueueSize(name, cfg.Queues[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConfigureLogging sets the level of each subsystem listed in the config.
+func (nc *NodeConfig) ConfigureLogging(cfg *rconfig.Config) error {
+	subsystems := make([]string, 0, len(cfg.Logging))
+	for subsys := range cfg.Logging {
+		subsystems = append(subsystems, subsys)
+	}
+	sort.Strings(subsystems)
+
+	for _, subsys := range subsystems {
+		ll := rapi.LogLevel{Subsystem: subsys, Level: cfg.Logging[subsys]}
+		if _, err := nc.API.Logging().SetLevel(ll); err != nil {
+			return fmt.Errorf("%s: %s", subsys, err)
+		}
+	}
+	return nil
+}
+
 // TODO: do the actual configuration using api/client module
 func (nc *NodeConfig) ConfigurePeering(cfg *rconfig.Config) error {
 	for _, addr := range cfg.Peer.Listen {
@@ -41,34 +100,83 @@ func (nc *NodeConfig) ConfigurePeering(cfg *rconfig.Config) error {
 			return err
 		}
 	}
+	// connecting can take a while, and unreachable peers shouldn't hold up the rest
 	for _, addr := range cfg.Peer.Connect {
-		_, err := nc.API.Peer().Connect(addr)
-		if err != nil {
-			return err
-		}
+		go func(addr rovy.Multiaddr) {
+			pi, err := nc.API.Peer().Connect(addr)
+			if err != nil {
+				nc.Logger.Printf("failed to connect to %s: %s", addr, err)
+				return
+			}
+			if pi.Status != "ok" {
+				nc.Logger.Printf("failed to connect to %s: %s (%s)", addr, pi.Status, pi.Reason)
+			}
+		}(addr)
 	}
 	return nil
 }
 
 // TODO: make use of actual config
-// TODO: close our FD?
 func (nc *NodeConfig) ConfigureFcnet(cfg *rconfig.Config, node *rnode.Node) error {
 	if !cfg.Fcnet.Enabled {
 		return nil
 	}
 
+	v4 := rapi.FcnetIPv4{
+		Addr:    cfg.Fcnet.IPv4.Addr,
+		Routes:  cfg.Fcnet.IPv4.Routes,
+		Exports: cfg.Fcnet.IPv4.Exports,
+		Peers:   cfg.Fcnet.IPv4.Peers,
+	}
+	exit := rapi.FcnetExit{
+		Serve:   cfg.Fcnet.Exit.Serve,
+		Clients: cfg.Fcnet.Exit.Clients,
+		Exits:   cfg.Fcnet.Exit.Exits,
+		Routes:  cfg.Fcnet.Exit.Routes,
+	}
+	if err := nc.StartFcnet(cfg.Fcnet.Ifname, node.IPAddr(), v4, exit.Routes); err != nil {
+		return err
+	}
+
+	fw := rapi.FcnetFirewall{Ports: cfg.Fcnet.AllowPorts, Peers: cfg.Fcnet.AllowPeers}
+	if _, err := nc.API.Fcnet().SetFirewall(fw); err != nil {
+		return fmt.Errorf("api: firewall: %s", err)
+	}
+
+	if _, err := nc.API.Fcnet().SetDNS(rapi.FcnetDNS{Upstreams: cfg.Fcnet.DNSUpstreams}); err != nil {
+		return fmt.Errorf("api: dns: %s", err)
+	}
+
+	if _, err := nc.API.Fcnet().SetIPv4(v4); err != nil {
+		return fmt.Errorf("api: ipv4: %s", err)
+	}
+
+	if _, err := nc.API.Fcnet().SetExit(exit); err != nil {
+		return fmt.Errorf("api: exit: %s", err)
+	}
+
+	return nil
+}
+
+// StartFcnet sets up the TUN device using NetworkManager, and hands it to the node.
+// It's also used by `rovy fcnet start` for starting fcnet again after it was stopped.
+// The device gets v4's address and routes, if there's an address, and the exit routes.
+func (nc *NodeConfig) StartFcnet(ifname string, ip netip.Addr, v4 rapi.FcnetIPv4, exitRoutes []netip.Prefix) error {
 	nm := fcnet.NewNMTUN(nc.Logger)
-	if err := nm.Start(cfg.Fcnet.Ifname, node.IPAddr(), rovy.UpperMTU); err != nil {
+	nm.SetIPv4(v4.Addr, v4.Routes)
+	nm.SetExitRoutes(exitRoutes)
+	if err := nm.Start(ifname, ip, rovy.UpperMTU); err != nil {
 		return fmt.Errorf("networkmanager: %s", err)
 	}
 
+	// TODO: close our FD?
 	tunfd := nm.Device().File()
 
 	if err := nc.API.Fcnet().Start(tunfd); err != nil {
 		return fmt.Errorf("api: %s", err)
 	}
 
-	nc.Logger.Printf("started fcnet endpoint %s using NetworkManager", node.IPAddr())
+	nc.Logger.Printf("started fcnet endpoint %s using NetworkManager", ip)
 
 	return nil
 }
@@ -21,6 +21,10 @@ type NodeAPI interface {
 	Fcnet() FcnetAPI
 	Peer() PeerAPI
 	Discovery() DiscoveryAPI
+	Stats() StatsAPI
+	Logging() LoggingAPI
+	Policy() PolicyAPI
+	Petname() PetnameAPI
 }
 
 type PeerStatus struct {
@@ -29,10 +33,17 @@ type PeerStatus struct {
 }
 
 type PeerInfo struct {
-	PeerID rovy.PeerID
-	Addr   rovy.Multiaddr
-	Status string // ok, timeout, handshake-hello, connection-error, ...
-	Reason string
+	PeerID      rovy.PeerID
+	Addr        rovy.Multiaddr
+	Status      string // ok, timeout, handshake-hello, connection-error, ...
+	Reason      string
+	Slot        string // forwarder slot, e.g. 0a
+	Established time.Time
+	LastRecv    time.Time
+	RxBytes     uint64
+	RxPackets   uint64
+	TxBytes     uint64
+	TxPackets   uint64
 }
 
 type PeerListener struct {
@@ -44,9 +55,9 @@ type PeerListener struct {
 type PeerAPI interface {
 	Status() (PeerStatus, error)
 	Listen(rovy.Multiaddr) (PeerListener, error)
-	// Close(rovy.Multiaddr) (PeerListener, error)
+	Close(rovy.Multiaddr) (PeerListener, error)
 	Connect(rovy.Multiaddr) (PeerInfo, error)
-	// Disconnect(rovy.Multiaddr) (PeerInfo, error)
+	Disconnect(rovy.Multiaddr) (PeerInfo, error)
 }
 
 type DiscoveryStatus struct {
@@ -63,7 +74,126 @@ type DiscoveryAPI interface {
 	StopLinkLocal() error
 }
 
+// QueueStats describes one of the node's packet queues.
+type QueueStats struct {
+	Name      string // e.g. lowerRecv
+	Length    int
+	Capacity  int
+	HighWater int    // the largest Length seen so far
+	Dropped   uint64 // packets dropped because the queue was full
+}
+
+type StatsAPI interface {
+	Queues() ([]QueueStats, error)
+}
+
+// LogLevel is the level of one of the node's log subsystems,
+// i.e. one of debug, info, warn, error.
+type LogLevel struct {
+	Subsystem string // e.g. session
+	Level     string
+}
+
+type LoggingAPI interface {
+	Levels() ([]LogLevel, error)
+	SetLevel(LogLevel) (LogLevel, error)
+}
+
+// PeerPolicy decides which upper codecs and fcnet ports a group of peers may reach.
+// A peer can reach every codec, unless a policy that applies to it lists codecs.
+type PeerPolicy struct {
+	Name   string
+	Peers  []rovy.PeerID // empty means all peers
+	Codecs []string      // e.g. fcnet or 0x42004, or * for all
+	Ports  []string      // e.g. tcp/22, or * for all
+}
+
+type PolicyAPI interface {
+	List() ([]PeerPolicy, error)
+	Set(PeerPolicy) (PeerPolicy, error)
+	Remove(name string) error
+}
+
+// Petname is a local name for a peer, which resolves as <name>.rovy.
+type Petname struct {
+	Name   string // without .rovy
+	PeerID rovy.PeerID
+}
+
+type PetnameAPI interface {
+	List() ([]Petname, error)
+	Add(Petname) (Petname, error)
+	Remove(name string) error
+}
+
+// FcnetFirewall is what fcnet's firewall lets in, in addition to replies
+// to outbound flows. Ports are e.g. tcp/22, udp/53, or icmp for echo requests.
+type FcnetFirewall struct {
+	Ports []string
+	Peers []rovy.PeerID
+}
+
+// FcnetStatus describes fcnet's TUN device. The counters are the same as in /metrics.
+type FcnetStatus struct {
+	Running         bool
+	Ifname          string
+	MTU             int
+	IPAddress       netip.Addr
+	DNSRunning      bool
+	TunRxPackets    uint64
+	TunRxBytes      uint64
+	TunTxPackets    uint64
+	TunTxBytes      uint64
+	DNSQueries      uint64
+	FirewallDropped uint64
+}
+
+// FcnetDNS configures fc00::1's DNS server, which forwards names
+// outside of .rovy to Upstreams, e.g. [2620:fe::fe]:53.
+type FcnetDNS struct {
+	Upstreams []string
+}
+
+// FcnetIPv4 configures IPv4 over fcnet, which is only exchanged with Peers.
+// Addr is the TUN device's own address, and Routes are the prefixes routed into the device.
+// Exports are prefixes behind this node which the peers may reach, optionally limited
+// to ports, e.g. "192.168.1.10/32 tcp/80". Learned are the routes advertised by peers.
+type FcnetIPv4 struct {
+	Addr    netip.Addr
+	Routes  []netip.Prefix
+	Exports []string
+	Peers   []rovy.PeerID
+	Learned []FcnetIPv4Route
+}
+
+type FcnetIPv4Route struct {
+	Prefix netip.Prefix
+	PeerID rovy.PeerID
+}
+
+// FcnetExit configures exit nodes, which carry IPv6 traffic to and from the Internet.
+// With Serve, the Clients may use this node as their exit. Exits are used in order,
+// the first one that advertised itself and has a route is Selected, and Routes are the prefixes routed
+// into the TUN device for the exit, e.g. 2000::/3.
+type FcnetExit struct {
+	Serve    bool
+	Clients  []rovy.PeerID
+	Exits    []rovy.PeerID
+	Routes   []netip.Prefix
+	Selected *rovy.PeerID
+}
+
 type FcnetAPI interface {
 	Start(tunfd *os.File) error
+	Stop() error
+	Status() (FcnetStatus, error)
+	Firewall() (FcnetFirewall, error)
+	SetFirewall(FcnetFirewall) (FcnetFirewall, error)
+	DNS() (FcnetDNS, error)
+	SetDNS(FcnetDNS) (FcnetDNS, error)
+	IPv4() (FcnetIPv4, error)
+	SetIPv4(FcnetIPv4) (FcnetIPv4, error)
+	Exit() (FcnetExit, error)
+	SetExit(FcnetExit) (FcnetExit, error)
 	NodeAPI() NodeAPI // TODO: ?
 }
@@ -30,7 +30,7 @@ func (s *Server) serveDiscoveryLinkLocalStart(w http.ResponseWriter, r *http.Req
 	}
 
 	w.WriteHeader(http.StatusOK)
-	s.logger.Printf("api request %s -> ok", r.RequestURI)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
 }
 
 func (s *Server) serveDiscoveryLinkLocalStop(w http.ResponseWriter, r *http.Request) {
@@ -40,5 +40,5 @@ func (s *Server) serveDiscoveryLinkLocalStop(w http.ResponseWriter, r *http.Requ
 	}
 
 	w.WriteHeader(http.StatusOK)
-	s.logger.Printf("api request %s -> ok", r.RequestURI)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
 }
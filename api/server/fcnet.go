@@ -9,6 +9,7 @@ import (
 	"golang.org/x/sys/unix"
 
 	rovy "go.rovy.net"
+	rovyapi "go.rovy.net/api"
 	fcnet "go.rovy.net/fcnet"
 	rovynode "go.rovy.net/node"
 )
@@ -26,6 +27,13 @@ func (s *Server) serveFcnetStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	prev := s.getFcnet()
+	if prev != nil && prev.Running() {
+		unix.Close(fd)
+		s.writeError(w, r, fmt.Errorf("start: %s", fcnet.ErrRunning))
+		return
+	}
+
 	// TODO: check if the device has correct address and mtu
 	tunif, err := fcnet.FileTUN(fd)
 	if err != nil {
@@ -36,13 +44,304 @@ func (s *Server) serveFcnetStart(w http.ResponseWriter, r *http.Request) {
 	node := s.node.(*rovynode.Node)
 
 	fc := fcnet.NewFcnet(node, tunif)
+	if prev != nil {
+		// a restart keeps the firewall rules, dns upstreams, ipv4 and exit config
+		fc.Firewall().SetRules(prev.Firewall().Ports(), prev.Firewall().Peers())
+		_ = fc.DNSUpstreams().Set(prev.DNSUpstreams().Get())
+		v4 := prev.IPv4()
+		_ = fc.IPv4().Set(v4.Addr(), v4.TunRoutes(), v4.Exports(), v4.Peers())
+		ex := prev.Exit()
+		_ = fc.Exit().Set(ex.Serving(), ex.Clients(), ex.Exits(), ex.Routes())
+	}
 	if err := fc.Start(rovy.UpperMTU); err != nil {
+		tunif.Close()
 		s.writeError(w, r, fmt.Errorf("start: %s", err))
 		return
 	}
 
+	s.Lock()
+	s.fcnet = fc
+	s.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) serveFcnetStop(w http.ResponseWriter, r *http.Request) {
+	fc := s.getFcnet()
+	if fc == nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.stop: %s", fcnet.ErrNotRunning))
+		return
+	}
+
+	if err := fc.Stop(); err != nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.stop: %s", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) serveFcnetStatus(w http.ResponseWriter, r *http.Request) {
+	var fs rovyapi.FcnetStatus
+	if fc := s.getFcnet(); fc != nil {
+		st := fc.Status()
+		fs = rovyapi.FcnetStatus{
+			Running:         st.Running,
+			Ifname:          st.Ifname,
+			MTU:             st.MTU,
+			IPAddress:       st.Addr,
+			DNSRunning:      st.DNSRunning,
+			TunRxPackets:    st.Stats.TunRxPackets,
+			TunRxBytes:      st.Stats.TunRxBytes,
+			TunTxPackets:    st.Stats.TunTxPackets,
+			TunTxBytes:      st.Stats.TunTxBytes,
+			DNSQueries:      st.Stats.DNSQueries,
+			FirewallDropped: st.Stats.FirewallDropped,
+		}
+	}
+
+	out, err := json.Marshal(&fs)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) getFcnet() *fcnet.Fcnet {
+	s.Lock()
+	defer s.Unlock()
+	return s.fcnet
+}
+
+func (s *Server) serveFcnetFirewall(w http.ResponseWriter, r *http.Request) {
+	fc := s.getFcnet()
+	if fc == nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.firewall: fcnet isn't running"))
+		return
+	}
+
+	s.writeFirewall(w, r, fc.Firewall())
+}
+
+func (s *Server) serveFcnetSetFirewall(w http.ResponseWriter, r *http.Request) {
+	var params rovyapi.FcnetFirewall
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	fc := s.getFcnet()
+	if fc == nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.setfirewall: fcnet isn't running"))
+		return
+	}
+
+	ports := make([]fcnet.PortRule, 0, len(params.Ports))
+	for _, p := range params.Ports {
+		pr, err := fcnet.ParsePortRule(p)
+		if err != nil {
+			s.writeError(w, r, fmt.Errorf("fcnet.setfirewall: %s", err))
+			return
+		}
+		ports = append(ports, pr)
+	}
+	fc.Firewall().SetRules(ports, params.Peers)
+
+	s.writeFirewall(w, r, fc.Firewall())
+}
+
+func (s *Server) writeFirewall(w http.ResponseWriter, r *http.Request, fw *fcnet.Firewall) {
+	out := rovyapi.FcnetFirewall{Ports: []string{}, Peers: fw.Peers()}
+	for _, pr := range fw.Ports() {
+		out.Ports = append(out.Ports, pr.String())
+	}
+
+	body, err := json.Marshal(&out)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	body = append(body, 0x0a) // newline
+	_, _ = w.Write(body)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) serveFcnetDNS(w http.ResponseWriter, r *http.Request) {
+	fc := s.getFcnet()
+	if fc == nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.dns: fcnet isn't running"))
+		return
+	}
+
+	s.writeDNS(w, r, fc.DNSUpstreams())
+}
+
+func (s *Server) serveFcnetSetDNS(w http.ResponseWriter, r *http.Request) {
+	var params rovyapi.FcnetDNS
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	fc := s.getFcnet()
+	if fc == nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.setdns: fcnet isn't running"))
+		return
+	}
+
+	if err := fc.DNSUpstreams().Set(params.Upstreams); err != nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.setdns: %s", err))
+		return
+	}
+
+	s.writeDNS(w, r, fc.DNSUpstreams())
+}
+
+func (s *Server) writeDNS(w http.ResponseWriter, r *http.Request, u *fcnet.Upstreams) {
+	out := rovyapi.FcnetDNS{Upstreams: u.Get()}
+
+	body, err := json.Marshal(&out)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	body = append(body, 0x0a) // newline
+	_, _ = w.Write(body)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) serveFcnetIPv4(w http.ResponseWriter, r *http.Request) {
+	fc := s.getFcnet()
+	if fc == nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.ipv4: fcnet isn't running"))
+		return
+	}
+
+	s.writeIPv4(w, r, fc.IPv4())
+}
+
+func (s *Server) serveFcnetSetIPv4(w http.ResponseWriter, r *http.Request) {
+	var params rovyapi.FcnetIPv4
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	var exports []fcnet.Export
+	for _, str := range params.Exports {
+		ex, err := fcnet.ParseExport(str)
+		if err != nil {
+			s.writeError(w, r, fmt.Errorf("fcnet.setipv4: %s", err))
+			return
+		}
+		exports = append(exports, ex)
+	}
+
+	fc := s.getFcnet()
+	if fc == nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.setipv4: fcnet isn't running"))
+		return
+	}
+
+	if err := fc.IPv4().Set(params.Addr, params.Routes, exports, params.Peers); err != nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.setipv4: %s", err))
+		return
+	}
+
+	s.writeIPv4(w, r, fc.IPv4())
+}
+
+func (s *Server) writeIPv4(w http.ResponseWriter, r *http.Request, v4 *fcnet.IPv4) {
+	out := rovyapi.FcnetIPv4{
+		Addr:    v4.Addr(),
+		Routes:  v4.TunRoutes(),
+		Exports: []string{},
+		Peers:   v4.Peers(),
+		Learned: []rovyapi.FcnetIPv4Route{},
+	}
+	for _, ex := range v4.Exports() {
+		out.Exports = append(out.Exports, ex.String())
+	}
+	for _, rt := range v4.Routes() {
+		out.Learned = append(out.Learned, rovyapi.FcnetIPv4Route{Prefix: rt.Prefix, PeerID: rt.PeerID})
+	}
+
+	body, err := json.Marshal(&out)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	body = append(body, 0x0a) // newline
+	_, _ = w.Write(body)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) serveFcnetExit(w http.ResponseWriter, r *http.Request) {
+	fc := s.getFcnet()
+	if fc == nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.exit: fcnet isn't running"))
+		return
+	}
+
+	s.writeExit(w, r, fc)
+}
+
+func (s *Server) serveFcnetSetExit(w http.ResponseWriter, r *http.Request) {
+	var params rovyapi.FcnetExit
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	fc := s.getFcnet()
+	if fc == nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.setexit: fcnet isn't running"))
+		return
+	}
+
+	if err := fc.Exit().Set(params.Serve, params.Clients, params.Exits, params.Routes); err != nil {
+		s.writeError(w, r, fmt.Errorf("fcnet.setexit: %s", err))
+		return
+	}
+
+	s.writeExit(w, r, fc)
+}
+
+func (s *Server) writeExit(w http.ResponseWriter, r *http.Request, fc *fcnet.Fcnet) {
+	ex := fc.Exit()
+	out := rovyapi.FcnetExit{
+		Serve:   ex.Serving(),
+		Clients: ex.Clients(),
+		Exits:   ex.Exits(),
+		Routes:  ex.Routes(),
+	}
+	if pid, present := fc.SelectedExit(); present {
+		out.Selected = &pid
+	}
+
+	body, err := json.Marshal(&out)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
-	s.logger.Printf("api request %s -> ok", r.RequestURI)
+	body = append(body, 0x0a) // newline
+	_, _ = w.Write(body)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
 }
 
 func receiveFD(socket string) (int, error) {
@@ -0,0 +1,53 @@
+package rovyapis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	rovyapi "go.rovy.net/api"
+)
+
+func (s *Server) serveLoggingLevels(w http.ResponseWriter, r *http.Request) {
+	levels, err := s.node.Logging().Levels()
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("logging.levels: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&levels)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) serveLoggingSetLevel(w http.ResponseWriter, r *http.Request) {
+	var params rovyapi.LogLevel
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	ll, err := s.node.Logging().SetLevel(params)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("logging.setlevel: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&ll)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
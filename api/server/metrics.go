@@ -0,0 +1,166 @@
+package rovyapis
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	mux "github.com/gorilla/mux"
+
+	rovy "go.rovy.net"
+	rovyapi "go.rovy.net/api"
+	fcnet "go.rovy.net/fcnet"
+	rovynode "go.rovy.net/node"
+	session "go.rovy.net/node/session"
+)
+
+// ServeMetrics serves only /metrics, e.g. on a TCP listener for Prometheus,
+// while the rest of the API stays on the unix socket.
+func (s *Server) ServeMetrics(lis net.Listener) {
+	router := mux.NewRouter()
+	router.HandleFunc("/metrics", s.serveMetrics)
+
+	srv := &http.Server{Handler: router}
+	if err := srv.Serve(lis); err != nil {
+		s.logger.Error("metrics", "err", err)
+	}
+}
+
+// serveMetrics writes the Prometheus text format.
+// Successful requests aren't logged, since they come in every few seconds.
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	node, ok := s.node.(*rovynode.Node)
+	if !ok {
+		s.writeError(w, r, fmt.Errorf("metrics: not available for %T", s.node))
+		return
+	}
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, node, s.getFcnet())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func writeMetrics(w io.Writer, node *rovynode.Node, fc *fcnet.Fcnet) {
+	mw := &metricsWriter{w: w}
+
+	mw.family("rovy_info", "gauge", "Information about the node, the value is always 1.")
+	mw.sample("rovy_info", 1, "peerid", node.PeerID().String(), "ip", node.IPAddr().String())
+
+	stages := map[int]uint64{}
+	for _, sess := range node.SessionManager().Sessions() {
+		stages[sess.Stage()] += 1
+	}
+
+	mw.family("rovy_sessions", "gauge", "Number of sessions, by stage.")
+	for _, stage := range []int{session.HelloStage, session.ResponseStage, session.EstablishedStage} {
+		mw.sample("rovy_sessions", stages[stage], "stage", session.StageString(stage))
+	}
+
+	sc := node.SessionManager().Counters()
+	mw.family("rovy_handshakes_total", "counter", "Handshakes which completed or failed.")
+	mw.sample("rovy_handshakes_total", sc.HandshakeSuccesses, "result", "success")
+	mw.sample("rovy_handshakes_total", sc.HandshakeFailures, "result", "failure")
+	mw.family("rovy_decrypt_failures_total", "counter", "Data packets which failed to decrypt.")
+	mw.sample("rovy_decrypt_failures_total", sc.DecryptFailures)
+
+	// the totals include sessions which are gone, so that the counters don't go down
+	peers := node.SessionManager().PeerTraffic()
+	pids := make([]rovy.PeerID, 0, len(peers))
+	for pid := range peers {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i].String() < pids[j].String() })
+	peerFamilies := []struct {
+		name, help string
+		value      func(session.PeerTraffic) uint64
+	}{
+		{"rovy_peer_rx_bytes_total", "Bytes received from a peer, including session overhead.", func(pt session.PeerTraffic) uint64 { return pt.RxBytes }},
+		{"rovy_peer_rx_packets_total", "Packets received from a peer.", func(pt session.PeerTraffic) uint64 { return pt.RxPackets }},
+		{"rovy_peer_tx_bytes_total", "Bytes sent to a peer, including session overhead.", func(pt session.PeerTraffic) uint64 { return pt.TxBytes }},
+		{"rovy_peer_tx_packets_total", "Packets sent to a peer.", func(pt session.PeerTraffic) uint64 { return pt.TxPackets }},
+	}
+	for _, pf := range peerFamilies {
+		mw.family(pf.name, "counter", pf.help)
+		for _, pid := range pids {
+			mw.sample(pf.name, pf.value(peers[pid]), "peer", pid.String())
+		}
+	}
+
+	mw.family("rovy_forwarder_dropped_total", "counter", "Packets which the forwarder couldn't send on.")
+	mw.sample("rovy_forwarder_dropped_total", node.Forwarder().Dropped())
+
+	qs, _ := node.Stats().Queues()
+	queueFamilies := []struct {
+		name, typ, help string
+		value           func(q *rovyapi.QueueStats) uint64
+	}{
+		{"rovy_queue_length", "gauge", "Packets currently in a queue.", func(q *rovyapi.QueueStats) uint64 { return uint64(q.Length) }},
+		{"rovy_queue_capacity", "gauge", "Maximum number of packets in a queue.", func(q *rovyapi.QueueStats) uint64 { return uint64(q.Capacity) }},
+		{"rovy_queue_high_water", "gauge", "Largest number of packets seen in a queue.", func(q *rovyapi.QueueStats) uint64 { return uint64(q.HighWater) }},
+		{"rovy_queue_dropped_total", "counter", "Packets dropped because a queue was full.", func(q *rovyapi.QueueStats) uint64 { return q.Dropped }},
+	}
+	for _, qf := range queueFamilies {
+		mw.family(qf.name, qf.typ, qf.help)
+		for i := range qs {
+			mw.sample(qf.name, qf.value(&qs[i]), "queue", qs[i].Name)
+		}
+	}
+
+	rtpeers, rtroutes := node.Routing().Size()
+	mw.family("rovy_routing_peers", "gauge", "Peers in the routing table.")
+	mw.sample("rovy_routing_peers", uint64(rtpeers))
+	mw.family("rovy_routing_routes", "gauge", "Routes in the routing table.")
+	mw.sample("rovy_routing_routes", uint64(rtroutes))
+
+	if fc == nil {
+		return
+	}
+	fs := fc.Stats()
+	mw.family("rovy_fcnet_tun_rx_packets_total", "counter", "Packets read from the fcnet TUN device.")
+	mw.sample("rovy_fcnet_tun_rx_packets_total", fs.TunRxPackets)
+	mw.family("rovy_fcnet_tun_rx_bytes_total", "counter", "Bytes read from the fcnet TUN device.")
+	mw.sample("rovy_fcnet_tun_rx_bytes_total", fs.TunRxBytes)
+	mw.family("rovy_fcnet_tun_tx_packets_total", "counter", "Packets written to the fcnet TUN device.")
+	mw.sample("rovy_fcnet_tun_tx_packets_total", fs.TunTxPackets)
+	mw.family("rovy_fcnet_tun_tx_bytes_total", "counter", "Bytes written to the fcnet TUN device.")
+	mw.sample("rovy_fcnet_tun_tx_bytes_total", fs.TunTxBytes)
+	mw.family("rovy_fcnet_dns_queries_total", "counter", "DNS queries received by fc00::1.")
+	mw.sample("rovy_fcnet_dns_queries_total", fs.DNSQueries)
+	mw.family("rovy_fcnet_firewall_dropped_total", "counter", "Inbound fcnet packets dropped by the firewall.")
+	mw.sample("rovy_fcnet_firewall_dropped_total", fs.FirewallDropped)
+}
+
+// metricsWriter writes metrics in the Prometheus text exposition format.
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (mw *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one value, labels are pairs of name and value.
+func (mw *metricsWriter) sample(name string, value uint64, labels ...string) {
+	if len(labels) == 0 {
+		fmt.Fprintf(mw.w, "%s %d\n", name, value)
+		return
+	}
+
+	var lb strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			lb.WriteByte(',')
+		}
+		fmt.Fprintf(&lb, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	fmt.Fprintf(mw.w, "%s{%s} %d\n", name, lb.String(), value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
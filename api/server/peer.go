@@ -9,7 +9,22 @@ import (
 )
 
 func (s *Server) servePeerStatus(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusInternalServerError)
+	ps, err := s.node.Peer().Status()
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("peer.status: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&ps)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
 }
 
 func (s *Server) servePeerListen(w http.ResponseWriter, r *http.Request) {
@@ -34,9 +49,80 @@ func (s *Server) servePeerListen(w http.ResponseWriter, r *http.Request) {
 	out = append(out, 0x0a) // newline
 	_, _ = w.Write(out)
 
-	s.logger.Printf("api request %s -> ok", r.RequestURI)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) servePeerClose(w http.ResponseWriter, r *http.Request) {
+	params := struct{ Addr rovy.Multiaddr }{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	pl, err := s.node.Peer().Close(params.Addr)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("peer.close: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&pl)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
 }
 
 func (s *Server) servePeerConnect(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusInternalServerError)
+	params := struct{ Addr rovy.Multiaddr }{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	pi, err := s.node.Peer().Connect(params.Addr)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("peer.connect: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&pi)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", pi.Status)
+}
+
+func (s *Server) servePeerDisconnect(w http.ResponseWriter, r *http.Request) {
+	params := struct{ Addr rovy.Multiaddr }{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	pi, err := s.node.Peer().Disconnect(params.Addr)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("peer.disconnect: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&pi)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
 }
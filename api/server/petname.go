@@ -0,0 +1,69 @@
+package rovyapis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	rovyapi "go.rovy.net/api"
+)
+
+func (s *Server) servePetnameList(w http.ResponseWriter, r *http.Request) {
+	petnames, err := s.node.Petname().List()
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("petname.list: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&petnames)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) servePetnameAdd(w http.ResponseWriter, r *http.Request) {
+	var params rovyapi.Petname
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	pn, err := s.node.Petname().Add(params)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("petname.add: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&pn)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) servePetnameRemove(w http.ResponseWriter, r *http.Request) {
+	params := struct{ Name string }{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	if err := s.node.Petname().Remove(params.Name); err != nil {
+		s.writeError(w, r, fmt.Errorf("petname.remove: %s", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
@@ -0,0 +1,69 @@
+package rovyapis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	rovyapi "go.rovy.net/api"
+)
+
+func (s *Server) servePolicyList(w http.ResponseWriter, r *http.Request) {
+	policies, err := s.node.Policy().List()
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("policy.list: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&policies)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) servePolicySet(w http.ResponseWriter, r *http.Request) {
+	var params rovyapi.PeerPolicy
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	pp, err := s.node.Policy().Set(params)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("policy.set: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&pp)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
+
+func (s *Server) servePolicyRemove(w http.ResponseWriter, r *http.Request) {
+	params := struct{ Name string }{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeError(w, r, fmt.Errorf("params: %s", err))
+		return
+	}
+
+	if err := s.node.Policy().Remove(params.Name); err != nil {
+		s.writeError(w, r, fmt.Errorf("policy.remove: %s", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
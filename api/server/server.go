@@ -2,21 +2,25 @@ package rovyapis
 
 import (
 	"encoding/json"
-	"log"
 	"net"
 	"net/http"
+	"sync"
 
 	mux "github.com/gorilla/mux"
 	rovyapi "go.rovy.net/api"
+	fcnet "go.rovy.net/fcnet"
+	logging "go.rovy.net/node/util/logging"
 )
 
 type Server struct {
+	sync.Mutex
 	node   rovyapi.NodeAPI
-	logger *log.Logger
+	logger *logging.Logger
+	fcnet  *fcnet.Fcnet // set once fcnet is started, and kept after it's stopped
 }
 
-func NewServer(node rovyapi.NodeAPI, logger *log.Logger) *Server {
-	s := &Server{node, logger}
+func NewServer(node rovyapi.NodeAPI, logger *logging.Logger) *Server {
+	s := &Server{node: node, logger: logger}
 	return s
 }
 
@@ -28,11 +32,35 @@ func (s *Server) Serve(lis net.Listener) {
 	router.HandleFunc("/v0/start", s.serveStart)
 	router.HandleFunc("/v0/stop", s.serveStop)
 	router.HandleFunc("/v0/fcnet/start", s.serveFcnetStart) // not part of THE api
+	router.HandleFunc("/v0/fcnet/stop", s.serveFcnetStop)
+	router.HandleFunc("/v0/fcnet/status", s.serveFcnetStatus)
+	router.HandleFunc("/v0/fcnet/firewall", s.serveFcnetFirewall)
+	router.HandleFunc("/v0/fcnet/firewall/set", s.serveFcnetSetFirewall)
+	router.HandleFunc("/v0/fcnet/dns", s.serveFcnetDNS)
+	router.HandleFunc("/v0/fcnet/dns/set", s.serveFcnetSetDNS)
+	router.HandleFunc("/v0/fcnet/ipv4", s.serveFcnetIPv4)
+	router.HandleFunc("/v0/fcnet/ipv4/set", s.serveFcnetSetIPv4)
+	router.HandleFunc("/v0/fcnet/exit", s.serveFcnetExit)
+	router.HandleFunc("/v0/fcnet/exit/set", s.serveFcnetSetExit)
 	router.HandleFunc("/v0/peer/status", s.servePeerStatus)
 	router.HandleFunc("/v0/peer/listen", s.servePeerListen)
-	// router.HandleFunc("/v0/peer/close", s.servePeerClose)
+	router.HandleFunc("/v0/peer/close", s.servePeerClose)
 	router.HandleFunc("/v0/peer/connect", s.servePeerConnect)
-	// router.HandleFunc("/v0/peer/disconnect", s.servePeerDisconnect)
+	router.HandleFunc("/v0/peer/disconnect", s.servePeerDisconnect)
+
+	router.HandleFunc("/v0/policy/list", s.servePolicyList)
+	router.HandleFunc("/v0/policy/set", s.servePolicySet)
+	router.HandleFunc("/v0/policy/remove", s.servePolicyRemove)
+
+	router.HandleFunc("/v0/petname/list", s.servePetnameList)
+	router.HandleFunc("/v0/petname/add", s.servePetnameAdd)
+	router.HandleFunc("/v0/petname/remove", s.servePetnameRemove)
+
+	router.HandleFunc("/v0/stats/queues", s.serveStatsQueues)
+	router.HandleFunc("/metrics", s.serveMetrics)
+
+	router.HandleFunc("/v0/logging/levels", s.serveLoggingLevels)
+	router.HandleFunc("/v0/logging/setlevel", s.serveLoggingSetLevel)
 
 	// router.HandleFunc("/v0/discovery/status", s.serveDiscoveryStatus)
 	router.HandleFunc("/v0/discovery/linklocal/start", s.serveDiscoveryLinkLocalStart)
@@ -45,7 +73,7 @@ func (s *Server) Serve(lis net.Listener) {
 }
 
 func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
-	s.logger.Printf("api: request %s -> error: %s", r.RequestURI, err)
+	s.logger.Warn("api request", "uri", r.RequestURI, "err", err)
 	w.WriteHeader(http.StatusInternalServerError)
 }
 
@@ -61,7 +89,7 @@ func (s *Server) serveInfo(w http.ResponseWriter, r *http.Request) {
 	out = append(out, 0x0a) // newline
 	_, _ = w.Write(out)
 
-	s.logger.Printf("api request %s -> ok", r.RequestURI)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
 }
 
 func (s *Server) serveStart(w http.ResponseWriter, r *http.Request) {
@@ -81,7 +109,7 @@ func (s *Server) serveStart(w http.ResponseWriter, r *http.Request) {
 	out = append(out, 0x0a) // newline
 	_, _ = w.Write(out)
 
-	s.logger.Printf("api request %s -> ok", r.RequestURI)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
 }
 
 func (s *Server) serveStop(w http.ResponseWriter, r *http.Request) {
@@ -101,5 +129,5 @@ func (s *Server) serveStop(w http.ResponseWriter, r *http.Request) {
 	out = append(out, 0x0a) // newline
 	_, _ = w.Write(out)
 
-	s.logger.Printf("api request %s -> ok", r.RequestURI)
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
 }
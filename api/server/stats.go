@@ -0,0 +1,26 @@
+package rovyapis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func (s *Server) serveStatsQueues(w http.ResponseWriter, r *http.Request) {
+	qs, err := s.node.Stats().Queues()
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("stats.queues: %s", err))
+		return
+	}
+
+	out, err := json.Marshal(&qs)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("json: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	out = append(out, 0x0a) // newline
+	_, _ = w.Write(out)
+
+	s.logger.Info("api request", "uri", r.RequestURI, "result", "ok")
+}
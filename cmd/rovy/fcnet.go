@@ -0,0 +1,504 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"text/tabwriter"
+
+	cli "github.com/urfave/cli/v2"
+	rovy "go.rovy.net"
+	rovyapi "go.rovy.net/api"
+	rovyapic "go.rovy.net/api/client"
+	rnodecfg "go.rovy.net/api/config/nodecfg"
+	fcnet "go.rovy.net/fcnet"
+)
+
+var fcnetCmd = &cli.Command{
+	Name: "fcnet",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "status",
+			Action: fcnetStatusCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+		},
+		{
+			Name:   "stop",
+			Usage:  "stop fcnet and close its TUN device",
+			Action: fcnetStopCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+		},
+		{
+			Name:   "start",
+			Usage:  "create a TUN device using NetworkManager, and start fcnet with it",
+			Action: fcnetStartCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag, ifnameFlag},
+		},
+		{
+			Name:   "restart",
+			Action: fcnetRestartCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag, ifnameFlag},
+		},
+		{
+			Name:   "dns",
+			Usage:  "list the resolvers that fc00::1 forwards to",
+			Action: fcnetDNSCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+			Subcommands: []*cli.Command{
+				{
+					Name:      "set",
+					Usage:     "replace the resolvers, or remove them if none are given",
+					ArgsUsage: "[<ip>[:port]...]",
+					Action:    fcnetDNSSetCmdFunc,
+					Flags:     []cli.Flag{directoryFlag, socketFlag},
+				},
+			},
+		},
+		{
+			Name:   "ipv4",
+			Usage:  "show the ipv4 config, and the routes learned from peers",
+			Action: fcnetIPv4CmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+			Subcommands: []*cli.Command{
+				{
+					Name:   "set",
+					Usage:  "replace the ipv4 config, or disable ipv4 if there's no address",
+					Action: fcnetIPv4SetCmdFunc,
+					Flags: []cli.Flag{directoryFlag, socketFlag,
+						&cli.StringFlag{Name: "addr", Usage: "our address, e.g. 100.64.0.1"},
+						&cli.StringSliceFlag{Name: "route", Usage: "prefix routed into the TUN device, e.g. 192.168.2.0/24"},
+						&cli.StringSliceFlag{Name: "export", Usage: "prefix that peers may reach, e.g. \"192.168.1.0/24 tcp/80\""},
+						&cli.StringSliceFlag{Name: "peer", Usage: "peer to exchange ipv4 with"},
+					},
+				},
+			},
+		},
+		{
+			Name:   "exit",
+			Usage:  "show the exit node config, and which exit is used",
+			Action: fcnetExitCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+			Subcommands: []*cli.Command{
+				{
+					Name:   "set",
+					Usage:  "replace the exit node config",
+					Action: fcnetExitSetCmdFunc,
+					Flags: []cli.Flag{directoryFlag, socketFlag,
+						&cli.BoolFlag{Name: "serve", Usage: "be an exit for the clients"},
+						&cli.StringSliceFlag{Name: "client", Usage: "peer that may use us as exit"},
+						&cli.StringSliceFlag{Name: "exit", Usage: "peer to use as exit, in order of preference"},
+						&cli.StringSliceFlag{Name: "route", Usage: "prefix that goes through the exit, e.g. 2000::/3"},
+					},
+				},
+			},
+		},
+		{
+			Name:   "ports",
+			Usage:  "list the ports that fcnet's firewall lets in",
+			Action: fcnetPortsCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+			Subcommands: []*cli.Command{
+				{
+					Name:      "allow",
+					Usage:     "let unsolicited inbound packets in",
+					ArgsUsage: "<tcp/PORT|udp/PORT|icmp>...",
+					Action:    fcnetPortsAllowCmdFunc,
+					Flags:     []cli.Flag{directoryFlag, socketFlag},
+				},
+				{
+					Name:      "deny",
+					Usage:     "drop unsolicited inbound packets again",
+					ArgsUsage: "<tcp/PORT|udp/PORT|icmp>...",
+					Action:    fcnetPortsDenyCmdFunc,
+					Flags:     []cli.Flag{directoryFlag, socketFlag},
+				},
+			},
+		},
+	},
+}
+
+var ifnameFlag = &cli.StringFlag{
+	Name:  "ifname",
+	Value: fcnet.TunIfname,
+}
+
+func fcnetPortsCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	fw, err := api.Fcnet().Firewall()
+	if err != nil {
+		return exitErr("fcnet/firewall: %s", err)
+	}
+
+	printFirewall(os.Stdout, fw)
+
+	return nil
+}
+
+func fcnetPortsAllowCmdFunc(c *cli.Context) error {
+	return updateFirewallPorts(c, func(ports map[string]bool, arg string) {
+		ports[arg] = true
+	})
+}
+
+func fcnetPortsDenyCmdFunc(c *cli.Context) error {
+	return updateFirewallPorts(c, func(ports map[string]bool, arg string) {
+		delete(ports, arg)
+	})
+}
+
+// updateFirewallPorts applies fn to the current ports for each argument,
+// and sets the result.
+func updateFirewallPorts(c *cli.Context, fn func(map[string]bool, string)) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	if c.NArg() == 0 {
+		return exitErr("expecting at least one port argument")
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	fw, err := api.Fcnet().Firewall()
+	if err != nil {
+		return exitErr("fcnet/firewall: %s", err)
+	}
+
+	ports := map[string]bool{}
+	for _, p := range fw.Ports {
+		ports[p] = true
+	}
+	for _, arg := range c.Args().Slice() {
+		pr, err := fcnet.ParsePortRule(arg)
+		if err != nil {
+			return exitErr("%s", err)
+		}
+		fn(ports, pr.String())
+	}
+	fw.Ports = []string{}
+	for p := range ports {
+		fw.Ports = append(fw.Ports, p)
+	}
+
+	fw, err = api.Fcnet().SetFirewall(fw)
+	if err != nil {
+		return exitErr("fcnet/firewall/set: %s", err)
+	}
+
+	printFirewall(os.Stdout, fw)
+
+	return nil
+}
+
+func fcnetDNSCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	fd, err := api.Fcnet().DNS()
+	if err != nil {
+		return exitErr("fcnet/dns: %s", err)
+	}
+
+	printFcnetDNS(os.Stdout, fd)
+
+	return nil
+}
+
+func fcnetDNSSetCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	fd, err := api.Fcnet().SetDNS(rovyapi.FcnetDNS{Upstreams: c.Args().Slice()})
+	if err != nil {
+		return exitErr("fcnet/dns/set: %s", err)
+	}
+
+	printFcnetDNS(os.Stdout, fd)
+
+	return nil
+}
+
+func fcnetIPv4CmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	f4, err := api.Fcnet().IPv4()
+	if err != nil {
+		return exitErr("fcnet/ipv4: %s", err)
+	}
+
+	printFcnetIPv4(os.Stdout, f4)
+
+	return nil
+}
+
+func fcnetIPv4SetCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	var params rovyapi.FcnetIPv4
+	if a := c.String("addr"); a != "" {
+		if params.Addr, err = netip.ParseAddr(a); err != nil {
+			return exitErr("addr: %s", err)
+		}
+	}
+	for _, r := range c.StringSlice("route") {
+		pfx, err := netip.ParsePrefix(r)
+		if err != nil {
+			return exitErr("route: %s", err)
+		}
+		params.Routes = append(params.Routes, pfx)
+	}
+	for _, e := range c.StringSlice("export") {
+		ex, err := fcnet.ParseExport(e)
+		if err != nil {
+			return exitErr("export: %s", err)
+		}
+		params.Exports = append(params.Exports, ex.String())
+	}
+	for _, p := range c.StringSlice("peer") {
+		pid, err := rovy.ParsePeerID(p)
+		if err != nil {
+			return exitErr("peer: %s", err)
+		}
+		params.Peers = append(params.Peers, pid)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	f4, err := api.Fcnet().SetIPv4(params)
+	if err != nil {
+		return exitErr("fcnet/ipv4/set: %s", err)
+	}
+
+	printFcnetIPv4(os.Stdout, f4)
+
+	return nil
+}
+
+func printFcnetIPv4(out io.Writer, f4 rovyapi.FcnetIPv4) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if !f4.Addr.IsValid() {
+		fmt.Fprintf(tw, "Address:\tdisabled\n")
+	} else {
+		fmt.Fprintf(tw, "Address:\t%s\n", f4.Addr)
+	}
+	for _, pfx := range f4.Routes {
+		fmt.Fprintf(tw, "Route:\t%s\n", pfx)
+	}
+	for _, ex := range f4.Exports {
+		fmt.Fprintf(tw, "Export:\t%s\n", ex)
+	}
+	for _, pid := range f4.Peers {
+		fmt.Fprintf(tw, "Peer:\t%s\n", pid)
+	}
+	if len(f4.Learned) > 0 {
+		fmt.Fprintf(tw, "\nPREFIX\tPEER\n")
+		for _, rt := range f4.Learned {
+			fmt.Fprintf(tw, "%s\t%s\n", rt.Prefix, rt.PeerID)
+		}
+	}
+	tw.Flush()
+}
+
+func fcnetExitCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	fe, err := api.Fcnet().Exit()
+	if err != nil {
+		return exitErr("fcnet/exit: %s", err)
+	}
+
+	printFcnetExit(os.Stdout, fe)
+
+	return nil
+}
+
+func fcnetExitSetCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	params := rovyapi.FcnetExit{Serve: c.Bool("serve")}
+	for _, p := range c.StringSlice("client") {
+		pid, err := rovy.ParsePeerID(p)
+		if err != nil {
+			return exitErr("client: %s", err)
+		}
+		params.Clients = append(params.Clients, pid)
+	}
+	for _, p := range c.StringSlice("exit") {
+		pid, err := rovy.ParsePeerID(p)
+		if err != nil {
+			return exitErr("exit: %s", err)
+		}
+		params.Exits = append(params.Exits, pid)
+	}
+	for _, r := range c.StringSlice("route") {
+		pfx, err := netip.ParsePrefix(r)
+		if err != nil {
+			return exitErr("route: %s", err)
+		}
+		params.Routes = append(params.Routes, pfx)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	fe, err := api.Fcnet().SetExit(params)
+	if err != nil {
+		return exitErr("fcnet/exit/set: %s", err)
+	}
+
+	printFcnetExit(os.Stdout, fe)
+
+	return nil
+}
+
+func printFcnetExit(out io.Writer, fe rovyapi.FcnetExit) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Serving:\t%t\n", fe.Serve)
+	for _, pid := range fe.Clients {
+		fmt.Fprintf(tw, "Client:\t%s\n", pid)
+	}
+	for _, pid := range fe.Exits {
+		fmt.Fprintf(tw, "Exit:\t%s\n", pid)
+	}
+	for _, pfx := range fe.Routes {
+		fmt.Fprintf(tw, "Route:\t%s\n", pfx)
+	}
+	if fe.Selected != nil {
+		fmt.Fprintf(tw, "Selected:\t%s\n", *fe.Selected)
+	}
+	tw.Flush()
+}
+
+func printFcnetDNS(out io.Writer, fd rovyapi.FcnetDNS) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "UPSTREAM\n")
+	for _, addr := range fd.Upstreams {
+		fmt.Fprintf(tw, "%s\n", addr)
+	}
+	tw.Flush()
+}
+
+func printFirewall(out io.Writer, fw rovyapi.FcnetFirewall) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ALLOW\n")
+	for _, p := range fw.Ports {
+		fmt.Fprintf(tw, "%s\n", p)
+	}
+	for _, pid := range fw.Peers {
+		fmt.Fprintf(tw, "peer %s\n", pid)
+	}
+	tw.Flush()
+}
+
+func fcnetStatusCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	fs, err := api.Fcnet().Status()
+	if err != nil {
+		return exitErr("fcnet/status: %s", err)
+	}
+
+	printFcnetStatus(os.Stdout, fs)
+
+	return nil
+}
+
+func fcnetStopCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	if err := api.Fcnet().Stop(); err != nil {
+		return exitErr("fcnet/stop: %s", err)
+	}
+
+	return nil
+}
+
+func fcnetStartCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	ni, err := api.Info()
+	if err != nil {
+		return exitErr("info: %s", err)
+	}
+
+	// the ipv4 and exit config is kept across restarts, and the TUN device needs
+	// its addresses and routes. before fcnet was ever started, there's none.
+	f4, _ := api.Fcnet().IPv4()
+	fe, _ := api.Fcnet().Exit()
+
+	nc := &rnodecfg.NodeConfig{API: api, Logger: logger}
+	if err := nc.StartFcnet(c.String("ifname"), ni.IPAddress, f4, fe.Routes); err != nil {
+		return exitErr("fcnet/start: %s", err)
+	}
+
+	return nil
+}
+
+func fcnetRestartCmdFunc(c *cli.Context) error {
+	if err := fcnetStopCmdFunc(c); err != nil {
+		return err
+	}
+	return fcnetStartCmdFunc(c)
+}
+
+func printFcnetStatus(out io.Writer, fs rovyapi.FcnetStatus) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Running:\t%t\n", fs.Running)
+	if fs.Ifname != "" {
+		fmt.Fprintf(tw, "Interface:\t%s\n", fs.Ifname)
+		fmt.Fprintf(tw, "MTU:\t%d\n", fs.MTU)
+		fmt.Fprintf(tw, "Address:\t%s\n", fs.IPAddress)
+		fmt.Fprintf(tw, "DNS:\t%t\n", fs.DNSRunning)
+		fmt.Fprintf(tw, "Rx:\t%d packets, %d bytes\n", fs.TunRxPackets, fs.TunRxBytes)
+		fmt.Fprintf(tw, "Tx:\t%d packets, %d bytes\n", fs.TunTxPackets, fs.TunTxBytes)
+		fmt.Fprintf(tw, "DNS queries:\t%d\n", fs.DNSQueries)
+		fmt.Fprintf(tw, "Firewall dropped:\t%d\n", fs.FirewallDropped)
+	}
+	tw.Flush()
+}
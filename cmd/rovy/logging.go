@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	cli "github.com/urfave/cli/v2"
+	rovyapi "go.rovy.net/api"
+	rovyapic "go.rovy.net/api/client"
+)
+
+var loggingCmd = &cli.Command{
+	Name: "logging",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "levels",
+			Action: loggingLevelsCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+		},
+		{
+			Name:      "set",
+			Usage:     "change the log level of a subsystem",
+			ArgsUsage: "<subsystem> <debug|info|warn|error>",
+			Action:    loggingSetCmdFunc,
+			Flags:     []cli.Flag{directoryFlag, socketFlag},
+		},
+	},
+}
+
+func loggingLevelsCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	levels, err := api.Logging().Levels()
+	if err != nil {
+		return exitErr("logging/levels: %s", err)
+	}
+
+	printLogLevels(os.Stdout, levels)
+
+	return nil
+}
+
+func loggingSetCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	if c.NArg() != 2 {
+		return exitErr("expecting subsystem and level arguments")
+	}
+	params := rovyapi.LogLevel{Subsystem: c.Args().Get(0), Level: c.Args().Get(1)}
+
+	api := rovyapic.NewClient(socket, logger)
+	ll, err := api.Logging().SetLevel(params)
+	if err != nil {
+		return exitErr("logging/setlevel: %s", err)
+	}
+
+	printLogLevels(os.Stdout, []rovyapi.LogLevel{ll})
+
+	return nil
+}
+
+func printLogLevels(out io.Writer, levels []rovyapi.LogLevel) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "SUBSYSTEM\tLEVEL\n")
+	for _, ll := range levels {
+		fmt.Fprintf(tw, "%s\t%s\n", ll.Subsystem, ll.Level)
+	}
+	tw.Flush()
+}
@@ -24,6 +24,10 @@ var app = &cli.App{
 		infoCmd,
 		stopCmd,
 		peerCmd,
+		statsCmd,
+		loggingCmd,
+		fcnetCmd,
+		petnameCmd,
 	},
 }
 
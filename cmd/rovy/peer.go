@@ -2,10 +2,15 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	cli "github.com/urfave/cli/v2"
 	rovy "go.rovy.net"
+	rovyapi "go.rovy.net/api"
 	rovyapic "go.rovy.net/api/client"
 )
 
@@ -15,18 +20,56 @@ var peerCmd = &cli.Command{
 		{
 			Name:   "status",
 			Action: peerStatusCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
 		},
 		{
 			Name:   "listen",
 			Action: peerListenCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+		},
+		{
+			Name:   "close",
+			Action: peerCloseCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
 		},
 		{
 			Name:   "connect",
 			Action: peerConnectCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+		},
+		{
+			Name:   "disconnect",
+			Usage:  "forget the sessions over an address, or all of a peer's with only /rovy/<peerid>",
+			Action: peerDisconnectCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
 		},
 		{
 			Name:   "policy",
+			Usage:  "list the policies for which codecs and fcnet ports peers may reach",
 			Action: peerPolicyCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+			Subcommands: []*cli.Command{
+				{
+					Name:  "set",
+					Usage: "add or replace a policy",
+					Description: "Peers can reach every codec, unless a policy that applies to them lists codecs.\n" +
+						"Then they can only reach the codecs which those policies allow.\n" +
+						"Policies with only ports don't restrict codecs for anybody.",
+					ArgsUsage: "<name>",
+					Action:    peerPolicySetCmdFunc,
+					Flags: []cli.Flag{directoryFlag, socketFlag,
+						&cli.StringSliceFlag{Name: "peer", Usage: "PeerID the policy applies to, default all peers"},
+						&cli.StringSliceFlag{Name: "codec", Usage: "upper codec, e.g. fcnet or 0x42004, or *; restricts the peers to the listed codecs"},
+						&cli.StringSliceFlag{Name: "port", Usage: "fcnet port, e.g. tcp/22, or *"},
+					},
+				},
+				{
+					Name:      "remove",
+					ArgsUsage: "<name>",
+					Action:    peerPolicyRemoveCmdFunc,
+					Flags:     []cli.Flag{directoryFlag, socketFlag},
+				},
+			},
 		},
 	},
 }
@@ -44,11 +87,69 @@ func peerStatusCmdFunc(c *cli.Context) error {
 		return exitErr("peer/status: %s", err)
 	}
 
-	fmt.Fprintf(os.Stdout, "Status: %#v\n", status)
+	printPeerStatus(os.Stdout, status, time.Now())
 
 	return nil
 }
 
+func printPeerStatus(out io.Writer, status rovyapi.PeerStatus, now time.Time) {
+	fmt.Fprintf(out, "Listeners:\n")
+	if len(status.Listeners) == 0 {
+		fmt.Fprintf(out, "  (none)\n")
+	}
+	for _, pl := range status.Listeners {
+		if len(pl.EffectiveAddrs) == 0 {
+			fmt.Fprintf(out, "  %s (not running)\n", pl.ListenAddr)
+			continue
+		}
+		fmt.Fprintf(out, "  %s\n", pl.ListenAddr)
+		for _, ea := range pl.EffectiveAddrs {
+			fmt.Fprintf(out, "    %s\n", ea)
+		}
+	}
+
+	fmt.Fprintf(out, "\nPeers:\n")
+	if len(status.Peers) == 0 {
+		fmt.Fprintf(out, "  (none)\n")
+		return
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "  PEER\tADDRESS\tSTATUS\tSLOT\tUP\tLAST RECV\tRX\tTX\n")
+	for _, pi := range status.Peers {
+		slot := pi.Slot
+		if slot == "" {
+			slot = "-"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			pi.PeerID, pi.Addr, pi.Status, slot,
+			sinceString(pi.Established, now), sinceString(pi.LastRecv, now),
+			trafficString(pi.RxBytes, pi.RxPackets), trafficString(pi.TxBytes, pi.TxPackets))
+	}
+	tw.Flush()
+}
+
+func sinceString(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return now.Sub(t).Truncate(time.Second).String()
+}
+
+func trafficString(bytes, packets uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	v := float64(bytes)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i += 1
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B (%d pkts)", bytes, packets)
+	}
+	return fmt.Sprintf("%.1f %s (%d pkts)", v, units[i], packets)
+}
+
 func peerListenCmdFunc(c *cli.Context) error {
 	logger := newLogger(c)
 	socket, err := getSocket(c)
@@ -71,16 +172,201 @@ func peerListenCmdFunc(c *cli.Context) error {
 			return exitErr("peer/listen: %s", err)
 		}
 
-		fmt.Fprintf(os.Stdout, "Listener: %#v\n", pl)
+		fmt.Fprintf(os.Stdout, "Listening: %s\n", pl.ListenAddr)
+		for _, ea := range pl.EffectiveAddrs {
+			fmt.Fprintf(os.Stdout, "  %s\n", ea)
+		}
+	}
+
+	return nil
+}
+
+func peerCloseCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	if c.NArg() == 0 {
+		return exitErr("expecting multiaddr argument")
+	}
+	for i := 0; i < c.NArg(); i++ {
+		maddr, err := rovy.ParseMultiaddr(c.Args().Get(i))
+		if err != nil {
+			return exitErr("multiaddr: %s", err)
+		}
+
+		api := rovyapic.NewClient(socket, logger)
+		pl, err := api.Peer().Close(maddr)
+		if err != nil {
+			return exitErr("peer/close: %s", err)
+		}
+
+		fmt.Fprintf(os.Stdout, "Closed: %s\n", pl.ListenAddr)
 	}
 
 	return nil
 }
 
 func peerConnectCmdFunc(c *cli.Context) error {
-	return exitErr("TODO: connect is not yet implemented")
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	if c.NArg() == 0 {
+		return exitErr("expecting multiaddr argument")
+	}
+	var failed int
+	for i := 0; i < c.NArg(); i++ {
+		maddr, err := rovy.ParseMultiaddr(c.Args().Get(i))
+		if err != nil {
+			return exitErr("multiaddr: %s", err)
+		}
+
+		api := rovyapic.NewClient(socket, logger)
+		pi, err := api.Peer().Connect(maddr)
+		if err != nil {
+			return exitErr("peer/connect: %s", err)
+		}
+
+		if pi.Status != "ok" {
+			failed += 1
+			fmt.Fprintf(os.Stdout, "%s: %s (%s)\n", maddr, pi.Status, pi.Reason)
+		} else {
+			fmt.Fprintf(os.Stdout, "%s: %s\n", maddr, pi.Status)
+		}
+	}
+
+	if failed > 0 {
+		return exitErr("failed to connect to %d of %d peers", failed, c.NArg())
+	}
+	return nil
+}
+
+func peerDisconnectCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	if c.NArg() == 0 {
+		return exitErr("expecting multiaddr argument")
+	}
+	for i := 0; i < c.NArg(); i++ {
+		maddr, err := rovy.ParseMultiaddr(c.Args().Get(i))
+		if err != nil {
+			return exitErr("multiaddr: %s", err)
+		}
+
+		api := rovyapic.NewClient(socket, logger)
+		pi, err := api.Peer().Disconnect(maddr)
+		if err != nil {
+			return exitErr("peer/disconnect: %s", err)
+		}
+
+		fmt.Fprintf(os.Stdout, "%s: %s\n", maddr, pi.Status)
+	}
+
+	return nil
 }
 
 func peerPolicyCmdFunc(c *cli.Context) error {
-	return exitErr("TODO: policy is not yet implemented")
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	policies, err := api.Policy().List()
+	if err != nil {
+		return exitErr("policy/list: %s", err)
+	}
+
+	printPolicies(os.Stdout, policies)
+
+	return nil
+}
+
+func peerPolicySetCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	if c.NArg() != 1 {
+		return exitErr("expecting policy name argument")
+	}
+	params := rovyapi.PeerPolicy{
+		Name:   c.Args().First(),
+		Codecs: c.StringSlice("codec"),
+		Ports:  c.StringSlice("port"),
+	}
+	for _, s := range c.StringSlice("peer") {
+		pid, err := rovy.ParsePeerID(s)
+		if err != nil {
+			return exitErr("peer %s: %s", s, err)
+		}
+		params.Peers = append(params.Peers, pid)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	pp, err := api.Policy().Set(params)
+	if err != nil {
+		return exitErr("policy/set: %s", err)
+	}
+
+	printPolicies(os.Stdout, []rovyapi.PeerPolicy{pp})
+
+	return nil
+}
+
+func peerPolicyRemoveCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	if c.NArg() != 1 {
+		return exitErr("expecting policy name argument")
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	if err := api.Policy().Remove(c.Args().First()); err != nil {
+		return exitErr("policy/remove: %s", err)
+	}
+
+	return nil
+}
+
+func printPolicies(out io.Writer, policies []rovyapi.PeerPolicy) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tPEERS\tCODECS\tPORTS\n")
+	for _, pp := range policies {
+		peers := "all"
+		if len(pp.Peers) > 0 {
+			peers = ""
+			for i, pid := range pp.Peers {
+				if i > 0 {
+					peers += ","
+				}
+				peers += pid.String()
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", pp.Name, peers, listOrDash(pp.Codecs), listOrDash(pp.Ports))
+	}
+	tw.Flush()
+}
+
+func listOrDash(l []string) string {
+	if len(l) == 0 {
+		return "-"
+	}
+	return strings.Join(l, ",")
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	cli "github.com/urfave/cli/v2"
+	rovy "go.rovy.net"
+	rovyapi "go.rovy.net/api"
+	rovyapic "go.rovy.net/api/client"
+)
+
+var petnameCmd = &cli.Command{
+	Name:  "petname",
+	Usage: "manage local names for peers, which resolve as <name>.rovy",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "ls",
+			Action: petnameLsCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+		},
+		{
+			Name:      "add",
+			ArgsUsage: "<name> <peerid>",
+			Action:    petnameAddCmdFunc,
+			Flags:     []cli.Flag{directoryFlag, socketFlag},
+		},
+		{
+			Name:      "rm",
+			ArgsUsage: "<name>",
+			Action:    petnameRmCmdFunc,
+			Flags:     []cli.Flag{directoryFlag, socketFlag},
+		},
+	},
+}
+
+func petnameLsCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	petnames, err := api.Petname().List()
+	if err != nil {
+		return exitErr("petname/list: %s", err)
+	}
+
+	printPetnames(os.Stdout, petnames)
+
+	return nil
+}
+
+func petnameAddCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	if c.NArg() != 2 {
+		return exitErr("expecting name and peerid arguments")
+	}
+	pid, err := rovy.ParsePeerID(c.Args().Get(1))
+	if err != nil {
+		return exitErr("peerid: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	pn, err := api.Petname().Add(rovyapi.Petname{Name: c.Args().Get(0), PeerID: pid})
+	if err != nil {
+		return exitErr("petname/add: %s", err)
+	}
+
+	printPetnames(os.Stdout, []rovyapi.Petname{pn})
+
+	return nil
+}
+
+func petnameRmCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	if c.NArg() != 1 {
+		return exitErr("expecting name argument")
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	if err := api.Petname().Remove(c.Args().First()); err != nil {
+		return exitErr("petname/remove: %s", err)
+	}
+
+	return nil
+}
+
+func printPetnames(out io.Writer, petnames []rovyapi.Petname) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tPEERID\n")
+	for _, pn := range petnames {
+		fmt.Fprintf(tw, "%s.rovy\t%s\n", pn.Name, pn.PeerID)
+	}
+	tw.Flush()
+}
@@ -22,6 +22,7 @@ import (
 
 const KeyfileName = "keyfile.toml"
 const ConfigName = "config.toml"
+const PetnamesName = "petnames.toml"
 
 var startCmd = &cli.Command{
 	Name:   "start",
@@ -92,27 +93,59 @@ func startCmdFunc(c *cli.Context) error {
 	node := rovynode.NewNode(privkey, logger)
 	logger.Printf("we are /rovy/%s", node.PeerID())
 
-	if err := startAPI(node, socket); err != nil {
+	if !ephemeral && !stdin {
+		petnames := filepath.Join(filepath.Dir(config), PetnamesName)
+		if err := node.Petnames().SetFile(petnames); err != nil {
+			return exitErr("petnames: %s", err)
+		}
+	}
+
+	api, err := startAPI(node, socket)
+	if err != nil {
 		return exitErr("api: %s", err)
 	}
 	logger.Printf("api socket ready at http:%s", socket)
 
+	var cfg *rconfig.Config
 	if !stdin {
 		if !ephemeral {
-			if err := configureNode(node, socket, config); err != nil {
-				return exitErr("config: %s", err)
+			cfg, err = rconfig.LoadConfig(config)
+			if err != nil {
+				return exitErr("config: failed to load config: %s", err)
 			}
 		} else {
-			if err := configureNodeDefault(node, socket); err != nil {
-				return exitErr("config: %s", err)
-			}
+			cfg = rconfig.DefaultConfig()
+		}
+	}
+
+	if cfg != nil && cfg.Metrics.Listen != "" {
+		metricslis, err := net.Listen("tcp", cfg.Metrics.Listen)
+		if err != nil {
+			return exitErr("metrics: failed to listen: %s", err)
+		}
+		go api.ServeMetrics(metricslis)
+		logger.Printf("metrics ready at http://%s/metrics", metricslis.Addr())
+	}
+
+	// queue sizes can only be changed while the node is stopped
+	nc := &rnodecfg.NodeConfig{API: rovyapic.NewClient(socket, node.Log()), Logger: node.Log()}
+	if cfg != nil {
+		if err := nc.ConfigureQueues(cfg, node); err != nil {
+			return exitErr("config: %s", err)
 		}
 	}
 
+	// the node needs to be running before we can connect to peers
 	if _, err := node.Start(); err != nil {
 		return exitErr("node: %s", err)
 	}
 
+	if cfg != nil {
+		if err := nc.ConfigureAll(cfg, node); err != nil {
+			return exitErr("config: %s", err)
+		}
+	}
+
 	select {
 	// XXX shutdown needs to break this select
 	}
@@ -145,40 +178,17 @@ func readPrivateKey(keyfile string, stdin io.Reader) (privkey rovy.PrivateKey, e
 	}
 }
 
-func startAPI(node *rovynode.Node, socket string) error {
+func startAPI(node *rovynode.Node, socket string) (*rovyapis.Server, error) {
 	if err := checkSocket(socket); err != nil {
-		return fmt.Errorf("failed to check socket %s: %s", socket, err)
+		return nil, fmt.Errorf("failed to check socket %s: %s", socket, err)
 	}
 	apilis, err := net.Listen("unix", socket)
 	if err != nil {
-		return fmt.Errorf("failed to start socket listener: %s", err)
+		return nil, fmt.Errorf("failed to start socket listener: %s", err)
 	}
-	api := rovyapis.NewServer(node, node.Log())
+	api := rovyapis.NewServer(node, node.Logger(rovynode.LogAPI))
 	go api.Serve(apilis)
-	return nil
-}
-
-func configureNode(node *rovynode.Node, socket, config string) error {
-	cfg, err := rconfig.LoadConfig(config)
-	if err != nil {
-		return fmt.Errorf("failed to load config: %s", err)
-	}
-	client := rovyapic.NewClient(socket, node.Log())
-	nc := &rnodecfg.NodeConfig{API: client, Logger: node.Log()}
-	if err = nc.ConfigureAll(cfg, node); err != nil {
-		return err
-	}
-	return nil
-}
-
-func configureNodeDefault(node *rovynode.Node, socket string) error {
-	cfg := rconfig.DefaultConfig()
-	client := rovyapic.NewClient(socket, node.Log())
-	nc := &rnodecfg.NodeConfig{API: client, Logger: node.Log()}
-	if err := nc.ConfigureAll(cfg, node); err != nil {
-		return err
-	}
-	return nil
+	return api, nil
 }
 
 func checkSocket(socket string) error {
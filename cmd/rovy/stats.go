@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	cli "github.com/urfave/cli/v2"
+	rovyapi "go.rovy.net/api"
+	rovyapic "go.rovy.net/api/client"
+)
+
+var statsCmd = &cli.Command{
+	Name: "stats",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "queues",
+			Action: statsQueuesCmdFunc,
+			Flags:  []cli.Flag{directoryFlag, socketFlag},
+		},
+	},
+}
+
+func statsQueuesCmdFunc(c *cli.Context) error {
+	logger := newLogger(c)
+	socket, err := getSocket(c)
+	if err != nil {
+		return exitErr("getsocket: %s", err)
+	}
+
+	api := rovyapic.NewClient(socket, logger)
+	qs, err := api.Stats().Queues()
+	if err != nil {
+		return exitErr("stats/queues: %s", err)
+	}
+
+	printQueueStats(os.Stdout, qs)
+
+	return nil
+}
+
+func printQueueStats(out io.Writer, qs []rovyapi.QueueStats) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "QUEUE\tLENGTH\tCAPACITY\tHIGH WATER\tDROPPED\n")
+	for _, q := range qs {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", q.Name, q.Length, q.Capacity, q.HighWater, q.Dropped)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,76 @@
+package examples_test
+
+import (
+	"testing"
+
+	rovy "go.rovy.net"
+)
+
+// Once the packet pool is warmed up, sending and receiving a packet
+// doesn't allocate, in any of the routines along the way.
+func TestSteadyStateAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+
+	addrA := rovy.MustParseMultiaddr("/ip6/::1/udp/12260")
+	addrB := rovy.MustParseMultiaddr("/ip6/::1/udp/12261")
+
+	nodeA, err := newNode("nodeA", addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newNode("nodeB", addrB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	recv := make(chan struct{}, 1)
+	nodeB.Handle(0x42003, func(upkt rovy.UpperPacket) error {
+		recv <- struct{}{}
+		return nil
+	})
+	if err := nodeA.Connect(nodeB.PeerID(), addrB); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := make([]byte, 1000)
+	allocs := testing.AllocsPerRun(1000, func() {
+		if err := nodeA.Send(nodeB.PeerID(), 0x42003, payload); err != nil {
+			t.Fatal(err)
+		}
+		<-recv
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations per packet, got %.2f", allocs)
+	}
+}
+
+// Released buffers come back zeroed, and packets that aren't from the pool
+// aren't put into it.
+func TestPacketPool(t *testing.T) {
+	pkt := rovy.AllocPacket()
+	if len(pkt.Buf) != rovy.TptMTU {
+		t.Fatalf("expected a %d bytes buffer, got %d", rovy.TptMTU, len(pkt.Buf))
+	}
+	for i := range pkt.Buf {
+		pkt.Buf[i] = 0x42
+	}
+	pkt.Release()
+
+	rovy.NewPacket(make([]byte, 100)).Release()
+
+	for i := 0; i < 10; i++ {
+		pkt := rovy.AllocPacket()
+		if len(pkt.Buf) != rovy.TptMTU {
+			t.Fatalf("unexpected packet from the pool: len=%d", len(pkt.Buf))
+		}
+		for _, b := range pkt.Buf {
+			if b != 0 {
+				t.Fatal("expected a zeroed buffer from the pool")
+			}
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"runtime"
@@ -16,10 +16,16 @@ import (
 
 const BenchmarkCodec = 0x42002
 
-func newNode(name string, lisaddr rovy.Multiaddr) (*node.Node, error) {
+func newNode(name string, lisaddr rovy.Multiaddr, udpBackend string, udpBatch int) (*node.Node, error) {
 	logger := log.New(os.Stderr, "["+name+"] ", log.Ltime|log.Lshortfile)
 
 	node := node.NewNode(rovy.MustGeneratePrivateKey(), logger)
+	if err := node.SetUDPBackend(udpBackend); err != nil {
+		return nil, err
+	}
+	if err := node.SetUDPBatchSize(udpBatch); err != nil {
+		return nil, err
+	}
 	if _, err := node.Start(); err != nil {
 		return node, err
 	}
@@ -34,6 +40,10 @@ func newNode(name string, lisaddr rovy.Multiaddr) (*node.Node, error) {
 
 func run() error {
 	cpuprof := flag.String("cpuprofile", "", "write cpu profile to `file`")
+	memprof := flag.String("memprofile", "", "write allocation profile to `file`")
+	udpBackend := flag.String("udp-backend", node.UDPBackendStd, "UDP transport `backend`, std or io_uring")
+	udpBatch := flag.Int("udp-batch", node.UDPBatchSize, "messages per syscall of the std UDP backend, 1 disables batching, GSO and GRO")
+	amount := flag.Int("n", 1000000, "number of packets")
 	flag.Parse()
 	if *cpuprof != "" {
 		f, err := os.Create(*cpuprof)
@@ -51,11 +61,11 @@ func run() error {
 	addrA := rovy.MustParseMultiaddr("/ip6/::1/udp/12345")
 	addrB := rovy.MustParseMultiaddr("/ip6/::1/udp/12346")
 
-	nodeA, err := newNode("nodeA", addrA)
+	nodeA, err := newNode("nodeA", addrA, *udpBackend, *udpBatch)
 	if err != nil {
 		return err
 	}
-	nodeB, err := newNode("nodeB", addrB)
+	nodeB, err := newNode("nodeB", addrB, *udpBackend, *udpBatch)
 	if err != nil {
 		return err
 	}
@@ -64,24 +74,25 @@ func run() error {
 		return err
 	}
 
-	amount := 1000000
 	mtu := rovy.UpperMTU
+	var mstart runtime.MemStats
+	runtime.ReadMemStats(&mstart)
 	start := time.Now()
 
 	var j int
 	nodeB.Handle(BenchmarkCodec, func(pkt rovy.UpperPacket) error {
-		_, err := binary.ReadVarint(bytes.NewBuffer(pkt.Payload()))
-		if err != nil {
-			log.Printf("ReadVarint: %s", err)
-			return err
+		if _, n := binary.Varint(pkt.Payload()); n <= 0 {
+			log.Printf("Varint: invalid varint")
+			return fmt.Errorf("invalid varint")
 		}
 		j += 1
 		return nil
 	})
 
-	nodeA.Log().Printf("sending %d packets, %d bytes each", amount, mtu)
-	for i := 1; i <= amount; i++ {
-		p := make([]byte, mtu)
+	nodeA.Log().Printf("sending %d packets, %d bytes each", *amount, mtu)
+	// Send copies the payload, so we can reuse it
+	p := make([]byte, mtu)
+	for i := 1; i <= *amount; i++ {
 		binary.PutVarint(p, int64(i))
 		if err := nodeA.Send(nodeB.PeerID(), BenchmarkCodec, p); err != nil {
 			return err
@@ -92,8 +103,22 @@ func run() error {
 	time.Sleep(250 * time.Millisecond)
 
 	duration := time.Now().Sub(start)
+	var mend runtime.MemStats
+	runtime.ReadMemStats(&mend)
 	gbps := float64(j*mtu) * 8 / 1000 / 1000 / 1000 / duration.Seconds()
-	nodeB.Log().Printf("received %d packets, took %s, %.2f Gbps", j, duration, gbps)
+	allocs := float64(mend.Mallocs-mstart.Mallocs) / float64(*amount)
+	nodeB.Log().Printf("received %d packets, took %s, %.2f Gbps, %.2f allocs/packet", j, duration, gbps, allocs)
+
+	if *memprof != "" {
+		f, err := os.Create(*memprof)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err = pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
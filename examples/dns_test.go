@@ -0,0 +1,231 @@
+package examples_test
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	dns "github.com/miekg/dns"
+
+	rovy "go.rovy.net"
+	fcnet "go.rovy.net/fcnet"
+	petname "go.rovy.net/node/petname"
+)
+
+func TestReverseDNS(t *testing.T) {
+	local := rovy.NewPeerID(rovy.MustGeneratePrivateKey().PublicKey())
+	remote := rovy.NewPeerID(rovy.MustGeneratePrivateKey().PublicKey())
+	unknown := rovy.NewPeerID(rovy.MustGeneratePrivateKey().PublicKey())
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: fcnet.DNSHandler{
+		LocalPeerID: local,
+		LookupIPv6: func(ip netip.Addr) (rovy.PeerID, error) {
+			if ip == remote.PublicKey().IPAddr() {
+				return remote, nil
+			}
+			return rovy.PeerID{}, errors.New("address unknown")
+		},
+	}}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	query := func(pid rovy.PeerID) *dns.Msg {
+		name, err := dns.ReverseAddr(pid.PublicKey().IPAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypePTR)
+		res, err := dns.Exchange(m, pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, pid := range []rovy.PeerID{local, remote} {
+		res := query(pid)
+		if len(res.Answer) != 1 {
+			t.Fatalf("expected one answer for %s, got %v", pid, res)
+		}
+		if ptr := res.Answer[0].(*dns.PTR).Ptr; ptr != pid.String()+".rovy." {
+			t.Fatalf("expected %s.rovy., got %s", pid, ptr)
+		}
+	}
+
+	if res := query(unknown); res.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN for unknown address, got %s", dns.RcodeToString[res.Rcode])
+	}
+}
+
+func TestPetnames(t *testing.T) {
+	pid := rovy.NewPeerID(rovy.MustGeneratePrivateKey().PublicKey())
+	path := filepath.Join(t.TempDir(), "petnames.toml")
+
+	store := petname.NewStore()
+	if err := store.SetFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("desktop", pid); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "Desktop", "-desktop", "a.b", "localhost", "bafzqaifoo"} {
+		if err := store.Add(name, pid); err == nil {
+			t.Fatalf("expected error for petname %q", name)
+		}
+	}
+
+	// the file is loaded again on the next start
+	store = petname.NewStore()
+	if err := store.SetFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if pid2, present := store.Lookup("desktop"); !present || pid2 != pid {
+		t.Fatalf("expected desktop to be %s, got %s", pid, pid2)
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: fcnet.DNSHandler{Petnames: store}}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	m := new(dns.Msg)
+	m.SetQuestion("desktop.rovy.", dns.TypeAAAA)
+	res, err := dns.Exchange(m, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Answer) != 1 || !res.Answer[0].(*dns.AAAA).AAAA.Equal(pid.PublicKey().IPAddr().AsSlice()) {
+		t.Fatalf("expected %s for desktop.rovy, got %v", pid.PublicKey().IPAddr(), res)
+	}
+
+	if err := store.Remove("desktop"); err != nil {
+		t.Fatal(err)
+	}
+	res, err = dns.Exchange(m, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN after removing petname, got %s", dns.RcodeToString[res.Rcode])
+	}
+}
+
+func TestDNSRecordsAndForwarding(t *testing.T) {
+	local := rovy.NewPeerID(rovy.MustGeneratePrivateKey().PublicKey())
+
+	// the upstream answers everything with 192.0.2.1
+	upc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uplis, err := net.Listen("tcp", upc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	uphandler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		w.WriteMsg(m)
+	})
+	upstream := &dns.Server{PacketConn: upc, Handler: uphandler}
+	go upstream.ActivateAndServe()
+	defer upstream.Shutdown()
+	upstreamTCP := &dns.Server{Listener: uplis, Handler: uphandler}
+	go upstreamTCP.ActivateAndServe()
+	defer upstreamTCP.Shutdown()
+
+	var upstreams fcnet.Upstreams
+	if err := upstreams.Set([]string{"fc00::1"}); err == nil {
+		t.Fatal("expected error for fc00::1 as upstream")
+	}
+	if err := upstreams.Set([]string{upc.LocalAddr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	handler := fcnet.DNSHandler{LocalPeerID: local, Upstreams: &upstreams}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: handler}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvTCP := &dns.Server{Listener: lis, Handler: handler}
+	go srvTCP.ActivateAndServe()
+	defer srvTCP.Shutdown()
+
+	exchange := func(network string, m *dns.Msg) *dns.Msg {
+		addr := pc.LocalAddr().String()
+		if network == "tcp" {
+			addr = lis.Addr().String()
+		}
+		c := &dns.Client{Net: network}
+		res, _, err := c.Exchange(m, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// no question at all
+	if res := exchange("udp", &dns.Msg{}); res.Rcode != dns.RcodeFormatError {
+		t.Fatalf("expected FORMERR for empty question, got %s", dns.RcodeToString[res.Rcode])
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		m := new(dns.Msg)
+		m.SetQuestion("localhost.rovy.", dns.TypeANY)
+		m.SetEdns0(4096, false)
+		res := exchange(network, m)
+		if len(res.Answer) != 2 || res.IsEdns0() == nil {
+			t.Fatalf("%s: expected AAAA and TXT with EDNS, got %v", network, res)
+		}
+
+		m.SetQuestion("localhost.rovy.", dns.TypeA)
+		if res := exchange(network, m); res.Rcode != dns.RcodeSuccess || len(res.Answer) != 0 {
+			t.Fatalf("%s: expected empty answer for A, got %v", network, res)
+		}
+
+		m.SetQuestion("_http._tcp.localhost.rovy.", dns.TypeSRV)
+		if res := exchange(network, m); res.Rcode != dns.RcodeSuccess || len(res.Answer) != 0 {
+			t.Fatalf("%s: expected empty answer for SRV, got %v", network, res)
+		}
+
+		// without EDNS, there's no room for extended rcodes like BADNAME
+		broken := new(dns.Msg)
+		broken.SetQuestion("bafzqaibroken.rovy.", dns.TypeAAAA)
+		if res := exchange(network, broken); res.Rcode != dns.RcodeNameError {
+			t.Fatalf("%s: expected NXDOMAIN for malformed PeerID, got %v", network, res)
+		}
+
+		m.SetQuestion("www.localhost.rovy.", dns.TypeAAAA)
+		if res := exchange(network, m); res.Rcode != dns.RcodeNameError {
+			t.Fatalf("%s: expected NXDOMAIN for subdomain, got %v", network, res)
+		}
+
+		m.SetQuestion("example.com.", dns.TypeA)
+		res = exchange(network, m)
+		if len(res.Answer) != 1 || !res.Answer[0].(*dns.A).A.Equal(net.ParseIP("192.0.2.1")) {
+			t.Fatalf("%s: expected forwarded answer, got %v", network, res)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+package examples_test
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	netlink "github.com/vishvananda/netlink"
+	netns "github.com/vishvananda/netns"
+
+	rovy "go.rovy.net"
+	node "go.rovy.net/node"
+)
+
+// TestEthernet connects two nodes over a veth pair,
+// each of them in its own network namespace.
+func TestEthernet(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating network namespaces requires root")
+	}
+
+	codec := uint64(0x42001)
+	payload := []byte{0x42, 0x42, 0x42, 0x42}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origns.Close()
+	defer netns.Set(origns)
+
+	// netns.New switches into the new namespace right away
+	nsA, err := netns.New()
+	if err != nil {
+		t.Skipf("can't create network namespace: %s", err)
+	}
+	defer nsA.Close()
+	nsB, err := netns.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nsB.Close()
+	if err := netns.Set(origns); err != nil {
+		t.Fatal(err)
+	}
+
+	veth := &netlink.Veth{
+		LinkAttrs:     netlink.LinkAttrs{Name: "rovyA", Namespace: netlink.NsFd(nsA)},
+		PeerName:      "rovyB",
+		PeerNamespace: netlink.NsFd(nsB),
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Skipf("can't create veth pair: %s", err)
+	}
+
+	startNode := func(name string, ns netns.NsHandle, ifname string) *node.Node {
+		if err := netns.Set(ns); err != nil {
+			t.Fatal(err)
+		}
+		defer netns.Set(origns)
+
+		link, err := netlink.LinkByName(ifname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := netlink.LinkSetUp(link); err != nil {
+			t.Fatal(err)
+		}
+
+		// the socket is created within the namespace, and stays there
+		n, err := newNode(name, rovy.MustParseMultiaddr("/ethif/"+ifname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	nodeA := startNode("nodeA", nsA, "rovyA")
+	defer nodeA.Stop()
+	nodeB := startNode("nodeB", nsB, "rovyB")
+	defer nodeB.Stop()
+
+	ps, err := nodeB.Peer().Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.Listeners) != 1 || len(ps.Listeners[0].EffectiveAddrs) != 1 {
+		t.Fatalf("expected one effective address, got %+v", ps.Listeners)
+	}
+	addrB := rovy.Multiaddr{Ifname: "rovyA", MAC: ps.Listeners[0].EffectiveAddrs[0].MAC}
+
+	echo := make(chan []byte, 1)
+	nodeB.Handle(codec, func(pkt rovy.UpperPacket) error {
+		echo <- append([]byte{}, pkt.Payload()...)
+		return nil
+	})
+
+	if err := nodeA.Connect(nodeB.PeerID(), addrB); err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+	if err := nodeA.Send(nodeB.PeerID(), codec, payload); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	select {
+	case pl := <-echo:
+		if !bytes.Equal(pl, payload) {
+			t.Fatalf("expected %#v but got %#v", payload, pl)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+}
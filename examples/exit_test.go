@@ -0,0 +1,180 @@
+package examples_test
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	ipv6 "golang.org/x/net/ipv6"
+
+	rovy "go.rovy.net"
+	fcnet "go.rovy.net/fcnet"
+	node "go.rovy.net/node"
+)
+
+// A uses B as exit to reach an address on the Internet.
+func TestExit(t *testing.T) {
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+		t.Fatal(err)
+	}
+
+	devA, devB := newChanDevice(), newChanDevice()
+	fcA, fcB := fcnet.NewFcnet(nodeA, devA), fcnet.NewFcnet(nodeB, devB)
+	if err := fcA.Start(rovy.UpperMTU); err != nil {
+		t.Fatal(err)
+	}
+	defer fcA.Stop()
+	if err := fcB.Start(rovy.UpperMTU); err != nil {
+		t.Fatal(err)
+	}
+	defer fcB.Stop()
+
+	if err := fcA.Exit().Set(false, nil, []rovy.PeerID{nodeB.PeerID()}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fcB.Exit().Set(true, []rovy.PeerID{nodeA.PeerID()}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitExit(t, fcA, nodeB.PeerID())
+
+	inet := netip.MustParseAddr("2001:db8::1")
+	devA.in <- udpPacket(nodeA.IPAddr(), inet, 100)
+	p := recvFrom(t, devB, nodeA.IPAddr())
+	if dst := netip.AddrFrom16(*(*[16]byte)(p[24:40])); dst != inet {
+		t.Fatalf("expected packet to %s, got %s", inet, dst)
+	}
+
+	// the exit doesn't forward anything but global unicast, e.g. into its LAN,
+	// even if the client sends it directly
+	ula := netip.MustParseAddr("fd12::1")
+	if err := nodeA.Send(nodeB.PeerID(), fcnet.FcnetExitMulticodec, udpPacket(nodeA.IPAddr(), ula, 100)); err != nil {
+		t.Fatal(err)
+	}
+	devA.in <- udpPacket(nodeA.IPAddr(), inet, 100)
+	p = recvFrom(t, devB, nodeA.IPAddr())
+	if dst := netip.AddrFrom16(*(*[16]byte)(p[24:40])); dst != inet {
+		t.Fatalf("expected packet to %s, got %s", inet, dst)
+	}
+	if err := fcA.Exit().Set(false, nil, []rovy.PeerID{nodeB.PeerID()}, []netip.Prefix{netip.MustParsePrefix("::/0")}); err == nil {
+		t.Fatal("expected error for route outside of 2000::/3")
+	}
+
+	// an unsolicited packet from the Internet doesn't get through A's firewall,
+	// but the reply does
+	other := netip.MustParseAddr("2001:db8::2")
+	devB.in <- udpPacket(other, nodeA.IPAddr(), 100)
+	reply := udpPacket(inet, nodeA.IPAddr(), 100)
+	binary.BigEndian.PutUint16(reply[40:42], 9)
+	binary.BigEndian.PutUint16(reply[42:44], 12345)
+	devB.in <- reply
+	p = recvFrom(t, devA, inet)
+	if dst := netip.AddrFrom16(*(*[16]byte)(p[24:40])); dst != nodeA.IPAddr() {
+		t.Fatalf("expected reply to %s, got %s", nodeA.IPAddr(), dst)
+	}
+	for i := 0; fcA.Stats().FirewallDropped != 1; i++ {
+		if i == 100 {
+			t.Fatalf("expected 1 dropped packet, got %d", fcA.Stats().FirewallDropped)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A configured exit that doesn't serve A isn't selected.
+func TestExitNotServing(t *testing.T) {
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+		t.Fatal(err)
+	}
+
+	fcA, fcB := fcnet.NewFcnet(nodeA, newChanDevice()), fcnet.NewFcnet(nodeB, newChanDevice())
+	if err := fcA.Start(rovy.UpperMTU); err != nil {
+		t.Fatal(err)
+	}
+	defer fcA.Stop()
+	if err := fcB.Start(rovy.UpperMTU); err != nil {
+		t.Fatal(err)
+	}
+	defer fcB.Stop()
+
+	if err := fcA.Exit().Set(false, nil, []rovy.PeerID{nodeB.PeerID()}, nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if pid, ok := fcA.SelectedExit(); ok {
+		t.Fatalf("expected no exit, got %s", pid)
+	}
+
+	// once B serves A, it advertises itself, and A selects it
+	if err := fcB.Exit().Set(true, []rovy.PeerID{nodeA.PeerID()}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitExit(t, fcA, nodeB.PeerID())
+
+	// and once it stops, A forgets it
+	if err := fcB.Exit().Set(false, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if _, ok := fcA.SelectedExit(); !ok {
+			break
+		}
+		if i == 100 {
+			t.Fatal("expected no exit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitExit waits until fc selected the exit, which happens once it advertised itself.
+func waitExit(t *testing.T, fc *fcnet.Fcnet, exit rovy.PeerID) {
+	for i := 0; ; i++ {
+		if pid, ok := fc.SelectedExit(); ok && pid == exit {
+			return
+		}
+		if i == 100 {
+			t.Fatalf("expected exit %s", exit)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// recvFrom returns the next IPv6 packet from src that's written to the device.
+func recvFrom(t *testing.T, dev *chanDevice, src netip.Addr) []byte {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case p := <-dev.out:
+			if len(p) >= ipv6.HeaderLen && p[0]>>4 == 6 && netip.AddrFrom16(*(*[16]byte)(p[8:24])) == src {
+				return p
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for packet from %s", src)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+//go:build linux
+
+package examples_test
+
+import (
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	netlink "github.com/vishvananda/netlink"
+	netns "github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+
+	rovy "go.rovy.net"
+	fcnet "go.rovy.net/fcnet"
+	node "go.rovy.net/node"
+)
+
+// TestExitNetns has A use B as exit, each of them in its own network namespace,
+// with real TUN devices. The Internet is 2001:db8::1 on B's loopback interface,
+// and A reaches it over UDP through its route for 2000::/3.
+func TestExitNetns(t *testing.T) {
+	nsA, nsB, within := vethNamespaces(t, 1500)
+	inet := netip.MustParseAddr("2001:db8::1")
+
+	startNode := func(name string, ns netns.NsHandle, addr string) (n *node.Node, fc *fcnet.Fcnet) {
+		within(ns, func() {
+			logger := log.New(os.Stderr, "["+name+"] ", log.Ltime|log.Lshortfile)
+
+			var err error
+			n, err = newNode(name, rovy.MustParseMultiaddr("/ip6/"+addr+"/udp/12295"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { n.Stop() })
+
+			dev, err := fcnet.NetlinkTun("rovy0", n.IPAddr().AsSlice(), rovy.UpperMTU, logger)
+			if err != nil {
+				t.Skipf("can't create tun device: %s", err)
+			}
+			fc = fcnet.NewFcnet(n, dev)
+			if err := fc.Start(rovy.UpperMTU); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { fc.Stop() })
+		})
+		return n, fc
+	}
+	nodeA, fcA := startNode("nodeA", nsA, "fd00::1")
+	nodeB, fcB := startNode("nodeB", nsB, "fd00::2")
+
+	within(nsB, func() {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipnet := &net.IPNet{IP: inet.AsSlice(), Mask: net.CIDRMask(128, 128)}
+		if err := netlink.AddrAdd(lo, &netlink.Addr{IPNet: ipnet, Flags: unix.IFA_F_NODAD}); err != nil {
+			t.Fatal(err)
+		}
+		if err := netlink.LinkSetUp(lo); err != nil {
+			t.Fatal(err)
+		}
+	})
+	within(nsA, func() {
+		logger := log.New(os.Stderr, "[nodeA] ", log.Ltime|log.Lshortfile)
+		routes := []netip.Prefix{netip.MustParsePrefix("2000::/3")}
+		if err := fcnet.NetlinkAddExitRoutes("rovy0", nodeA.IPAddr(), routes, logger); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/ip6/fd00::2/udp/12295")); err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+	if err := fcA.Exit().Set(false, nil, []rovy.PeerID{nodeB.PeerID()}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fcB.Exit().Set(true, []rovy.PeerID{nodeA.PeerID()}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitExit(t, fcA, nodeB.PeerID())
+
+	// the sockets stay in the namespace they were created in
+	var server, client *net.UDPConn
+	within(nsB, func() {
+		var err error
+		server, err = net.ListenUDP("udp6", &net.UDPAddr{IP: inet.AsSlice(), Port: 12296})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer server.Close()
+	within(nsA, func() {
+		var err error
+		client, err = net.DialUDP("udp6", nil, &net.UDPAddr{IP: inet.AsSlice(), Port: 12296})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer client.Close()
+
+	payload := []byte("hello internet")
+	if _, err := client.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1500)
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, raddr, err := server.ReadFromUDPAddrPort(buf)
+	if err != nil {
+		t.Fatalf("exit didn't forward: %s", err)
+	}
+	if string(buf[:n]) != string(payload) {
+		t.Fatalf("expected %q, got %q", payload, buf[:n])
+	}
+	if src := raddr.Addr(); src != nodeA.IPAddr() {
+		t.Fatalf("expected packet from %s, got %s", nodeA.IPAddr(), src)
+	}
+
+	// the reply comes back through the exit and A's firewall
+	if _, err := server.WriteToUDPAddrPort(buf[:n], raddr); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = client.Read(buf)
+	if err != nil {
+		t.Fatalf("no reply: %s", err)
+	}
+	if string(buf[:n]) != string(payload) {
+		t.Fatalf("expected %q, got %q", payload, buf[:n])
+	}
+}
@@ -102,6 +102,7 @@ func run() error {
 	if err := fcnetD.Start(rovy.UpperMTU); err != nil {
 		return err
 	}
+	fcnetD.Firewall().SetRules([]fcnet.PortRule{{Proto: "tcp", Port: 80}, {Proto: "icmp"}}, nil)
 
 	if err := nodeA.Connect(nodeB.PeerID(), addrB); err != nil {
 		nodeA.Log().Printf("failed to connect nodeA to nodeB: %s", err)
@@ -0,0 +1,49 @@
+package examples_test
+
+import (
+	"net/netip"
+	"testing"
+
+	wgnet "golang.zx2c4.com/wireguard/tun/netstack"
+
+	rovy "go.rovy.net"
+	fcnet "go.rovy.net/fcnet"
+	node "go.rovy.net/node"
+)
+
+func TestFcnetRestart(t *testing.T) {
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+	n, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		dev, _, err := wgnet.CreateNetTUN([]netip.Addr{n.IPAddr()}, nil, rovy.UpperMTU)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fc := fcnet.NewFcnet(n, dev)
+		if err := fc.Start(rovy.UpperMTU); err != nil {
+			t.Fatalf("start #%d: %s", i, err)
+		}
+
+		st := fc.Status()
+		if !st.Running || !st.DNSRunning || st.MTU != rovy.UpperMTU || st.Addr != n.IPAddr() {
+			t.Fatalf("unexpected status after start: %+v", st)
+		}
+
+		if err := fc.Stop(); err != nil {
+			t.Fatalf("stop #%d: %s", i, err)
+		}
+		if fc.Running() {
+			t.Fatal("expected fcnet to be stopped")
+		}
+		if err := fc.Stop(); err != fcnet.ErrNotRunning {
+			t.Fatalf("expected ErrNotRunning, got %v", err)
+		}
+		if err := fc.Start(rovy.UpperMTU); err != fcnet.ErrStopped {
+			t.Fatalf("expected ErrStopped, got %v", err)
+		}
+	}
+}
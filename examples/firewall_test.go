@@ -0,0 +1,124 @@
+package examples_test
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	rovy "go.rovy.net"
+	fcnet "go.rovy.net/fcnet"
+)
+
+// ipv6Packet returns a minimal IPv6 packet whose payload starts with the
+// given source and destination ports, or ICMPv6 type and echo identifier.
+func ipv6Packet(nexthdr byte, src, dst netip.Addr, a, b uint16) []byte {
+	pkt := make([]byte, 40+8)
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:6], 8)
+	pkt[6] = nexthdr
+	pkt[7] = 64
+	copy(pkt[8:24], src.AsSlice())
+	copy(pkt[24:40], dst.AsSlice())
+	if nexthdr == 58 {
+		pkt[40] = byte(a)
+		binary.BigEndian.PutUint16(pkt[44:46], b)
+	} else {
+		binary.BigEndian.PutUint16(pkt[40:42], a)
+		binary.BigEndian.PutUint16(pkt[42:44], b)
+	}
+	return pkt
+}
+
+// fragment inserts a fragment header into an IPv6 packet.
+func fragment(pkt []byte, id uint32, offset uint16, more bool) []byte {
+	frag := make([]byte, 0, len(pkt)+8)
+	frag = append(frag, pkt[:40]...)
+	frag = append(frag, pkt[6], 0, 0, 0, 0, 0, 0, 0)
+	frag = append(frag, pkt[40:]...)
+	frag[6] = 44
+	binary.BigEndian.PutUint16(frag[4:6], uint16(len(frag)-40))
+	fo := offset &^ 0x7
+	if more {
+		fo |= 0x1
+	}
+	binary.BigEndian.PutUint16(frag[42:44], fo)
+	binary.BigEndian.PutUint32(frag[44:48], id)
+	return frag
+}
+
+func TestFirewall(t *testing.T) {
+	local := rovy.MustGeneratePrivateKey().PublicKey().IPAddr()
+	remotePeer := rovy.NewPeerID(rovy.MustGeneratePrivateKey().PublicKey())
+	remote := remotePeer.PublicKey().IPAddr()
+
+	fw := fcnet.NewFirewall(nil)
+
+	if fw.Inbound(remotePeer, ipv6Packet(6, remote, local, 40000, 22)) {
+		t.Fatal("expected unsolicited tcp to be dropped")
+	}
+
+	pr, err := fcnet.ParsePortRule("tcp/22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.SetRules([]fcnet.PortRule{pr}, nil)
+	if !fw.Inbound(remotePeer, ipv6Packet(6, remote, local, 40000, 22)) {
+		t.Fatal("expected tcp/22 to be allowed")
+	}
+	if fw.Inbound(remotePeer, ipv6Packet(17, remote, local, 40000, 22)) {
+		t.Fatal("expected udp/22 to be dropped")
+	}
+
+	// replies to outbound flows are let in, but only on the same ports
+	fw.Outbound(ipv6Packet(17, local, remote, 50000, 53))
+	if !fw.Inbound(remotePeer, ipv6Packet(17, remote, local, 53, 50000)) {
+		t.Fatal("expected reply to outbound udp flow to be allowed")
+	}
+	if fw.Inbound(remotePeer, ipv6Packet(17, remote, local, 53, 50001)) {
+		t.Fatal("expected udp to other port to be dropped")
+	}
+
+	// later fragments are let through if the first one was
+	if fw.Inbound(remotePeer, fragment(ipv6Packet(17, remote, local, 0, 0), 1, 1232, false)) {
+		t.Fatal("expected fragment without first fragment to be dropped")
+	}
+	if fw.Inbound(remotePeer, fragment(ipv6Packet(17, remote, local, 53, 50001), 2, 0, true)) {
+		t.Fatal("expected first fragment to other port to be dropped")
+	}
+	if fw.Inbound(remotePeer, fragment(ipv6Packet(17, remote, local, 0, 0), 2, 1232, false)) {
+		t.Fatal("expected fragment of dropped packet to be dropped")
+	}
+	if !fw.Inbound(remotePeer, fragment(ipv6Packet(17, remote, local, 53, 50000), 3, 0, true)) {
+		t.Fatal("expected first fragment of reply to be allowed")
+	}
+	if !fw.Inbound(remotePeer, fragment(ipv6Packet(17, remote, local, 0, 0), 3, 1232, false)) {
+		t.Fatal("expected later fragment of reply to be allowed")
+	}
+	if !fw.Inbound(remotePeer, fragment(ipv6Packet(17, remote, local, 53, 50000), 4, 0, false)) {
+		t.Fatal("expected atomic fragment of reply to be allowed")
+	}
+
+	// echo replies need an echo request, echo requests need icmp to be allowed
+	if fw.Inbound(remotePeer, ipv6Packet(58, remote, local, 129, 7)) {
+		t.Fatal("expected unsolicited echo reply to be dropped")
+	}
+	fw.Outbound(ipv6Packet(58, local, remote, 128, 7))
+	if !fw.Inbound(remotePeer, ipv6Packet(58, remote, local, 129, 7)) {
+		t.Fatal("expected echo reply to be allowed")
+	}
+	if fw.Inbound(remotePeer, ipv6Packet(58, remote, local, 128, 7)) {
+		t.Fatal("expected echo request to be dropped")
+	}
+
+	fw.SetRules(nil, []rovy.PeerID{remotePeer})
+	if !fw.Inbound(remotePeer, ipv6Packet(6, remote, local, 40000, 443)) {
+		t.Fatal("expected everything from allowed peer")
+	}
+
+	if _, err := fcnet.ParsePortRule("icmp/1"); err == nil {
+		t.Fatal("expected error for icmp with port")
+	}
+	if _, err := fcnet.ParsePortRule("sctp/1"); err == nil {
+		t.Fatal("expected error for unknown protocol")
+	}
+}
@@ -0,0 +1,111 @@
+package examples_test
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+	fcnet "go.rovy.net/fcnet"
+	node "go.rovy.net/node"
+)
+
+// A reaches udp/53 in the prefix exported by B, but nothing else there.
+func TestIPv4(t *testing.T) {
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+		t.Fatal(err)
+	}
+
+	devA, devB := newChanDevice(), newChanDevice()
+	fcA, fcB := fcnet.NewFcnet(nodeA, devA), fcnet.NewFcnet(nodeB, devB)
+	if err := fcA.Start(rovy.UpperMTU); err != nil {
+		t.Fatal(err)
+	}
+	defer fcA.Stop()
+	if err := fcB.Start(rovy.UpperMTU); err != nil {
+		t.Fatal(err)
+	}
+	defer fcB.Stop()
+
+	addrA, addrB := netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("100.64.0.2")
+	export, err := fcnet.ParseExport("10.42.0.0/24 udp/53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fcB.IPv4().Set(addrB, nil, []fcnet.Export{export}, []rovy.PeerID{nodeA.PeerID()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fcA.IPv4().Set(addrA, nil, nil, []rovy.PeerID{nodeB.PeerID()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A's advertisement goes out right away, and B answers with its own
+	for i := 0; len(fcA.IPv4().Routes()) < 2 || len(fcB.IPv4().Routes()) < 1; i++ {
+		if i == 100 {
+			t.Fatalf("routes weren't learned: A=%v B=%v", fcA.IPv4().Routes(), fcB.IPv4().Routes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dns := netip.MustParseAddr("10.42.0.7")
+	devA.in <- udp4Packet(addrA, dns, 80, 100)
+	devA.in <- udp4Packet(addrA, dns, 53, 100)
+	p := recv4(t, devB)
+	if dst := netip.AddrFrom4(*(*[4]byte)(p[16:20])); dst != dns {
+		t.Fatalf("expected packet to %s, got %s", dns, dst)
+	}
+	if port := binary.BigEndian.Uint16(p[22:24]); port != 53 {
+		t.Fatalf("expected udp/53 to be let through, got udp/%d", port)
+	}
+
+	// the reply comes from the exported prefix
+	devB.in <- udp4Packet(dns, addrA, 12345, 100)
+	p = recv4(t, devA)
+	if src := netip.AddrFrom4(*(*[4]byte)(p[12:16])); src != dns {
+		t.Fatalf("expected reply from %s, got %s", dns, src)
+	}
+}
+
+// recv4 returns the next IPv4 packet written to the device.
+func recv4(t *testing.T, dev *chanDevice) []byte {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case p := <-dev.out:
+			if len(p) >= 20 && p[0]>>4 == 4 {
+				return p
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for ipv4 packet")
+		}
+	}
+}
+
+// udp4Packet returns an IPv4 packet of the given length with a UDP header.
+func udp4Packet(src, dst netip.Addr, port uint16, length int) []byte {
+	p := make([]byte, length)
+	p[0] = 0x45
+	binary.BigEndian.PutUint16(p[2:4], uint16(length))
+	p[8] = 64
+	p[9] = 17
+	copy(p[12:16], src.AsSlice())
+	copy(p[16:20], dst.AsSlice())
+	binary.BigEndian.PutUint16(p[20:22], 12345)
+	binary.BigEndian.PutUint16(p[22:24], port)
+	binary.BigEndian.PutUint16(p[24:26], uint16(length-20))
+	return p
+}
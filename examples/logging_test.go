@@ -0,0 +1,44 @@
+package examples_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	rovy "go.rovy.net"
+	rapi "go.rovy.net/api"
+	node "go.rovy.net/node"
+	logging "go.rovy.net/node/util/logging"
+)
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	n := node.NewNode(rovy.MustGeneratePrivateKey(), log.New(&buf, "", 0))
+
+	if _, err := n.Logging().SetLevel(rapi.LogLevel{Subsystem: "nonexistent", Level: "debug"}); err == nil {
+		t.Fatal("expected error for unknown subsystem")
+	}
+	if _, err := n.Logging().SetLevel(rapi.LogLevel{Subsystem: node.LogSession, Level: "loud"}); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+	ll, err := n.Logging().SetLevel(rapi.LogLevel{Subsystem: node.LogSession, Level: "warning"})
+	if err != nil || ll.Level != "warn" {
+		t.Fatalf("unexpected result: %+v %v", ll, err)
+	}
+
+	l := n.Logger(node.LogSession)
+	l.Info("not written")
+	for i := 0; i < logging.RateBurst+5; i++ {
+		l.Warn("dropping packet", "n", i, "reason", "no session")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != logging.RateBurst {
+		t.Fatalf("expected %d lines, got %d:\n%s", logging.RateBurst, len(lines), buf.String())
+	}
+	expected := `level=warn subsys=session msg="dropping packet" n=0 reason="no session"`
+	if lines[0] != expected {
+		t.Fatalf("expected %q, got %q", expected, lines[0])
+	}
+}
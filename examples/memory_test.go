@@ -0,0 +1,92 @@
+package examples_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+	node "go.rovy.net/node"
+)
+
+func TestMemoryNetwork(t *testing.T) {
+	t.Parallel()
+
+	codec := uint64(0x42001)
+	latency := 20 * time.Millisecond
+
+	mn := node.NewMemoryNetwork(node.MemoryOptions{Latency: latency})
+
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	recv := make(chan int, 2)
+	nodeB.Handle(codec, func(pkt rovy.UpperPacket) error {
+		recv <- len(pkt.Payload())
+		return nil
+	})
+
+	// the handshake is a full round trip
+	start := time.Now()
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+	if d := time.Since(start); d < 2*latency {
+		t.Fatalf("handshake took %s, expected at least %s", d, 2*latency)
+	}
+
+	// packets beyond the MTU don't make it to nodeB.
+	// hello packets take up the full TptMTU, so we only lower it now.
+	mn.SetOptions(node.MemoryOptions{Latency: latency, MTU: 600})
+	for _, size := range []int{1000, 100} {
+		if err := nodeA.Send(nodeB.PeerID(), codec, make([]byte, size)); err != nil {
+			t.Fatalf("send: %s", err)
+		}
+	}
+	select {
+	case n := <-recv:
+		if n != 100 {
+			t.Fatalf("expected the 100 byte packet, got %d bytes", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+	select {
+	case n := <-recv:
+		t.Fatalf("unexpected packet of %d bytes", n)
+	case <-time.After(2 * latency):
+	}
+}
+
+// Without latency the handshake can complete before Connect starts waiting for it,
+// which must not make Connect time out.
+func TestMemoryConnectNoLatency(t *testing.T) {
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("node%d", i)
+		n, err := newMemoryNode(name, mn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer n.Stop()
+
+		if err := nodeA.Connect(n.PeerID(), rovy.MustParseMultiaddr("/memory/"+name)); err != nil {
+			t.Fatalf("connect to %s: %s", name, err)
+		}
+	}
+}
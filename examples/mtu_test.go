@@ -0,0 +1,94 @@
+//go:build linux
+
+package examples_test
+
+import (
+	"errors"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+	node "go.rovy.net/node"
+	logging "go.rovy.net/node/util/logging"
+	ringbuf "go.rovy.net/node/util/ringbuf"
+)
+
+// The real transports report packets larger than the link's MTU of 1300 bytes
+// with PacketTooLargeError. UDP only knows after the kernel refused one.
+func TestTransportMTU(t *testing.T) {
+	nsA, _, within := vethNamespaces(t, 1300)
+	logger := logging.New(node.LogTransport, log.New(os.Stderr, "[mtu] ", log.Ltime|log.Lshortfile))
+
+	newPacket := func(length int, dst rovy.Multiaddr) rovy.Packet {
+		pkt := rovy.AllocPacket()
+		pkt.Length = length
+		pkt.TptDst = dst
+		return pkt
+	}
+	expectTooLarge := func(err error, mtu int) {
+		t.Helper()
+		var tooLarge node.PacketTooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.MTU != mtu {
+			t.Fatalf("expected PacketTooLargeError with MTU %d, got %v", mtu, err)
+		}
+	}
+
+	var tpts []node.Transport
+	within(nsA, func() {
+		eth, err := node.NewEthernetTransport(rovy.MustParseMultiaddr("/ethif/rovyA"), logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		udp, err := node.NewUDPTransport(rovy.MustParseMultiaddr("/ip6/fd00::1/udp/12290"), logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tpts = append(tpts, eth, udp)
+		if node.IOUringSupported() == nil {
+			uring, err := node.NewIOUringTransport(rovy.MustParseMultiaddr("/ip6/fd00::1/udp/12291"), logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tpts = append(tpts, uring)
+		}
+		for _, tpt := range tpts {
+			if err := tpt.Start(ringbuf.NewRingBuffer(16)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	defer func() {
+		for _, tpt := range tpts {
+			tpt.Stop()
+		}
+	}()
+
+	// 1300 minus the 2 bytes of length prefix
+	mac := rovy.Multiaddr{Ifname: "rovyA", MAC: [6]byte{0x02, 0, 0, 0, 0, 0x01}}
+	expectTooLarge(tpts[0].Send(newPacket(rovy.TptMTU, mac)), 1298)
+	if err := tpts[0].Send(newPacket(1298, mac)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1300 minus the IPv6 and UDP headers
+	dst := rovy.MustParseMultiaddr("/ip6/fd00::2/udp/12290")
+	for _, tpt := range tpts[1:] {
+		if err := tpt.Send(newPacket(1252, dst)); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		for i := 0; err == nil; i++ {
+			if i == 100 {
+				t.Fatalf("%s: expected PacketTooLargeError", tpt.ListenMultiaddr())
+			}
+			err = tpt.Send(newPacket(rovy.TptMTU, dst))
+			time.Sleep(10 * time.Millisecond)
+		}
+		expectTooLarge(err, 1252)
+		if err := tpt.Send(newPacket(1252, dst)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
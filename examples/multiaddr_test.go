@@ -0,0 +1,28 @@
+package examples_test
+
+import (
+	"testing"
+
+	multiaddr "github.com/multiformats/go-multiaddr"
+
+	rovy "go.rovy.net"
+)
+
+// The binary form of our own multiaddrs is understood by go-multiaddr.
+func TestMultiaddrBytes(t *testing.T) {
+	for _, s := range []string{
+		"/ip6/fd00::1/udp/12345",
+		"/ip4/127.0.0.1/tcp/443/wss",
+		"/ethif/eth0",
+		"/ethif/eth0/mac/02:00:00:00:00:01",
+		"/memory/nodeA",
+	} {
+		ma, err := multiaddr.NewMultiaddrBytes(rovy.MustParseMultiaddr(s).Bytes())
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+		if ma.String() != s {
+			t.Fatalf("expected %s, got %s", s, ma)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+//go:build linux
+
+package examples_test
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	netlink "github.com/vishvananda/netlink"
+	netns "github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// vethNamespaces creates two network namespaces, connected by a veth pair of
+// rovyA with fd00::1 and rovyB with fd00::2, and with the given MTU.
+// The test's goroutine stays locked to its thread, in the original namespace.
+// The returned function runs f within a namespace.
+func vethNamespaces(t *testing.T, mtu int) (nsA, nsB netns.NsHandle, within func(netns.NsHandle, func())) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating network namespaces requires root")
+	}
+
+	runtime.LockOSThread()
+	t.Cleanup(runtime.UnlockOSThread)
+
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		netns.Set(origns)
+		origns.Close()
+	})
+
+	// netns.New switches into the new namespace right away
+	nsA, err = netns.New()
+	if err != nil {
+		t.Skipf("can't create network namespace: %s", err)
+	}
+	t.Cleanup(func() { nsA.Close() })
+	nsB, err = netns.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nsB.Close() })
+	if err := netns.Set(origns); err != nil {
+		t.Fatal(err)
+	}
+
+	veth := &netlink.Veth{
+		LinkAttrs:     netlink.LinkAttrs{Name: "rovyA", MTU: mtu, Namespace: netlink.NsFd(nsA)},
+		PeerName:      "rovyB",
+		PeerNamespace: netlink.NsFd(nsB),
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Skipf("can't create veth pair: %s", err)
+	}
+
+	within = func(ns netns.NsHandle, f func()) {
+		if err := netns.Set(ns); err != nil {
+			t.Fatal(err)
+		}
+		defer netns.Set(origns)
+		f()
+	}
+
+	setup := func(ifname, addr string) func() {
+		return func() {
+			link, err := netlink.LinkByName(ifname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := netlink.LinkSetMTU(link, mtu); err != nil {
+				t.Fatal(err)
+			}
+			ipnet := &net.IPNet{IP: net.ParseIP(addr), Mask: net.CIDRMask(64, 128)}
+			if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: ipnet, Flags: unix.IFA_F_NODAD}); err != nil {
+				t.Fatal(err)
+			}
+			if err := netlink.LinkSetUp(link); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	within(nsA, setup("rovyA", "fd00::1"))
+	within(nsB, setup("rovyB", "fd00::2"))
+	return nsA, nsB, within
+}
@@ -0,0 +1,194 @@
+package examples_test
+
+import (
+	"testing"
+
+	rovy "go.rovy.net"
+	rovyapi "go.rovy.net/api"
+	node "go.rovy.net/node"
+)
+
+func TestPeerConnectDisconnect(t *testing.T) {
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	if err := nodeB.AddTransport(mn.NewTransport("nodeB2", nodeB.Logger(node.LogTransport))); err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody listens there, so the handshake times out
+	timedOut := make(chan rovyapi.PeerInfo, 1)
+	if !testing.Short() {
+		nobody := rovy.MustParseMultiaddr("/memory/nobody")
+		nobody.PeerID = rovy.NewPeerID(rovy.MustGeneratePrivateKey().PublicKey())
+		go func() {
+			pi, err := nodeA.Peer().Connect(nobody)
+			if err != nil {
+				t.Error(err)
+			}
+			timedOut <- pi
+		}()
+	}
+
+	addrB := rovy.MustParseMultiaddr("/memory/nodeB")
+	addrB.PeerID = nodeB.PeerID()
+	pi, err := nodeA.Peer().Connect(addrB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.Status != "ok" || pi.Reason != "" {
+		t.Fatalf("expected status ok, got %s (%s)", pi.Status, pi.Reason)
+	}
+	pi = peerStatus(t, nodeA, nodeB.PeerID())
+	if pi.Status != "ok" || pi.Slot == "" {
+		t.Fatalf("expected established session with a slot, got %+v", pi)
+	}
+
+	// a different address means a new handshake, instead of reusing the session
+	addrB2 := rovy.MustParseMultiaddr("/memory/nodeB2")
+	addrB2.PeerID = nodeB.PeerID()
+	if pi, err = nodeA.Peer().Connect(addrB2); err != nil || pi.Status != "ok" {
+		t.Fatalf("connect to second address: %+v %v", pi, err)
+	}
+	var addrs []string
+	ps, err := nodeA.Peer().Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pi := range ps.Peers {
+		if pi.PeerID == nodeB.PeerID() {
+			addrs = append(addrs, pi.Addr.String())
+		}
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("expected sessions with both addresses, got %v", addrs)
+	}
+
+	// disconnecting one address keeps the session with the other one
+	pi, err = nodeA.Peer().Disconnect(addrB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.Status != "disconnected" {
+		t.Fatalf("expected status disconnected, got %s", pi.Status)
+	}
+	if pi = peerStatus(t, nodeA, nodeB.PeerID()); pi.Status != "ok" || pi.Addr.String() != "/memory/nodeB2" || pi.Slot == "" {
+		t.Fatalf("expected session with the second address, got %+v", pi)
+	}
+	if _, err := nodeA.Peer().Disconnect(addrB); err == nil {
+		t.Fatal("expected error disconnecting again")
+	}
+
+	// without an address, all sessions with the peer are gone
+	if _, err = nodeA.Peer().Disconnect(rovy.Multiaddr{PeerID: nodeB.PeerID()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, present := nodeA.SessionManager().Find(nodeB.PeerID()); present {
+		t.Fatal("expected sessions to be gone after disconnect")
+	}
+	if _, present := nodeA.Forwarder().Lookup(nodeB.PeerID()); present {
+		t.Fatal("expected forwarder slot to be freed after disconnect")
+	}
+
+	pi, err = nodeA.Peer().Connect(addrB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.Status != "ok" {
+		t.Fatalf("reconnect: expected status ok, got %s (%s)", pi.Status, pi.Reason)
+	}
+	if pi = peerStatus(t, nodeA, nodeB.PeerID()); pi.Slot == "" {
+		t.Fatalf("reconnect: expected a slot, got %+v", pi)
+	}
+
+	if !testing.Short() {
+		pi = <-timedOut
+		if pi.Status != "timeout" || pi.Reason == "" {
+			t.Fatalf("expected status timeout with a reason, got %s (%s)", pi.Status, pi.Reason)
+		}
+	}
+}
+
+// peerStatus returns the peer's first entry in the node's peer status.
+func peerStatus(t *testing.T, n *node.Node, pid rovy.PeerID) rovyapi.PeerInfo {
+	ps, err := n.Peer().Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pi := range ps.Peers {
+		if pi.PeerID == pid {
+			return pi
+		}
+	}
+	t.Fatalf("%s isn't in the peer status", pid)
+	return rovyapi.PeerInfo{}
+}
+
+func TestPeerStatus(t *testing.T) {
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	recv := make(chan struct{}, 16)
+	nodeB.Handle(0x42003, func(upkt rovy.UpperPacket) error {
+		recv <- struct{}{}
+		return nil
+	})
+
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+		t.Fatal(err)
+	}
+	before := peerStatus(t, nodeA, nodeB.PeerID())
+	if before.Status != "ok" || before.Established.IsZero() {
+		t.Fatalf("expected established session, got %+v", before)
+	}
+	slot, present := nodeA.Forwarder().Lookup(nodeB.PeerID())
+	if !present || before.Slot != slot.String() {
+		t.Fatalf("expected slot %s, got %q", slot, before.Slot)
+	}
+
+	const n = 10
+	payload := make([]byte, 100)
+	for i := 0; i < n; i++ {
+		if err := nodeA.Send(nodeB.PeerID(), 0x42003, payload); err != nil {
+			t.Fatal(err)
+		}
+		<-recv
+	}
+
+	// every data packet has the session header and tag on top of the payload
+	after := peerStatus(t, nodeA, nodeB.PeerID())
+	if after.TxPackets-before.TxPackets != n {
+		t.Fatalf("expected %d more tx packets, got %d", n, after.TxPackets-before.TxPackets)
+	}
+	txBytes := after.TxBytes - before.TxBytes
+	if txBytes%n != 0 || txBytes/n < uint64(len(payload)+16+16) {
+		t.Fatalf("unexpected tx bytes for %d packets: %d", n, txBytes)
+	}
+
+	piB := peerStatus(t, nodeB, nodeA.PeerID())
+	if piB.Status != "ok" || piB.Slot == "" || piB.LastRecv.IsZero() {
+		t.Fatalf("unexpected status on the other side: %+v", piB)
+	}
+	if piB.RxPackets < n || piB.RxBytes < txBytes {
+		t.Fatalf("expected at least %d packets and %d bytes received, got %d and %d", n, txBytes, piB.RxPackets, piB.RxBytes)
+	}
+}
@@ -0,0 +1,63 @@
+package examples_test
+
+import (
+	"encoding/binary"
+	"runtime"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+	node "go.rovy.net/node"
+)
+
+// TestPacketOrder checks that packets to a peer stay in order,
+// even though they're encrypted and decrypted by several workers at once.
+func TestPacketOrder(t *testing.T) {
+	codec := uint64(0x42003)
+	amount := 500
+
+	// the crypto workers are started with the node
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	recv := make(chan uint32, amount)
+	nodeB.Handle(codec, func(pkt rovy.UpperPacket) error {
+		recv <- binary.BigEndian.Uint32(pkt.Payload())
+		return nil
+	})
+
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+
+	payload := make([]byte, 1000)
+	for i := 0; i < amount; i++ {
+		binary.BigEndian.PutUint32(payload, uint32(i))
+		if err := nodeA.Send(nodeB.PeerID(), codec, payload); err != nil {
+			t.Fatalf("send: %s", err)
+		}
+	}
+
+	timeout := time.After(2 * time.Second)
+	for i := 0; i < amount; i++ {
+		select {
+		case n := <-recv:
+			if n != uint32(i) {
+				t.Fatalf("expected packet %d, got %d", i, n)
+			}
+		case <-timeout:
+			t.Fatalf("timed out after %d of %d packets", i, amount)
+		}
+	}
+}
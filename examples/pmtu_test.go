@@ -0,0 +1,233 @@
+package examples_test
+
+import (
+	"encoding/binary"
+	"log"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	icmp "golang.org/x/net/icmp"
+	ipv6 "golang.org/x/net/ipv6"
+	tun "golang.zx2c4.com/wireguard/tun"
+
+	rovy "go.rovy.net"
+	fcnet "go.rovy.net/fcnet"
+	node "go.rovy.net/node"
+	routing "go.rovy.net/node/routing"
+	logging "go.rovy.net/node/util/logging"
+)
+
+// newPMTUPath connects A -> B on a regular link, and B -> C on the returned network,
+// and returns A, C, and A's route to C.
+func newPMTUPath(t *testing.T) (*node.Node, *node.Node, *node.MemoryNetwork, rovy.Route) {
+	mn1 := node.NewMemoryNetwork(node.MemoryOptions{})
+	mn2 := node.NewMemoryNetwork(node.MemoryOptions{})
+
+	nodeA, err := newMemoryNode("nodeA", mn1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nodeA.Stop() })
+	nodeB, err := newMemoryNode("nodeB", mn1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nodeB.Stop() })
+	if err := nodeB.AddTransport(mn2.NewTransport("nodeB2", nodeB.Logger(node.LogTransport))); err != nil {
+		t.Fatal(err)
+	}
+	nodeC, err := newMemoryNode("nodeC", mn2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nodeC.Stop() })
+
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeC.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB2")); err != nil {
+		t.Fatal(err)
+	}
+	// nodeB only considers nodeC connected once it gets data from it
+	if err := nodeC.Send(nodeB.PeerID(), 0x42003, []byte{0x42}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := nodeB.Routing().GetRoute(nodeC.PeerID()); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	route := nodeA.Routing().MustGetRoute(nodeB.PeerID()).
+		Join(nodeB.Routing().MustGetRoute(nodeC.PeerID()))
+	nodeA.Routing().AddRoute(nodeC.PeerID(), route)
+	if err := nodeA.Connect(nodeC.PeerID(), rovy.Multiaddr{}); err != nil {
+		t.Fatal(err)
+	}
+	return nodeA, nodeC, mn2, route
+}
+
+// A -> B is a regular link, B -> C has a smaller MTU.
+func TestPathMTU(t *testing.T) {
+	nodeA, nodeC, mn2, route := newPMTUPath(t)
+
+	// hello packets take up the full TptMTU, so we only lower it now
+	mn2.SetOptions(node.MemoryOptions{MTU: 1400})
+
+	mtu, err := nodeA.ProbePathMTU(route)
+	if err != nil {
+		t.Fatalf("probe: %s", err)
+	}
+	if mtu != 1400 {
+		t.Fatalf("expected path MTU 1400, got %d", mtu)
+	}
+
+	recv := make(chan int, 1)
+	nodeC.Handle(fcnet.FcnetMulticodec, func(upkt rovy.UpperPacket) error {
+		recv <- len(upkt.Payload())
+		return nil
+	})
+
+	dev := newChanDevice()
+	fc := fcnet.NewFcnet(nodeA, dev)
+	if err := fc.Start(rovy.UpperMTU); err != nil {
+		t.Fatal(err)
+	}
+	defer fc.Stop()
+
+	// too large for the path, and thus answered with Packet Too Big
+	expected := 1400 - (rovy.TptMTU - rovy.UpperMTU)
+	dev.in <- udpPacket(nodeA.IPAddr(), nodeC.IPAddr(), expected+1)
+	timeout := time.After(time.Second)
+	for ptb := false; !ptb; {
+		select {
+		case p := <-dev.out:
+			if len(p) < ipv6.HeaderLen || p[6] != 58 {
+				continue
+			}
+			msg, err := icmp.ParseMessage(58, p[ipv6.HeaderLen:])
+			if err != nil || msg.Type != ipv6.ICMPTypePacketTooBig {
+				continue
+			}
+			if body := msg.Body.(*icmp.PacketTooBig); body.MTU != expected {
+				t.Fatalf("expected Packet Too Big with MTU %d, got %d", expected, body.MTU)
+			}
+			ptb = true
+		case <-timeout:
+			t.Fatal("timed out waiting for Packet Too Big")
+		}
+	}
+
+	// fits the path
+	dev.in <- udpPacket(nodeA.IPAddr(), nodeC.IPAddr(), expected)
+	select {
+	case n := <-recv:
+		if n != expected {
+			t.Fatalf("expected %d bytes, got %d", expected, n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+}
+
+// B -> C drops packets which are too large, without telling anybody.
+func TestPathMTUStepDown(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for probes to time out")
+	}
+	nodeA, _, mn2, route := newPMTUPath(t)
+	mn2.SetOptions(node.MemoryOptions{MTU: 1400, DropTooLarge: true})
+
+	// 1452 and 1410 time out, 1389 fits
+	mtu, err := nodeA.ProbePathMTU(route)
+	if err != nil {
+		t.Fatalf("probe: %s", err)
+	}
+	if mtu > 1400 || mtu < node.MinPathMTU {
+		t.Fatalf("expected path MTU between %d and 1400, got %d", node.MinPathMTU, mtu)
+	}
+	if known, _ := nodeA.Routing().PathMTU(route); known != mtu {
+		t.Fatalf("expected routing to know path MTU %d, got %d", mtu, known)
+	}
+
+	// nothing gets through, so we assume the minimum
+	mn2.SetOptions(node.MemoryOptions{MTU: 1000, DropTooLarge: true})
+	nodeA.Routing().SetPathMTU(route, rovy.TptMTU)
+	mtu, err = nodeA.ProbePathMTU(route)
+	if err == nil || mtu != node.MinPathMTU {
+		t.Fatalf("expected timeout with path MTU %d, got %d (%v)", node.MinPathMTU, mtu, err)
+	}
+	if known, _ := nodeA.Routing().PathMTU(route); known != node.MinPathMTU {
+		t.Fatalf("expected routing to know path MTU %d, got %d", node.MinPathMTU, known)
+	}
+}
+
+// udpPacket returns an IPv6 packet of the given length with a UDP header.
+func udpPacket(src, dst netip.Addr, length int) []byte {
+	p := make([]byte, length)
+	p[0] = 0x60
+	binary.BigEndian.PutUint16(p[4:6], uint16(length-ipv6.HeaderLen))
+	p[6] = 17
+	p[7] = 64
+	copy(p[8:24], src.AsSlice())
+	copy(p[24:40], dst.AsSlice())
+	binary.BigEndian.PutUint16(p[40:42], 12345)
+	binary.BigEndian.PutUint16(p[42:44], 9)
+	binary.BigEndian.PutUint16(p[44:46], uint16(length-ipv6.HeaderLen))
+	return p
+}
+
+// chanDevice is a TUN device whose packets are read from in, and written to out.
+type chanDevice struct {
+	in     chan []byte
+	out    chan []byte
+	closed chan struct{}
+}
+
+func newChanDevice() *chanDevice {
+	return &chanDevice{in: make(chan []byte), out: make(chan []byte, 16), closed: make(chan struct{})}
+}
+
+func (d *chanDevice) Read(buf []byte, offset int) (int, error) {
+	select {
+	case p := <-d.in:
+		return copy(buf[offset:], p), nil
+	case <-d.closed:
+		return 0, os.ErrClosed
+	}
+}
+
+func (d *chanDevice) Write(buf []byte, offset int) (int, error) {
+	select {
+	case d.out <- append([]byte{}, buf[offset:]...):
+	default:
+	}
+	return len(buf) - offset, nil
+}
+
+func (d *chanDevice) File() *os.File         { return nil }
+func (d *chanDevice) Flush() error           { return nil }
+func (d *chanDevice) MTU() (int, error)      { return rovy.UpperMTU, nil }
+func (d *chanDevice) Name() (string, error)  { return "chan0", nil }
+func (d *chanDevice) Events() chan tun.Event { return nil }
+func (d *chanDevice) Close() error           { close(d.closed); return nil }
+
+// A peer that gets a freed slot doesn't inherit the path MTUs via the previous peer.
+func TestPathMTURemovePeer(t *testing.T) {
+	r := routing.NewRouting(logging.New(node.LogRouting, log.New(os.Stderr, "[routing] ", log.Ltime|log.Lshortfile)))
+
+	slot := rovy.NewRoute(0x05)
+	r.SetPathMTU(slot, 1300)
+	r.SetPathMTU(rovy.NewRoute(0x05, 0x07), 1280)
+	r.SetPathMTU(rovy.NewRoute(0x06), 1350)
+	r.RemovePeer(rovy.NewPeerID(rovy.MustGeneratePrivateKey().PublicKey()), slot)
+
+	if mtu, known := r.PathMTU(rovy.NewRoute(0x05, 0x07)); known || mtu != rovy.TptMTU {
+		t.Fatalf("expected path MTU %d of unknown route, got %d", rovy.TptMTU, mtu)
+	}
+	if mtu, known := r.PathMTU(rovy.NewRoute(0x06)); !known || mtu != 1350 {
+		t.Fatalf("expected path MTU 1350 of other slot, got %d", mtu)
+	}
+}
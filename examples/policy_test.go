@@ -0,0 +1,153 @@
+package examples_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+	rovyapi "go.rovy.net/api"
+	node "go.rovy.net/node"
+)
+
+func TestPolicy(t *testing.T) {
+	codecAllowed := uint64(0x42011)
+	codecDenied := uint64(0x42012)
+
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var allowed, denied atomic.Int32
+	nodeB.Handle(codecAllowed, func(upkt rovy.UpperPacket) error {
+		allowed.Add(1)
+		return nil
+	})
+	nodeB.Handle(codecDenied, func(upkt rovy.UpperPacket) error {
+		denied.Add(1)
+		return nil
+	})
+
+	pp, err := nodeB.Policy().Set(rovyapi.PeerPolicy{
+		Name:   "nodeA",
+		Peers:  []rovy.PeerID{nodeA.PeerID()},
+		Codecs: []string{"0x42011"},
+		Ports:  []string{"tcp/22"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pp.Codecs) != 1 || pp.Codecs[0] != "0x42011" || pp.Ports[0] != "tcp/22" {
+		t.Fatalf("unexpected policy: %+v", pp)
+	}
+	if _, err := nodeB.Policy().Set(rovyapi.PeerPolicy{Name: "bad", Ports: []string{"tcp/x"}}); err == nil {
+		t.Fatal("expected error for invalid port")
+	}
+
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeA.Send(nodeB.PeerID(), codecAllowed, []byte{0x42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeA.Send(nodeB.PeerID(), codecDenied, []byte{0x42}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if allowed.Load() != 1 || denied.Load() != 0 {
+		t.Fatalf("expected 1 allowed and 0 denied packets, got %d and %d", allowed.Load(), denied.Load())
+	}
+
+	// without policies, everything is allowed again
+	if err := nodeB.Policy().Remove("nodeA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeA.Send(nodeB.PeerID(), codecDenied, []byte{0x42}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if denied.Load() != 1 {
+		t.Fatalf("expected 1 packet after removing policy, got %d", denied.Load())
+	}
+}
+
+// A ports-only policy for A doesn't cut anybody off from codecs,
+// and a codec policy for A doesn't restrict C.
+func TestPolicyCodecsOptIn(t *testing.T) {
+	codec := uint64(0x42013)
+
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+	nodeC, err := newMemoryNode("nodeC", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeC.Stop()
+
+	var fromA, fromC atomic.Int32
+	nodeB.Handle(codec, func(upkt rovy.UpperPacket) error {
+		switch upkt.UpperSrc {
+		case nodeA.PeerID():
+			fromA.Add(1)
+		case nodeC.PeerID():
+			fromC.Add(1)
+		}
+		return nil
+	})
+
+	for _, n := range []*node.Node{nodeA, nodeC} {
+		if err := n.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send := func() {
+		for _, n := range []*node.Node{nodeA, nodeC} {
+			if err := n.Send(nodeB.PeerID(), codec, []byte{0x42}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if _, err := nodeB.Policy().Set(rovyapi.PeerPolicy{
+		Name:  "ssh",
+		Peers: []rovy.PeerID{nodeA.PeerID()},
+		Ports: []string{"tcp/22"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	send()
+	if fromA.Load() != 1 || fromC.Load() != 1 {
+		t.Fatalf("expected 1 packet from each with a ports-only policy, got %d and %d", fromA.Load(), fromC.Load())
+	}
+
+	if _, err := nodeB.Policy().Set(rovyapi.PeerPolicy{
+		Name:   "ssh",
+		Peers:  []rovy.PeerID{nodeA.PeerID()},
+		Codecs: []string{"fcnet"},
+		Ports:  []string{"tcp/22"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	send()
+	if fromA.Load() != 1 || fromC.Load() != 2 {
+		t.Fatalf("expected only C's packet with a codec policy for A, got %d and %d", fromA.Load(), fromC.Load())
+	}
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package examples_test
+
+// raceEnabled reports whether the race detector is on, which makes allocation counts meaningless.
+const raceEnabled = true
@@ -0,0 +1,71 @@
+package examples_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+)
+
+func TestRestart(t *testing.T) {
+	addrA := rovy.MustParseMultiaddr("/ip6/::1/udp/12250")
+	addrB := rovy.MustParseMultiaddr("/ip6/::1/udp/12251")
+
+	nodeA, err := newNode("nodeA", addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeB, err := newNode("nodeB", addrB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	if _, err := nodeA.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 3; i++ {
+		if _, err := nodeA.Start(); err != nil {
+			t.Fatalf("start #%d: %s", i, err)
+		}
+
+		// the socket must have been bound again
+		ps, err := nodeA.Peer().Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ps.Listeners) != 1 || len(ps.Listeners[0].EffectiveAddrs) != 1 {
+			t.Fatalf("start #%d: expected one effective address, got %+v", i, ps.Listeners)
+		}
+
+		// only the first attempt performs a handshake,
+		// stopping keeps the established session around.
+		if err := nodeA.Connect(nodeB.PeerID(), addrB); err != nil {
+			t.Fatalf("connect #%d: %s", i, err)
+		}
+
+		if _, err := nodeA.Stop(); err != nil {
+			t.Fatalf("stop #%d: %s", i, err)
+		}
+	}
+
+	// give the runtime a moment to reap exited goroutines
+	time.Sleep(50 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("leaked goroutines: %d before, %d after", before, after)
+	}
+
+	if _, err := nodeA.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nodeA.Peer().Close(addrA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nodeA.Peer().Listen(addrA); err != nil {
+		t.Fatalf("listen after close: %s", err)
+	}
+	nodeA.Stop()
+}
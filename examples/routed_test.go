@@ -6,77 +6,81 @@ import (
 	"time"
 
 	rovy "go.rovy.net"
+	node "go.rovy.net/node"
 )
 
 func TestRouted(t *testing.T) {
+	t.Parallel()
+
 	codec := uint64(0x42003)
 
 	payload := []byte{0x42, 0x42, 0x42, 0x42}
 	payload2 := []byte{0x0, 0x0, 0x0, 0x0}
 
-	addrA := rovy.MustParseMultiaddr("/ip6/::1/udp/12340")
-	addrB := rovy.MustParseMultiaddr("/ip6/::1/udp/12341")
-	addrC := rovy.MustParseMultiaddr("/ip6/::1/udp/12342")
-	addrD := rovy.MustParseMultiaddr("/ip6/::1/udp/12343")
-	addrE := rovy.MustParseMultiaddr("/ip6/::1/udp/12344")
-	addrF := rovy.MustParseMultiaddr("/ip6/::1/udp/12345")
-	addrG := rovy.MustParseMultiaddr("/ip6/::1/udp/12346")
-	addrH := rovy.MustParseMultiaddr("/ip6/::1/udp/12347")
-	addrI := rovy.MustParseMultiaddr("/ip6/::1/udp/12348")
-	addrJ := rovy.MustParseMultiaddr("/ip6/::1/udp/12349")
-	addrK := rovy.MustParseMultiaddr("/ip6/::1/udp/12350")
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+
+	addrB := rovy.MustParseMultiaddr("/memory/nodeB")
+	addrC := rovy.MustParseMultiaddr("/memory/nodeC")
+	addrD := rovy.MustParseMultiaddr("/memory/nodeD")
+	addrE := rovy.MustParseMultiaddr("/memory/nodeE")
+	addrF := rovy.MustParseMultiaddr("/memory/nodeF")
+	addrG := rovy.MustParseMultiaddr("/memory/nodeG")
+	addrH := rovy.MustParseMultiaddr("/memory/nodeH")
+	addrI := rovy.MustParseMultiaddr("/memory/nodeI")
+	addrJ := rovy.MustParseMultiaddr("/memory/nodeJ")
+	addrK := rovy.MustParseMultiaddr("/memory/nodeK")
 
-	nodeA, err := newNode("nodeA", addrA)
+	nodeA, err := newMemoryNode("nodeA", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeB, err := newNode("nodeB", addrB)
+	nodeB, err := newMemoryNode("nodeB", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeC, err := newNode("nodeC", addrC)
+	nodeC, err := newMemoryNode("nodeC", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeD, err := newNode("nodeD", addrD)
+	nodeD, err := newMemoryNode("nodeD", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeE, err := newNode("nodeE", addrE)
+	nodeE, err := newMemoryNode("nodeE", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeF, err := newNode("nodeF", addrF)
+	nodeF, err := newMemoryNode("nodeF", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeG, err := newNode("nodeG", addrG)
+	nodeG, err := newMemoryNode("nodeG", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeH, err := newNode("nodeH", addrH)
+	nodeH, err := newMemoryNode("nodeH", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeI, err := newNode("nodeI", addrI)
+	nodeI, err := newMemoryNode("nodeI", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeJ, err := newNode("nodeJ", addrJ)
+	nodeJ, err := newMemoryNode("nodeJ", mn)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	nodeK, err := newNode("nodeK", addrK)
+	nodeK, err := newMemoryNode("nodeK", mn)
 	if err != nil {
 		t.Error(err)
 		return
@@ -0,0 +1,140 @@
+package examples_test
+
+import (
+	"encoding/hex"
+	"log"
+	"os"
+	"testing"
+
+	rovy "go.rovy.net"
+	fcnet "go.rovy.net/fcnet"
+	node "go.rovy.net/node"
+)
+
+func TestSignature(t *testing.T) {
+	privkey := rovy.MustGeneratePrivateKey()
+	msg := []byte("ping")
+
+	sig, err := privkey.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := privkey.PublicKey().Verify(msg, sig); err != nil {
+		t.Fatal(err)
+	}
+	if err := privkey.PublicKey().Verify([]byte("pong"), sig); err == nil {
+		t.Fatal("expected error for different message")
+	}
+	if err := rovy.MustGeneratePrivateKey().PublicKey().Verify(msg, sig); err == nil {
+		t.Fatal("expected error for different key")
+	}
+}
+
+// Signatures made by a Python implementation of the XEdDSA spec, with fixed random bytes.
+// Keys 0 and 2 have an Edwards public key with sign 1, so their scalar is negated.
+var xeddsaVectors = []struct {
+	privkey, pubkey, msg, sig string
+}{
+	{
+		"dd9a67eae269ce63128418c4449305075f26a22c7a562170744883303be40e0f",
+		"01c1457b8670cd1a25fbdecc787feac4afea227e39ac41bd5aaaddb488266f61",
+		"",
+		"faf9193484cc77382a873ebf9075d1a33a8ccae83efbe1567d623bef3e9a16a752ba6b638a51e3777f62fae33003987e5900f1bccd0806d524d6081a7ffbeb0c",
+	},
+	{
+		"c1e8342ec9734af2eca1a3770e3deb9ee2850d7f0d259d143aaf4883d99c14c9",
+		"6498d97106637199484506a5b4667fe399f4537ae72b28155709c1b8a2eba467",
+		"70696e67",
+		"acde97dfb5040f743f4c8830b7a6321ee4a2a22cb0778ccd446117e151f69ed4078f0966a1d77a30b57d2f2f08ff7e1fac0c536e4f82ca6749293d23d1df300c",
+	},
+	{
+		"7b9a64c19d3bc0c638add455c3925fc2f8a027f1efa04168c92a4b7f2fe7ff5e",
+		"3d317a11d960593cb5479e8c859c3bc378576c795dea28dc32dee68297078551",
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f404142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f606162636465666768696a6b6c6d6e6f707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9fa0a1a2a3a4a5a6a7a8a9aaabacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebfc0c1c2c3c4c5c6c7",
+		"59b64d44b5f644a9d5f6c294267c9e23b90198980ae5bed200371f21c3b00f244ce7c64fb11ac1c72caa41d4500f7c9045bc19d15ea8f4e9f646b1b03267730c",
+	},
+}
+
+func TestSignatureVectors(t *testing.T) {
+	unhex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	for i, v := range xeddsaVectors {
+		privkey := rovy.NewPrivateKey(unhex(v.privkey))
+		pubkey := rovy.NewPublicKey(unhex(v.pubkey))
+		msg, sig := unhex(v.msg), unhex(v.sig)
+
+		if got := hex.EncodeToString(privkey.PublicKey().Bytes()); got != v.pubkey {
+			t.Fatalf("vector %d: expected public key %s, got %s", i, v.pubkey, got)
+		}
+		if err := pubkey.Verify(msg, sig); err != nil {
+			t.Fatalf("vector %d: %s", i, err)
+		}
+		sig2, err := privkey.Sign(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pubkey.Verify(msg, sig2); err != nil {
+			t.Fatalf("vector %d: own signature: %s", i, err)
+		}
+
+		sig[i] ^= 0x01
+		if err := pubkey.Verify(msg, sig); err == nil {
+			t.Fatalf("vector %d: expected error for modified signature", i)
+		}
+		sig[i] ^= 0x01
+		if err := pubkey.Verify(append(msg, 0), sig); err == nil {
+			t.Fatalf("vector %d: expected error for modified message", i)
+		}
+	}
+
+	// u = p isn't canonical, and u = p-1 has no Edwards form
+	sig := unhex(xeddsaVectors[0].sig)
+	for _, u := range []string{
+		"edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+	} {
+		if err := rovy.NewPublicKey(unhex(u)).Verify(nil, sig); err == nil {
+			t.Fatalf("expected error for public key %s", u)
+		}
+	}
+}
+
+func TestPingPacketSignature(t *testing.T) {
+	logger := log.New(os.Stderr, "[ping] ", log.Ltime|log.Lshortfile)
+	n := node.NewNode(rovy.MustGeneratePrivateKey(), logger)
+
+	ppkt := fcnet.NewPingPacket(rovy.AllocPacket())
+	defer ppkt.Release()
+	ppkt.SetSender(n.PeerID().PublicKey())
+	ppkt.SetIsReply(true)
+	ppkt = ppkt.SetPayload(make([]byte, rovy.TptMTU))
+	if len(ppkt.Payload()) != rovy.TptMTU-ppkt.Offset-fcnet.PingHeaderSize-ppkt.Padding {
+		t.Fatalf("expected payload to be truncated, got len=%d", len(ppkt.Payload()))
+	}
+
+	sig, err := n.Sign(ppkt.SignedBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ppkt.SetSignature(sig)
+	if err := ppkt.Sender().Verify(ppkt.SignedBytes(), ppkt.Signature()); err != nil {
+		t.Fatal(err)
+	}
+
+	// forwarders change the route on the way, that's fine
+	ppkt.SetRoute(rovy.NewRoute(0x1, 0x2))
+	if err := ppkt.Sender().Verify(ppkt.SignedBytes(), ppkt.Signature()); err != nil {
+		t.Fatal(err)
+	}
+
+	ppkt.SetIsReply(false)
+	if err := ppkt.Sender().Verify(ppkt.SignedBytes(), ppkt.Signature()); err == nil {
+		t.Fatal("expected error for modified request id")
+	}
+}
@@ -0,0 +1,137 @@
+package examples_test
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+	rovyapis "go.rovy.net/api/server"
+	node "go.rovy.net/node"
+	session "go.rovy.net/node/session"
+	ringbuf "go.rovy.net/node/util/ringbuf"
+)
+
+func TestQueueStats(t *testing.T) {
+	logger := log.New(os.Stderr, "[stats] ", log.Ltime|log.Lshortfile)
+	n := node.NewNode(rovy.MustGeneratePrivateKey(), logger)
+
+	if err := n.SetQueueSize("lowerMux", 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetQueueSize("nonexistent", 16); err == nil {
+		t.Fatal("expected error for unknown queue")
+	}
+	if _, err := n.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Stop()
+	if err := n.SetQueueSize("lowerMux", 32); err != node.ErrRunning {
+		t.Fatalf("expected ErrRunning, got %v", err)
+	}
+
+	qs, err := n.Stats().Queues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	capacity := map[string]int{}
+	for _, q := range qs {
+		capacity[q.Name] = q.Capacity
+	}
+	if capacity["lowerMux"] != 16 || capacity["lowerRecv"] != node.DefaultQueueSize || capacity[node.CryptoQueueName] == 0 {
+		t.Fatalf("unexpected capacities: %v", capacity)
+	}
+
+	// a full RingBuffer drops the oldest packets
+	rb := ringbuf.NewRingBuffer(2)
+	for i := 0; i < 5; i++ {
+		rb.Put(rovy.AllocPacket())
+	}
+	st := rb.Stats()
+	if st.Length != 2 || st.HighWater != 2 || st.Dropped != 3 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	logger := log.New(os.Stderr, "[metrics] ", log.Ltime|log.Lshortfile)
+	n := node.NewNode(rovy.MustGeneratePrivateKey(), logger)
+	if _, err := n.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Stop()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go rovyapis.NewServer(n, n.Logger(node.LogAPI)).ServeMetrics(lis)
+
+	res, err := http.Get("http://" + lis.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"# TYPE rovy_handshakes_total counter",
+		`rovy_handshakes_total{result="failure"} 0`,
+		`rovy_sessions{stage="established"} 0`,
+		`rovy_queue_capacity{queue="lowerRecv"} `,
+		"rovy_forwarder_dropped_total 0",
+		"rovy_routing_peers 0",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Fatalf("expected %q in metrics:\n%s", line, body)
+		}
+	}
+}
+
+// The per-peer traffic totals don't go down when sessions are removed,
+// since they're exported as Prometheus counters.
+func TestPeerTraffic(t *testing.T) {
+	mn := node.NewMemoryNetwork(node.MemoryOptions{})
+
+	nodeA, err := newMemoryNode("nodeA", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB, err := newMemoryNode("nodeB", mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	if err := nodeA.Connect(nodeB.PeerID(), rovy.MustParseMultiaddr("/memory/nodeB")); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeA.Send(nodeB.PeerID(), 0x42001, []byte{0x42}); err != nil {
+		t.Fatal(err)
+	}
+	var before session.PeerTraffic
+	for i := 0; before.TxPackets == 0; i++ {
+		if i == 100 {
+			t.Fatal("expected a packet sent to nodeB")
+		}
+		time.Sleep(10 * time.Millisecond)
+		before = nodeA.SessionManager().PeerTraffic()[nodeB.PeerID()]
+	}
+
+	if err := nodeA.Disconnect(nodeB.PeerID()); err != nil {
+		t.Fatal(err)
+	}
+	if after := nodeA.SessionManager().PeerTraffic()[nodeB.PeerID()]; after.TxPackets < before.TxPackets || after.TxBytes < before.TxBytes {
+		t.Fatalf("expected totals of at least %+v after disconnect, got %+v", before, after)
+	}
+}
@@ -0,0 +1,63 @@
+package examples_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+)
+
+func TestStreamTransport(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		testStreamEcho(t, "/ip4/127.0.0.1/tcp/12271", "/ip6/::1/udp/12272")
+	})
+	t.Run("tls", func(t *testing.T) {
+		testStreamEcho(t, "/ip6/::1/tcp/12273/tls", "/ip6/::1/udp/12274")
+	})
+}
+
+// testStreamEcho has nodeB, which only listens on UDP, connect to nodeA
+// via the stream transport and exchange a packet both ways.
+func testStreamEcho(t *testing.T, lisA, lisB string) {
+	codec := uint64(0x42001)
+	payload := []byte{0x42, 0x42, 0x42, 0x42}
+
+	addrA := rovy.MustParseMultiaddr(lisA)
+	nodeA, err := newNode("nodeA", addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+
+	nodeB, err := newNode("nodeB", rovy.MustParseMultiaddr(lisB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	nodeA.Handle(codec, func(pkt rovy.UpperPacket) error {
+		return nodeA.Send(pkt.UpperSrc, codec, pkt.Payload())
+	})
+	echo := make(chan []byte, 1)
+	nodeB.Handle(codec, func(pkt rovy.UpperPacket) error {
+		echo <- append([]byte{}, pkt.Payload()...)
+		return nil
+	})
+
+	if err := nodeB.Connect(nodeA.PeerID(), addrA); err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+	if err := nodeB.Send(nodeA.PeerID(), codec, payload); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	select {
+	case pl := <-echo:
+		if !bytes.Equal(pl, payload) {
+			t.Fatalf("expected %#v but got %#v", payload, pl)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for echo")
+	}
+}
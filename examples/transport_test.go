@@ -0,0 +1,57 @@
+package examples_test
+
+import (
+	"testing"
+
+	rovy "go.rovy.net"
+)
+
+func TestTransportSelection(t *testing.T) {
+	addrA6 := rovy.MustParseMultiaddr("/ip6/::1/udp/12260")
+	addrA4 := rovy.MustParseMultiaddr("/ip4/127.0.0.1/udp/12261")
+	addrA4b := rovy.MustParseMultiaddr("/ip4/127.0.0.1/udp/12262")
+	addrB := rovy.MustParseMultiaddr("/ip4/127.0.0.1/udp/12263")
+	addrC := rovy.MustParseMultiaddr("/ip4/127.0.0.1/udp/12264")
+
+	// the ip6 listener comes first, like in the default config
+	nodeA, err := newNode("nodeA", addrA6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	for _, ma := range []rovy.Multiaddr{addrA4, addrA4b} {
+		if _, err := nodeA.Peer().Listen(ma); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodeB, err := newNode("nodeB", addrB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	nodeC, err := newNode("nodeC", addrC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeC.Stop()
+
+	// nodeA has to pick one of its ip4 sockets to reach nodeB
+	if err := nodeA.Connect(nodeB.PeerID(), addrB); err != nil {
+		t.Fatalf("connect A->B: %s", err)
+	}
+
+	// nodeC reaches nodeA on its second ip4 socket,
+	// and nodeA must respond from that same socket.
+	if err := nodeC.Connect(nodeA.PeerID(), addrA4b); err != nil {
+		t.Fatalf("connect C->A: %s", err)
+	}
+	ps, err := nodeC.Peer().Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.Peers) != 1 || ps.Peers[0].Addr != addrA4b {
+		t.Fatalf("expected nodeC to see nodeA at %s, got %+v", addrA4b, ps.Peers)
+	}
+}
@@ -0,0 +1,78 @@
+package examples_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+	node "go.rovy.net/node"
+	logging "go.rovy.net/node/util/logging"
+	ringbuf "go.rovy.net/node/util/ringbuf"
+)
+
+// TestUDPBatch sends a burst of packets, which the sender batches,
+// and with GSO coalesces into larger messages. Each packet has to arrive intact.
+func TestUDPBatch(t *testing.T) {
+	logger := logging.New(node.LogTransport, log.New(os.Stderr, "[udp] ", log.Ltime|log.Lshortfile))
+
+	tptA, err := node.NewUDPTransport(rovy.MustParseMultiaddr("/ip6/::1/udp/12281"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tptB, err := node.NewUDPTransport(rovy.MustParseMultiaddr("/ip6/::1/udp/12282"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBurst(t, tptA, tptB, 250)
+}
+
+// testBurst sends amount packets from tptA to tptB, and checks that each arrives intact.
+func testBurst(t *testing.T, tptA, tptB node.Transport, amount int) {
+	recvA := ringbuf.NewRingBuffer(amount)
+	recvB := ringbuf.NewRingBuffer(amount)
+	if err := tptA.Start(recvA); err != nil {
+		t.Fatal(err)
+	}
+	defer tptA.Stop()
+	if err := tptB.Start(recvB); err != nil {
+		t.Fatal(err)
+	}
+	defer tptB.Stop()
+
+	// every 10th packet is shorter, which ends a GSO message early
+	payload := func(i int) []byte {
+		size := 1000
+		if i%10 == 9 {
+			size = 100
+		}
+		return bytes.Repeat([]byte{byte(i)}, size)
+	}
+
+	for i := 0; i < amount; i++ {
+		pkt := rovy.NewPacket(make([]byte, rovy.TptMTU))
+		pkt.Length = copy(pkt.Buf, payload(i))
+		pkt.TptDst = tptB.LocalMultiaddr()
+		if err := tptA.Send(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := map[byte]bool{}
+	timeout := time.After(2 * time.Second)
+	for len(seen) < amount {
+		select {
+		case pkt := <-recvB.Channel():
+			i := int(pkt.Bytes()[0])
+			if !bytes.Equal(pkt.Bytes(), payload(i)) || pkt.TptSrc != tptA.LocalMultiaddr() {
+				t.Fatalf("packet %d got mangled: length=%d src=%s", i, pkt.Length, pkt.TptSrc)
+			}
+			seen[byte(i)] = true
+		case <-timeout:
+			t.Fatalf("timed out after %d of %d packets", len(seen), amount)
+		}
+	}
+}
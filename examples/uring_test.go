@@ -0,0 +1,34 @@
+//go:build linux && (amd64 || arm64)
+
+package examples_test
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	rovy "go.rovy.net"
+	node "go.rovy.net/node"
+	logging "go.rovy.net/node/util/logging"
+)
+
+// TestIOUring is TestUDPBatch between the io_uring transport and the standard one,
+// in both directions.
+func TestIOUring(t *testing.T) {
+	if err := node.IOUringSupported(); err != nil {
+		t.Skip(err)
+	}
+	logger := logging.New(node.LogTransport, log.New(os.Stderr, "[uring] ", log.Ltime|log.Lshortfile))
+
+	tptA, err := node.NewUDPTransport(rovy.MustParseMultiaddr("/ip6/::1/udp/12283"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tptB, err := node.NewIOUringTransport(rovy.MustParseMultiaddr("/ip6/::1/udp/12284"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBurst(t, tptA, tptB, 250)
+	testBurst(t, tptB, tptA, 250)
+}
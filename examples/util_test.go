@@ -23,3 +23,21 @@ func newNode(name string, lisaddr rovy.Multiaddr) (*node.Node, error) {
 	logger.Printf("%s/rovy/%s", lisaddr, node.PeerID())
 	return node, nil
 }
+
+// newMemoryNode creates a node listening on /memory/<name> on the given network.
+func newMemoryNode(name string, mn *node.MemoryNetwork) (*node.Node, error) {
+	logger := log.New(os.Stderr, "["+name+"] ", log.Ltime|log.Lshortfile)
+
+	n := node.NewNode(rovy.MustGeneratePrivateKey(), logger)
+	if _, err := n.Start(); err != nil {
+		return n, err
+	}
+
+	tpt := mn.NewTransport(name, n.Logger(node.LogTransport))
+	if err := n.AddTransport(tpt); err != nil {
+		return nil, err
+	}
+
+	logger.Printf("%s/rovy/%s", tpt.ListenMultiaddr(), n.PeerID())
+	return n, nil
+}
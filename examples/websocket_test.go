@@ -0,0 +1,77 @@
+package examples_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	rovy "go.rovy.net"
+	node "go.rovy.net/node"
+)
+
+func TestWebSocketTransport(t *testing.T) {
+	t.Run("ws", func(t *testing.T) {
+		testStreamEcho(t, "/ip4/127.0.0.1/tcp/12275/ws", "/ip6/::1/udp/12276")
+	})
+	t.Run("wss", func(t *testing.T) {
+		testStreamEcho(t, "/ip6/::1/tcp/12277/wss", "/ip6/::1/udp/12278")
+	})
+}
+
+// TestWebSocketHandler mounts nodeA's transport into an httptest server,
+// instead of having the transport listen by itself.
+func TestWebSocketHandler(t *testing.T) {
+	codec := uint64(0x42001)
+	payload := []byte{0x42, 0x42, 0x42, 0x42}
+
+	srv := httptest.NewUnstartedServer(nil)
+	defer srv.Close()
+	addrA := rovy.FromAddrPort(netip.MustParseAddrPort(srv.Listener.Addr().String()))
+	addrA.Tpt = rovy.WSMultiaddrCodec
+
+	nodeA, err := newNode("nodeA", rovy.MustParseMultiaddr("/ip6/::1/udp/12279"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+
+	tpt, err := node.NewWebSocketHandler(addrA, nodeA.Logger(node.LogTransport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeA.AddTransport(tpt); err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = tpt
+	srv.Start()
+
+	nodeB, err := newNode("nodeB", rovy.MustParseMultiaddr("/ip6/::1/udp/12280"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeB.Stop()
+
+	echo := make(chan []byte, 1)
+	nodeA.Handle(codec, func(pkt rovy.UpperPacket) error {
+		echo <- append([]byte{}, pkt.Payload()...)
+		return nil
+	})
+
+	if err := nodeB.Connect(nodeA.PeerID(), addrA); err != nil {
+		t.Fatalf("connect: %s", err)
+	}
+	if err := nodeB.Send(nodeA.PeerID(), codec, payload); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	select {
+	case pl := <-echo:
+		if !bytes.Equal(pl, payload) {
+			t.Fatalf("expected %#v but got %#v", payload, pl)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+}
@@ -1,84 +1,355 @@
 package fcnet
 
 import (
-	"log"
+	"fmt"
 	"net"
+	"net/netip"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	cid "github.com/ipfs/go-cid"
 	dns "github.com/miekg/dns"
 
 	rovy "go.rovy.net"
+	petname "go.rovy.net/node/petname"
+	logging "go.rovy.net/node/util/logging"
 )
 
+// RovyZone is the zone of peer names, e.g. bafzqai....rovy or <petname>.rovy.
+const RovyZone = "rovy."
+
+// ReverseZone is the ip6.arpa zone of fc00::/8, for which ServeDNS answers PTR queries.
+const ReverseZone = "c.f.ip6.arpa."
+
+// MaxUDPSize is the EDNS buffer size we announce. Larger responses are truncated,
+// and the client is expected to retry over TCP.
+const MaxUDPSize = 1232
+
+// ForwardTimeout is how long we wait for each upstream resolver.
+const ForwardTimeout = 2 * time.Second
+
 type DNSHandler struct {
 	LocalPeerID rovy.PeerID
+	Queries     *atomic.Uint64                        // optional, counts the queries
+	Log         *logging.Logger                       // optional
+	LookupIPv6  func(netip.Addr) (rovy.PeerID, error) // optional, for PTR queries of other peers
+	Petnames    *petname.Store                        // optional, for AAAA queries of <petname>.rovy
+	Upstreams   *Upstreams                            // optional, for names outside of .rovy
 }
 
+// ServeDNS answers for .rovy and fc00::/8's ip6.arpa zone itself,
+// and forwards everything else to the upstream resolvers.
+//
+// For a known .rovy name, AAAA is its fc address, TXT is its PeerID,
+// and ANY is both. Other types, e.g. A or SRV, get an empty answer.
 func (h DNSHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	if h.Queries != nil {
+		h.Queries.Add(1)
+	}
+
+	m := new(dns.Msg)
+	if len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeFormatError)
+		h.reply(w, r, m)
+		return
+	}
 	qtype := r.Question[0].Qtype
 	qname := r.Question[0].Name
+	lname := strings.ToLower(qname)
 
-	log.Printf("dns request: %s %s", dns.Type(qtype), qname)
+	if h.Log != nil {
+		h.Log.Debug("dns request", "qtype", dns.Type(qtype), "qname", qname)
+	}
 
-	m := new(dns.Msg)
 	m.SetReply(r)
 
-	if qname == "localhost.rovy." {
-		rr := &dns.AAAA{
-			Hdr:  dns.RR_Header{Name: qname, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 0},
-			AAAA: net.IP(h.LocalPeerID.PublicKey().IPAddr().AsSlice()),
-		}
-		m.Answer = append(m.Answer, rr)
-		w.WriteMsg(m)
+	switch {
+	case dns.IsSubDomain(ReverseZone, lname):
+		m.Authoritative = true
+		h.servePTR(w, r, m)
+	case dns.IsSubDomain(RovyZone, lname):
+		m.Authoritative = true
+		h.serveRovy(w, r, m)
+	default:
+		h.forward(w, r)
+	}
+}
+
+func (h DNSHandler) serveRovy(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
+	qtype := r.Question[0].Qtype
+	qname := r.Question[0].Name
+
+	// the peer's name is the label in front of .rovy,
+	// any labels in front of it have to be like _http._tcp
+	labels := dns.SplitDomainName(strings.ToLower(qname))
+	if len(labels) < 2 {
+		// the zone itself
+		h.reply(w, r, m)
 		return
 	}
+	name := labels[len(labels)-2]
+	for _, l := range labels[:len(labels)-2] {
+		if !strings.HasPrefix(l, "_") {
+			m.SetRcode(r, dns.RcodeNameError)
+			h.reply(w, r, m)
+			return
+		}
+	}
 
-	if qtype != dns.TypeAAAA || !strings.HasPrefix(qname, "bafzqai") || !strings.HasSuffix(qname, ".rovy.") {
-		m.SetRcode(r, dns.RcodeNameError)
-		w.WriteMsg(m)
+	pid, rcode := h.lookupName(name)
+	if rcode != dns.RcodeSuccess {
+		m.SetRcode(r, rcode)
+		h.reply(w, r, m)
+		return
+	}
+	if len(labels) > 2 {
+		// e.g. SRV, which we don't know about
+		h.reply(w, r, m)
 		return
 	}
 
-	cid, err := cid.Decode(strings.TrimSuffix(qname, ".rovy."))
+	hdr := dns.RR_Header{Name: qname, Class: dns.ClassINET, Ttl: 0}
+	if qtype == dns.TypeAAAA || qtype == dns.TypeANY {
+		hdr.Rrtype = dns.TypeAAAA
+		m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.IP(pid.PublicKey().IPAddr().AsSlice())})
+	}
+	if qtype == dns.TypeTXT || qtype == dns.TypeANY {
+		hdr.Rrtype = dns.TypeTXT
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: hdr, Txt: []string{"peerid=" + pid.String()}})
+	}
+	h.reply(w, r, m)
+}
+
+// lookupName resolves localhost, a petname, or a PeerID.
+func (h DNSHandler) lookupName(name string) (rovy.PeerID, int) {
+	if name == "localhost" {
+		return h.LocalPeerID, dns.RcodeSuccess
+	}
+	if h.Petnames != nil {
+		if pid, present := h.Petnames.Lookup(name); present {
+			return pid, dns.RcodeSuccess
+		}
+	}
+	if !strings.HasPrefix(name, "bafzqai") {
+		return rovy.PeerID{}, dns.RcodeNameError
+	}
+
+	cid, err := cid.Decode(name)
 	if err != nil {
-		log.Printf("cid: %s", err)
-		m.SetRcode(r, dns.RcodeBadName)
-		w.WriteMsg(m)
-		return
+		h.warn("dns: cid", "name", name, "err", err)
+		return rovy.PeerID{}, dns.RcodeNameError
 	}
 	pid, err := rovy.PeerIDFromCid(cid)
 	if err != nil {
-		log.Printf("cid: %s", err)
-		m.SetRcode(r, dns.RcodeBadName)
-		w.WriteMsg(m)
+		h.warn("dns: cid", "name", name, "err", err)
+		return rovy.PeerID{}, dns.RcodeNameError
+	}
+	return pid, dns.RcodeSuccess
+}
+
+// servePTR answers with <peerid>.rovy for fc addresses of the local node,
+// or of peers that the routing table knows.
+func (h DNSHandler) servePTR(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
+	qname := r.Question[0].Name
+
+	ip, ok := parseReverseIPv6(qname)
+	if !ok {
+		// not a full address, e.g. a query for the zone itself
+		m.SetRcode(r, dns.RcodeNameError)
+		h.reply(w, r, m)
 		return
 	}
 
-	rr := &dns.AAAA{
-		Hdr:  dns.RR_Header{Name: qname, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 0},
-		AAAA: net.IP(pid.PublicKey().IPAddr().AsSlice()),
+	var pid rovy.PeerID
+	if ip == h.LocalPeerID.PublicKey().IPAddr() {
+		pid = h.LocalPeerID
+	} else if h.LookupIPv6 != nil {
+		pid, _ = h.LookupIPv6(ip)
+	}
+	if pid.Empty() {
+		m.SetRcode(r, dns.RcodeNameError)
+		h.reply(w, r, m)
+		return
 	}
-	m.Answer = append(m.Answer, rr)
-	w.WriteMsg(m)
+
+	qtype := r.Question[0].Qtype
+	if qtype == dns.TypePTR || qtype == dns.TypeANY {
+		rr := &dns.PTR{
+			Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 0},
+			Ptr: pid.String() + ".rovy.",
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	h.reply(w, r, m)
 }
 
+// reply adds EDNS if the query had it, and truncates UDP responses
+// to what the client can take.
+func (h DNSHandler) reply(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(MaxUDPSize, opt.Do())
+		size = int(opt.UDPSize())
+		if size < dns.MinMsgSize {
+			size = dns.MinMsgSize
+		}
+		if size > MaxUDPSize {
+			size = MaxUDPSize
+		}
+	}
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); !ok {
+		m.Truncate(size)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		h.warn("dns: write", "err", err)
+	}
+}
+
+// forward passes the query on to each upstream resolver in turn,
+// using the same protocol it came in with, and returns the first response as is.
+// Without upstreams, names outside of .rovy don't exist.
+func (h DNSHandler) forward(w dns.ResponseWriter, r *dns.Msg) {
+	var upstreams []string
+	if h.Upstreams != nil {
+		upstreams = h.Upstreams.Get()
+	}
+
+	m := new(dns.Msg)
+	if len(upstreams) == 0 {
+		m.SetRcode(r, dns.RcodeNameError)
+		h.reply(w, r, m)
+		return
+	}
+
+	c := &dns.Client{Net: "udp", Timeout: ForwardTimeout}
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		c.Net = "tcp"
+	}
+	for _, addr := range upstreams {
+		res, _, err := c.Exchange(r, addr)
+		if err != nil {
+			h.warn("dns: forward", "upstream", addr, "err", err)
+			continue
+		}
+		if err := w.WriteMsg(res); err != nil {
+			h.warn("dns: write", "err", err)
+		}
+		return
+	}
+
+	m.SetRcode(r, dns.RcodeServerFailure)
+	h.reply(w, r, m)
+}
+
+// parseReverseIPv6 is the inverse of dns.ReverseAddr for IPv6,
+// i.e. it parses 32 nibbles in reverse order followed by ip6.arpa.
+func parseReverseIPv6(qname string) (netip.Addr, bool) {
+	labels := dns.SplitDomainName(strings.ToLower(qname))
+	if len(labels) != 34 || labels[32] != "ip6" || labels[33] != "arpa" {
+		return netip.Addr{}, false
+	}
+
+	var b [16]byte
+	for i := 0; i < 32; i++ {
+		n, err := strconv.ParseUint(labels[31-i], 16, 4)
+		if err != nil || len(labels[31-i]) != 1 {
+			return netip.Addr{}, false
+		}
+		b[i/2] |= byte(n) << (4 * (1 - i%2))
+	}
+	return netip.AddrFrom16(b), true
+}
+
+func (h DNSHandler) warn(msg string, kv ...any) {
+	if h.Log != nil {
+		h.Log.Warn(msg, kv...)
+	}
+}
+
+// Upstreams are the resolvers which DNSHandler forwards to, as ip:port.
+// They can be changed while the DNS server is running.
+type Upstreams struct {
+	sync.RWMutex
+	addrs []string
+}
+
+// Set replaces the resolvers. The port defaults to 53,
+// e.g. 2620:fe::fe or [2620:fe::fe]:53 or 9.9.9.9:53.
+func (u *Upstreams) Set(addrs []string) error {
+	parsed := make([]string, 0, len(addrs))
+	for _, s := range addrs {
+		ap, err := netip.ParseAddrPort(s)
+		if err != nil {
+			ip, err2 := netip.ParseAddr(s)
+			if err2 != nil {
+				return fmt.Errorf("upstream %s: %s", s, err)
+			}
+			ap = netip.AddrPortFrom(ip, 53)
+		}
+		if ap.Addr() == fc1Addr {
+			return fmt.Errorf("upstream %s: that's us", s)
+		}
+		parsed = append(parsed, ap.String())
+	}
+
+	u.Lock()
+	defer u.Unlock()
+	u.addrs = parsed
+	return nil
+}
+
+func (u *Upstreams) Get() []string {
+	u.RLock()
+	defer u.RUnlock()
+	return append([]string{}, u.addrs...)
+}
+
+// testHookInitDns makes initDns fail if it returns an error.
+var testHookInitDns = func() error { return nil }
+
 func (fc *Fcnet) initDns() error {
+	if err := testHookInitDns(); err != nil {
+		return err
+	}
+
 	pktconn, err := fc.fc1net.ListenUDP(&net.UDPAddr{Port: 53})
 	if err != nil {
 		return err
 	}
-	serv := &dns.Server{
-		Net:        "udp6",
-		PacketConn: pktconn,
-		Handler:    DNSHandler{fc.node.PeerID()},
+	lis, err := fc.fc1net.ListenTCP(&net.TCPAddr{Port: 53})
+	if err != nil {
+		pktconn.Close()
+		return err
+	}
+
+	handler := DNSHandler{
+		LocalPeerID: fc.node.PeerID(),
+		Queries:     &fc.dnsQueries,
+		Log:         fc.log,
+		LookupIPv6:  fc.routing.LookupIPv6,
+		Petnames:    fc.node.Petnames(),
+		Upstreams:   &fc.upstreams,
+	}
+	fc.fc1dns = &dns.Server{Net: "udp6", PacketConn: pktconn, Handler: handler}
+	fc.fc1dnsTCP = &dns.Server{Net: "tcp6", Listener: lis, Handler: handler}
+
+	fc.dnsRunning.Store(true)
+	for _, serv := range []*dns.Server{fc.fc1dns, fc.fc1dnsTCP} {
+		go func(serv *dns.Server) {
+			defer fc.dnsRunning.Store(false)
+			if err := serv.ActivateAndServe(); err != nil && !fc.stopped() {
+				fc.log.Error("dns", "net", serv.Net, "err", err)
+			}
+		}(serv)
 	}
-	go func() {
-		if err = serv.ActivateAndServe(); err != nil {
-			fc.log.Printf("dns: %s", err)
-		}
-	}()
 
-	fc.fc1dns = serv
 	return nil
 }
+
+// DNSUpstreams are the resolvers that fc00::1 forwards to for names outside of .rovy.
+func (fc *Fcnet) DNSUpstreams() *Upstreams {
+	return &fc.upstreams
+}
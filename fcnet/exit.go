@@ -0,0 +1,444 @@
+package fcnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	ipv6 "golang.org/x/net/ipv6"
+
+	rovy "go.rovy.net"
+	policy "go.rovy.net/node/policy"
+	logging "go.rovy.net/node/util/logging"
+)
+
+// Exit nodes
+//
+// An exit node carries IPv6 traffic between its clients and the Internet.
+// Clients send packets for global unicast destinations in 2000::/3 to the first of their exits
+// which advertised itself and they have a route to, with their own fc00::/8 address as the source.
+// Exits only forward packets to 2000::/3, so that clients can't reach e.g. the exit's LAN.
+// The exit writes them to its TUN device, and the kernel forwards them,
+// usually with masquerading so that replies come back to the exit.
+// Replies for a client's fc00::/8 address are sent back to the client.
+//
+// Packets for the Internet go through the TUN device because of routes like 2000::/3,
+// which must not cover the addresses that the exit is connected to,
+// otherwise the connection to the exit would go through itself.
+//
+// For IPv4, the exit exports 0.0.0.0/0, see IPv4 over fcnet.
+//
+// Exits advertise the prefixes they carry traffic for to their clients, every AdvertInterval,
+// and clients only use exits whose advertisement hasn't expired. Clients solicit
+// an advertisement from exits they haven't heard from. Advertisements are a flags byte,
+// with 0x1 for soliciting, followed by 16 bytes address and 1 byte prefix length
+// per prefix. Nodes which don't serve send none, which also tells former clients.
+//
+// This can be tried out with two network namespaces, e.g. with `ip netns add`,
+// connected by a veth pair with ULA addresses, and with the exit in the namespace
+// that has Internet access and an nftables masquerade rule for fc00::/8.
+// The client's namespace gets a route for 2000::/3 into its rovy0 device.
+
+const FcnetExitMulticodec = 0x42008
+const FcnetExitRoutesMulticodec = 0x42009
+
+const exitSolicit = 0x1
+
+var fcPrefix = netip.MustParsePrefix("fc00::/8")
+
+// globalPrefix is IPv6 global unicast, the only destinations exits carry traffic to.
+var globalPrefix = netip.MustParsePrefix("2000::/3")
+
+func init() {
+	policy.RegisterCodec("fcnet-exit", FcnetExitMulticodec)
+	policy.RegisterCodec("fcnet-exit-routes", FcnetExitRoutesMulticodec)
+}
+
+// Exit is the configuration for serving as an exit node, and for using exit nodes.
+// It can be changed while fcnet is running.
+type Exit struct {
+	sync.RWMutex
+	serve      bool
+	clients    map[netip.Addr]rovy.PeerID // by their fc00::/8 address
+	exits      []rovy.PeerID
+	routes     []netip.Prefix
+	advertised map[rovy.PeerID]exitAdvert
+	retired    []rovy.PeerID // former clients, which haven't been told yet
+	changed    chan struct{} // wakes up the advertising loop
+}
+
+// exitAdvert is what one of our exits advertised.
+type exitAdvert struct {
+	prefixes []netip.Prefix
+	expires  time.Time
+}
+
+// Set replaces the configuration. With serve, the clients may use us as their exit.
+// Exits are the peers we use as exits, in order of preference,
+// and routes are the prefixes routed to the TUN device,
+// which are only kept here for setting up the device.
+func (ex *Exit) Set(serve bool, clients []rovy.PeerID, exits []rovy.PeerID, routes []netip.Prefix) error {
+	for _, pfx := range routes {
+		if !pfx.Addr().Is6() || !globalPrefix.Contains(pfx.Addr()) || pfx.Bits() < globalPrefix.Bits() {
+			return fmt.Errorf("exit: not a prefix within %s: %s", globalPrefix, pfx)
+		}
+	}
+
+	ex.Lock()
+	defer ex.Unlock()
+
+	// clients we stop serving get told with an empty advertisement
+	if ex.serve {
+		for addr, pid := range ex.clients {
+			if serve && containsPeer(clients, pid) {
+				continue
+			}
+			ex.retired = append(ex.retired, pid)
+			delete(ex.clients, addr)
+		}
+	}
+
+	ex.serve = serve
+	ex.clients = map[netip.Addr]rovy.PeerID{}
+	for _, pid := range clients {
+		ex.clients[pid.PublicKey().IPAddr()] = pid
+	}
+	ex.exits = append([]rovy.PeerID{}, exits...)
+	ex.routes = append([]netip.Prefix{}, routes...)
+
+	// forget what peers advertised, who aren't our exits anymore
+	for pid := range ex.advertised {
+		if !ex.isExitLocked(pid) {
+			delete(ex.advertised, pid)
+		}
+	}
+
+	if ex.changed != nil {
+		select {
+		case ex.changed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (ex *Exit) Serving() bool {
+	ex.RLock()
+	defer ex.RUnlock()
+	return ex.serve
+}
+
+func (ex *Exit) Clients() []rovy.PeerID {
+	ex.RLock()
+	defer ex.RUnlock()
+
+	out := make([]rovy.PeerID, 0, len(ex.clients))
+	for _, pid := range ex.clients {
+		out = append(out, pid)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out
+}
+
+func (ex *Exit) Exits() []rovy.PeerID {
+	ex.RLock()
+	defer ex.RUnlock()
+	return append([]rovy.PeerID{}, ex.exits...)
+}
+
+func (ex *Exit) Routes() []netip.Prefix {
+	ex.RLock()
+	defer ex.RUnlock()
+	return append([]netip.Prefix{}, ex.routes...)
+}
+
+// client returns the client with the given fc00::/8 address, if we're serving.
+func (ex *Exit) client(addr netip.Addr) (rovy.PeerID, bool) {
+	ex.RLock()
+	defer ex.RUnlock()
+
+	if !ex.serve {
+		return rovy.PeerID{}, false
+	}
+	pid, present := ex.clients[addr]
+	return pid, present
+}
+
+func (ex *Exit) isExit(pid rovy.PeerID) bool {
+	ex.RLock()
+	defer ex.RUnlock()
+	return ex.isExitLocked(pid)
+}
+
+func (ex *Exit) isExitLocked(pid rovy.PeerID) bool {
+	for _, pid2 := range ex.exits {
+		if pid2 == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// takeRetired returns the former clients, and forgets them.
+func (ex *Exit) takeRetired() []rovy.PeerID {
+	ex.Lock()
+	defer ex.Unlock()
+
+	out := ex.retired
+	ex.retired = nil
+	return out
+}
+
+func containsPeer(pids []rovy.PeerID, pid rovy.PeerID) bool {
+	for _, pid2 := range pids {
+		if pid2 == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func (ex *Exit) isClient(pid rovy.PeerID) bool {
+	ex.RLock()
+	defer ex.RUnlock()
+	return ex.serve && ex.clients[pid.PublicKey().IPAddr()] == pid
+}
+
+// Advertised returns the exits whose advertisement hasn't expired yet, in order of preference.
+func (ex *Exit) Advertised() []rovy.PeerID {
+	ex.RLock()
+	defer ex.RUnlock()
+
+	out := []rovy.PeerID{}
+	for _, pid := range ex.exits {
+		if len(ex.advertisedLocked(pid)) > 0 {
+			out = append(out, pid)
+		}
+	}
+	return out
+}
+
+// advertisedLocked returns the prefixes the exit advertised, unless they expired.
+func (ex *Exit) advertisedLocked(pid rovy.PeerID) []netip.Prefix {
+	adv, present := ex.advertised[pid]
+	if !present || time.Now().After(adv.expires) {
+		return nil
+	}
+	return adv.prefixes
+}
+
+// covers reports whether the exit advertised a prefix containing dst.
+func (ex *Exit) covers(pid rovy.PeerID, dst netip.Addr) bool {
+	ex.RLock()
+	defer ex.RUnlock()
+
+	for _, pfx := range ex.advertisedLocked(pid) {
+		if pfx.Contains(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+// learn keeps what one of our exits advertised. An empty advertisement means
+// that it doesn't serve us anymore.
+func (ex *Exit) learn(src rovy.PeerID, pfxs []netip.Prefix) error {
+	ex.Lock()
+	defer ex.Unlock()
+
+	if !ex.isExitLocked(src) {
+		return fmt.Errorf("exit: advertisement from %s, which isn't our exit", src)
+	}
+	if len(pfxs) == 0 {
+		delete(ex.advertised, src)
+		return nil
+	}
+	if ex.advertised == nil {
+		ex.advertised = map[rovy.PeerID]exitAdvert{}
+	}
+	ex.advertised[src] = exitAdvert{prefixes: pfxs, expires: time.Now().Add(RouteTimeout)}
+	return nil
+}
+
+// advertisement returns what we carry traffic for in the advertisement format.
+func (ex *Exit) advertisement(flags byte) []byte {
+	ex.RLock()
+	defer ex.RUnlock()
+
+	buf := []byte{flags}
+	if ex.serve {
+		a := globalPrefix.Addr().As16()
+		buf = append(buf, a[:]...)
+		buf = append(buf, byte(globalPrefix.Bits()))
+	}
+	return buf
+}
+
+// Exit is the exit node configuration.
+func (fc *Fcnet) Exit() *Exit {
+	return &fc.exit
+}
+
+// SelectedExit returns the first exit that advertised itself, and that we have a route to.
+func (fc *Fcnet) SelectedExit() (rovy.PeerID, bool) {
+	return fc.selectExit(globalPrefix.Addr())
+}
+
+// selectExit returns the first exit that advertised a prefix containing dst,
+// and that we have a route to.
+func (fc *Fcnet) selectExit(dst netip.Addr) (rovy.PeerID, bool) {
+	for _, pid := range fc.exit.Exits() {
+		if !fc.exit.covers(pid, dst) {
+			continue
+		}
+		if _, err := fc.routing.GetRoute(pid); err == nil {
+			return pid, true
+		}
+	}
+	return rovy.PeerID{}, false
+}
+
+// advertiseExit sends our advertisement to our clients, and solicits one
+// from the exits we haven't heard from.
+func (fc *Fcnet) advertiseExit() {
+	for _, pid := range fc.exit.takeRetired() {
+		if err := fc.sendUpper(pid, FcnetExitRoutesMulticodec, []byte{0}); err != nil {
+			fc.log.Debug("exit: retire", "peer", pid, "err", err)
+		}
+	}
+
+	if fc.exit.Serving() {
+		adv := fc.exit.advertisement(0)
+		for _, pid := range fc.exit.Clients() {
+			if err := fc.sendUpper(pid, FcnetExitRoutesMulticodec, adv); err != nil {
+				fc.log.Debug("exit: advertise", "peer", pid, "err", err)
+			}
+		}
+	}
+
+	advertised := map[rovy.PeerID]bool{}
+	for _, pid := range fc.exit.Advertised() {
+		advertised[pid] = true
+	}
+	adv := fc.exit.advertisement(exitSolicit)
+	for _, pid := range fc.exit.Exits() {
+		if advertised[pid] {
+			continue
+		}
+		if err := fc.sendUpper(pid, FcnetExitRoutesMulticodec, adv); err != nil {
+			fc.log.Debug("exit: solicit", "peer", pid, "err", err)
+		}
+	}
+}
+
+func (fc *Fcnet) handleExitRoutesPacket(src rovy.PeerID, payload []byte) error {
+	if len(payload) == 0 || (len(payload)-1)%17 != 0 {
+		return fmt.Errorf("exit: advertisement from %s: invalid length %d", src, len(payload))
+	}
+	flags := payload[0]
+
+	var pfxs []netip.Prefix
+	for i := 1; i < len(payload); i += 17 {
+		pfx, err := netip.AddrFrom16(*(*[16]byte)(payload[i : i+16])).Prefix(int(payload[i+16]))
+		if err != nil {
+			return fmt.Errorf("exit: advertisement from %s: %s", src, err)
+		}
+		pfxs = append(pfxs, pfx)
+	}
+
+	if fc.exit.isExit(src) {
+		if err := fc.exit.learn(src, pfxs); err != nil {
+			return err
+		}
+		fc.log.Debug("exit: routes", "peer", src, "prefixes", pfxs)
+	}
+
+	// a client that hasn't heard from us gets our routes right away
+	if flags&exitSolicit != 0 && fc.exit.isClient(src) {
+		return fc.sendUpper(src, FcnetExitRoutesMulticodec, fc.exit.advertisement(0))
+	}
+	return nil
+}
+
+// handleTunExitPacket sends a packet for the Internet to our exit.
+func (fc *Fcnet) handleTunExitPacket(buf []byte) error {
+	dst := netip.AddrFrom16(*(*[16]byte)(buf[24:40]))
+	if !globalPrefix.Contains(dst) {
+		fc.log.Debug("exit: dropping packet outside of global unicast", "dst", dst)
+		return nil
+	}
+
+	peerid, present := fc.selectExit(dst)
+	if !present {
+		// TODO: icmp destination unreachable
+		return nil
+	}
+
+	route, err := fc.routing.GetRoute(peerid)
+	if err != nil {
+		return fmt.Errorf("tun: no route for exit %s: %s", peerid, err)
+	}
+	if mtu := fc.pathMTU(route); len(buf) > mtu {
+		return fc.packetTooBig(buf, mtu)
+	}
+
+	fc.fw.Outbound(buf)
+
+	return fc.sendUpper(peerid, FcnetExitMulticodec, buf)
+}
+
+// handleTunReplyPacket sends a packet from the Internet back to our client.
+func (fc *Fcnet) handleTunReplyPacket(peerid rovy.PeerID, buf []byte) error {
+	route, err := fc.routing.GetRoute(peerid)
+	if err != nil {
+		return fmt.Errorf("tun: no route for exit client %s: %s", peerid, err)
+	}
+	if mtu := fc.pathMTU(route); len(buf) > mtu {
+		// the sender is somewhere on the Internet, and fc00::1 isn't a valid source there.
+		// the client's TCP stack announces an MSS that fits anyway.
+		fc.log.Debug("exit: dropping reply too big for the path", "len", len(buf), "mtu", mtu)
+		return nil
+	}
+
+	return fc.sendUpper(peerid, FcnetExitMulticodec, buf)
+}
+
+// handleExitPacket writes packets from our clients to the TUN device,
+// and replies from our exit, if they pass the firewall.
+func (fc *Fcnet) handleExitPacket(src rovy.PeerID, payload []byte) error {
+	n := len(payload)
+	if n < ipv6.HeaderLen || payload[0]>>4 != 0x6 {
+		return fmt.Errorf("fcnet: recv: not an ipv6 packet")
+	}
+	if gotlen := int(binary.BigEndian.Uint16(payload[4:6])); n != gotlen+ipv6.HeaderLen {
+		return fmt.Errorf("fcnet: recv: length mismatch, expected %d, got %d", n, gotlen+ipv6.HeaderLen)
+	}
+
+	psrc := netip.AddrFrom16(*(*[16]byte)(payload[8:24]))
+	pdst := netip.AddrFrom16(*(*[16]byte)(payload[24:40]))
+
+	// a reply from the Internet, through our exit
+	if pdst == fc.ip && globalPrefix.Contains(psrc) && fc.exit.isExit(src) {
+		if !fc.fw.Inbound(src, payload) {
+			fc.fwDropped.Add(1)
+			if fc.log.Enabled(logging.DebugLevel) {
+				nexthdr, _ := transportHeader(payload)
+				fc.log.Debug("firewall: dropping inbound exit packet", "src", psrc, "exit", src, "nexthdr", nexthdr)
+			}
+			return nil
+		}
+		return fc.writeTun(payload)
+	}
+
+	// a client's packet for the Internet, but not for anything else the exit can reach
+	if pid, present := fc.exit.client(psrc); present && pid == src && globalPrefix.Contains(pdst) {
+		return fc.writeTun(payload)
+	}
+
+	fc.fwDropped.Add(1)
+	fc.log.Debug("exit: dropping packet", "peer", src, "src", psrc, "dst", pdst)
+	return nil
+}
@@ -0,0 +1,8 @@
+package fcnet
+
+// SetInitDnsHook replaces the hook which makes initDns fail, and returns a function that restores it.
+func SetInitDnsHook(hook func() error) (restore func()) {
+	prev := testHookInitDns
+	testHookInitDns = hook
+	return func() { testHookInitDns = prev }
+}
@@ -3,9 +3,11 @@ package fcnet
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
 	"net/netip"
+	"sync"
+	"sync/atomic"
 
 	dns "github.com/miekg/dns"
 	ipv6 "golang.org/x/net/ipv6"
@@ -14,7 +16,10 @@ import (
 	rovy "go.rovy.net"
 	node "go.rovy.net/node"
 	forwarder "go.rovy.net/node/forwarder"
+	petname "go.rovy.net/node/petname"
+	policy "go.rovy.net/node/policy"
 	rovyrt "go.rovy.net/node/routing"
+	logging "go.rovy.net/node/util/logging"
 )
 
 const FcnetMulticodec = 0x42004
@@ -28,58 +33,263 @@ var (
 	fc1Addr         = netip.MustParseAddr("fc00::1")
 )
 
+func init() {
+	policy.RegisterCodec("fcnet", FcnetMulticodec)
+}
+
+// logSubsystem is the node's logger used by fcnet.
+const logSubsystem = node.LogFcnet
+
 type nodeIface interface {
 	PeerID() rovy.PeerID
+	Sign([]byte) ([]byte, error)
 	Handle(uint64, node.UpperHandler)
 	HandleLower(uint64, node.LowerHandler)
+	Unhandle(uint64)
+	UnhandleLower(uint64)
 	Forwarder() *forwarder.Forwarder
 	Routing() *rovyrt.Routing
 	SendUpper(rovy.UpperPacket) error
-	Log() *log.Logger
+	Logger(string) *logging.Logger
+	Policies() *policy.Policies
+	Petnames() *petname.Store
+	ProbePathMTU(rovy.Route) (int, error)
 }
 
 type routingIface interface {
 	GetRoute(rovy.PeerID) (rovy.Route, error)
 	LookupIPv6(netip.Addr) (rovy.PeerID, error)
+	PathMTU(rovy.Route) (int, bool)
+	SetPathMTU(rovy.Route, int)
 }
 
 type Fcnet struct {
 	node    nodeIface
 	routing routingIface
-	log     *log.Logger
+	log     *logging.Logger
 	ip      netip.Addr
 	device  Device
 	fc1net  *wgnet.Net
 	fc1tun  Device
 	fc1dns  *dns.Server
+	fw      *Firewall
+
+	fc1dnsTCP *dns.Server
+	upstreams Upstreams
+	probing   sync.Map // routes which are being probed
+	ipv4      IPv4
+	exit      Exit
+
+	lock       sync.Mutex
+	state      int // one of the state constants below
+	ifname     string
+	mtu        int
+	done       chan struct{} // closed by Stop
+	dnsRunning atomic.Bool
+
+	tunRxPackets atomic.Uint64
+	tunRxBytes   atomic.Uint64
+	tunTxPackets atomic.Uint64
+	tunTxBytes   atomic.Uint64
+	dnsQueries   atomic.Uint64
+	fwDropped    atomic.Uint64
+}
+
+const (
+	stateNew = iota
+	stateRunning
+	stateStopped
+)
+
+var (
+	ErrRunning    = errors.New("fcnet is running")
+	ErrNotRunning = errors.New("fcnet is not running")
+	ErrStopped    = errors.New("fcnet was stopped, start a new one")
+)
+
+// Stats are the totals of packets read from (rx) and written to (tx)
+// the TUN device, of DNS queries answered by fc00::1,
+// and of inbound packets dropped by the firewall.
+type Stats struct {
+	TunRxPackets    uint64
+	TunRxBytes      uint64
+	TunTxPackets    uint64
+	TunTxBytes      uint64
+	DNSQueries      uint64
+	FirewallDropped uint64
+}
+
+func (fc *Fcnet) Stats() Stats {
+	return Stats{
+		TunRxPackets:    fc.tunRxPackets.Load(),
+		TunRxBytes:      fc.tunRxBytes.Load(),
+		TunTxPackets:    fc.tunTxPackets.Load(),
+		TunTxBytes:      fc.tunTxBytes.Load(),
+		DNSQueries:      fc.dnsQueries.Load(),
+		FirewallDropped: fc.fwDropped.Load(),
+	}
+}
+
+// writeTun writes a packet to the TUN device, and counts it.
+func (fc *Fcnet) writeTun(buf []byte) error {
+	if _, err := fc.device.Write(buf, 0); err != nil {
+		return err
+	}
+	fc.tunTxPackets.Add(1)
+	fc.tunTxBytes.Add(uint64(len(buf)))
+	return nil
 }
 
 func NewFcnet(node nodeIface, dev Device) *Fcnet {
 	fc := &Fcnet{
-		node: node, ip: node.PeerID().PublicKey().IPAddr(), log: node.Log(), device: dev, routing: node.Routing(),
+		node: node, ip: node.PeerID().PublicKey().IPAddr(), log: node.Logger(logSubsystem), device: dev, routing: node.Routing(),
+		fw: NewFirewall(node.Policies()),
 	}
+	fc.ipv4.changed = make(chan struct{}, 1)
+	fc.exit.changed = make(chan struct{}, 1)
 	return fc
 }
 
+// Firewall returns the filter for inbound packets, which drops everything
+// that isn't part of an outbound flow, or allowed by port or peer.
+func (fc *Fcnet) Firewall() *Firewall {
+	return fc.fw
+}
+
+// Start registers the fcnet codecs with the node, and starts reading from the TUN device.
+// A Fcnet can only be started once, after Stop it takes a new one and a new device.
 func (fc *Fcnet) Start(mtu int) error {
-	fc.node.HandleLower(PingMulticodec, fc.handlePingPacket)
-	fc.node.Handle(FcnetMulticodec, func(upkt rovy.UpperPacket) error {
-		return fc.handleFcnetPacket(upkt.UpperSrc, upkt.Payload())
-	})
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	switch fc.state {
+	case stateRunning:
+		return ErrRunning
+	case stateStopped:
+		return ErrStopped
+	}
+
+	ifname, err := fc.device.Name()
+	if err != nil {
+		return fmt.Errorf("tun name: %s", err)
+	}
+	fc.ifname = ifname
+	fc.mtu = mtu
+	fc.done = make(chan struct{})
 
 	if err := fc.initFcnet1(mtu); err != nil {
 		return err
 	}
 
 	if err := fc.initDns(); err != nil {
+		// stops the fc00::1 routine, Start can be tried again with a new done channel
+		close(fc.done)
+		fc.fc1tun.Close()
 		return err
 	}
 
+	fc.node.HandleLower(PingMulticodec, fc.handlePingPacket)
+	fc.node.Handle(FcnetMulticodec, func(upkt rovy.UpperPacket) error {
+		return fc.handleFcnetPacket(upkt.UpperSrc, upkt.Payload())
+	})
+	fc.node.Handle(Fcnet4Multicodec, func(upkt rovy.UpperPacket) error {
+		return fc.handleFcnet4Packet(upkt.UpperSrc, upkt.Payload())
+	})
+	fc.node.Handle(Fcnet4RoutesMulticodec, func(upkt rovy.UpperPacket) error {
+		return fc.handleRoutesPacket(upkt.UpperSrc, upkt.Payload())
+	})
+	fc.node.Handle(FcnetExitMulticodec, func(upkt rovy.UpperPacket) error {
+		return fc.handleExitPacket(upkt.UpperSrc, upkt.Payload())
+	})
+	fc.node.Handle(FcnetExitRoutesMulticodec, func(upkt rovy.UpperPacket) error {
+		return fc.handleExitRoutesPacket(upkt.UpperSrc, upkt.Payload())
+	})
+
 	go fc.listenTun()
+	go fc.advertiseLoop()
 
+	fc.state = stateRunning
 	return nil
 }
 
+// Stop deregisters the fcnet codecs, shuts down fc00::1 and its DNS server,
+// and closes the TUN device.
+func (fc *Fcnet) Stop() error {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	if fc.state != stateRunning {
+		return ErrNotRunning
+	}
+	fc.state = stateStopped
+	close(fc.done)
+
+	fc.node.Unhandle(FcnetMulticodec)
+	fc.node.Unhandle(Fcnet4Multicodec)
+	fc.node.Unhandle(Fcnet4RoutesMulticodec)
+	fc.node.Unhandle(FcnetExitMulticodec)
+	fc.node.Unhandle(FcnetExitRoutesMulticodec)
+	fc.node.UnhandleLower(PingMulticodec)
+
+	if err := fc.fc1dns.Shutdown(); err != nil {
+		fc.log.Warn("stop: dns shutdown", "err", err)
+	}
+	if err := fc.fc1dnsTCP.Shutdown(); err != nil {
+		fc.log.Warn("stop: dns shutdown", "net", "tcp", "err", err)
+	}
+	if err := fc.fc1tun.Close(); err != nil {
+		fc.log.Warn("stop: fc00::1 close", "err", err)
+	}
+	if err := fc.device.Close(); err != nil {
+		return fmt.Errorf("tun close: %s", err)
+	}
+	return nil
+}
+
+func (fc *Fcnet) Running() bool {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.state == stateRunning
+}
+
+// Status describes the TUN device and whether fcnet is running.
+type Status struct {
+	Running    bool
+	Ifname     string
+	MTU        int
+	Addr       netip.Addr
+	DNSRunning bool
+	Stats      Stats
+}
+
+func (fc *Fcnet) Status() Status {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	return Status{
+		Running:    fc.state == stateRunning,
+		Ifname:     fc.ifname,
+		MTU:        fc.mtu,
+		Addr:       fc.ip,
+		DNSRunning: fc.dnsRunning.Load(),
+		Stats:      fc.Stats(),
+	}
+}
+
+// stopped reports whether the routines should exit after a read error.
+func (fc *Fcnet) stopped() bool {
+	return isClosed(fc.done)
+}
+
+func isClosed(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
 func (fc *Fcnet) initFcnet1(mtu int) error {
 	addrs := []netip.Addr{fc1Addr}
 	dnssrv := []netip.Addr{}
@@ -90,18 +300,23 @@ func (fc *Fcnet) initFcnet1(mtu int) error {
 	fc.fc1tun = ftun
 	fc.fc1net = fnet
 
+	// the device and done channel are this Start's, even if it fails and is tried again
+	done := fc.done
 	go func() {
+		// the buffer is reused, the device write doesn't hold on to it
+		buf := make([]byte, rovy.TptMTU)[rovy.UpperOffset:]
 		for {
-			pkt := rovy.NewPacket(make([]byte, rovy.TptMTU))
-			buf := pkt.Bytes()[rovy.UpperOffset:]
-
-			if _, err := fc.fc1tun.Read(buf, 0); err != nil {
-				fc.log.Printf("dns: tun read: %s", err)
+			n, err := ftun.Read(buf, 0)
+			if err != nil {
+				if isClosed(done) {
+					return
+				}
+				fc.log.Warn("dns: tun read", "err", err)
 				continue
 			}
 
-			if _, err = fc.device.Write(buf, 0); err != nil {
-				fc.log.Printf("dns: tun write: %s", err)
+			if err = fc.writeTun(buf[:n]); err != nil {
+				fc.log.Warn("dns: tun write", "err", err)
 				continue
 			}
 		}
@@ -111,26 +326,35 @@ func (fc *Fcnet) initFcnet1(mtu int) error {
 }
 
 func (fc *Fcnet) listenTun() {
+	// the buffer is reused, handleTunPacket copies whatever it sends on
+	buf := make([]byte, rovy.TptMTU)[rovy.UpperOffset:]
 	for {
-		pkt := rovy.NewPacket(make([]byte, rovy.TptMTU))
-		buf := pkt.Bytes()[rovy.UpperOffset:]
-
 		// TODO: "not pollable" error when device is deleted
 		n, err := fc.device.Read(buf, 0)
 		if err != nil {
-			fc.log.Printf("fcnet: tun read: %s", err)
-			continue
-		}
-		pkt.Length = rovy.UpperOffset + n
-
-		if buf[0]>>4 != 6 {
-			fc.log.Printf("tun: not an ipv6 packet: %#v buf=%#v", buf[0]>>4, buf[0:16])
+			if fc.stopped() {
+				return
+			}
+			fc.log.Warn("tun read", "err", err)
 			continue
 		}
+		fc.tunRxPackets.Add(1)
+		fc.tunRxBytes.Add(uint64(n))
 
-		if err := fc.handleTunPacket(buf[:n]); err != nil {
-			fc.log.Printf("fcnet: handleTunPacket: %s", err)
-			continue
+		switch buf[0] >> 4 {
+		case 6:
+			if err := fc.handleTunPacket(buf[:n]); err != nil {
+				fc.log.Warn("handleTunPacket", "err", err)
+			}
+		case 4:
+			if !fc.ipv4.Enabled() {
+				continue
+			}
+			if err := fc.handleTun4Packet(buf[:n]); err != nil {
+				fc.log.Warn("handleTun4Packet", "err", err)
+			}
+		default:
+			fc.log.Warn("tun: dropping packet with unknown ip version", "version", buf[0]>>4)
 		}
 	}
 }
@@ -160,7 +384,11 @@ func (fc *Fcnet) handleTunPacket(buf []byte) error {
 	}
 
 	if src != fc.ip {
-		fc.log.Printf("tun: dropping packet with illegal src address %s -> %s", src, dst)
+		// a reply from the Internet for one of our exit clients
+		if peerid, present := fc.exit.client(dst); present && globalPrefix.Contains(src) {
+			return fc.handleTunReplyPacket(peerid, buf)
+		}
+		fc.log.Warn("tun: dropping packet with illegal src address", "src", src, "dst", dst)
 		return nil
 	}
 
@@ -170,6 +398,10 @@ func (fc *Fcnet) handleTunPacket(buf []byte) error {
 		return err
 	}
 
+	if !fcPrefix.Contains(dst) {
+		return fc.handleTunExitPacket(buf)
+	}
+
 	peerid, err := fc.routing.LookupIPv6(dst)
 	if err != nil {
 		return err
@@ -182,7 +414,13 @@ func (fc *Fcnet) handleTunPacket(buf []byte) error {
 
 	// end-to-end transmission
 	if hops >= route.Len() {
-		upkt := rovy.NewUpperPacket(rovy.NewPacket(make([]byte, rovy.TptMTU)))
+		if mtu := fc.pathMTU(route); plen > mtu {
+			return fc.packetTooBig(buf, mtu)
+		}
+
+		fc.fw.Outbound(buf)
+
+		upkt := rovy.NewUpperPacket(rovy.AllocPacket())
 		upkt.UpperDst = peerid
 		upkt.SetRoute(route)
 		upkt.SetCodec(FcnetMulticodec)
@@ -198,7 +436,7 @@ func (fc *Fcnet) handleTunPacket(buf []byte) error {
 		}
 		rt := rovy.NewRoute(r...)
 
-		ppkt := NewPingPacket(rovy.NewPacket(make([]byte, rovy.TptMTU)))
+		ppkt := NewPingPacket(rovy.AllocPacket())
 		ppkt.LowerSrc = fc.node.PeerID()
 		ppkt.SetRoute(rt)
 		ppkt.SetSender(ppkt.LowerSrc.PublicKey())
@@ -207,6 +445,7 @@ func (fc *Fcnet) handleTunPacket(buf []byte) error {
 
 		ppkt, err := fc.sign(ppkt)
 		if err != nil {
+			ppkt.Release()
 			return err
 		}
 
@@ -216,9 +455,8 @@ func (fc *Fcnet) handleTunPacket(buf []byte) error {
 		return fc.node.Forwarder().SendRaw(lpkt)
 	}
 
-	fc.log.Printf(
-		"tun: dropped outgoing %s -> %s - ttl is too low for non-icmp (nexthdr=%d)",
-		src, dst, nexthdr)
+	fc.log.Warn("tun: dropping outgoing packet, ttl is too low for non-icmp",
+		"src", src, "dst", dst, "nexthdr", nexthdr)
 
 	return nil
 }
@@ -242,6 +480,14 @@ func (fc *Fcnet) handleFcnetPacket(src rovy.PeerID, payload []byte) error {
 		return fmt.Errorf("fcnet: recv: dst address mismatch")
 	}
 
-	_, err := fc.device.Write(payload, 0)
-	return err
+	if !fc.fw.Inbound(src, payload) {
+		fc.fwDropped.Add(1)
+		if fc.log.Enabled(logging.DebugLevel) {
+			nexthdr, _ := transportHeader(payload)
+			fc.log.Debug("firewall: dropping inbound packet", "src", src, "nexthdr", nexthdr)
+		}
+		return nil
+	}
+
+	return fc.writeTun(payload)
 }
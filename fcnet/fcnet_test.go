@@ -0,0 +1,68 @@
+package fcnet_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/netip"
+	"runtime"
+	"testing"
+	"time"
+
+	wgnet "golang.zx2c4.com/wireguard/tun/netstack"
+
+	rovy "go.rovy.net"
+	fcnet "go.rovy.net/fcnet"
+	node "go.rovy.net/node"
+)
+
+// A Start that fails in initDns doesn't leave the fc00::1 routine behind,
+// and can be tried again.
+func TestStartDnsFailure(t *testing.T) {
+	n := node.NewNode(rovy.MustGeneratePrivateKey(), log.New(ioutil.Discard, "", log.LstdFlags))
+	if _, err := n.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Stop()
+
+	dev, _, err := wgnet.CreateNetTUN([]netip.Addr{n.IPAddr()}, nil, rovy.UpperMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := fcnet.NewFcnet(n, dev)
+
+	errDns := errors.New("dns failed")
+	restore := fcnet.SetInitDnsHook(func() error { return errDns })
+	err = fc.Start(rovy.UpperMTU)
+	restore()
+	if err != errDns {
+		t.Fatalf("expected %q, got %v", errDns, err)
+	}
+	for i := 0; fc1Routines() > 0; i++ {
+		if i == 100 {
+			t.Fatal("expected the fc00::1 routine to exit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := fc.Start(rovy.UpperMTU); err != nil {
+		t.Fatalf("start again: %s", err)
+	}
+	if st := fc.Status(); !st.Running || !st.DNSRunning {
+		t.Fatalf("unexpected status after start: %+v", st)
+	}
+	if n := fc1Routines(); n != 1 {
+		t.Fatalf("expected one fc00::1 routine, got %d", n)
+	}
+	if err := fc.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fc1Routines returns the number of goroutines reading from fc00::1.
+func fc1Routines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return bytes.Count(buf, []byte("fcnet.(*Fcnet).initFcnet1.func"))
+}
@@ -0,0 +1,339 @@
+package fcnet
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	ipv6 "golang.org/x/net/ipv6"
+
+	rovy "go.rovy.net"
+	policy "go.rovy.net/node/policy"
+)
+
+// PortRule allows unsolicited inbound packets of a protocol and port.
+type PortRule = policy.PortRule
+
+var ParsePortRule = policy.ParsePortRule
+
+const (
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// FlowTimeout is how long a flow stays open without packets in either direction.
+const FlowTimeout = 5 * time.Minute
+
+// MaxFlows limits the flow table. Once it's full, new outbound flows
+// aren't tracked, and replies to them are dropped.
+const MaxFlows = 65536
+
+// FragmentTimeout is how long the remaining fragments of a packet are let in
+// after its first fragment, which is the reassembly timeout of RFC 8200.
+const FragmentTimeout = 60 * time.Second
+
+// MaxFragments limits the fragment table, like MaxFlows.
+const MaxFragments = 4096
+
+// flowKey identifies a flow from our point of view.
+// For ICMP echo, lport is the echo identifier and rport is 0.
+type flowKey struct {
+	proto  byte
+	remote netip.Addr
+	lport  uint16
+	rport  uint16
+}
+
+// fragKey identifies the fragments of an inbound packet.
+type fragKey struct {
+	remote netip.Addr
+	id     uint32
+}
+
+// Firewall is fcnet's stateful inbound packet filter.
+// Inbound TCP, UDP, and ICMPv6 is dropped unless it belongs to a flow
+// that we started, or its port or peer is allowed. ICMPv6 errors
+// are let through if the packet they quote belongs to one of our flows.
+//
+// Non-first fragments don't have a transport header. They're let through
+// if the first fragment was, so fragments that arrive before it are dropped.
+//
+// Ports can also be allowed for particular peers, by the node's policies.
+type Firewall struct {
+	sync.Mutex
+	policies  *policy.Policies
+	ports     map[PortRule]struct{}
+	peers     map[rovy.PeerID]struct{}
+	flows     map[flowKey]time.Time // last seen
+	frags     map[fragKey]time.Time // first fragment seen
+	lastSweep time.Time
+}
+
+// NewFirewall returns a firewall which allows nothing yet.
+// The policies are optional.
+func NewFirewall(policies *policy.Policies) *Firewall {
+	return &Firewall{
+		policies: policies,
+		ports:    map[PortRule]struct{}{},
+		peers:    map[rovy.PeerID]struct{}{},
+		flows:    map[flowKey]time.Time{},
+		frags:    map[fragKey]time.Time{},
+	}
+}
+
+// Ports returns the allowed ports, sorted.
+func (fw *Firewall) Ports() []PortRule {
+	fw.Lock()
+	defer fw.Unlock()
+
+	out := make([]PortRule, 0, len(fw.ports))
+	for pr := range fw.ports {
+		out = append(out, pr)
+	}
+	policy.SortPortRules(out)
+	return out
+}
+
+// Peers returns the peers which are allowed everything.
+func (fw *Firewall) Peers() []rovy.PeerID {
+	fw.Lock()
+	defer fw.Unlock()
+
+	out := make([]rovy.PeerID, 0, len(fw.peers))
+	for pid := range fw.peers {
+		out = append(out, pid)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out
+}
+
+// SetRules replaces the allowed ports and peers. Existing flows stay open.
+func (fw *Firewall) SetRules(ports []PortRule, peers []rovy.PeerID) {
+	fw.Lock()
+	defer fw.Unlock()
+
+	fw.ports = map[PortRule]struct{}{}
+	for _, pr := range ports {
+		fw.ports[pr] = struct{}{}
+	}
+	fw.peers = map[rovy.PeerID]struct{}{}
+	for _, pid := range peers {
+		fw.peers[pid] = struct{}{}
+	}
+}
+
+// Outbound tracks an outbound packet's flow, so that replies are let in.
+func (fw *Firewall) Outbound(pkt []byte) {
+	key, ok := parseFlow(pkt, false)
+	if !ok {
+		return
+	}
+
+	fw.Lock()
+	defer fw.Unlock()
+
+	now := time.Now()
+	if now.Sub(fw.lastSweep) > FlowTimeout {
+		fw.sweep(now)
+	}
+	if _, present := fw.flows[key]; present || len(fw.flows) < MaxFlows {
+		fw.flows[key] = now
+	}
+}
+
+// Inbound reports whether an inbound packet from the peer is allowed.
+func (fw *Firewall) Inbound(src rovy.PeerID, pkt []byte) bool {
+	fw.Lock()
+	defer fw.Unlock()
+
+	if _, present := fw.peers[src]; present {
+		return true
+	}
+
+	id, offset, more, isFrag := fragmentHeader(pkt)
+	if !isFrag || (offset == 0 && !more) {
+		return fw.inbound(src, pkt)
+	}
+	remote, _ := netip.AddrFromSlice(pkt[8:24])
+	key := fragKey{remote: remote, id: id}
+	if offset != 0 {
+		seen, present := fw.frags[key]
+		return present && time.Since(seen) <= FragmentTimeout
+	}
+	if !fw.inbound(src, pkt) {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(fw.lastSweep) > FragmentTimeout {
+		fw.sweep(now)
+	}
+	if _, present := fw.frags[key]; present || len(fw.frags) < MaxFragments {
+		fw.frags[key] = now
+	}
+	return true
+}
+
+// inbound is Inbound for unfragmented packets and first fragments.
+func (fw *Firewall) inbound(src rovy.PeerID, pkt []byte) bool {
+	nexthdr, l4 := transportHeader(pkt)
+	switch nexthdr {
+	case protoTCP, protoUDP:
+		if len(l4) < 4 {
+			return false
+		}
+		proto := "tcp"
+		if nexthdr == protoUDP {
+			proto = "udp"
+		}
+		if fw.allowPort(src, PortRule{Proto: proto, Port: binary.BigEndian.Uint16(l4[2:4])}) {
+			return true
+		}
+	case protoICMPv6:
+		if len(l4) < 8 {
+			return false
+		}
+		typ := l4[0]
+		if typ == byte(ipv6.ICMPTypeEchoRequest) {
+			return fw.allowPort(src, PortRule{Proto: "icmp"})
+		}
+		if typ < 128 {
+			// an error about a packet we sent, which it quotes after the icmp header
+			key, ok := parseFlow(l4[8:], false)
+			return ok && fw.established(key)
+		}
+	default:
+		return false
+	}
+
+	key, ok := parseFlow(pkt, true)
+	return ok && fw.established(key)
+}
+
+func (fw *Firewall) allowPort(src rovy.PeerID, pr PortRule) bool {
+	if _, present := fw.ports[pr]; present {
+		return true
+	}
+	return fw.policies != nil && fw.policies.AllowPort(src, pr)
+}
+
+func (fw *Firewall) established(key flowKey) bool {
+	seen, present := fw.flows[key]
+	if !present || time.Since(seen) > FlowTimeout {
+		return false
+	}
+	fw.flows[key] = time.Now()
+	return true
+}
+
+func (fw *Firewall) sweep(now time.Time) {
+	for key, seen := range fw.flows {
+		if now.Sub(seen) > FlowTimeout {
+			delete(fw.flows, key)
+		}
+	}
+	for key, seen := range fw.frags {
+		if now.Sub(seen) > FragmentTimeout {
+			delete(fw.frags, key)
+		}
+	}
+	fw.lastSweep = now
+}
+
+// transportHeader skips the IPv6 header and common extension headers.
+// Non-first fragments have no transport header, and return nexthdr 0xff.
+func transportHeader(pkt []byte) (byte, []byte) {
+	if len(pkt) < ipv6.HeaderLen {
+		return 0xff, nil
+	}
+	nexthdr := pkt[6]
+	rest := pkt[ipv6.HeaderLen:]
+	for {
+		switch nexthdr {
+		case 0, 43, 60: // hop-by-hop, routing, destination options
+			if len(rest) < 8 {
+				return 0xff, nil
+			}
+			n := 8 + int(rest[1])*8
+			if len(rest) < n {
+				return 0xff, nil
+			}
+			nexthdr, rest = rest[0], rest[n:]
+		case 44: // fragment
+			if len(rest) < 8 || binary.BigEndian.Uint16(rest[2:4])&0xfff8 != 0 {
+				return 0xff, nil
+			}
+			nexthdr, rest = rest[0], rest[8:]
+		default:
+			return nexthdr, rest
+		}
+	}
+}
+
+// fragmentHeader returns the identification, offset, and more-fragments flag
+// of a fragmented packet.
+func fragmentHeader(pkt []byte) (id uint32, offset uint16, more bool, ok bool) {
+	if len(pkt) < ipv6.HeaderLen {
+		return 0, 0, false, false
+	}
+	nexthdr := pkt[6]
+	rest := pkt[ipv6.HeaderLen:]
+	for {
+		switch nexthdr {
+		case 0, 43, 60: // hop-by-hop, routing, destination options
+			if len(rest) < 8 || len(rest) < 8+int(rest[1])*8 {
+				return 0, 0, false, false
+			}
+			nexthdr, rest = rest[0], rest[8+int(rest[1])*8:]
+		case 44: // fragment
+			if len(rest) < 8 {
+				return 0, 0, false, false
+			}
+			fo := binary.BigEndian.Uint16(rest[2:4])
+			return binary.BigEndian.Uint32(rest[4:8]), fo & 0xfff8, fo&0x1 != 0, true
+		default:
+			return 0, 0, false, false
+		}
+	}
+}
+
+// parseFlow returns the flow of an outbound packet, or if inbound is set,
+// of an inbound one. Only TCP, UDP, and ICMPv6 echo have flows.
+func parseFlow(pkt []byte, inbound bool) (flowKey, bool) {
+	nexthdr, l4 := transportHeader(pkt)
+	if len(pkt) < ipv6.HeaderLen {
+		return flowKey{}, false
+	}
+	remote, _ := netip.AddrFromSlice(pkt[24:40])
+	if inbound {
+		remote, _ = netip.AddrFromSlice(pkt[8:24])
+	}
+
+	key := flowKey{proto: nexthdr, remote: remote}
+	switch nexthdr {
+	case protoTCP, protoUDP:
+		if len(l4) < 4 {
+			return key, false
+		}
+		key.lport = binary.BigEndian.Uint16(l4[0:2])
+		key.rport = binary.BigEndian.Uint16(l4[2:4])
+		if inbound {
+			key.lport, key.rport = key.rport, key.lport
+		}
+		return key, true
+	case protoICMPv6:
+		if len(l4) < 8 {
+			return key, false
+		}
+		typ := l4[0]
+		if (!inbound && typ != byte(ipv6.ICMPTypeEchoRequest)) || (inbound && typ != byte(ipv6.ICMPTypeEchoReply)) {
+			return key, false
+		}
+		key.lport = binary.BigEndian.Uint16(l4[4:6])
+		return key, true
+	default:
+		return key, false
+	}
+}
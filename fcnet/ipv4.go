@@ -0,0 +1,539 @@
+package fcnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	rovy "go.rovy.net"
+	policy "go.rovy.net/node/policy"
+	logging "go.rovy.net/node/util/logging"
+)
+
+// IPv4 over fcnet
+//
+// Unlike fc00::/8, IPv4 addresses aren't derived from keys, so IPv4 is only
+// carried between trusted peers which tell each other what they export.
+// Each node advertises its own address on the TUN device as a /32,
+// plus the prefixes it exports, e.g. a LAN or single services on it.
+// Packets from a trusted peer have to come from what it advertised,
+// and go to our address or into our exports, restricted to the export's ports.
+//
+// Packets written to the TUN device for an exported LAN are forwarded by the kernel,
+// which requires ip_forward, and masquerading or a route back on the LAN.
+//
+// Advertisements are the exported prefixes, 4 bytes address and 1 byte prefix length each.
+
+const Fcnet4Multicodec = 0x42006
+const Fcnet4RoutesMulticodec = 0x42007
+
+// AdvertInterval is how often we advertise to trusted peers,
+// and RouteTimeout how long their advertisements are good for.
+const AdvertInterval = 30 * time.Second
+const RouteTimeout = 3 * AdvertInterval
+
+func init() {
+	policy.RegisterCodec("fcnet4", Fcnet4Multicodec)
+	policy.RegisterCodec("fcnet4-routes", Fcnet4RoutesMulticodec)
+}
+
+// Export is an IPv4 prefix that trusted peers can send packets into.
+// With Ports, only these are reachable, e.g. 192.168.1.10/32 tcp/80.
+type Export struct {
+	Prefix netip.Prefix
+	Ports  []PortRule
+}
+
+// ParseExport parses a prefix followed by optional port rules, separated by spaces.
+func ParseExport(s string) (Export, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Export{}, fmt.Errorf("export: empty")
+	}
+	pfx, err := netip.ParsePrefix(fields[0])
+	if err != nil {
+		return Export{}, fmt.Errorf("export %s: %s", s, err)
+	}
+	if !pfx.Addr().Is4() {
+		return Export{}, fmt.Errorf("export %s: not an ipv4 prefix", s)
+	}
+
+	ex := Export{Prefix: pfx.Masked()}
+	for _, f := range fields[1:] {
+		pr, err := ParsePortRule(f)
+		if err != nil {
+			return Export{}, fmt.Errorf("export %s: %s", s, err)
+		}
+		ex.Ports = append(ex.Ports, pr)
+	}
+	policy.SortPortRules(ex.Ports)
+	return ex, nil
+}
+
+func (ex Export) String() string {
+	s := []string{ex.Prefix.String()}
+	for _, pr := range ex.Ports {
+		s = append(s, pr.String())
+	}
+	return strings.Join(s, " ")
+}
+
+func (ex Export) allows(pkt []byte) bool {
+	if len(ex.Ports) == 0 {
+		return true
+	}
+	pr, ok := portRule4(pkt)
+	if !ok {
+		return false
+	}
+	for _, pr2 := range ex.Ports {
+		if pr2 == pr {
+			return true
+		}
+	}
+	return false
+}
+
+// Route4 is a prefix which a trusted peer advertised to us.
+type Route4 struct {
+	Prefix  netip.Prefix
+	PeerID  rovy.PeerID
+	expires time.Time
+}
+
+// IPv4 is the configuration and routing table for IPv4 over fcnet.
+// It can be changed while fcnet is running.
+type IPv4 struct {
+	sync.RWMutex
+	addr    netip.Addr
+	tunrt   []netip.Prefix
+	exports []Export
+	peers   map[rovy.PeerID]bool
+	routes  []Route4
+	changed chan struct{} // wakes up the advertising loop
+}
+
+// Set replaces the configuration. Addr is our address on the TUN device,
+// and tunRoutes are the prefixes routed to the TUN device,
+// which are only kept here for setting up the device.
+func (v4 *IPv4) Set(addr netip.Addr, tunRoutes []netip.Prefix, exports []Export, peers []rovy.PeerID) error {
+	if addr.IsValid() && !addr.Is4() {
+		return fmt.Errorf("ipv4: not an ipv4 address: %s", addr)
+	}
+	for _, pfx := range tunRoutes {
+		if !pfx.Addr().Is4() {
+			return fmt.Errorf("ipv4: not an ipv4 prefix: %s", pfx)
+		}
+	}
+
+	v4.Lock()
+	defer v4.Unlock()
+
+	v4.addr = addr
+	v4.tunrt = append([]netip.Prefix{}, tunRoutes...)
+	v4.exports = append([]Export{}, exports...)
+	v4.peers = map[rovy.PeerID]bool{}
+	for _, pid := range peers {
+		v4.peers[pid] = true
+	}
+
+	// forget what peers advertised, who aren't trusted anymore
+	var keep []Route4
+	for _, rt := range v4.routes {
+		if v4.peers[rt.PeerID] {
+			keep = append(keep, rt)
+		}
+	}
+	v4.routes = keep
+
+	if v4.changed != nil {
+		select {
+		case v4.changed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (v4 *IPv4) Addr() netip.Addr {
+	v4.RLock()
+	defer v4.RUnlock()
+	return v4.addr
+}
+
+func (v4 *IPv4) TunRoutes() []netip.Prefix {
+	v4.RLock()
+	defer v4.RUnlock()
+	return append([]netip.Prefix{}, v4.tunrt...)
+}
+
+func (v4 *IPv4) Exports() []Export {
+	v4.RLock()
+	defer v4.RUnlock()
+	return append([]Export{}, v4.exports...)
+}
+
+func (v4 *IPv4) Peers() []rovy.PeerID {
+	v4.RLock()
+	defer v4.RUnlock()
+
+	out := make([]rovy.PeerID, 0, len(v4.peers))
+	for pid := range v4.peers {
+		out = append(out, pid)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out
+}
+
+// Routes returns what trusted peers advertised, longest prefixes first.
+func (v4 *IPv4) Routes() []Route4 {
+	v4.RLock()
+	defer v4.RUnlock()
+
+	now := time.Now()
+	out := []Route4{}
+	for _, rt := range v4.routes {
+		if now.Before(rt.expires) {
+			out = append(out, rt)
+		}
+	}
+	return out
+}
+
+// Enabled reports whether we have anything to do with IPv4.
+func (v4 *IPv4) Enabled() bool {
+	v4.RLock()
+	defer v4.RUnlock()
+	return len(v4.peers) > 0 && (v4.addr.IsValid() || len(v4.exports) > 0)
+}
+
+// lookup returns the peer with the longest prefix containing dst.
+func (v4 *IPv4) lookup(dst netip.Addr) (rovy.PeerID, bool) {
+	v4.RLock()
+	defer v4.RUnlock()
+
+	// routes are sorted by prefix length
+	now := time.Now()
+	for _, rt := range v4.routes {
+		if rt.Prefix.Contains(dst) && now.Before(rt.expires) {
+			return rt.PeerID, true
+		}
+	}
+	return rovy.PeerID{}, false
+}
+
+// learn replaces a trusted peer's routes, and reports whether it had none before.
+func (v4 *IPv4) learn(pid rovy.PeerID, prefixes []netip.Prefix) (bool, error) {
+	v4.Lock()
+	defer v4.Unlock()
+
+	if !v4.peers[pid] {
+		return false, fmt.Errorf("ipv4: routes from untrusted peer %s", pid)
+	}
+
+	now := time.Now()
+	isnew := true
+	var routes []Route4
+	for _, rt := range v4.routes {
+		if rt.PeerID != pid {
+			routes = append(routes, rt)
+		} else if now.Before(rt.expires) {
+			isnew = false
+		}
+	}
+	for _, pfx := range prefixes {
+		routes = append(routes, Route4{Prefix: pfx, PeerID: pid, expires: now.Add(RouteTimeout)})
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Prefix.Bits() > routes[j].Prefix.Bits() })
+	v4.routes = routes
+	return isnew, nil
+}
+
+// advertisement returns our address and exports in the advertisement format.
+func (v4 *IPv4) advertisement() []byte {
+	v4.RLock()
+	defer v4.RUnlock()
+
+	var pfxs []netip.Prefix
+	if v4.addr.IsValid() {
+		pfxs = append(pfxs, netip.PrefixFrom(v4.addr, 32))
+	}
+	for _, ex := range v4.exports {
+		pfxs = append(pfxs, ex.Prefix)
+	}
+
+	buf := make([]byte, 0, 5*len(pfxs))
+	for _, pfx := range pfxs {
+		a := pfx.Addr().As4()
+		buf = append(buf, a[:]...)
+		buf = append(buf, byte(pfx.Bits()))
+	}
+	return buf
+}
+
+// inbound decides whether a packet from a trusted peer may be written to the TUN device.
+func (v4 *IPv4) inbound(src rovy.PeerID, pkt []byte) error {
+	v4.RLock()
+	defer v4.RUnlock()
+
+	if !v4.peers[src] {
+		return fmt.Errorf("untrusted peer")
+	}
+
+	srcip := netip.AddrFrom4(*(*[4]byte)(pkt[12:16]))
+	dstip := netip.AddrFrom4(*(*[4]byte)(pkt[16:20]))
+
+	now := time.Now()
+	spoofed := true
+	for _, rt := range v4.routes {
+		if rt.PeerID == src && rt.Prefix.Contains(srcip) && now.Before(rt.expires) {
+			spoofed = false
+			break
+		}
+	}
+	if spoofed {
+		return fmt.Errorf("source %s wasn't advertised", srcip)
+	}
+
+	if dstip == v4.addr {
+		return nil
+	}
+	for _, ex := range v4.exports {
+		if ex.Prefix.Contains(dstip) && ex.allows(pkt) {
+			return nil
+		}
+	}
+	return fmt.Errorf("destination %s isn't exported", dstip)
+}
+
+// outbound checks that a packet from the TUN device has one of our sources.
+func (v4 *IPv4) outbound(pkt []byte) bool {
+	v4.RLock()
+	defer v4.RUnlock()
+
+	srcip := netip.AddrFrom4(*(*[4]byte)(pkt[12:16]))
+	if srcip == v4.addr {
+		return true
+	}
+	for _, ex := range v4.exports {
+		if ex.Prefix.Contains(srcip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPv4 is the IPv4 configuration and routing table.
+func (fc *Fcnet) IPv4() *IPv4 {
+	return &fc.ipv4
+}
+
+// advertiseLoop sends our advertisements to the trusted peers and exit clients
+// periodically, and whenever the configuration changes.
+func (fc *Fcnet) advertiseLoop() {
+	ticker := time.NewTicker(AdvertInterval)
+	defer ticker.Stop()
+
+	for {
+		fc.advertise()
+		fc.advertiseExit()
+
+		select {
+		case <-fc.done:
+			return
+		case <-ticker.C:
+		case <-fc.ipv4.changed:
+		case <-fc.exit.changed:
+		}
+	}
+}
+
+func (fc *Fcnet) advertise() {
+	if !fc.ipv4.Enabled() {
+		return
+	}
+	adv := fc.ipv4.advertisement()
+	for _, pid := range fc.ipv4.Peers() {
+		if err := fc.sendUpper(pid, Fcnet4RoutesMulticodec, adv); err != nil {
+			fc.log.Debug("ipv4: advertise", "peer", pid, "err", err)
+		}
+	}
+}
+
+func (fc *Fcnet) handleRoutesPacket(src rovy.PeerID, payload []byte) error {
+	if len(payload)%5 != 0 {
+		return fmt.Errorf("ipv4: advertisement from %s: invalid length %d", src, len(payload))
+	}
+
+	var pfxs []netip.Prefix
+	for i := 0; i < len(payload); i += 5 {
+		pfx, err := netip.AddrFrom4(*(*[4]byte)(payload[i : i+4])).Prefix(int(payload[i+4]))
+		if err != nil {
+			return fmt.Errorf("ipv4: advertisement from %s: %s", src, err)
+		}
+		pfxs = append(pfxs, pfx)
+	}
+
+	isnew, err := fc.ipv4.learn(src, pfxs)
+	if err != nil {
+		return err
+	}
+	if fc.log.Enabled(logging.DebugLevel) {
+		fc.log.Debug("ipv4: routes", "peer", src, "prefixes", pfxs)
+	}
+
+	// a peer we didn't know about gets our routes right away
+	if isnew && fc.ipv4.Enabled() {
+		return fc.sendUpper(src, Fcnet4RoutesMulticodec, fc.ipv4.advertisement())
+	}
+	return nil
+}
+
+func (fc *Fcnet) handleTun4Packet(buf []byte) error {
+	plen := len(buf)
+	if plen < 20 {
+		return fmt.Errorf("tun: ipv4 packet too short (len=%d)", plen)
+	}
+	if gotlen := int(binary.BigEndian.Uint16(buf[2:4])); plen != gotlen {
+		return fmt.Errorf("tun: ipv4 length mismatch, expected %d, got %d", plen, gotlen)
+	}
+
+	dst := netip.AddrFrom4(*(*[4]byte)(buf[16:20]))
+	if !fc.ipv4.outbound(buf) {
+		fc.log.Debug("tun: dropping ipv4 packet with foreign src address", "src", netip.AddrFrom4(*(*[4]byte)(buf[12:16])), "dst", dst)
+		return nil
+	}
+	peerid, present := fc.ipv4.lookup(dst)
+	if !present {
+		// TODO: icmp destination unreachable
+		return nil
+	}
+
+	route, err := fc.routing.GetRoute(peerid)
+	if err != nil {
+		return fmt.Errorf("tun: no route for %s: %s", peerid, err)
+	}
+	if mtu := fc.pathMTU(route); plen > mtu {
+		return fc.fragmentationNeeded(buf, mtu)
+	}
+
+	upkt := rovy.NewUpperPacket(rovy.AllocPacket())
+	upkt.UpperDst = peerid
+	upkt.SetRoute(route)
+	upkt.SetCodec(Fcnet4Multicodec)
+	upkt = upkt.SetPayload(buf)
+	return fc.node.SendUpper(upkt)
+}
+
+func (fc *Fcnet) handleFcnet4Packet(src rovy.PeerID, payload []byte) error {
+	n := len(payload)
+	if n < 20 || payload[0]>>4 != 4 {
+		return fmt.Errorf("fcnet: recv: not an ipv4 packet")
+	}
+	if gotlen := int(binary.BigEndian.Uint16(payload[2:4])); n != gotlen {
+		return fmt.Errorf("fcnet: recv: ipv4 length mismatch, expected %d, got %d", n, gotlen)
+	}
+
+	if err := fc.ipv4.inbound(src, payload); err != nil {
+		fc.fwDropped.Add(1)
+		fc.log.Debug("ipv4: dropping inbound packet", "src", src, "err", err)
+		return nil
+	}
+
+	return fc.writeTun(payload)
+}
+
+// fragmentationNeeded is the IPv4 equivalent of packetTooBig. Packets without
+// the don't-fragment bit are dropped instead, since we don't fragment.
+func (fc *Fcnet) fragmentationNeeded(buf []byte, mtu int) error {
+	if buf[6]&0x40 == 0 {
+		fc.log.Debug("ipv4: dropping packet too big for the path", "len", len(buf), "mtu", mtu)
+		return nil
+	}
+	src := fc.ipv4.Addr()
+	if !src.IsValid() {
+		return nil
+	}
+	if ihl := int(buf[0]&0x0f) * 4; buf[9] == 1 && len(buf) > ihl {
+		switch buf[ihl] {
+		case 3, 4, 5, 11, 12: // no errors about errors
+			return nil
+		}
+	}
+
+	// header and as much as the minimum reassembly size of 576 bytes allows
+	quote := buf
+	if max := 576 - 20 - 8; len(quote) > max {
+		quote = quote[:max]
+	}
+	icmpdata := make([]byte, 8+len(quote))
+	icmpdata[0] = 3 // destination unreachable
+	icmpdata[1] = 4 // fragmentation needed
+	binary.BigEndian.PutUint16(icmpdata[6:8], uint16(mtu))
+	copy(icmpdata[8:], quote)
+	binary.BigEndian.PutUint16(icmpdata[2:4], ipChecksum(icmpdata))
+
+	p2 := make([]byte, 20+len(icmpdata))
+	p2[0] = 0x45
+	binary.BigEndian.PutUint16(p2[2:4], uint16(len(p2)))
+	p2[8] = 64
+	p2[9] = 1
+	copy(p2[12:16], src.AsSlice())
+	copy(p2[16:20], buf[12:16])
+	binary.BigEndian.PutUint16(p2[10:12], ipChecksum(p2[:20]))
+	copy(p2[20:], icmpdata)
+
+	return fc.writeTun(p2)
+}
+
+// sendUpper sends the payload to a peer under the given codec.
+func (fc *Fcnet) sendUpper(pid rovy.PeerID, codec uint64, payload []byte) error {
+	route, err := fc.routing.GetRoute(pid)
+	if err != nil {
+		return err
+	}
+	upkt := rovy.NewUpperPacket(rovy.AllocPacket())
+	upkt.UpperDst = pid
+	upkt.SetRoute(route)
+	upkt.SetCodec(codec)
+	upkt = upkt.SetPayload(payload)
+	return fc.node.SendUpper(upkt)
+}
+
+// portRule4 returns the protocol and destination port of an IPv4 packet,
+// with ICMP echo requests as icmp.
+func portRule4(pkt []byte) (PortRule, bool) {
+	ihl := int(pkt[0]&0x0f) * 4
+	if ihl < 20 || len(pkt) < ihl+4 || binary.BigEndian.Uint16(pkt[6:8])&0x1fff != 0 {
+		return PortRule{}, false
+	}
+	rest := pkt[ihl:]
+	switch pkt[9] {
+	case 1:
+		return PortRule{Proto: "icmp"}, rest[0] == 8
+	case 6:
+		return PortRule{Proto: "tcp", Port: binary.BigEndian.Uint16(rest[2:4])}, true
+	case 17:
+		return PortRule{Proto: "udp", Port: binary.BigEndian.Uint16(rest[2:4])}, true
+	}
+	return PortRule{}, false
+}
+
+// ipChecksum is the internet checksum of RFC 1071.
+func ipChecksum(b []byte) uint16 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s>>16 != 0 {
+		s = s&0xffff + s>>16
+	}
+	return ^uint16(s)
+}
@@ -2,6 +2,7 @@ package fcnet
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"net"
@@ -12,22 +13,37 @@ import (
 	rovy "go.rovy.net"
 )
 
-const SignatureSize = 8
-const RandomizerSize = 8
+const SignatureSize = rovy.SignatureSize
+const RandomizerSize = 16
 const RequestIdSize = 4
 
-var stubSignature = [SignatureSize]byte{0x1, 0x3, 0x1, 0x2, 0x1, 0x3, 0x1, 0x2}
-var stubRandomizer = [RandomizerSize]byte{0x42, 0x42, 0x42, 0x42, 0x42, 0x42, 0x42, 0x42}
+// PingHeaderSize is everything in front of the payload, see PingPacket.
+const PingHeaderSize = 4 + 16 + rovy.PublicKeySize + SignatureSize + RequestIdSize + RandomizerSize
+
 var pingRequestId = [RequestIdSize]byte{0x0, 0x0, 0x0, 0x1}
 var pongRequestId = [RequestIdSize]byte{0x0, 0x0, 0x0, 0x2}
 
-// TODO implement me
+// sign sets a fresh randomizer, and signs the packet with the node's static key.
 func (fc *Fcnet) sign(ppkt PingPacket) (PingPacket, error) {
+	var rnd [RandomizerSize]byte
+	if _, err := rand.Read(rnd[:]); err != nil {
+		return ppkt, err
+	}
+	ppkt.SetRandomizer(rnd)
+
+	sig, err := fc.node.Sign(ppkt.SignedBytes())
+	if err != nil {
+		return ppkt, err
+	}
+	ppkt.SetSignature(sig)
 	return ppkt, nil
 }
 
-// TODO implement me
+// verify checks that the packet was signed by its sender.
 func (fc *Fcnet) verify(ppkt PingPacket) error {
+	if err := ppkt.Sender().Verify(ppkt.SignedBytes(), ppkt.Signature()); err != nil {
+		return fmt.Errorf("fcnet: ping from %s: %s", rovy.NewPeerID(ppkt.Sender()), err)
+	}
 	return nil
 }
 
@@ -37,6 +53,14 @@ func (fc *Fcnet) handlePingPacket(lpkt rovy.LowerPacket) error {
 	if !ppkt.IsDestination() {
 		return fc.node.Forwarder().HandlePacket(lpkt)
 	}
+	defer lpkt.Release()
+
+	if ppkt.Length < ppkt.Offset+PingHeaderSize+ppkt.Padding {
+		return fmt.Errorf("fcnet: ping packet too short (len=%d)", ppkt.Length)
+	}
+	if err := fc.verify(ppkt); err != nil {
+		return err
+	}
 
 	prevrt, err := fc.node.Routing().GetRoute(lpkt.LowerSrc)
 	if err != nil {
@@ -49,11 +73,10 @@ func (fc *Fcnet) handlePingPacket(lpkt rovy.LowerPacket) error {
 	route := rovy.NewRoute(fwdhdr[2 : 2+fwdhdr[0]]...).Reverse()
 
 	if ppkt.IsReply() {
-		if err := fc.verify(ppkt); err != nil {
-			return err
-		}
-
 		buf := ppkt.Payload()
+		if len(buf) < ipv6.HeaderLen {
+			return fmt.Errorf("fcnet: pong payload too short (len=%d)", len(buf))
+		}
 
 		dst := fc.ip.AsSlice()
 		if 0 != bytes.Compare(buf[8:24], dst) {
@@ -63,7 +86,7 @@ func (fc *Fcnet) handlePingPacket(lpkt rovy.LowerPacket) error {
 		src2 := ppkt.Sender().IPAddr().AsSlice()
 		dst2 := dst
 
-		body := &icmp.TimeExceeded{Data: buf[:]}
+		body := &icmp.TimeExceeded{Data: icmpQuote(buf)}
 		msg := icmp.Message{
 			Type: ipv6.ICMPTypeTimeExceeded,
 			Code: 0,
@@ -93,7 +116,6 @@ func (fc *Fcnet) handlePingPacket(lpkt rovy.LowerPacket) error {
 		cbuf[len(chdr)+3] ^= byte(csum >> 8)
 		icmpdata := cbuf[len(chdr):]
 
-		// TODO: make sure the resulting packet doesn't exceed MTU
 		ilen := len(icmpdata)
 		p2 := make([]byte, 40+ilen)
 		copy(p2[0:4], buf[0:4]) // copying the src flowlabel might be stupid
@@ -107,7 +129,7 @@ func (fc *Fcnet) handlePingPacket(lpkt rovy.LowerPacket) error {
 		return fc.handleFcnetPacket(rovy.NewPeerID(ppkt.Sender()), p2)
 	}
 
-	ppkt2 := NewPingPacket(rovy.NewPacket(make([]byte, rovy.TptMTU)))
+	ppkt2 := NewPingPacket(rovy.AllocPacket())
 	ppkt2.LowerSrc = fc.node.PeerID()
 	ppkt2.SetRoute(route)
 	ppkt2.SetSender(fc.node.PeerID().PublicKey())
@@ -116,6 +138,7 @@ func (fc *Fcnet) handlePingPacket(lpkt rovy.LowerPacket) error {
 
 	ppkt2, err = fc.sign(ppkt2)
 	if err != nil {
+		ppkt2.Release()
 		return err
 	}
 
@@ -139,14 +162,16 @@ func icmpChecksum(b []byte) uint16 {
 	return ^uint16(s)
 }
 
-//  4 bytes - codec
-// 16 bytes - forwarder header
-// 32 bytes - sender static key
-//  8 bytes - signature (over requestID+randomizer+payload)
-//  4 bytes   request id
-//  8 bytes - randomizer
-//  .       - payload
-// = 72+ bytes
+// PingPacket layout:
+//
+//	 4 bytes - codec
+//	16 bytes - forwarder header
+//	32 bytes - sender static key
+//	64 bytes - XEdDSA signature (over requestID+randomizer+payload)
+//	 4 bytes - request id
+//	16 bytes - randomizer
+//	 .       - payload, truncated to fit
+//	= 136+ bytes
 type PingPacket struct {
 	Offset  int
 	Padding int
@@ -159,9 +184,6 @@ func NewPingPacket(basepkt rovy.Packet) PingPacket {
 		Offset:  16, // msgtype + session index + nonce
 		Padding: 16,
 	}
-
-	pkt.SetSignature(stubSignature)
-	pkt.SetRandomizer(stubRandomizer)
 	return pkt
 }
 
@@ -212,46 +234,55 @@ func (pkt PingPacket) SetSender(key rovy.PublicKey) {
 	copy(pkt.Buf[o:o+rovy.PublicKeySize], key.Bytes())
 }
 
-func (pkt PingPacket) Signature() (sig [SignatureSize]byte) {
+func (pkt PingPacket) Signature() []byte {
 	o := pkt.Offset + 52
-	copy(sig[:], pkt.Buf[o:o+SignatureSize])
-	return sig
+	return pkt.Buf[o : o+SignatureSize]
 }
 
-func (pkt PingPacket) SetSignature(sig [SignatureSize]byte) {
+func (pkt PingPacket) SetSignature(sig []byte) {
 	o := pkt.Offset + 52
-	copy(pkt.Buf[o:o+SignatureSize], sig[:])
+	copy(pkt.Buf[o:o+SignatureSize], sig)
 }
 
 func (pkt PingPacket) RequestId() (reqid [RequestIdSize]byte) {
-	o := pkt.Offset + 60
+	o := pkt.Offset + 116
 	copy(reqid[:], pkt.Buf[o:o+RequestIdSize])
 	return reqid
 }
 
 func (pkt PingPacket) SetRequestId(reqid [RequestIdSize]byte) {
-	o := pkt.Offset + 60
+	o := pkt.Offset + 116
 	copy(pkt.Buf[o:o+RequestIdSize], reqid[:])
 }
 
 func (pkt PingPacket) Randomizer() (rnd [RandomizerSize]byte) {
-	o := pkt.Offset + 64
+	o := pkt.Offset + 120
 	copy(rnd[:], pkt.Buf[o:o+RandomizerSize])
 	return rnd
 }
 
 func (pkt PingPacket) SetRandomizer(rnd [RandomizerSize]byte) {
-	o := pkt.Offset + 64
+	o := pkt.Offset + 120
 	copy(pkt.Buf[o:o+RandomizerSize], rnd[:])
 }
 
+// SignedBytes is what the signature covers: request id, randomizer, and payload.
+func (pkt PingPacket) SignedBytes() []byte {
+	o := pkt.Offset + 116
+	return pkt.Buf[o : pkt.Length-pkt.Padding]
+}
+
 func (pkt PingPacket) Payload() []byte {
-	o := pkt.Offset + 72
+	o := pkt.Offset + PingHeaderSize
 	return pkt.Buf[o : pkt.Length-pkt.Padding]
 }
 
+// SetPayload copies as much of pt as fits into the packet.
 func (pkt PingPacket) SetPayload(pt []byte) PingPacket {
-	o := pkt.Offset + 72
+	o := pkt.Offset + PingHeaderSize
+	if max := len(pkt.Buf) - pkt.Padding - o; len(pt) > max {
+		pt = pt[:max]
+	}
 	pkt.Length = o + len(pt) + pkt.Padding
 	copy(pkt.Buf[o:pkt.Length-pkt.Padding], pt)
 	return pkt
@@ -0,0 +1,99 @@
+package fcnet
+
+import (
+	"encoding/binary"
+
+	icmp "golang.org/x/net/icmp"
+	ipv6 "golang.org/x/net/ipv6"
+
+	rovy "go.rovy.net"
+)
+
+// MinMTU is IPv6's minimum link MTU. We don't go below it,
+// even if the path is known to be smaller.
+const MinMTU = 1280
+
+// pathMTU returns the largest IPv6 packet that fits the route,
+// and starts probing the route if that hasn't happened yet.
+func (fc *Fcnet) pathMTU(route rovy.Route) int {
+	mtu, known := fc.routing.PathMTU(route)
+	if !known {
+		fc.probe(route)
+	}
+
+	// the overhead of upper packets on top of lower packets is always the same
+	mtu -= rovy.TptMTU - rovy.UpperMTU
+	if mtu < MinMTU {
+		mtu = MinMTU
+	}
+	return mtu
+}
+
+// probe runs the node's path MTU discovery for the route in the background,
+// unless it's already running.
+func (fc *Fcnet) probe(route rovy.Route) {
+	key := string(route.Bytes())
+	if _, running := fc.probing.LoadOrStore(key, true); running {
+		return
+	}
+
+	go func() {
+		defer fc.probing.Delete(key)
+
+		mtu, err := fc.node.ProbePathMTU(route)
+		if err != nil {
+			// after timeouts, the node has stepped down to a path MTU that's likely to work.
+			// other errors aren't about the size, so we go with what we know,
+			// instead of probing again with every packet.
+			if _, known := fc.routing.PathMTU(route); !known {
+				mtu, _ = fc.routing.PathMTU(route)
+				fc.routing.SetPathMTU(route, mtu)
+			}
+			fc.log.Debug("pmtu: probe failed", "route", route, "mtu", mtu, "err", err)
+			return
+		}
+		fc.log.Debug("pmtu: probed", "route", route, "mtu", mtu)
+	}()
+}
+
+// packetTooBig tells the sender of a packet that it doesn't fit the path,
+// with an ICMPv6 Packet Too Big from fc00::1, so that e.g. TCP lowers its segment size.
+func (fc *Fcnet) packetTooBig(buf []byte, mtu int) error {
+	// no errors about errors
+	if nexthdr, rest := transportHeader(buf); nexthdr == 58 && (len(rest) == 0 || rest[0] < 128) {
+		return nil
+	}
+
+	src := fc1Addr.AsSlice()
+	dst := buf[8:24]
+
+	msg := icmp.Message{
+		Type: ipv6.ICMPTypePacketTooBig,
+		Body: &icmp.PacketTooBig{MTU: mtu, Data: icmpQuote(buf)},
+	}
+	icmpdata, err := msg.Marshal(icmp.IPv6PseudoHeader(src, dst))
+	if err != nil {
+		return err
+	}
+
+	p2 := make([]byte, ipv6.HeaderLen+len(icmpdata))
+	p2[0] = 0x60
+	binary.BigEndian.PutUint16(p2[4:6], uint16(len(icmpdata)))
+	p2[6] = 58
+	p2[7] = 64
+	copy(p2[8:24], src)
+	copy(p2[24:40], dst)
+	copy(p2[40:], icmpdata)
+
+	fc.log.Debug("pmtu: packet too big", "len", len(buf), "mtu", mtu)
+	return fc.writeTun(p2)
+}
+
+// icmpQuote returns as much of the packet as an ICMPv6 error message can carry,
+// without exceeding the minimum MTU, see RFC 4443 section 2.4.
+func icmpQuote(buf []byte) []byte {
+	if max := MinMTU - ipv6.HeaderLen - 8; len(buf) > max {
+		return buf[:max]
+	}
+	return buf
+}
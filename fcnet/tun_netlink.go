@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
 	"runtime"
 
 	netlink "github.com/vishvananda/netlink"
@@ -68,6 +69,54 @@ func NetlinkTun(ifname string, ip6 net.IP, mtu int, logger *log.Logger) (Device,
 	return Device(dev), nil
 }
 
+// NetlinkSetIPv4 adds an IPv4 address and routes to the TUN device.
+func NetlinkSetIPv4(ifname string, addr netip.Addr, routes []netip.Prefix, logger *log.Logger) error {
+	link, err := netlink.LinkByName(ifname)
+	if err != nil {
+		return fmt.Errorf("LinkByName: %s", err)
+	}
+
+	nladdr, err := netlink.ParseAddr(addr.String() + "/32")
+	if err != nil {
+		return fmt.Errorf("ParseAddr: %s", err)
+	}
+	if err = netlink.AddrAdd(link, nladdr); err != nil {
+		return fmt.Errorf("AddrAdd: %s", err)
+	}
+
+	for _, pfx := range routes {
+		dst := &net.IPNet{IP: pfx.Masked().Addr().AsSlice(), Mask: net.CIDRMask(pfx.Bits(), 32)}
+		err = netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Src: addr.AsSlice()})
+		if err != nil {
+			logger.Printf("failed to add route %s => %s, skipping", pfx, ifname)
+		} else {
+			logger.Printf("added route %s => %s", pfx, ifname)
+		}
+	}
+
+	return nil
+}
+
+// NetlinkAddExitRoutes routes IPv6 prefixes into the TUN device, for use with an exit node.
+func NetlinkAddExitRoutes(ifname string, ip6 netip.Addr, routes []netip.Prefix, logger *log.Logger) error {
+	link, err := netlink.LinkByName(ifname)
+	if err != nil {
+		return fmt.Errorf("LinkByName: %s", err)
+	}
+
+	for _, pfx := range routes {
+		dst := &net.IPNet{IP: pfx.Masked().Addr().AsSlice(), Mask: net.CIDRMask(pfx.Bits(), 128)}
+		err = netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Src: ip6.AsSlice()})
+		if err != nil {
+			logger.Printf("failed to add route %s => %s, skipping", pfx, ifname)
+		} else {
+			logger.Printf("added route %s => %s", pfx, ifname)
+		}
+	}
+
+	return nil
+}
+
 func NetlinkTunWithNamespace(ifname string, ip6 net.IP, mtu int, logger *log.Logger) (Device, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
@@ -21,7 +21,14 @@ import (
 // nmcli conn add save no type tun ifname rovy0 con-name rovy0 \
 //   mtu '1280' ipv4.method 'disabled' \
 //   ipv6.method 'manual' ipv6.addresses 'fce2:2cda:998a:5dfc:ccb8:dd48:e541:76cd' \
-//   ipv6.routes 'fc00::/8' ipv6.dns 'fc00::1' ipv6.dns-search '~rovy'
+//   ipv6.routes 'fc00::/8' ipv6.dns 'fc00::1' ipv6.dns-search '~rovy,~c.f.ip6.arpa'
+//
+// With IPv4, see SetIPv4:
+// nmcli conn modify rovy0 ipv4.method 'manual' ipv4.addresses '100.64.0.1/32' \
+//   ipv4.routes '192.168.1.0/24'
+//
+// With an exit node, see SetExitRoutes:
+// nmcli conn modify rovy0 ipv6.routes 'fc00::/8, 2000::/3'
 //
 
 type NMTUN struct {
@@ -29,6 +36,10 @@ type NMTUN struct {
 	conn   *dbus.ObjectPath
 	dev    tun.Device
 	logger *log.Logger
+
+	ipv4Addr   netip.Addr
+	ipv4Routes []netip.Prefix
+	exitRoutes []netip.Prefix
 }
 
 func NewNMTUN(logger *log.Logger) *NMTUN {
@@ -36,6 +47,18 @@ func NewNMTUN(logger *log.Logger) *NMTUN {
 	return nm
 }
 
+// SetIPv4 configures an IPv4 address and routes on the device when it's started.
+// Without an address, IPv4 stays disabled.
+func (nm *NMTUN) SetIPv4(addr netip.Addr, routes []netip.Prefix) {
+	nm.ipv4Addr = addr
+	nm.ipv4Routes = routes
+}
+
+// SetExitRoutes adds IPv6 routes for traffic that goes through an exit node.
+func (nm *NMTUN) SetExitRoutes(routes []netip.Prefix) {
+	nm.exitRoutes = routes
+}
+
 func (nm *NMTUN) Start(ifname string, ip netip.Addr, mtu int) error {
 	bus, err := dbus.SystemBus()
 	if err != nil {
@@ -239,14 +262,41 @@ func (nm *NMTUN) prepareConn(ifname string, ip netip.Addr, mtu int) map[string]m
 			"address-data": dbus.MakeVariant([]map[string]interface{}{
 				{"address": ip.String(), "prefix": uint32(128)},
 			}),
-			"route-data": dbus.MakeVariant([]map[string]interface{}{
-				{"dest": "fc00::", "prefix": uint32(8)},
-			}),
+			"route-data": dbus.MakeVariant(nm.prepareRoutes6()),
 			"dns":        dbus.MakeVariant([][]byte{netip.MustParseAddr("fc00::1").AsSlice()}),
-			"dns-search": dbus.MakeVariant([]string{"~rovy."}),
+			"dns-search": dbus.MakeVariant([]string{"~rovy.", "~" + ReverseZone}),
 		},
-		"ipv4": {
+		"ipv4": nm.prepareIPv4(),
+	}
+}
+
+func (nm *NMTUN) prepareRoutes6() []map[string]interface{} {
+	routes := []map[string]interface{}{
+		{"dest": "fc00::", "prefix": uint32(8)},
+	}
+	for _, pfx := range nm.exitRoutes {
+		routes = append(routes, map[string]interface{}{"dest": pfx.Masked().Addr().String(), "prefix": uint32(pfx.Bits())})
+	}
+	return routes
+}
+
+func (nm *NMTUN) prepareIPv4() map[string]dbus.Variant {
+	if !nm.ipv4Addr.IsValid() {
+		return map[string]dbus.Variant{
 			"method": dbus.MakeVariant("disabled"),
-		},
+		}
+	}
+
+	routes := []map[string]interface{}{}
+	for _, pfx := range nm.ipv4Routes {
+		routes = append(routes, map[string]interface{}{"dest": pfx.Masked().Addr().String(), "prefix": uint32(pfx.Bits())})
+	}
+	return map[string]dbus.Variant{
+		"method": dbus.MakeVariant("manual"),
+		"address-data": dbus.MakeVariant([]map[string]interface{}{
+			{"address": nm.ipv4Addr.String(), "prefix": uint32(32)},
+		}),
+		"route-data":    dbus.MakeVariant(routes),
+		"never-default": dbus.MakeVariant(true),
 	}
 }
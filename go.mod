@@ -3,6 +3,7 @@ module go.rovy.net
 go 1.19
 
 require (
+	filippo.io/edwards25519 v1.0.0
 	github.com/cucumber/godog v0.12.6
 	github.com/fxamacker/cbor/v2 v2.4.0
 	github.com/godbus/dbus/v5 v5.1.0
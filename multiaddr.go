@@ -4,32 +4,35 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/netip"
 	"strconv"
 	"strings"
 
 	cid "github.com/ipfs/go-cid"
 	multiaddr "github.com/multiformats/go-multiaddr"
+	varint "github.com/multiformats/go-varint"
 )
 
 const (
 	// TODO: officially register the multicodec numbers
-	RovyMultiaddrCodec  = 0x1a6
-	ProtoMultiaddrCodec = 0x34
-	IP4MultiaddrCodec   = 0x4
-	IP6MultiaddrCodec   = 0x29
-	UDPMultiaddrCodec   = 0x111
+	RovyMultiaddrCodec   = 0x1a6
+	ProtoMultiaddrCodec  = 0x34
+	IP4MultiaddrCodec    = 0x4
+	IP6MultiaddrCodec    = 0x29
+	UDPMultiaddrCodec    = 0x111
+	TCPMultiaddrCodec    = 0x6
+	TLSMultiaddrCodec    = 0x1c0
+	WSMultiaddrCodec     = 0x1dd
+	WSSMultiaddrCodec    = 0x1de
+	MemoryMultiaddrCodec = 0x309
+
+	// not registered, so they're in the multicodec private use range
+	EthifMultiaddrCodec = 0x300001
+	MACMultiaddrCodec   = 0x300002
 )
 
 var (
-	udp4protocols = []multiaddr.Protocol{
-		multiaddr.ProtocolWithCode(IP4MultiaddrCodec),
-		multiaddr.ProtocolWithCode(UDPMultiaddrCodec),
-	}
-	udp6protocols = []multiaddr.Protocol{
-		multiaddr.ProtocolWithCode(IP6MultiaddrCodec),
-		multiaddr.ProtocolWithCode(UDPMultiaddrCodec),
-	}
 	rovyProtocol = multiaddr.Protocol{
 		Name:       "rovy",
 		Code:       RovyMultiaddrCodec,
@@ -45,11 +48,35 @@ var (
 		Path:       true,
 		Transcoder: multiaddr.NewTranscoderFromFunctions(protoMaddrStr2b, protoMaddrB2Str, nil),
 	}
+	ethifProtocol = multiaddr.Protocol{
+		Name:       "ethif",
+		Code:       EthifMultiaddrCodec,
+		VCode:      multiaddr.CodeToVarint(EthifMultiaddrCodec),
+		Size:       multiaddr.LengthPrefixedVarSize,
+		Transcoder: multiaddr.NewTranscoderFromFunctions(protoMaddrStr2b, protoMaddrB2Str, nil),
+	}
+	memoryProtocol = multiaddr.Protocol{
+		Name:       "memory",
+		Code:       MemoryMultiaddrCodec,
+		VCode:      multiaddr.CodeToVarint(MemoryMultiaddrCodec),
+		Size:       multiaddr.LengthPrefixedVarSize,
+		Transcoder: multiaddr.NewTranscoderFromFunctions(protoMaddrStr2b, protoMaddrB2Str, nil),
+	}
+	macProtocol = multiaddr.Protocol{
+		Name:       "mac",
+		Code:       MACMultiaddrCodec,
+		VCode:      multiaddr.CodeToVarint(MACMultiaddrCodec),
+		Size:       48,
+		Transcoder: multiaddr.NewTranscoderFromFunctions(macMaddrStr2b, macMaddrB2Str, nil),
+	}
 )
 
 func init() {
 	multiaddr.AddProtocol(rovyProtocol)
 	multiaddr.AddProtocol(protoProtocol)
+	multiaddr.AddProtocol(ethifProtocol)
+	multiaddr.AddProtocol(macProtocol)
+	multiaddr.AddProtocol(memoryProtocol)
 }
 
 func maddrStr2b(s string) ([]byte, error) {
@@ -90,9 +117,35 @@ func protoMaddrB2Str(b []byte) (string, error) {
 	return string(b), nil
 }
 
+func macMaddrStr2b(s string) ([]byte, error) {
+	hw, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("failed to parse mac addr: '%s' is not an EUI-48 address", s)
+	}
+	return hw, nil
+}
+
+func macMaddrB2Str(b []byte) (string, error) {
+	return net.HardwareAddr(b).String(), nil
+}
+
+var emptyMAC [6]byte
+
 type Multiaddr struct {
-	IP     netip.Addr
-	Port   uint16
+	IP   netip.Addr
+	Port uint16
+	// Tpt is the transport protocol on top of IP and port. Zero means UDP,
+	// otherwise it's the codec of TCP, or of TLS, WS or WSS on top of TCP.
+	Tpt uint64
+	// Ifname and MAC are used instead of IP and port by the Ethernet transport.
+	// The interface name is always a local one, also in remote addresses.
+	Ifname string
+	MAC    [6]byte
+	// Memory is the name of an in-memory transport endpoint, see node.MemoryNetwork.
+	Memory string
 	PeerID PeerID
 	More   multiaddr.Multiaddr
 }
@@ -114,21 +167,56 @@ func MustParseMultiaddr(addr string) Multiaddr {
 func ParseMultiaddr(addr string) (ma Multiaddr, err error) {
 	a := strings.Split(addr, "/")
 	a = a[1:]
-	if len(a) >= 4 && a[0] == "ip6" && a[2] == "udp" {
+	if len(a) >= 4 && a[0] == "ip6" && (a[2] == "udp" || a[2] == "tcp") {
 		ip, err := netip.ParseAddrPort("[" + a[1] + "]:" + a[3])
 		if err != nil {
 			return ma, err
 		}
 		ma.IP, ma.Port = ip.Addr(), ip.Port()
-		a = a[4:]
+		a = a[2:]
 	}
-	if len(a) >= 4 && a[0] == "ip4" && a[2] == "udp" {
+	if len(a) >= 4 && a[0] == "ip4" && (a[2] == "udp" || a[2] == "tcp") {
 		ip, err := netip.ParseAddrPort(a[1] + ":" + a[3])
 		if err != nil {
 			return ma, err
 		}
 		ma.IP, ma.Port = ip.Addr(), ip.Port()
-		a = a[4:]
+		a = a[2:]
+	}
+	if ma.IP.IsValid() {
+		if a[0] == "tcp" {
+			ma.Tpt = TCPMultiaddrCodec
+		}
+		a = a[2:]
+		if ma.Tpt == TCPMultiaddrCodec && len(a) >= 1 {
+			switch a[0] {
+			case "tls":
+				ma.Tpt = TLSMultiaddrCodec
+				a = a[1:]
+			case "ws":
+				ma.Tpt = WSMultiaddrCodec
+				a = a[1:]
+			case "wss":
+				ma.Tpt = WSSMultiaddrCodec
+				a = a[1:]
+			}
+		}
+	}
+	if len(a) >= 2 && a[0] == "ethif" && !ma.IP.IsValid() {
+		ma.Ifname = a[1]
+		a = a[2:]
+		if len(a) >= 2 && a[0] == "mac" {
+			b, err := macMaddrStr2b(a[1])
+			if err != nil {
+				return ma, err
+			}
+			copy(ma.MAC[:], b)
+			a = a[2:]
+		}
+	}
+	if len(a) >= 2 && a[0] == "memory" && !ma.IP.IsValid() && ma.Ifname == "" {
+		ma.Memory = a[1]
+		a = a[2:]
 	}
 	if len(a) >= 2 && a[0] == "rovy" {
 		c, err := cid.Parse(a[1])
@@ -175,6 +263,21 @@ func (ma Multiaddr) Bytes() []byte {
 		} else {
 			l += 21
 		}
+		switch ma.Tpt {
+		case TCPMultiaddrCodec:
+			l -= 1
+		case TLSMultiaddrCodec, WSMultiaddrCodec, WSSMultiaddrCodec:
+			l += 1
+		}
+	}
+	if ma.Ifname != "" {
+		l += varint.UvarintSize(EthifMultiaddrCodec) + varint.UvarintSize(uint64(len(ma.Ifname))) + len(ma.Ifname)
+		if ma.MAC != emptyMAC {
+			l += varint.UvarintSize(MACMultiaddrCodec) + 6
+		}
+	}
+	if ma.Memory != "" {
+		l += 2 + varint.UvarintSize(uint64(len(ma.Memory))) + len(ma.Memory)
 	}
 	if ma.More != nil {
 		mb := ma.More.Bytes()
@@ -197,11 +300,49 @@ func (ma Multiaddr) Bytes() []byte {
 			copy(buf[1:17], ma.IP.AsSlice())
 			n += 17
 		}
-		buf[n+0] = 0x91 // varint multicodec for /udp, code=273
-		buf[n+1] = 0x02
-		buf[n+2] = byte(ma.Port >> 8)
-		buf[n+3] = byte(ma.Port)
-		n += 4
+		if ma.Tpt == 0 {
+			buf[n+0] = 0x91 // varint multicodec for /udp, code=273
+			buf[n+1] = 0x02
+			n += 2
+		} else {
+			buf[n+0] = 0x06 // varint multicodec for /tcp, code=6
+			n += 1
+		}
+		buf[n+0] = byte(ma.Port >> 8)
+		buf[n+1] = byte(ma.Port)
+		n += 2
+		switch ma.Tpt {
+		case TLSMultiaddrCodec:
+			buf[n+0] = 0xc0 // varint multicodec for /tls, code=448
+			buf[n+1] = 0x03
+			n += 2
+		case WSMultiaddrCodec:
+			buf[n+0] = 0xdd // varint multicodec for /ws, code=477
+			buf[n+1] = 0x03
+			n += 2
+		case WSSMultiaddrCodec:
+			buf[n+0] = 0xde // varint multicodec for /wss, code=478
+			buf[n+1] = 0x03
+			n += 2
+		}
+	}
+
+	if ma.Ifname != "" {
+		n += varint.PutUvarint(buf[n:], EthifMultiaddrCodec)
+		n += varint.PutUvarint(buf[n:], uint64(len(ma.Ifname)))
+		n += copy(buf[n:], ma.Ifname)
+		if ma.MAC != emptyMAC {
+			n += varint.PutUvarint(buf[n:], MACMultiaddrCodec)
+			n += copy(buf[n:], ma.MAC[:])
+		}
+	}
+
+	if ma.Memory != "" {
+		buf[n+0] = 0x89 // varint multicodec for /memory, code=777
+		buf[n+1] = 0x06
+		n += 2
+		n += varint.PutUvarint(buf[n:], uint64(len(ma.Memory)))
+		n += copy(buf[n:], ma.Memory)
 	}
 
 	if ma.More != nil {
@@ -228,9 +369,29 @@ func (ma Multiaddr) String() string {
 		} else {
 			out += "/ip6/" + ma.IP.String()
 		}
-		if ma.Port > 0 {
+		if ma.Tpt == 0 && ma.Port > 0 {
 			out += "/udp/" + strconv.FormatUint(uint64(ma.Port), 10)
 		}
+		if ma.Tpt != 0 {
+			out += "/tcp/" + strconv.FormatUint(uint64(ma.Port), 10)
+		}
+		switch ma.Tpt {
+		case TLSMultiaddrCodec:
+			out += "/tls"
+		case WSMultiaddrCodec:
+			out += "/ws"
+		case WSSMultiaddrCodec:
+			out += "/wss"
+		}
+	}
+	if ma.Ifname != "" {
+		out += "/ethif/" + ma.Ifname
+		if ma.MAC != emptyMAC {
+			out += "/mac/" + net.HardwareAddr(ma.MAC[:]).String()
+		}
+	}
+	if ma.Memory != "" {
+		out += "/memory/" + ma.Memory
 	}
 	if ma.More != nil {
 		out += ma.More.String()
@@ -245,11 +406,29 @@ func (ma Multiaddr) Protocols() []multiaddr.Protocol {
 	var protos []multiaddr.Protocol
 	if ma.IP.IsValid() {
 		if ma.IP.Is4() {
-			protos = udp4protocols
+			protos = append(protos, multiaddr.ProtocolWithCode(IP4MultiaddrCodec))
 		} else {
-			protos = udp6protocols
+			protos = append(protos, multiaddr.ProtocolWithCode(IP6MultiaddrCodec))
+		}
+		switch ma.Tpt {
+		case 0:
+			protos = append(protos, multiaddr.ProtocolWithCode(UDPMultiaddrCodec))
+		case TCPMultiaddrCodec:
+			protos = append(protos, multiaddr.ProtocolWithCode(TCPMultiaddrCodec))
+		case TLSMultiaddrCodec, WSMultiaddrCodec, WSSMultiaddrCodec:
+			protos = append(protos, multiaddr.ProtocolWithCode(TCPMultiaddrCodec))
+			protos = append(protos, multiaddr.ProtocolWithCode(int(ma.Tpt)))
+		}
+	}
+	if ma.Ifname != "" {
+		protos = append(protos, ethifProtocol)
+		if ma.MAC != emptyMAC {
+			protos = append(protos, macProtocol)
 		}
 	}
+	if ma.Memory != "" {
+		protos = append(protos, memoryProtocol)
+	}
 	if ma.More != nil {
 		protos = append(protos, ma.More.Protocols()...)
 	}
@@ -264,8 +443,19 @@ func (ma Multiaddr) ValueForProtocol(code int) (string, error) {
 		return ma.IP.String(), nil
 	} else if code == IP6MultiaddrCodec && ma.IP.Is6() {
 		return ma.IP.String(), nil
-	} else if code == UDPMultiaddrCodec && ma.IP.IsValid() {
+	} else if code == UDPMultiaddrCodec && ma.IP.IsValid() && ma.Tpt == 0 {
+		return strconv.FormatUint(uint64(ma.Port), 10), nil
+	} else if code == TCPMultiaddrCodec && ma.IP.IsValid() && ma.Tpt != 0 {
 		return strconv.FormatUint(uint64(ma.Port), 10), nil
+	} else if ma.Tpt != 0 && ma.Tpt != TCPMultiaddrCodec && uint64(code) == ma.Tpt {
+		return "", nil
+	}
+	if code == EthifMultiaddrCodec && ma.Ifname != "" {
+		return ma.Ifname, nil
+	} else if code == MACMultiaddrCodec && ma.MAC != emptyMAC {
+		return net.HardwareAddr(ma.MAC[:]).String(), nil
+	} else if code == MemoryMultiaddrCodec && ma.Memory != "" {
+		return ma.Memory, nil
 	}
 	if code == RovyMultiaddrCodec && ma.PeerID != emptyPeerID {
 		return ma.PeerID.String(), nil
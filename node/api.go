@@ -24,4 +24,20 @@ func (node *Node) Discovery() rovyapi.DiscoveryAPI {
 	return (*DiscoveryAPI)(node)
 }
 
+func (node *Node) Stats() rovyapi.StatsAPI {
+	return (*StatsAPI)(node)
+}
+
+func (node *Node) Logging() rovyapi.LoggingAPI {
+	return (*LoggingAPI)(node)
+}
+
+func (node *Node) Policy() rovyapi.PolicyAPI {
+	return (*PolicyAPI)(node)
+}
+
+func (node *Node) Petname() rovyapi.PetnameAPI {
+	return (*PetnameAPI)(node)
+}
+
 var _ rovyapi.NodeAPI = &Node{}
@@ -17,7 +17,7 @@ func (c *DiscoveryAPI) StartLinkLocal(opts rapi.DiscoveryLinkLocal) error {
 	ll := &rdisco.LinkLocal{
 		API:      c.NodeAPI(),
 		Interval: opts.Interval,
-		Log:      (*Node)(c).Log(),
+		Log:      (*Node)(c).Logger(LogDiscovery),
 	}
 	err := sm.Add(rdisco.ServiceTagLinkLocal, ll)
 	if err != nil {
@@ -2,7 +2,6 @@ package rdiscovery
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"net/netip"
 	"time"
@@ -12,6 +11,7 @@ import (
 	rovy "go.rovy.net"
 	rapi "go.rovy.net/api"
 	rservice "go.rovy.net/node/service"
+	logging "go.rovy.net/node/util/logging"
 )
 
 const (
@@ -28,7 +28,7 @@ type LinkLocalPacket struct {
 type LinkLocal struct {
 	API      rapi.NodeAPI
 	Interval time.Duration
-	Log      *log.Logger
+	Log      *logging.Logger
 	running  chan int
 }
 
@@ -76,7 +76,7 @@ func (ll *LinkLocal) receiveRoutine(conn *net.UDPConn) {
 
 	for {
 		if !ll.Running() {
-			ll.Log.Printf("discovery: shutting down linklocal receiveRoutine")
+			ll.Log.Debug("linklocal: shutting down receiveRoutine")
 			return
 		}
 
@@ -86,7 +86,7 @@ func (ll *LinkLocal) receiveRoutine(conn *net.UDPConn) {
 			return
 		}
 		if err != nil {
-			ll.Log.Printf("discovery: linklocal: error reading: %s", err)
+			ll.Log.Warn("linklocal: error reading", "err", err)
 			continue
 		}
 
@@ -95,15 +95,15 @@ func (ll *LinkLocal) receiveRoutine(conn *net.UDPConn) {
 		}
 
 		var pkt LinkLocalPacket
-		if err != cbor.Unmarshal(b[:n], &pkt) {
-			ll.Log.Printf("discovery: %s", err)
+		if err := cbor.Unmarshal(b[:n], &pkt); err != nil {
+			ll.Log.Warn("linklocal: dropping malformed announcement", "from", raddr, "err", err)
 			continue
 		}
 
 		for _, a := range pkt.Addrs {
 			a.IP = a.IP.WithZone(raddr.Addr().Zone())
 			a.PeerID = pkt.PeerID
-			ll.Log.Printf("discovery: found %s", a)
+			ll.Log.Debug("linklocal: found peer", "addr", a)
 		}
 	}
 }
@@ -115,13 +115,13 @@ func (ll *LinkLocal) announceRoutine(conn *net.UDPConn) {
 	for {
 		select {
 		case <-ll.running:
-			ll.Log.Printf("discovery: shutting down linklocal announceRoutine")
+			ll.Log.Debug("linklocal: shutting down announceRoutine")
 			return
 		case <-ticker.C:
 			// get interface names and respective link-local addresses
 			ifaces, err := ll.linklocalCapableInterfaces()
 			if err != nil {
-				ll.Log.Printf("discovery: linklocal: %s", err)
+				ll.Log.Warn("linklocal: interfaces", "err", err)
 				continue
 			}
 
@@ -130,12 +130,12 @@ func (ll *LinkLocal) announceRoutine(conn *net.UDPConn) {
 			// ourport = uint16(12345)
 			status, err := ll.API.Peer().Status()
 			if err != nil {
-				ll.Log.Printf("discovery: peer/status: %s", err)
+				ll.Log.Warn("linklocal: peer/status", "err", err)
 				continue
 			}
 			for _, listener := range status.Listeners {
-				if listener.ListenAddr.IP.Is6() {
-					ourport = listener.ListenAddr.Port
+				if listener.ListenAddr.IP.Is6() && listener.ListenAddr.Tpt == 0 && len(listener.EffectiveAddrs) > 0 {
+					ourport = listener.EffectiveAddrs[0].Port
 					break
 				}
 			}
@@ -149,13 +149,13 @@ func (ll *LinkLocal) announceRoutine(conn *net.UDPConn) {
 				}
 				buf, err := cbor.Marshal(pkt)
 				if err != nil {
-					ll.Log.Printf("discovery: cbor: %s", err)
+					ll.Log.Error("linklocal: cbor", "err", err)
 					break
 				}
 
 				addr := netip.AddrPortFrom(netip.IPv6LinkLocalAllNodes().WithZone(ifname), LinkLocalPort)
 				if _, err = conn.WriteToUDPAddrPort(buf, addr); err != nil {
-					ll.Log.Printf("discovery: %s", err)
+					ll.Log.Warn("linklocal: announce", "ifname", ifname, "err", err)
 				}
 			}
 		}
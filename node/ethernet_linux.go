@@ -0,0 +1,279 @@
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	rovy "go.rovy.net"
+	logging "go.rovy.net/node/util/logging"
+	ringbuf "go.rovy.net/node/util/ringbuf"
+)
+
+var _ Transport = &EthernetTransport{}
+
+func newEthernetTransport(lisaddr rovy.Multiaddr, logger *logging.Logger) (Transport, error) {
+	return NewEthernetTransport(lisaddr, logger)
+}
+
+// EthernetTransport sends and receives packets directly on an Ethernet interface,
+// using an AF_PACKET socket with our own EtherType. The kernel takes care of
+// the Ethernet header. Frames are padded to the minimum Ethernet frame size,
+// so each packet is prefixed with its length as a 16-bit big-endian integer.
+// Packets which don't fit the interface's MTU with that prefix fail with PacketTooLargeError.
+type EthernetTransport struct {
+	sync.Mutex
+	file       *os.File
+	listenAddr rovy.Multiaddr
+	localAddr  rovy.Multiaddr
+	running    chan int
+	routines   sync.WaitGroup
+	sendQ      *ringbuf.RingBuffer
+	logger     *logging.Logger
+	mtu        int // in bytes of packet, without the length prefix
+}
+
+// NewEthernetTransport only checks the listen address,
+// the socket is bound once the transport is started.
+func NewEthernetTransport(lisaddr rovy.Multiaddr, logger *logging.Logger) (*EthernetTransport, error) {
+	if lisaddr.Ifname == "" || lisaddr.IP.IsValid() || lisaddr.More != nil {
+		return nil, fmt.Errorf("can't listen on %s", lisaddr)
+	}
+
+	tpt := &EthernetTransport{
+		listenAddr: rovy.Multiaddr{Ifname: lisaddr.Ifname},
+		sendQ:      ringbuf.NewRingBuffer(TransportBufferSize),
+		logger:     logger,
+	}
+	return tpt, nil
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func (tpt *EthernetTransport) Start(next *ringbuf.RingBuffer) error {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if tpt.Running() {
+		return ErrRunning
+	}
+
+	iface, err := net.InterfaceByName(tpt.listenAddr.Ifname)
+	if err != nil {
+		return err
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("%s is not an Ethernet interface", iface.Name)
+	}
+
+	proto := htons(EthernetEtherType)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return fmt.Errorf("socket: %s", err)
+	}
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index}); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("bind: %s", err)
+	}
+
+	// os.File hands the non-blocking socket to the runtime's netpoller
+	tpt.file = os.NewFile(uintptr(fd), "ethif/"+iface.Name)
+	rawconn, err := tpt.file.SyscallConn()
+	if err != nil {
+		tpt.file.Close()
+		return err
+	}
+
+	tpt.localAddr = rovy.Multiaddr{Ifname: iface.Name}
+	copy(tpt.localAddr.MAC[:], iface.HardwareAddr)
+	tpt.mtu = iface.MTU - 2
+
+	tpt.running = make(chan int)
+	tpt.routines.Add(2)
+	go tpt.SendRoutine(rawconn, iface.Index)
+	go tpt.RecvRoutine(rawconn, tpt.localAddr, next)
+
+	return nil
+}
+
+// Stop closes the socket and waits for the send and receive routines to return.
+func (tpt *EthernetTransport) Stop() error {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if !tpt.Running() {
+		return ErrNotRunning
+	}
+
+	close(tpt.running)
+	err := tpt.file.Close()
+	tpt.routines.Wait()
+
+	tpt.file = nil
+	tpt.localAddr = rovy.Multiaddr{}
+	return err
+}
+
+func (tpt *EthernetTransport) Running() bool {
+	if tpt.running != nil {
+		select {
+		case <-tpt.running:
+			// we're not running anymore, channel is closed.
+			// the channel is unbuffered, so if we never write anything to it,
+			// then the only way to reach here is if the channel is closed.
+			return false
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (tpt *EthernetTransport) ListenMultiaddr() rovy.Multiaddr {
+	return tpt.listenAddr
+}
+
+func (tpt *EthernetTransport) LocalMultiaddr() rovy.Multiaddr {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if tpt.localAddr.Empty() {
+		return tpt.listenAddr
+	}
+	return tpt.localAddr
+}
+
+func (tpt *EthernetTransport) EffectiveMultiaddrs() ([]rovy.Multiaddr, error) {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if tpt.localAddr.Empty() {
+		return nil, nil
+	}
+	return []rovy.Multiaddr{tpt.localAddr}, nil
+}
+
+func (tpt *EthernetTransport) RecvRoutine(rawconn syscall.RawConn, laddr rovy.Multiaddr, next *ringbuf.RingBuffer) {
+	defer tpt.routines.Done()
+
+	buf := make([]byte, 2+rovy.TptMTU)
+	for {
+		var n int
+		var from unix.Sockaddr
+		var rerr error
+		err := rawconn.Read(func(fd uintptr) bool {
+			n, from, rerr = unix.Recvfrom(int(fd), buf, 0)
+			return rerr != unix.EAGAIN
+		})
+		if !tpt.Running() {
+			return
+		}
+		if err == nil {
+			err = rerr
+		}
+		if err != nil {
+			tpt.logger.Warn("RecvRoutine", "err", err)
+			continue
+		}
+
+		sll, ok := from.(*unix.SockaddrLinklayer)
+		if !ok || sll.Halen != 6 || n < 2 {
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(buf[0:2]))
+		if length > n-2 || length > rovy.TptMTU {
+			tpt.logger.Warn("RecvRoutine: dropping truncated frame")
+			continue
+		}
+
+		pkt := rovy.AllocPacket()
+		pkt.Length = copy(pkt.Buf, buf[2:2+length])
+		pkt.TptSrc = rovy.Multiaddr{Ifname: laddr.Ifname}
+		copy(pkt.TptSrc.MAC[:], sll.Addr[:6])
+		pkt.TptLocal = laddr
+		next.Put(pkt)
+	}
+}
+
+func (tpt *EthernetTransport) SendRoutine(rawconn syscall.RawConn, ifindex int) {
+	defer tpt.routines.Done()
+
+	buf := make([]byte, 2+rovy.TptMTU)
+	for {
+		select {
+		case <-tpt.running:
+			return
+		case pkt := <-tpt.sendQ.Channel():
+			if pkt.TptDst.MAC == [6]byte{} {
+				tpt.logger.Warn("SendRoutine: dropping packet without destination MAC")
+				pkt.Release()
+				continue
+			}
+			if pkt.Length > rovy.TptMTU {
+				tpt.logger.Warn("SendRoutine: dropping oversized packet")
+				pkt.Release()
+				continue
+			}
+
+			binary.BigEndian.PutUint16(buf[0:2], uint16(pkt.Length))
+			n := 2 + copy(buf[2:], pkt.Bytes())
+			dst := pkt.TptDst.MAC
+			pkt.Release()
+
+			sll := &unix.SockaddrLinklayer{
+				Protocol: htons(EthernetEtherType),
+				Ifindex:  ifindex,
+				Halen:    6,
+			}
+			copy(sll.Addr[:], dst[:])
+
+			var werr error
+			err := rawconn.Write(func(fd uintptr) bool {
+				werr = unix.Sendto(int(fd), buf[:n], 0, sll)
+				return werr != unix.EAGAIN
+			})
+			if !tpt.Running() {
+				return
+			}
+			if err == nil {
+				err = werr
+			}
+			if errors.Is(err, unix.EMSGSIZE) {
+				// the interface's MTU went down since we started
+				if iface, ierr := net.InterfaceByIndex(ifindex); ierr == nil {
+					tpt.Lock()
+					tpt.mtu = iface.MTU - 2
+					tpt.Unlock()
+				}
+			}
+			if err != nil {
+				tpt.logger.Warn("SendRoutine", "err", err)
+			}
+		}
+	}
+}
+
+func (tpt *EthernetTransport) Send(pkt rovy.Packet) error {
+	tpt.Lock()
+	running, mtu := tpt.running, tpt.mtu
+	tpt.Unlock()
+
+	if running != nil && pkt.Length > mtu {
+		pkt.Release()
+		return PacketTooLargeError{MTU: mtu}
+	}
+
+	if !tpt.Running() || !tpt.sendQ.PutWithBackpressureUntil(pkt, running) {
+		pkt.Release()
+		return ErrNotRunning
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package node
+
+import (
+	"errors"
+
+	rovy "go.rovy.net"
+	logging "go.rovy.net/node/util/logging"
+)
+
+func newEthernetTransport(lisaddr rovy.Multiaddr, logger *logging.Logger) (Transport, error) {
+	return nil, errors.New("the ethernet transport is only supported on linux")
+}
@@ -52,7 +52,8 @@
 //    It also means we can't just hand outgoing packets to `HandlePacket` because
 //    it would add "self" as the previous hop.
 //
-// TODO: send error reply packets
+// If a packet doesn't fit the link to the next hop, the forwarder drops it,
+// and sends a packet-too-big error along the reverse route, see pmtu.go.
 
 package forwarder
 
@@ -61,8 +62,10 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 
 	rovy "go.rovy.net"
+	logging "go.rovy.net/node/util/logging"
 )
 
 const (
@@ -79,10 +82,11 @@ var (
 	ErrZeroLenRoute   = errors.New("got zero-length route route")
 	ErrRouteTooLong   = errors.New("route is longer than 255 bytes")
 	ErrLoopRoute      = errors.New("route resulted in loop")
+	ErrPacketTooBig   = errors.New("packet exceeds the next hop's link MTU")
 
 	nullSlotEntry = &slotentry{
-		rovy.PeerID{},
-		func(pkt rovy.LowerPacket) error {
+		peerid: rovy.PeerID{},
+		send: func(pkt rovy.LowerPacket) error {
 			return fmt.Errorf("forwarder: dropping packet for unknown destination from %s via %s -- %#v\n", pkt.LowerSrc, rovy.NewUpperPacket(pkt.Packet).Route(), pkt.Bytes())
 		},
 	}
@@ -91,6 +95,7 @@ var (
 type slotentry struct {
 	peerid rovy.PeerID
 	send   sendFunc
+	mtu    int // zero means TptMTU
 }
 
 type sendFunc func(rovy.LowerPacket) error
@@ -98,16 +103,23 @@ type sendFunc func(rovy.LowerPacket) error
 // XXX: is rovy.PeerID okay as a map index type? yes but string might be faster
 type Forwarder struct {
 	sync.RWMutex
-	slots  map[int]*slotentry
-	bypeer map[rovy.PeerID]int
-	logger *log.Logger
+	slots    map[int]*slotentry
+	bypeer   map[rovy.PeerID]int
+	logger   *logging.Logger
+	dropped  atomic.Uint64
+	onTooBig func(rovy.Route, int)
+
+	probesLock sync.Mutex
+	probes     map[uint32]*probe
+	probeID    atomic.Uint32
 }
 
-func NewForwarder(logger *log.Logger) *Forwarder {
+func NewForwarder(logger *logging.Logger) *Forwarder {
 	fwd := &Forwarder{
 		slots:  make(map[int]*slotentry, NumSlots),
 		bypeer: make(map[rovy.PeerID]int, NumSlots),
 		logger: logger,
+		probes: map[uint32]*probe{},
 	}
 	for i := 0; i < NumSlots; i++ {
 		fwd.slots[i] = nullSlotEntry
@@ -132,7 +144,7 @@ func (fwd *Forwarder) Attach(peerid rovy.PeerID, send sendFunc) (rovy.Route, err
 
 	for i := 0; i < NumSlots; i++ {
 		if fwd.slots[i] == nullSlotEntry {
-			fwd.slots[i] = &slotentry{peerid, send}
+			fwd.slots[i] = &slotentry{peerid: peerid, send: send}
 			fwd.bypeer[peerid] = i
 			return rovy.NewRoute(byte(i)), nil
 		}
@@ -154,51 +166,90 @@ func (fwd *Forwarder) Detach(peerid rovy.PeerID) error {
 	return fmt.Errorf("slot entry not found")
 }
 
+func (fwd *Forwarder) Lookup(peerid rovy.PeerID) (rovy.Route, bool) {
+	fwd.RLock()
+	defer fwd.RUnlock()
+
+	i, present := fwd.bypeer[peerid]
+	if !present {
+		return rovy.NewRoute(), false
+	}
+	return rovy.NewRoute(byte(i)), true
+}
+
+// Dropped returns the number of packets which HandlePacket, SendPacket,
+// or SendRaw couldn't send on, e.g. because of an unknown slot.
+func (fwd *Forwarder) Dropped() uint64 {
+	return fwd.dropped.Load()
+}
+
+func (fwd *Forwarder) drop(err error) error {
+	if err != nil {
+		fwd.dropped.Add(1)
+	}
+	return err
+}
+
 // TODO drop if n+2+length > len(buf) || n+2+pos > len(buf)+2
 func (fwd *Forwarder) HandlePacket(pkt rovy.LowerPacket) error {
 	buf := pkt.Buf[rovy.FwdOffset : rovy.FwdOffset+16]
 
 	length := int(buf[1])
 	if length == 0 {
-		return ErrZeroLenRoute
+		return fwd.drop(ErrZeroLenRoute)
 	}
 
 	pos := int(buf[0])
 	if pos > length {
-		return ErrLoopRoute
+		return fwd.drop(ErrLoopRoute)
 	}
 
 	// TODO error if length > 14 || pos > 13
 
 	fwd.RLock()
-	defer fwd.RUnlock()
 
 	next := int(buf[2+pos+1])
 
 	prev, present := fwd.bypeer[pkt.LowerSrc]
 	if !present {
-		return ErrPrevHopUnknown
+		fwd.RUnlock()
+		return fwd.drop(ErrPrevHopUnknown)
 	}
 	buf[2+pos] = byte(prev)
 
 	if pos == length-1 {
-		return fwd.slots[0].send(pkt)
+		defer fwd.RUnlock()
+		return fwd.drop(fwd.slots[0].send(pkt))
 	}
 	buf[0] = byte(pos + 1)
 
+	se := fwd.slots[next]
+	if se.mtu > 0 && pkt.Length > se.mtu {
+		fwd.RUnlock()
+
+		// the route up to here, reversed, takes the error back to the sender
+		back := rovy.NewRoute(append([]byte{}, buf[2:2+pos+1]...)...).Reverse()
+		if err := fwd.sendTooBig(back, byte(next), se.mtu); err != nil {
+			fwd.logger.Debug("forwarder: sending packet-too-big", "err", err)
+		}
+		return fwd.drop(ErrPacketTooBig)
+	}
+
+	defer fwd.RUnlock()
+
 	// fwd.logger.Printf("forwarder: packet from %s forwarded along %s", from, rovy.NewRoute(buf[2+pos:2+buf[1]]...))
-	pkt.LowerDst = fwd.slots[next].peerid
-	return fwd.slots[next].send(pkt)
+	pkt.LowerDst = se.peerid
+	return fwd.drop(se.send(pkt))
 }
 
 // We expect the packet to have already passed through (upper) SessionManager.CreateData
 func (fwd *Forwarder) SendPacket(upkt rovy.UpperPacket) error {
 	length := upkt.Route().Len()
 	if length == 0 {
-		return ErrZeroLenRoute
+		return fwd.drop(ErrZeroLenRoute)
 	}
 	if length > 14 {
-		return ErrRouteTooLong
+		return fwd.drop(ErrRouteTooLong)
 	}
 
 	lpkt := rovy.NewLowerPacket(upkt.Packet)
@@ -208,9 +259,15 @@ func (fwd *Forwarder) SendPacket(upkt rovy.UpperPacket) error {
 	return fwd.SendRaw(lpkt)
 }
 
+// SendRaw sends a packet of ours to the first hop of its route.
+// If it doesn't fit that link, it returns ErrPacketTooBig,
+// and the route's first hop is passed to the OnTooBig callback.
 func (fwd *Forwarder) SendRaw(lpkt rovy.LowerPacket) error {
 	buf := lpkt.Payload()
 	next := int(buf[2+buf[0]])
+	if err := fwd.CheckMTU(rovy.NewRoute(byte(next)), lpkt.Length); err != nil {
+		return fwd.drop(err)
+	}
 	lpkt.LowerDst = fwd.slots[next].peerid
-	return fwd.slots[next].send(lpkt)
+	return fwd.drop(fwd.slots[next].send(lpkt))
 }
@@ -7,6 +7,7 @@ import (
 
 	rovy "go.rovy.net"
 	forwarder "go.rovy.net/node/forwarder"
+	logging "go.rovy.net/node/util/logging"
 )
 
 func BenchmarkHandlePacket(b *testing.B) {
@@ -14,7 +15,7 @@ func BenchmarkHandlePacket(b *testing.B) {
 	peeridB := newPeerID(b)
 	peeridC := newPeerID(b)
 
-	fwd := forwarder.NewForwarder(log.New(ioutil.Discard, "", log.LstdFlags))
+	fwd := forwarder.NewForwarder(logging.New("forwarder", log.New(ioutil.Discard, "", log.LstdFlags)))
 	fwd.Attach(peeridA, func(_ rovy.LowerPacket) error { return nil })
 	fwd.Attach(peeridB, func(_ rovy.LowerPacket) error { return nil })
 	fwd.Attach(peeridC, func(_ rovy.LowerPacket) error { return nil })
@@ -0,0 +1,272 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	rovy "go.rovy.net"
+)
+
+// Path MTU discovery
+//
+// A forwarder learns the MTU of the link to a peer with SetMTU,
+// usually after the transport refused a packet for being too large.
+// From then on, packets which don't fit that link are dropped,
+// and a packet-too-big error goes back along the reverse route:
+//
+// ```
+// [codec][len][pos][route][mtu][next]
+// ```
+//
+// - `mtu` is the link MTU in bytes of lower packet, 2 bytes big endian.
+// - `next` is the slot number of the link that the packet didn't fit.
+//
+// The sender of the oversized packet reverses the route it got the error on,
+// and appends `next`, which results in the prefix of its own route that ends with the small link.
+// That prefix and the MTU are handed to the OnTooBig callback, i.e. the routing table.
+//
+// Probes are full-sized packets along a route, which the destination answers with a small reply:
+//
+// ```
+// [codec][len][pos][route][id][reply][padding...]
+// ```
+//
+// - `id` identifies the probe, 4 bytes.
+// - `reply` is 0x1 for the reply, and 0x0 for the probe itself.
+//
+// These errors aren't signed, so a hop could make up a smaller MTU for paths through it.
+// It could as well just drop the packets though.
+
+const (
+	TooBigMulticodec = 0x12346
+	ProbeMulticodec  = 0x12348
+)
+
+var ErrProbeTimeout = errors.New("no reply to path MTU probe")
+
+type probe struct {
+	route rovy.Route
+	done  chan error
+}
+
+// OnTooBig sets the callback for packet-too-big errors about routes that we send on,
+// with the route up to and including the link that's too small, and that link's MTU.
+func (fwd *Forwarder) OnTooBig(cb func(rovy.Route, int)) {
+	fwd.Lock()
+	defer fwd.Unlock()
+	fwd.onTooBig = cb
+}
+
+// SetMTU sets the MTU of the link to a peer, in bytes of lower packet.
+// Zero means TptMTU.
+func (fwd *Forwarder) SetMTU(peerid rovy.PeerID, mtu int) error {
+	fwd.Lock()
+	defer fwd.Unlock()
+
+	i, present := fwd.bypeer[peerid]
+	if !present {
+		return ErrNextHopUnknown
+	}
+	se := *fwd.slots[i]
+	se.mtu = mtu
+	fwd.slots[i] = &se
+	return nil
+}
+
+// LinkMTU returns the MTU of the link to the peer in the given slot.
+func (fwd *Forwarder) LinkMTU(slot byte) int {
+	fwd.RLock()
+	defer fwd.RUnlock()
+
+	if mtu := fwd.slots[int(slot)].mtu; mtu > 0 {
+		return mtu
+	}
+	return rovy.TptMTU
+}
+
+// CheckMTU returns ErrPacketTooBig if a packet of the given length
+// doesn't fit the link to the route's first hop,
+// in which case the OnTooBig callback learns about that link.
+func (fwd *Forwarder) CheckMTU(route rovy.Route, length int) error {
+	if route.Empty() {
+		return ErrZeroLenRoute
+	}
+	first := route.Bytes()[0]
+	mtu := fwd.LinkMTU(first)
+	if length <= mtu {
+		return nil
+	}
+	fwd.tooBig(rovy.NewRoute(first), mtu)
+	return ErrPacketTooBig
+}
+
+func (fwd *Forwarder) tooBig(route rovy.Route, mtu int) {
+	fwd.RLock()
+	cb := fwd.onTooBig
+	fwd.RUnlock()
+
+	if cb != nil {
+		cb(route, mtu)
+	}
+
+	// wake up the probes which this affects, so they can try again
+	fwd.probesLock.Lock()
+	defer fwd.probesLock.Unlock()
+	for _, p := range fwd.probes {
+		if bytes.HasPrefix(p.route.Bytes(), route.Bytes()) {
+			select {
+			case p.done <- ErrPacketTooBig:
+			default:
+			}
+		}
+	}
+}
+
+// sendTooBig sends a packet-too-big error along the route.
+// The caller must not hold the lock, a blocking send would stall Attach and Detach.
+func (fwd *Forwarder) sendTooBig(route rovy.Route, next byte, mtu int) error {
+	lpkt := fwd.newControlPacket(TooBigMulticodec, route, 3)
+	pl := lpkt.Payload()[16:]
+	binary.BigEndian.PutUint16(pl[0:2], uint16(mtu))
+	pl[2] = next
+
+	fwd.RLock()
+	se := fwd.slots[int(route.Bytes()[0])]
+	fwd.RUnlock()
+	lpkt.LowerDst = se.peerid
+	return se.send(lpkt)
+}
+
+// HandleTooBig passes a packet-too-big error on towards the sender of the oversized packet,
+// or if that's us, calls the OnTooBig callback.
+func (fwd *Forwarder) HandleTooBig(lpkt rovy.LowerPacket) error {
+	route, pl, err := fwd.receiveControl(lpkt, 3)
+	if err != nil || route.Empty() {
+		return err
+	}
+	defer lpkt.Release()
+
+	mtu := int(binary.BigEndian.Uint16(pl[0:2]))
+	fwd.tooBig(route.Join(rovy.NewRoute(pl[2])), mtu)
+	return nil
+}
+
+// Probe sends a packet with the given length along the route, and waits for the reply.
+// It returns ErrPacketTooBig if a hop signals that the probe doesn't fit,
+// and ErrProbeTimeout if there's no reply in time.
+func (fwd *Forwarder) Probe(route rovy.Route, length int, timeout time.Duration) error {
+	if route.Empty() {
+		return ErrZeroLenRoute
+	}
+	if route.Len() > 14 {
+		return ErrRouteTooLong
+	}
+	if length > rovy.TptMTU || length < rovy.FwdOffset+16+5+16 {
+		return errors.New("probe length out of range")
+	}
+
+	id := fwd.probeID.Add(1)
+	p := &probe{route: route, done: make(chan error, 1)}
+	fwd.probesLock.Lock()
+	fwd.probes[id] = p
+	fwd.probesLock.Unlock()
+	defer func() {
+		fwd.probesLock.Lock()
+		delete(fwd.probes, id)
+		fwd.probesLock.Unlock()
+	}()
+
+	lpkt := fwd.newControlPacket(ProbeMulticodec, route, 5)
+	binary.BigEndian.PutUint32(lpkt.Payload()[16:20], id)
+	lpkt.Length = length
+	if err := fwd.SendRaw(lpkt); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-time.After(timeout):
+		return ErrProbeTimeout
+	}
+}
+
+// HandleProbe passes a probe or probe reply on, answers a probe for us,
+// or hands a reply for us to the waiting Probe call.
+func (fwd *Forwarder) HandleProbe(lpkt rovy.LowerPacket) error {
+	route, pl, err := fwd.receiveControl(lpkt, 5)
+	if err != nil || route.Empty() {
+		return err
+	}
+	defer lpkt.Release()
+
+	id := binary.BigEndian.Uint32(pl[0:4])
+	if pl[4] == 0x0 {
+		reply := fwd.newControlPacket(ProbeMulticodec, route, 5)
+		rpl := reply.Payload()[16:]
+		binary.BigEndian.PutUint32(rpl[0:4], id)
+		rpl[4] = 0x1
+
+		// not sending under the lock, a blocking send would stall Attach and Detach
+		fwd.RLock()
+		se := fwd.slots[int(route.Bytes()[0])]
+		fwd.RUnlock()
+		reply.LowerDst = se.peerid
+		return fwd.drop(se.send(reply))
+	}
+
+	fwd.probesLock.Lock()
+	defer fwd.probesLock.Unlock()
+	if p, present := fwd.probes[id]; present {
+		select {
+		case p.done <- nil:
+		default:
+		}
+	}
+	return nil
+}
+
+// newControlPacket allocates a lower packet with the forwarder header for the route,
+// and room for a payload of the given size.
+func (fwd *Forwarder) newControlPacket(codec uint64, route rovy.Route, size int) rovy.LowerPacket {
+	lpkt := rovy.NewLowerPacket(rovy.AllocPacket())
+	lpkt.SetCodec(codec)
+	lpkt = lpkt.SetPayload(make([]byte, 16+size))
+	upkt := rovy.NewUpperPacket(lpkt.Packet)
+	upkt.SetRoute(route)
+	return lpkt
+}
+
+// receiveControl forwards a control packet if we're not its destination,
+// in which case the returned route is empty. Otherwise it returns the route
+// back to where the packet came from, and the packet's payload after the forwarder header.
+func (fwd *Forwarder) receiveControl(lpkt rovy.LowerPacket, size int) (rovy.Route, []byte, error) {
+	if lpkt.Length < rovy.FwdOffset+16+size+16 {
+		lpkt.Release()
+		return rovy.Route{}, nil, errors.New("forwarder: control packet too short")
+	}
+
+	buf := lpkt.Buf[rovy.FwdOffset : rovy.FwdOffset+16]
+	pos, length := int(buf[0]), int(buf[1])
+	if length == 0 || length > 14 || pos >= length {
+		lpkt.Release()
+		return rovy.Route{}, nil, fwd.drop(ErrLoopRoute)
+	}
+	if pos+1 < length {
+		return rovy.Route{}, nil, fwd.HandlePacket(lpkt)
+	}
+
+	fwd.RLock()
+	prev, present := fwd.bypeer[lpkt.LowerSrc]
+	fwd.RUnlock()
+	if !present {
+		lpkt.Release()
+		return rovy.Route{}, nil, fwd.drop(ErrPrevHopUnknown)
+	}
+
+	hops := append([]byte{}, buf[2:2+pos]...)
+	hops = append(hops, byte(prev))
+	return rovy.NewRoute(hops...).Reverse(), lpkt.Buf[rovy.FwdOffset+16 : rovy.FwdOffset+16+size], nil
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	rapi "go.rovy.net/api"
+	logging "go.rovy.net/node/util/logging"
+)
+
+// Subsystems of the node's loggers, see Logger and LoggingAPI.
+const (
+	LogNode      = "node"
+	LogTransport = "transport"
+	LogSession   = "session"
+	LogForwarder = "forwarder"
+	LogRouting   = "routing"
+	LogService   = "service"
+	LogDiscovery = "discovery"
+	LogFcnet     = "fcnet"
+	LogAPI       = "api"
+)
+
+var logSubsystems = []string{
+	LogNode, LogTransport, LogSession, LogForwarder, LogRouting,
+	LogService, LogDiscovery, LogFcnet, LogAPI,
+}
+
+// Logger returns the logger of one of the node's subsystems.
+// Their output goes to the *log.Logger returned by Log.
+func (node *Node) Logger(subsys string) *logging.Logger {
+	return node.loggers.Get(subsys)
+}
+
+type LoggingAPI Node
+
+func (c *LoggingAPI) Levels() ([]rapi.LogLevel, error) {
+	var levels []rapi.LogLevel
+	for _, l := range (*Node)(c).loggers.All() {
+		levels = append(levels, rapi.LogLevel{Subsystem: l.Subsystem(), Level: l.Level().String()})
+	}
+	return levels, nil
+}
+
+func (c *LoggingAPI) SetLevel(ll rapi.LogLevel) (rapi.LogLevel, error) {
+	lvl, err := logging.ParseLevel(ll.Level)
+	if err != nil {
+		return ll, err
+	}
+	if err := (*Node)(c).loggers.SetLevel(ll.Subsystem, lvl); err != nil {
+		return ll, err
+	}
+	return rapi.LogLevel{Subsystem: ll.Subsystem, Level: lvl.String()}, nil
+}
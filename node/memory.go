@@ -0,0 +1,240 @@
+package node
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	rovy "go.rovy.net"
+	logging "go.rovy.net/node/util/logging"
+	ringbuf "go.rovy.net/node/util/ringbuf"
+)
+
+var ErrDuplicateEndpoint = errors.New("memory endpoint name already in use")
+
+// MemoryOptions describe the links of a MemoryNetwork.
+// The zero value is a perfect link with TptMTU.
+type MemoryOptions struct {
+	Latency time.Duration // added to every packet
+	Jitter  time.Duration // random extra delay of up to Jitter, which reorders packets
+	Loss    float64       // probability of a packet getting dropped, from 0 to 1
+	MTU     int           // larger packets are rejected, zero means TptMTU
+	Seed    int64         // seed for loss and jitter
+
+	// DropTooLarge silently drops packets larger than MTU instead of rejecting them,
+	// like a link further along the path that doesn't report its MTU.
+	DropTooLarge bool
+}
+
+// MemoryNetwork links in-memory transports within the same process,
+// which are addressed as /memory/<name>. Endpoint names are unique per network,
+// so tests with their own network can run in parallel without clashing.
+type MemoryNetwork struct {
+	sync.RWMutex
+	opts      MemoryOptions
+	rand      *rand.Rand
+	randLock  sync.Mutex
+	endpoints map[string]*MemoryTransport
+}
+
+// DefaultMemoryNetwork is used for /memory listeners created from a multiaddr,
+// e.g. through PeerAPI.Listen.
+var DefaultMemoryNetwork = NewMemoryNetwork(MemoryOptions{})
+
+func NewMemoryNetwork(opts MemoryOptions) *MemoryNetwork {
+	return &MemoryNetwork{
+		opts:      opts,
+		rand:      rand.New(rand.NewSource(opts.Seed)),
+		endpoints: map[string]*MemoryTransport{},
+	}
+}
+
+// SetOptions changes the link properties for all subsequently sent packets.
+func (mn *MemoryNetwork) SetOptions(opts MemoryOptions) {
+	mn.Lock()
+	defer mn.Unlock()
+
+	mn.opts = opts
+}
+
+func (mn *MemoryNetwork) options() MemoryOptions {
+	mn.RLock()
+	defer mn.RUnlock()
+
+	return mn.opts
+}
+
+func (mn *MemoryNetwork) random() float64 {
+	mn.randLock.Lock()
+	defer mn.randLock.Unlock()
+
+	return mn.rand.Float64()
+}
+
+// NewTransport creates a transport on this network. Its name is claimed once it's started.
+func (mn *MemoryNetwork) NewTransport(name string, logger *logging.Logger) *MemoryTransport {
+	return &MemoryTransport{
+		network: mn,
+		addr:    rovy.Multiaddr{Memory: name},
+		logger:  logger,
+	}
+}
+
+func (mn *MemoryNetwork) attach(tpt *MemoryTransport) error {
+	mn.Lock()
+	defer mn.Unlock()
+
+	if _, present := mn.endpoints[tpt.addr.Memory]; present {
+		return ErrDuplicateEndpoint
+	}
+	mn.endpoints[tpt.addr.Memory] = tpt
+	return nil
+}
+
+func (mn *MemoryNetwork) detach(tpt *MemoryTransport) {
+	mn.Lock()
+	defer mn.Unlock()
+
+	if mn.endpoints[tpt.addr.Memory] == tpt {
+		delete(mn.endpoints, tpt.addr.Memory)
+	}
+}
+
+func (mn *MemoryNetwork) endpoint(name string) (*MemoryTransport, bool) {
+	mn.RLock()
+	defer mn.RUnlock()
+
+	tpt, present := mn.endpoints[name]
+	return tpt, present
+}
+
+// send copies the packet, and delivers it to the destination after the link's delay.
+// Packets to unknown endpoints are silently dropped, like with UDP.
+func (mn *MemoryNetwork) send(src rovy.Multiaddr, pkt rovy.Packet) error {
+	opts := mn.options()
+
+	mtu := opts.MTU
+	if mtu == 0 {
+		mtu = rovy.TptMTU
+	}
+	if pkt.Length > mtu {
+		if opts.DropTooLarge {
+			return nil
+		}
+		return PacketTooLargeError{MTU: mtu}
+	}
+	if opts.Loss > 0 && mn.random() < opts.Loss {
+		return nil
+	}
+
+	dst, present := mn.endpoint(pkt.TptDst.Memory)
+	if !present {
+		return nil
+	}
+
+	pkt2 := rovy.AllocPacket()
+	pkt2.Length = copy(pkt2.Buf, pkt.Bytes())
+	pkt2.TptSrc = src
+	pkt2.TptLocal = dst.addr
+
+	delay := opts.Latency
+	if opts.Jitter > 0 {
+		delay += time.Duration(mn.random() * float64(opts.Jitter))
+	}
+	if delay == 0 {
+		dst.deliver(pkt2)
+	} else {
+		time.AfterFunc(delay, func() { dst.deliver(pkt2) })
+	}
+	return nil
+}
+
+// MemoryTransport is an endpoint on a MemoryNetwork.
+type MemoryTransport struct {
+	sync.Mutex
+	network *MemoryNetwork
+	addr    rovy.Multiaddr
+	next    *ringbuf.RingBuffer
+	running chan int
+	logger  *logging.Logger
+}
+
+func (tpt *MemoryTransport) Start(next *ringbuf.RingBuffer) error {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if tpt.Running() {
+		return ErrRunning
+	}
+	if err := tpt.network.attach(tpt); err != nil {
+		return err
+	}
+
+	tpt.next = next
+	tpt.running = make(chan int)
+	return nil
+}
+
+func (tpt *MemoryTransport) Stop() error {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if !tpt.Running() {
+		return ErrNotRunning
+	}
+
+	tpt.network.detach(tpt)
+	close(tpt.running)
+	return nil
+}
+
+func (tpt *MemoryTransport) Running() bool {
+	if tpt.running != nil {
+		select {
+		case <-tpt.running:
+			// we're not running anymore, channel is closed.
+			// the channel is unbuffered, so if we never write anything to it,
+			// then the only way to reach here is if the channel is closed.
+			return false
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (tpt *MemoryTransport) ListenMultiaddr() rovy.Multiaddr {
+	return tpt.addr
+}
+
+func (tpt *MemoryTransport) LocalMultiaddr() rovy.Multiaddr {
+	return tpt.addr
+}
+
+func (tpt *MemoryTransport) EffectiveMultiaddrs() ([]rovy.Multiaddr, error) {
+	if !tpt.Running() {
+		return nil, nil
+	}
+	return []rovy.Multiaddr{tpt.addr}, nil
+}
+
+func (tpt *MemoryTransport) Send(pkt rovy.Packet) error {
+	defer pkt.Release()
+
+	if !tpt.Running() {
+		return ErrNotRunning
+	}
+	return tpt.network.send(tpt.addr, pkt)
+}
+
+func (tpt *MemoryTransport) deliver(pkt rovy.Packet) {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if tpt.Running() {
+		tpt.next.Put(pkt)
+	} else {
+		pkt.Release()
+	}
+}
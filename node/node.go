@@ -1,46 +1,85 @@
 package node
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log"
 	"net/netip"
+	"runtime"
 	"sync"
+	"time"
 
 	rovy "go.rovy.net"
 	rapi "go.rovy.net/api"
 	forwarder "go.rovy.net/node/forwarder"
+	petname "go.rovy.net/node/petname"
+	policy "go.rovy.net/node/policy"
 	routing "go.rovy.net/node/routing"
 	service "go.rovy.net/node/service"
 	session "go.rovy.net/node/session"
+	logging "go.rovy.net/node/util/logging"
 	ringbuf "go.rovy.net/node/util/ringbuf"
 )
 
 const DirectUpperCodec = 0x12347
 
+// DefaultQueueSize is the capacity of each of the node's queues, see SetQueueSize.
 const DefaultQueueSize = 1024
 
+const ConnectTimeout = 10 * time.Second
+
+// ProbeTimeout is how long ProbePathMTU waits for each probe's reply.
+const ProbeTimeout = time.Second
+
+// ProbeAttempts is how many probes ProbePathMTU sends at most.
+// The first one might only make a hop learn its link MTU, without any signal to us,
+// the second one gets us a packet-too-big error, and the third one is confirmed.
+const ProbeAttempts = 4
+
+// MinPathMTU is the smallest path MTU that ProbePathMTU steps down to,
+// in bytes of lower packet. It carries 1280 bytes of upper payload, IPv6's minimum MTU.
+const MinPathMTU = 1280 + rovy.TptMTU - rovy.UpperMTU
+
 var ErrRunning = errors.New("routines are already running")
 var ErrNotRunning = errors.New("routines are not running")
+var ErrConnectTimeout = errors.New("timed out waiting for handshake")
+var ErrNotConnected = errors.New("not connected")
+var ErrDisconnected = errors.New("disconnected")
+var ErrNoTransport = errors.New("no transport available")
 
+// UpperHandler is called with upper packets of the codec it was registered for.
+// The packet is released once the handler returns, so it must not be kept around.
 type UpperHandler func(rovy.UpperPacket) error
+
+// LowerHandler is called with lower packets of the codec it was registered for.
+// It takes ownership of the packet, i.e. it either passes it on or releases it.
 type LowerHandler func(rovy.LowerPacket) error
 
 // TODO: move lower connection stuff to a Peering type (Connect, SendLower, Handle*)
 type Node struct {
 	peerid        rovy.PeerID
+	privkey       rovy.PrivateKey
 	logger        *log.Logger
-	transports    []*Transport
+	loggers       *logging.Loggers
+	log           *logging.Logger
+	transports    *TransportRegistry
 	waiters       map[rovy.PeerID][]chan error
 	waitersLock   sync.Mutex
 	sessions      *session.SessionManager
+	handlersLock  sync.RWMutex
 	upperHandlers map[uint64]UpperHandler
 	lowerHandlers map[uint64]LowerHandler
 	forwarder     *forwarder.Forwarder
 	routing       *routing.Routing
 	services      *service.ServiceManager
+	policies      *policy.Policies
+	petnames      *petname.Store
+	udpBackend    string
+	udpBatchSize  int
 
 	running    chan int
+	routines   sync.WaitGroup
 	helloSendQ *ringbuf.RingBuffer
 	lowerSendQ *ringbuf.RingBuffer
 	upperSendQ *ringbuf.RingBuffer
@@ -49,19 +88,35 @@ type Node struct {
 	lowerMuxQ  *ringbuf.RingBuffer
 	upperRecvQ *ringbuf.RingBuffer
 	upperMuxQ  *ringbuf.RingBuffer
+
+	cryptoQ         chan *cryptoJob
+	cryptoHighWater ringbuf.HighWater
+	cryptoWorkers   int
+	lowerEncrypt    *pipeline
+	upperEncrypt    *pipeline
+	lowerDecrypt    *pipeline
+	upperDecrypt    *pipeline
 }
 
 func NewNode(privkey rovy.PrivateKey, logger *log.Logger) *Node {
 	pubkey := privkey.PublicKey()
 	peerid := rovy.NewPeerID(pubkey)
 
+	loggers := logging.NewLoggers(logger, logSubsystems...)
+
 	node := &Node{
 		peerid:        peerid,
+		privkey:       privkey,
 		logger:        logger,
+		loggers:       loggers,
+		log:           loggers.Get(LogNode),
+		transports:    NewTransportRegistry(),
 		waiters:       map[rovy.PeerID][]chan error{},
 		upperHandlers: map[uint64]UpperHandler{},
 		lowerHandlers: map[uint64]LowerHandler{},
-		routing:       routing.NewRouting(logger),
+		routing:       routing.NewRouting(loggers.Get(LogRouting)),
+		policies:      policy.NewPolicies(),
+		petnames:      petname.NewStore(),
 		helloSendQ:    ringbuf.NewRingBuffer(DefaultQueueSize),
 		lowerSendQ:    ringbuf.NewRingBuffer(DefaultQueueSize),
 		upperSendQ:    ringbuf.NewRingBuffer(DefaultQueueSize),
@@ -70,20 +125,35 @@ func NewNode(privkey rovy.PrivateKey, logger *log.Logger) *Node {
 		lowerMuxQ:     ringbuf.NewRingBuffer(DefaultQueueSize),
 		upperRecvQ:    ringbuf.NewRingBuffer(DefaultQueueSize),
 		upperMuxQ:     ringbuf.NewRingBuffer(DefaultQueueSize),
+		cryptoQ:       make(chan *cryptoJob, DefaultQueueSize),
 	}
+	node.lowerEncrypt = newPipeline(node, "lowerEncrypt", node.doLowerEncrypt, node.sendTransport)
+	node.upperEncrypt = newPipeline(node, "upperEncrypt", node.doUpperEncrypt, node.doUpperSendFinish)
+	node.lowerDecrypt = newPipeline(node, "lowerDecrypt", node.doLowerDecrypt, node.doLowerRecvFinish)
+	node.upperDecrypt = newPipeline(node, "upperDecrypt", node.doUpperDecrypt, node.doUpperRecvFinish)
 
-	node.sessions = session.NewSessionManager(privkey, logger)
-	node.services = service.NewServiceManager(logger)
+	node.sessions = session.NewSessionManager(privkey, loggers.Get(LogSession))
+	node.services = service.NewServiceManager(loggers.Get(LogService))
 
-	node.forwarder = forwarder.NewForwarder(logger)
+	node.forwarder = forwarder.NewForwarder(loggers.Get(LogForwarder))
 	node.forwarder.Attach(peerid, func(lpkt rovy.LowerPacket) error {
 		node.upperRecvQ.Put(lpkt.Packet)
 		return nil
 	})
+	node.forwarder.OnTooBig(node.routing.SetPathMTU)
 
 	return node
 }
 
+// putLowerSend enqueues a packet for lowerSendRoutine.
+// It gives up if the node is stopped while the queue is full.
+func (node *Node) putLowerSend(pkt rovy.Packet) error {
+	if !node.lowerSendQ.PutWithBackpressureUntil(pkt, node.running) {
+		return ErrNotRunning
+	}
+	return nil
+}
+
 func (node *Node) Start() (rapi.NodeInfo, error) {
 	var ni rapi.NodeInfo
 
@@ -92,6 +162,7 @@ func (node *Node) Start() (rapi.NodeInfo, error) {
 	}
 
 	node.running = make(chan int)
+	node.routines.Add(8)
 	go node.helloSendRoutine()
 	go node.lowerSendRoutine()
 	go node.upperSendRoutine()
@@ -101,8 +172,19 @@ func (node *Node) Start() (rapi.NodeInfo, error) {
 	go node.upperRecvRoutine()
 	go node.upperMuxRoutine()
 
-	for _, tpt := range node.transports {
-		tpt.Start(node.lowerRecvQ)
+	// with a single CPU, the pipelines do the crypto themselves
+	node.cryptoWorkers = runtime.GOMAXPROCS(0)
+	if node.cryptoWorkers > 1 {
+		node.routines.Add(node.cryptoWorkers)
+		for i := 0; i < node.cryptoWorkers; i++ {
+			go node.cryptoRoutine()
+		}
+	}
+
+	for _, tpt := range node.transports.All() {
+		if err := tpt.Start(node.lowerRecvQ); err != nil {
+			node.log.Error("failed to start listener", "addr", tpt.ListenMultiaddr(), "err", err)
+		}
 	}
 
 	ni, _ = node.Info()
@@ -118,14 +200,28 @@ func (node *Node) Stop() (rapi.NodeInfo, error) {
 
 	close(node.running)
 
-	for _, tpt := range node.transports {
+	for _, tpt := range node.transports.All() {
+		if tpt.Running() {
+			tpt.Stop()
+		}
+	}
+	for _, tpt := range node.transports.RemoveDialers() {
 		tpt.Stop()
 	}
 
+	node.routines.Wait()
+	for _, pl := range node.pipelines() {
+		pl.reset()
+	}
+
 	ni, _ = node.Info()
 	return ni, nil
 }
 
+func (node *Node) pipelines() []*pipeline {
+	return []*pipeline{node.lowerEncrypt, node.upperEncrypt, node.lowerDecrypt, node.upperDecrypt}
+}
+
 func (node *Node) Running() bool {
 	if node.running != nil {
 		select {
@@ -149,15 +245,27 @@ func (node *Node) IPAddr() netip.Addr {
 	return node.peerid.PublicKey().IPAddr()
 }
 
+// Sign signs msg with the node's static key, see rovy.PrivateKey.Sign.
+func (node *Node) Sign(msg []byte) ([]byte, error) {
+	return node.privkey.Sign(msg)
+}
+
+// Log returns the output of the node's loggers, see Logger.
 func (node *Node) Log() *log.Logger {
 	return node.logger
 }
 
 func (node *Node) Addresses() (addrs []rovy.Multiaddr) {
-	for _, lis := range node.transports {
-		ma := lis.LocalMultiaddr()
-		ma.PeerID = node.PeerID()
-		addrs = append(addrs, ma)
+	for _, lis := range node.transports.All() {
+		eaddrs, err := lis.EffectiveMultiaddrs()
+		if err != nil {
+			node.log.Warn("addresses", "err", err)
+			continue
+		}
+		for _, ma := range eaddrs {
+			ma.PeerID = node.PeerID()
+			addrs = append(addrs, ma)
+		}
 	}
 	return addrs
 }
@@ -174,44 +282,71 @@ func (node *Node) Routing() *routing.Routing {
 	return node.routing
 }
 
+// Petnames are the node's local names for peers, e.g. desktop.rovy.
+func (node *Node) Petnames() *petname.Store {
+	return node.petnames
+}
+
+// Policies decide which upper codecs and fcnet ports each peer may reach.
+func (node *Node) Policies() *policy.Policies {
+	return node.policies
+}
+
 func (node *Node) Services() *service.ServiceManager {
 	return node.services
 }
 
-func (node *Node) WaitFor(pid rovy.PeerID) error {
-	node.waitersLock.Lock()
+// WaitFor blocks until the handshake with the given peer has completed,
+// or returns ErrConnectTimeout if that didn't happen within the timeout.
+func (node *Node) WaitFor(pid rovy.PeerID, timeout time.Duration) error {
+	return node.wait(pid, node.addWaiter(pid), timeout)
+}
 
-	_, present := node.waiters[pid]
-	if !present {
-		node.waiters[pid] = []chan error{}
-	}
+// addWaiter registers a channel which is notified when the handshake with the given peer completes.
+// Registering before sending the hello makes sure that a quick handshake isn't missed.
+func (node *Node) addWaiter(pid rovy.PeerID) chan error {
+	node.waitersLock.Lock()
+	defer node.waitersLock.Unlock()
 
 	ch := make(chan error, 1)
 	node.waiters[pid] = append(node.waiters[pid], ch)
-	node.waitersLock.Unlock()
-
-	return <-ch
+	return ch
 }
 
-func (node *Node) connectedCallback(peerid rovy.PeerID, lower bool) {
-	var err error
+// wait blocks until the channel from addWaiter is notified, or deregisters it after the timeout.
+func (node *Node) wait(pid rovy.PeerID, ch chan error, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-	if lower {
-		slot, err := node.forwarder.Attach(peerid, func(lpkt rovy.LowerPacket) error {
-			node.lowerSendQ.PutWithBackpressure(lpkt.Packet)
-			return nil
-		})
-		if err != nil {
-			err = fmt.Errorf("connected to %s, but forwarder error: %s", peerid, err)
-		} else {
-			node.routing.AddRoute(peerid, slot)
+	select {
+	case err := <-ch:
+		return err
+	case <-timer.C:
+		node.waitersLock.Lock()
+		defer node.waitersLock.Unlock()
+
+		// notified right as the timer fired
+		select {
+		case err := <-ch:
+			return err
+		default:
 		}
-	}
 
-	if err == nil {
-		node.Log().Printf("connected to %s", peerid)
+		w := node.waiters[pid]
+		for i, ch2 := range w {
+			if ch2 == ch {
+				node.waiters[pid] = append(w[:i], w[i+1:]...)
+				break
+			}
+		}
+		if len(node.waiters[pid]) == 0 {
+			delete(node.waiters, pid)
+		}
+		return ErrConnectTimeout
 	}
+}
 
+func (node *Node) notifyWaiters(peerid rovy.PeerID, err error) {
 	node.waitersLock.Lock()
 	defer node.waitersLock.Unlock()
 
@@ -224,7 +359,34 @@ func (node *Node) connectedCallback(peerid rovy.PeerID, lower bool) {
 	}
 }
 
+func (node *Node) connectedCallback(peerid rovy.PeerID, lower bool) {
+	var err error
+
+	if lower {
+		slot, present := node.forwarder.Lookup(peerid)
+		if !present {
+			slot, err = node.forwarder.Attach(peerid, func(lpkt rovy.LowerPacket) error {
+				return node.putLowerSend(lpkt.Packet)
+			})
+		}
+		if err != nil {
+			err = fmt.Errorf("connected to %s, but forwarder error: %s", peerid, err)
+		} else {
+			node.routing.AddRoute(peerid, slot)
+		}
+	}
+
+	if err == nil {
+		node.log.Info("connected", "peer", peerid)
+	}
+
+	node.notifyWaiters(peerid, err)
+}
+
 func (node *Node) Handle(codec uint64, cb UpperHandler) {
+	node.handlersLock.Lock()
+	defer node.handlersLock.Unlock()
+
 	_, present := node.upperHandlers[codec]
 	if present {
 		return
@@ -234,6 +396,9 @@ func (node *Node) Handle(codec uint64, cb UpperHandler) {
 }
 
 func (node *Node) HandleLower(codec uint64, cb LowerHandler) {
+	node.handlersLock.Lock()
+	defer node.handlersLock.Unlock()
+
 	_, present := node.lowerHandlers[codec]
 	if present {
 		return
@@ -242,10 +407,33 @@ func (node *Node) HandleLower(codec uint64, cb LowerHandler) {
 	node.lowerHandlers[codec] = cb
 }
 
-// TODO: timeouts
-// TODO: check if we already have a session
+// Unhandle removes the handler of an upper codec, so that it can be handled again.
+func (node *Node) Unhandle(codec uint64) {
+	node.handlersLock.Lock()
+	defer node.handlersLock.Unlock()
+	delete(node.upperHandlers, codec)
+}
+
+// UnhandleLower removes the handler of a lower codec, so that it can be handled again.
+func (node *Node) UnhandleLower(codec uint64) {
+	node.handlersLock.Lock()
+	defer node.handlersLock.Unlock()
+	delete(node.lowerHandlers, codec)
+}
+
+// Connect performs a handshake with the given peer and waits for it to complete.
+// If raddr is empty, the handshake is sent as an upper packet using the routing table.
+// An established session is reused only if it's with the same address,
+// otherwise there's a new handshake with raddr.
 func (node *Node) Connect(peerid rovy.PeerID, raddr rovy.Multiaddr) error {
-	pkt := rovy.NewPacket(make([]byte, rovy.TptMTU))
+	if s, _, present := node.SessionManager().Find(peerid); present {
+		if s.Stage() == session.EstablishedStage && sameAddr(s.RemoteAddr(), raddr) {
+			return nil
+		}
+	}
+
+	ch := node.addWaiter(peerid)
+	pkt := rovy.AllocPacket()
 
 	if !raddr.Empty() {
 		pkt.LowerDst = peerid
@@ -256,17 +444,170 @@ func (node *Node) Connect(peerid rovy.PeerID, raddr rovy.Multiaddr) error {
 		node.helloSendQ.Put(pkt)
 	}
 
-	if err := node.WaitFor(peerid); err != nil {
-		node.Log().Printf("connect %s: %s", peerid, err)
+	if err := node.wait(peerid, ch, ConnectTimeout); err != nil {
+		node.log.Warn("connect", "peer", peerid, "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// sameAddr compares transport addresses, ignoring the /rovy part.
+func sameAddr(a, b rovy.Multiaddr) bool {
+	a.PeerID, b.PeerID = rovy.PeerID{}, rovy.PeerID{}
+	if a.Empty() || b.Empty() {
+		return a.Empty() == b.Empty()
+	}
+	return bytes.Equal(a.Bytes(), b.Bytes())
+}
+
+// Disconnect forgets all sessions with the given peer and frees its forwarder slot.
+// The remote peer isn't notified, it'll have to find out by itself.
+func (node *Node) Disconnect(peerid rovy.PeerID) error {
+	n := node.SessionManager().RemovePeer(peerid)
+
+	slot, present := node.Forwarder().Lookup(peerid)
+	if present {
+		if err := node.Forwarder().Detach(peerid); err != nil {
+			return fmt.Errorf("forwarder: %s", err)
+		}
+	}
+
+	if n == 0 && !present {
+		return ErrNotConnected
+	}
+
+	for _, pl := range node.pipelines() {
+		pl.remove(peerid)
+	}
+	node.Routing().RemovePeer(peerid, slot)
+	node.notifyWaiters(peerid, ErrDisconnected)
+
+	node.log.Info("disconnected", "peer", peerid)
+	return nil
+}
+
+// DisconnectAddr forgets the sessions with the given peer over raddr, and keeps the others.
+// If none are left, the peer is disconnected entirely, like with Disconnect.
+func (node *Node) DisconnectAddr(peerid rovy.PeerID, raddr rovy.Multiaddr) error {
+	removed, left := node.SessionManager().RemovePeerAddr(peerid, raddr)
+	if removed == 0 {
+		return ErrNotConnected
+	}
+	if left == 0 {
+		return node.Disconnect(peerid)
+	}
+
+	node.log.Info("disconnected", "peer", peerid, "addr", raddr)
+	return nil
+}
+
+func (node *Node) Transports() *TransportRegistry {
+	return node.transports
+}
+
+// AddTransport registers a transport, and starts it right away if the node is running.
+// PeerAPI.Listen creates transports from multiaddrs, this is for ones created
+// by other means, e.g. NewWebSocketHandler.
+func (node *Node) AddTransport(tpt Transport) error {
+	if _, present := node.transports.Get(tpt.ListenMultiaddr()); present {
+		return ErrDuplicateListener
+	}
+
+	if node.Running() {
+		if err := tpt.Start(node.lowerRecvQ); err != nil {
+			return err
+		}
+	}
+	if err := node.transports.Add(tpt); err != nil {
+		if tpt.Running() {
+			tpt.Stop()
+		}
 		return err
 	}
+	return nil
+}
+
+// SetUDPBackend picks the implementation of subsequently added UDP listeners,
+// UDPBackendStd or UDPBackendIOUring. The empty string means UDPBackendStd.
+func (node *Node) SetUDPBackend(backend string) error {
+	switch backend {
+	case "", UDPBackendStd, UDPBackendIOUring:
+		node.udpBackend = backend
+		return nil
+	default:
+		return fmt.Errorf("unknown UDP backend: %s", backend)
+	}
+}
 
+// SetUDPBatchSize sets the number of messages per syscall of subsequently added
+// std UDP listeners, see UDPTransport.SetBatchSize. Zero means UDPBatchSize.
+func (node *Node) SetUDPBatchSize(n int) error {
+	if n < 0 || n > UDPBatchSize {
+		return fmt.Errorf("UDP batch size out of range: %d", n)
+	}
+	node.udpBatchSize = n
 	return nil
 }
 
-// TODO: pick correct transport
+// newTransport is NewTransport, except UDP listeners use the configured backend.
+// If io_uring isn't supported, it falls back to UDPTransport.
+func (node *Node) newTransport(lisaddr rovy.Multiaddr) (Transport, error) {
+	if node.udpBackend == UDPBackendIOUring && lisaddr.IP.IsValid() && lisaddr.Tpt == 0 {
+		err := IOUringSupported()
+		if err == nil {
+			return newIOUringTransport(lisaddr, node.Logger(LogTransport))
+		}
+		node.log.Warn("falling back to the std UDP backend", "addr", lisaddr, "err", err)
+	}
+	tpt, err := NewTransport(lisaddr, node.Logger(LogTransport))
+	if udptpt, ok := tpt.(*UDPTransport); ok && err == nil && node.udpBatchSize > 0 {
+		err = udptpt.SetBatchSize(node.udpBatchSize)
+	}
+	return tpt, err
+}
+
+// selectTransport picks the transport for sending to raddr, see TransportRegistry.Select.
+// If there's none, it sets up a dialer if raddr's kind of transport allows for it.
+func (node *Node) selectTransport(raddr, laddr rovy.Multiaddr) (Transport, error) {
+	tpt, err := node.transports.Select(raddr, laddr)
+	if err == ErrNoTransport {
+		tpt, err = node.transports.Dialer(raddr, func() (Transport, error) {
+			tpt, err := NewDialer(raddr, node.Logger(LogTransport))
+			if err != nil {
+				return nil, err
+			}
+			return tpt, tpt.Start(node.lowerRecvQ)
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", raddr, err)
+	}
+	return tpt, nil
+}
+
+// sendTransport sends the packet to pkt.TptDst, from the transport bound
+// to pkt.TptLocal if there is one, or any transport of the right kind.
+// If the packet is too large for the link, the forwarder learns the link MTU,
+// and signals subsequent oversized packets back to their sender.
 func (node *Node) sendTransport(pkt rovy.Packet) error {
-	return node.transports[0].Send(pkt)
+	tpt, err := node.selectTransport(pkt.TptDst, pkt.TptLocal)
+	if err != nil {
+		return err
+	}
+	if err = tpt.Send(pkt); err != nil {
+		node.learnMTU(pkt.LowerDst, err)
+	}
+	return err
+}
+
+// learnMTU tells the forwarder about the peer's link MTU, if err is a PacketTooLargeError.
+// It's separate from sendTransport, so that the latter doesn't allocate.
+func (node *Node) learnMTU(peerid rovy.PeerID, err error) {
+	var tooLarge PacketTooLargeError
+	if errors.As(err, &tooLarge) && !peerid.Empty() {
+		node.forwarder.SetMTU(peerid, tooLarge.MTU)
+	}
 }
 
 func (node *Node) Send(to rovy.PeerID, codec uint64, p []byte) error {
@@ -275,7 +616,7 @@ func (node *Node) Send(to rovy.PeerID, codec uint64, p []byte) error {
 		return err
 	}
 
-	pkt := rovy.NewPacket(make([]byte, rovy.TptMTU))
+	pkt := rovy.AllocPacket()
 	upkt := rovy.NewUpperPacket(pkt)
 	upkt.UpperDst = to
 	upkt.SetCodec(codec)
@@ -286,6 +627,45 @@ func (node *Node) Send(to rovy.PeerID, codec uint64, p []byte) error {
 }
 
 func (node *Node) SendUpper(upkt rovy.UpperPacket) error {
-	node.upperSendQ.PutWithBackpressure(upkt.Packet)
+	if !node.Running() || !node.upperSendQ.PutWithBackpressureUntil(upkt.Packet, node.running) {
+		return ErrNotRunning
+	}
 	return nil
 }
+
+// ProbePathMTU finds out how large the lower packets along the route can be.
+// It sends probes of the largest size known to work, and adjusts to the packet-too-big
+// errors of the hops on the way, until a probe makes it to the destination.
+// After a probe times out, it tries a smaller size, halfway down to MinPathMTU,
+// in case a hop drops the probes without telling us.
+// The result is kept in the routing table, see Routing.PathMTU.
+//
+// If no probe makes it, e.g. because the destination doesn't answer probes,
+// it assumes MinPathMTU for the route, and returns that with ErrProbeTimeout.
+func (node *Node) ProbePathMTU(route rovy.Route) (int, error) {
+	mtu, _ := node.routing.PathMTU(route)
+	for i := 0; i < ProbeAttempts; i++ {
+		err := node.forwarder.Probe(route, mtu, ProbeTimeout)
+		switch err {
+		case nil:
+			node.routing.SetPathMTU(route, mtu)
+			return mtu, nil
+		case forwarder.ErrPacketTooBig:
+			// the hop's MTU is in the routing table now
+			mtu, _ = node.routing.PathMTU(route)
+		case forwarder.ErrProbeTimeout:
+			if mtu > MinPathMTU {
+				mtu = (mtu + MinPathMTU + 1) / 2
+			}
+		default:
+			return 0, err
+		}
+	}
+
+	mtu, _ = node.routing.PathMTU(route)
+	if mtu > MinPathMTU {
+		mtu = MinPathMTU
+	}
+	node.routing.SetPathMTU(route, mtu)
+	return mtu, forwarder.ErrProbeTimeout
+}
@@ -1,37 +1,167 @@
 package node
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+
 	rovy "go.rovy.net"
 	rovyapi "go.rovy.net/api"
+	session "go.rovy.net/node/session"
 )
 
+var ErrUnknownListener = errors.New("no listener for this address")
+var ErrDuplicateListener = errors.New("already listening on this address")
+
 type PeerAPI Node
 
 func (c *PeerAPI) Status() (rovyapi.PeerStatus, error) {
+	node := (*Node)(c)
+
 	var listeners []rovyapi.PeerListener
-	for _, tpt := range (*Node)(c).transports {
-		listeners = append(listeners, rovyapi.PeerListener{ListenAddr: tpt.LocalMultiaddr()})
+	for _, tpt := range node.Transports().All() {
+		listeners = append(listeners, c.peerListener(tpt))
+	}
+
+	// one entry per lower session, i.e. sessions which have a transport address
+	var peers []rovyapi.PeerInfo
+	for _, s := range node.SessionManager().Sessions() {
+		if s.RemoteAddr().Empty() {
+			continue
+		}
+		peers = append(peers, c.peerInfo(s))
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].PeerID.String() < peers[j].PeerID.String()
+	})
+
+	return rovyapi.PeerStatus{Peers: peers, Listeners: listeners}, nil
+}
+
+func (c *PeerAPI) peerInfo(s *session.Session) rovyapi.PeerInfo {
+	st := s.Stats()
+	pi := rovyapi.PeerInfo{
+		PeerID:      s.RemotePeerID(),
+		Addr:        s.RemoteAddr(),
+		Status:      "ok",
+		Established: st.Established,
+		LastRecv:    st.LastRecv,
+		RxBytes:     st.RxBytes,
+		RxPackets:   st.RxPackets,
+		TxBytes:     st.TxBytes,
+		TxPackets:   st.TxPackets,
+	}
+	if s.Stage() != session.EstablishedStage {
+		pi.Status = session.StageString(s.Stage())
+	}
+	if slot, present := (*Node)(c).Forwarder().Lookup(pi.PeerID); present {
+		pi.Slot = slot.String()
 	}
-	return rovyapi.PeerStatus{Listeners: listeners}, nil
+	return pi
 }
 
+func (c *PeerAPI) peerListener(tpt Transport) rovyapi.PeerListener {
+	pl := rovyapi.PeerListener{ListenAddr: tpt.ListenMultiaddr()}
+
+	eaddrs, err := tpt.EffectiveMultiaddrs()
+	if err != nil {
+		c.log.Warn("listener", "addr", pl.ListenAddr, "err", err)
+	}
+	pl.EffectiveAddrs = eaddrs
+	return pl
+}
+
+// Listen adds a listener, and starts it right away if the node is running.
 func (c *PeerAPI) Listen(ma rovy.Multiaddr) (rovyapi.PeerListener, error) {
-	tpt, err := NewTransport(ma, c.logger)
+	ma.PeerID = rovy.PeerID{}
+	tpt, err := (*Node)(c).newTransport(ma)
 	if err != nil {
 		return rovyapi.PeerListener{}, err
 	}
 
+	if err := (*Node)(c).AddTransport(tpt); err != nil {
+		return rovyapi.PeerListener{}, err
+	}
+
+	return c.peerListener(tpt), nil
+}
+
+// Close stops a listener, closing its socket, and removes it from the node.
+func (c *PeerAPI) Close(ma rovy.Multiaddr) (rovyapi.PeerListener, error) {
 	node := (*Node)(c)
-	node.transports = append(node.transports, tpt)
-	if ((*Node)(c)).Running() {
-		tpt.Start(node.lowerRecvQ)
+
+	ma.PeerID = rovy.PeerID{}
+	tpt, err := node.Transports().Remove(ma)
+	if err != nil {
+		return rovyapi.PeerListener{}, err
 	}
 
-	return rovyapi.PeerListener{ListenAddr: ma}, nil
+	pl := c.peerListener(tpt)
+	pl.EffectiveAddrs = nil
+	if tpt.Running() {
+		if err := tpt.Stop(); err != nil {
+			return pl, err
+		}
+	}
+	return pl, nil
 }
 
+// Connect returns an error only if the connection couldn't be attempted.
+// The outcome of the attempt itself is reported in PeerInfo.Status and PeerInfo.Reason.
 func (c *PeerAPI) Connect(ma rovy.Multiaddr) (rovyapi.PeerInfo, error) {
-	return rovyapi.PeerInfo{}, nil
+	node := (*Node)(c)
+	peerid, raddr, err := splitPeerMultiaddr(ma)
+	if err != nil {
+		return rovyapi.PeerInfo{}, err
+	}
+	if !node.Running() {
+		return rovyapi.PeerInfo{}, ErrNotRunning
+	}
+
+	pi := rovyapi.PeerInfo{PeerID: peerid, Addr: raddr}
+
+	err = node.Connect(peerid, raddr)
+	switch {
+	case err == nil:
+		pi.Status = "ok"
+	case errors.Is(err, ErrConnectTimeout):
+		pi.Status = "timeout"
+		pi.Reason = fmt.Sprintf("no handshake reply within %s", ConnectTimeout)
+		if s, _, present := node.SessionManager().Find(peerid); present {
+			pi.Reason = fmt.Sprintf("stuck in %s for %s", session.StageString(s.Stage()), ConnectTimeout)
+		}
+	default:
+		pi.Status = "connection-error"
+		pi.Reason = err.Error()
+	}
+
+	return pi, nil
+}
+
+// Disconnect forgets the sessions with the peer over the multiaddr's transport address,
+// or all sessions with the peer if the multiaddr is only the /rovy part.
+func (c *PeerAPI) Disconnect(ma rovy.Multiaddr) (rovyapi.PeerInfo, error) {
+	node := (*Node)(c)
+	peerid, raddr, err := splitPeerMultiaddr(ma)
+	if err != nil {
+		return rovyapi.PeerInfo{}, err
+	}
+
+	pi := rovyapi.PeerInfo{PeerID: peerid, Addr: raddr}
+	if raddr.Empty() {
+		if s, _, present := node.SessionManager().Find(peerid); present {
+			pi.Addr = s.RemoteAddr()
+		}
+		err = node.Disconnect(peerid)
+	} else {
+		err = node.DisconnectAddr(peerid, raddr)
+	}
+	if err != nil {
+		return pi, err
+	}
+
+	pi.Status = "disconnected"
+	return pi, nil
 }
 
 func (c *PeerAPI) NodeAPI() rovyapi.NodeAPI {
@@ -39,3 +169,14 @@ func (c *PeerAPI) NodeAPI() rovyapi.NodeAPI {
 }
 
 var _ rovyapi.PeerAPI = &PeerAPI{}
+
+// splitPeerMultiaddr splits /ip6/::1/udp/1312/rovy/bafzqai... into its
+// PeerID and transport address. The transport address may be empty.
+func splitPeerMultiaddr(ma rovy.Multiaddr) (rovy.PeerID, rovy.Multiaddr, error) {
+	peerid := ma.PeerID
+	if peerid.Empty() {
+		return peerid, ma, fmt.Errorf("multiaddr is missing /rovy part: %s", ma)
+	}
+	ma.PeerID = rovy.PeerID{}
+	return peerid, ma, nil
+}
@@ -0,0 +1,26 @@
+package node
+
+import (
+	rapi "go.rovy.net/api"
+)
+
+type PetnameAPI Node
+
+func (c *PetnameAPI) List() ([]rapi.Petname, error) {
+	out := []rapi.Petname{}
+	for _, pn := range (*Node)(c).petnames.List() {
+		out = append(out, rapi.Petname{Name: pn.Name, PeerID: pn.PeerID})
+	}
+	return out, nil
+}
+
+func (c *PetnameAPI) Add(pn rapi.Petname) (rapi.Petname, error) {
+	if err := (*Node)(c).petnames.Add(pn.Name, pn.PeerID); err != nil {
+		return pn, err
+	}
+	return pn, nil
+}
+
+func (c *PetnameAPI) Remove(name string) error {
+	return (*Node)(c).petnames.Remove(name)
+}
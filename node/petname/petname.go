@@ -0,0 +1,164 @@
+// Package petname keeps the node's local names for peers,
+// which the fcnet DNS server resolves as e.g. desktop.rovy.
+package petname
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	toml "github.com/pelletier/go-toml/v2"
+
+	rovy "go.rovy.net"
+)
+
+var ErrUnknown = errors.New("unknown petname")
+
+// Petname is a name for a peer, without the .rovy suffix.
+type Petname struct {
+	Name   string
+	PeerID rovy.PeerID
+}
+
+// ValidName checks that name is a DNS label which can't be mistaken for a PeerID.
+func ValidName(name string) error {
+	if len(name) == 0 || len(name) > 63 {
+		return fmt.Errorf("petname %q: must be 1 to 63 characters long", name)
+	}
+	for i, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return fmt.Errorf("petname %q: only a-z, 0-9 and - are allowed", name)
+		}
+		if c == '-' && (i == 0 || i == len(name)-1) {
+			return fmt.Errorf("petname %q: can't start or end with -", name)
+		}
+	}
+	if name == "localhost" || strings.HasPrefix(name, "bafzqai") {
+		return fmt.Errorf("petname %q: reserved", name)
+	}
+	return nil
+}
+
+// Store maps names to PeerIDs. If it has a file, every change is saved to it.
+type Store struct {
+	sync.RWMutex
+	names map[string]rovy.PeerID
+	path  string
+}
+
+func NewStore() *Store {
+	return &Store{names: map[string]rovy.PeerID{}}
+}
+
+// file is the format of the petnames file, e.g.:
+//
+//	[Names]
+//	desktop = 'bafzqai...'
+type file struct {
+	Names map[string]rovy.PeerID
+}
+
+// SetFile loads the petnames from path, if it exists,
+// and saves them there from now on.
+func (s *Store) SetFile(path string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var f file
+		if err := toml.NewDecoder(bytes.NewReader(b)).Decode(&f); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		for name, pid := range f.Names {
+			if err := ValidName(name); err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			s.names[name] = pid
+		}
+	}
+
+	s.path = path
+	return nil
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := toml.Marshal(file{Names: s.names})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, b, 0600)
+}
+
+// Add sets a name, replacing the PeerID if the name exists.
+func (s *Store) Add(name string, pid rovy.PeerID) error {
+	if err := ValidName(name); err != nil {
+		return err
+	}
+	if pid.Empty() {
+		return fmt.Errorf("petname %q: empty PeerID", name)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	prev, present := s.names[name]
+	s.names[name] = pid
+	if err := s.save(); err != nil {
+		if present {
+			s.names[name] = prev
+		} else {
+			delete(s.names, name)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Store) Remove(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	pid, present := s.names[name]
+	if !present {
+		return ErrUnknown
+	}
+	delete(s.names, name)
+	if err := s.save(); err != nil {
+		s.names[name] = pid
+		return err
+	}
+	return nil
+}
+
+// Lookup returns the PeerID of a name, case-insensitively.
+func (s *Store) Lookup(name string) (rovy.PeerID, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	pid, present := s.names[strings.ToLower(name)]
+	return pid, present
+}
+
+// List returns all petnames, sorted by name.
+func (s *Store) List() []Petname {
+	s.RLock()
+	defer s.RUnlock()
+
+	out := make([]Petname, 0, len(s.names))
+	for name, pid := range s.names {
+		out = append(out, Petname{Name: name, PeerID: pid})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
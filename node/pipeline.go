@@ -0,0 +1,183 @@
+package node
+
+import (
+	"sync"
+
+	rovy "go.rovy.net"
+)
+
+// PeerQueueSize is how many packets per peer and direction
+// can be waiting for encryption or decryption.
+const PeerQueueSize = 256
+
+// cryptoJob is a packet on its way through one of the crypto workers.
+// The peer's queue holds on to it until the worker is done,
+// so that packets come out in the same order they went in.
+type cryptoJob struct {
+	pkt  rovy.Packet
+	err  error
+	pl   *pipeline
+	done chan struct{}
+}
+
+var cryptoJobPool = sync.Pool{
+	New: func() any {
+		return &cryptoJob{done: make(chan struct{}, 1)}
+	},
+}
+
+// pipeline is the parallel stage of one of the node's paths, like lower send.
+// Its work function runs on any of the crypto workers, while finish runs
+// in the packet's peer queue, in order with the peer's other packets.
+// This is the same as WireGuard's parallel pipeline.
+type pipeline struct {
+	sync.Mutex
+	name   string
+	node   *Node
+	work   func(pkt *rovy.Packet) error
+	finish func(pkt rovy.Packet) error
+	queues map[rovy.PeerID]*peerQueue
+}
+
+// peerQueue holds a peer's jobs in the order they were put.
+type peerQueue struct {
+	jobs    chan *cryptoJob
+	removed chan int
+}
+
+func newPipeline(node *Node, name string, work func(*rovy.Packet) error, finish func(rovy.Packet) error) *pipeline {
+	return &pipeline{
+		name:   name,
+		node:   node,
+		work:   work,
+		finish: finish,
+		queues: map[rovy.PeerID]*peerQueue{},
+	}
+}
+
+// queue returns the peer's queue, and starts its routine if it's new.
+func (pl *pipeline) queue(peerid rovy.PeerID) *peerQueue {
+	pl.Lock()
+	defer pl.Unlock()
+
+	q, present := pl.queues[peerid]
+	if !present {
+		q = &peerQueue{
+			jobs:    make(chan *cryptoJob, PeerQueueSize),
+			removed: make(chan int),
+		}
+		pl.queues[peerid] = q
+		pl.node.routines.Add(1)
+		go pl.peerRoutine(q)
+	}
+	return q
+}
+
+// remove stops the peer's queue. Packets still in it are dropped.
+func (pl *pipeline) remove(peerid rovy.PeerID) {
+	pl.Lock()
+	defer pl.Unlock()
+
+	if q, present := pl.queues[peerid]; present {
+		close(q.removed)
+		delete(pl.queues, peerid)
+	}
+}
+
+// reset forgets all queues, after their routines have returned.
+func (pl *pipeline) reset() {
+	pl.Lock()
+	defer pl.Unlock()
+
+	pl.queues = map[rovy.PeerID]*peerQueue{}
+}
+
+// put hands the packet to the peer's queue and the crypto workers,
+// and blocks while either of them is full. It gives up if the node is stopped,
+// or the peer is removed in the meantime. It takes ownership of the packet.
+func (pl *pipeline) put(peerid rovy.PeerID, pkt rovy.Packet) error {
+	running := pl.node.running
+	q := pl.queue(peerid)
+
+	job := cryptoJobPool.Get().(*cryptoJob)
+	job.pkt = pkt
+	job.err = nil
+	job.pl = pl
+	if pl.node.cryptoWorkers <= 1 {
+		job.run()
+	}
+
+	select {
+	case q.jobs <- job:
+	case <-q.removed:
+		pkt.Release()
+		return ErrNotConnected
+	case <-running:
+		pkt.Release()
+		return ErrNotRunning
+	}
+
+	if pl.node.cryptoWorkers <= 1 {
+		return nil
+	}
+	select {
+	case pl.node.cryptoQ <- job:
+		pl.node.cryptoHighWater.Mark(len(pl.node.cryptoQ))
+	case <-running:
+		return ErrNotRunning
+	}
+	return nil
+}
+
+func (pl *pipeline) peerRoutine(q *peerQueue) {
+	defer pl.node.routines.Done()
+
+	for {
+		select {
+		case <-pl.node.running:
+			return
+		case <-q.removed:
+			return
+		case job := <-q.jobs:
+			select {
+			case <-job.done:
+			case <-pl.node.running:
+				return
+			}
+
+			pkt, err := job.pkt, job.err
+			job.pkt = rovy.Packet{}
+			job.pl = nil
+			cryptoJobPool.Put(job)
+
+			if err != nil {
+				pkt.Release()
+			} else {
+				err = pl.finish(pkt)
+			}
+			if err != nil {
+				pl.node.log.Warn(pl.name, "err", err)
+			}
+		}
+	}
+}
+
+// cryptoRoutine is one of the crypto workers, which encrypt and decrypt
+// packets of all peers and paths. There's one per CPU, or none if there's only one.
+func (node *Node) cryptoRoutine() {
+	defer node.routines.Done()
+
+	for {
+		select {
+		case <-node.running:
+			return
+		case job := <-node.cryptoQ:
+			job.run()
+		}
+	}
+}
+
+func (job *cryptoJob) run() {
+	job.err = job.pl.work(&job.pkt)
+	job.done <- struct{}{}
+}
@@ -0,0 +1,79 @@
+package node
+
+import (
+	rovy "go.rovy.net"
+	rapi "go.rovy.net/api"
+	policy "go.rovy.net/node/policy"
+)
+
+type PolicyAPI Node
+
+func (c *PolicyAPI) List() ([]rapi.PeerPolicy, error) {
+	out := []rapi.PeerPolicy{}
+	for _, p := range (*Node)(c).policies.List() {
+		out = append(out, policyToAPI(p))
+	}
+	return out, nil
+}
+
+func (c *PolicyAPI) Set(pp rapi.PeerPolicy) (rapi.PeerPolicy, error) {
+	p, err := policyFromAPI(pp)
+	if err != nil {
+		return pp, err
+	}
+	if err := (*Node)(c).policies.Set(p); err != nil {
+		return pp, err
+	}
+	return policyToAPI(p), nil
+}
+
+func (c *PolicyAPI) Remove(name string) error {
+	return (*Node)(c).policies.Remove(name)
+}
+
+func policyFromAPI(pp rapi.PeerPolicy) (policy.Policy, error) {
+	p := policy.Policy{Name: pp.Name, Peers: pp.Peers}
+	for _, s := range pp.Codecs {
+		if s == policy.Wildcard {
+			p.AllCodecs = true
+			continue
+		}
+		codec, err := policy.ParseCodec(s)
+		if err != nil {
+			return p, err
+		}
+		p.Codecs = append(p.Codecs, codec)
+	}
+	for _, s := range pp.Ports {
+		if s == policy.Wildcard {
+			p.AllPorts = true
+			continue
+		}
+		pr, err := policy.ParsePortRule(s)
+		if err != nil {
+			return p, err
+		}
+		p.Ports = append(p.Ports, pr)
+	}
+	return p, nil
+}
+
+func policyToAPI(p policy.Policy) rapi.PeerPolicy {
+	pp := rapi.PeerPolicy{Name: p.Name, Peers: p.Peers, Codecs: []string{}, Ports: []string{}}
+	if pp.Peers == nil {
+		pp.Peers = []rovy.PeerID{}
+	}
+	if p.AllCodecs {
+		pp.Codecs = append(pp.Codecs, policy.Wildcard)
+	}
+	for _, codec := range p.Codecs {
+		pp.Codecs = append(pp.Codecs, policy.CodecString(codec))
+	}
+	if p.AllPorts {
+		pp.Ports = append(pp.Ports, policy.Wildcard)
+	}
+	for _, pr := range p.Ports {
+		pp.Ports = append(pp.Ports, pr.String())
+	}
+	return pp
+}
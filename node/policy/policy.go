@@ -0,0 +1,223 @@
+// Package policy decides which upper codecs and fcnet ports a peer may reach.
+//
+// A Policy applies to a group of peers, or to all peers if it lists none.
+// Codec restrictions are opt-in: a peer may reach every codec, unless a policy
+// that applies to it lists codecs. Then the peer's packets are only delivered
+// to codecs which one of those policies allows. A policy with only ports
+// doesn't restrict codecs, neither for its own peers nor for anybody else.
+//
+// Ports are in addition to the ports which fcnet's firewall allows for everyone.
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	rovy "go.rovy.net"
+)
+
+// Wildcard allows all codecs, or all ports.
+const Wildcard = "*"
+
+// PortRule is a protocol and port, e.g. tcp/22.
+// For ICMP it means echo requests, and Port is unused.
+type PortRule struct {
+	Proto string // tcp, udp, icmp
+	Port  uint16
+}
+
+// ParsePortRule parses rules like tcp/22, udp/53, or icmp.
+func ParsePortRule(s string) (PortRule, error) {
+	proto, port, found := strings.Cut(strings.ToLower(s), "/")
+	switch proto {
+	case "icmp":
+		if found {
+			return PortRule{}, fmt.Errorf("port rule %s: icmp doesn't have ports", s)
+		}
+		return PortRule{Proto: proto}, nil
+	case "tcp", "udp":
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return PortRule{}, fmt.Errorf("port rule %s: invalid port", s)
+		}
+		return PortRule{Proto: proto, Port: uint16(n)}, nil
+	default:
+		return PortRule{}, fmt.Errorf("port rule %s: unknown protocol", s)
+	}
+}
+
+func (pr PortRule) String() string {
+	if pr.Proto == "icmp" {
+		return pr.Proto
+	}
+	return pr.Proto + "/" + strconv.Itoa(int(pr.Port))
+}
+
+// SortPortRules sorts by protocol, then port.
+func SortPortRules(prs []PortRule) {
+	sort.Slice(prs, func(i, j int) bool {
+		if prs[i].Proto != prs[j].Proto {
+			return prs[i].Proto < prs[j].Proto
+		}
+		return prs[i].Port < prs[j].Port
+	})
+}
+
+var codecNames = struct {
+	sync.RWMutex
+	m map[string]uint64
+}{m: map[string]uint64{}}
+
+// RegisterCodec gives an upper codec a name for use in policies, e.g. fcnet.
+func RegisterCodec(name string, codec uint64) {
+	codecNames.Lock()
+	defer codecNames.Unlock()
+	codecNames.m[name] = codec
+}
+
+// ParseCodec parses a registered codec name, or a number like 0x42004.
+func ParseCodec(s string) (uint64, error) {
+	codecNames.RLock()
+	codec, present := codecNames.m[s]
+	codecNames.RUnlock()
+	if present {
+		return codec, nil
+	}
+
+	codec, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unknown codec: %s", s)
+	}
+	return codec, nil
+}
+
+// CodecString returns the codec's registered name, or its number in hex.
+func CodecString(codec uint64) string {
+	codecNames.RLock()
+	defer codecNames.RUnlock()
+	for name, c := range codecNames.m {
+		if c == codec {
+			return name
+		}
+	}
+	return "0x" + strconv.FormatUint(codec, 16)
+}
+
+type Policy struct {
+	Name      string
+	Peers     []rovy.PeerID // empty means all peers
+	Codecs    []uint64
+	AllCodecs bool
+	Ports     []PortRule
+	AllPorts  bool
+}
+
+// restrictsCodecs reports whether the policy lists codecs.
+func (p Policy) restrictsCodecs() bool {
+	return p.AllCodecs || len(p.Codecs) > 0
+}
+
+func (p Policy) appliesTo(pid rovy.PeerID) bool {
+	if len(p.Peers) == 0 {
+		return true
+	}
+	for _, pid2 := range p.Peers {
+		if pid2 == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// Policies is the set of policies of a node, by name.
+type Policies struct {
+	sync.RWMutex
+	policies map[string]Policy
+}
+
+func NewPolicies() *Policies {
+	return &Policies{policies: map[string]Policy{}}
+}
+
+// Set adds a policy, or replaces the one with the same name.
+func (ps *Policies) Set(p Policy) error {
+	if p.Name == "" {
+		return fmt.Errorf("policy needs a name")
+	}
+
+	ps.Lock()
+	defer ps.Unlock()
+	ps.policies[p.Name] = p
+	return nil
+}
+
+func (ps *Policies) Remove(name string) error {
+	ps.Lock()
+	defer ps.Unlock()
+
+	if _, present := ps.policies[name]; !present {
+		return fmt.Errorf("unknown policy: %s", name)
+	}
+	delete(ps.policies, name)
+	return nil
+}
+
+// List returns all policies, sorted by name.
+func (ps *Policies) List() []Policy {
+	ps.RLock()
+	defer ps.RUnlock()
+
+	out := make([]Policy, 0, len(ps.policies))
+	for _, p := range ps.policies {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// AllowCodec reports whether the peer may reach the upper codec.
+func (ps *Policies) AllowCodec(pid rovy.PeerID, codec uint64) bool {
+	ps.RLock()
+	defer ps.RUnlock()
+
+	restricted := false
+	for _, p := range ps.policies {
+		if !p.restrictsCodecs() || !p.appliesTo(pid) {
+			continue
+		}
+		restricted = true
+		if p.AllCodecs {
+			return true
+		}
+		for _, c := range p.Codecs {
+			if c == codec {
+				return true
+			}
+		}
+	}
+	return !restricted
+}
+
+// AllowPort reports whether a policy lets the peer reach the fcnet port.
+func (ps *Policies) AllowPort(pid rovy.PeerID, pr PortRule) bool {
+	ps.RLock()
+	defer ps.RUnlock()
+
+	for _, p := range ps.policies {
+		if !p.appliesTo(pid) {
+			continue
+		}
+		if p.AllPorts {
+			return true
+		}
+		for _, pr2 := range p.Ports {
+			if pr2 == pr {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -5,12 +5,15 @@ import (
 
 	rovy "go.rovy.net"
 	forwarder "go.rovy.net/node/forwarder"
+	policy "go.rovy.net/node/policy"
 	session "go.rovy.net/node/session"
 )
 
 // hello receive
 
 func (node *Node) helloRecvRoutine() {
+	defer node.routines.Done()
+
 	for {
 		select {
 		case <-node.running:
@@ -18,21 +21,19 @@ func (node *Node) helloRecvRoutine() {
 		case pkt := <-node.helloRecvQ.Channel():
 			// Packet only has LowerSrc if it was a data packet during the lower phase.
 			// That means if LowerSrc is set, this is definitely not a lower hello.
+			// handshake packets are consumed here, responses get their own packet
 			if pkt.LowerSrc.Empty() {
 				if pkt.TptSrc.Empty() {
-					node.Log().Printf("helloRecvRoutine: lower packet without TptSrc")
-					continue
-				}
-				if err := node.doLowerHelloRecv(pkt); err != nil {
-					node.Log().Printf("helloRecvRoutine: %s", err)
-					continue
+					node.log.Warn("helloRecvRoutine: lower packet without TptSrc")
+				} else if err := node.doLowerHelloRecv(pkt); err != nil {
+					node.log.Warn("helloRecvRoutine", "err", err)
 				}
 			} else {
 				if err := node.doUpperHelloRecv(pkt); err != nil {
-					node.Log().Printf("helloRecvRoutine: %s", err)
-					continue
+					node.log.Warn("helloRecvRoutine", "err", err)
 				}
 			}
+			pkt.Release()
 		}
 	}
 }
@@ -42,15 +43,16 @@ func (node *Node) doLowerHelloRecv(pkt rovy.Packet) error {
 	switch msgtype {
 	case session.HelloMsgType:
 		hellopkt := session.NewHelloPacket(pkt, rovy.LowerOffset, rovy.LowerPadding)
-		resppkt, err := node.SessionManager().HandleHello(hellopkt, pkt.TptSrc)
+		resppkt, err := node.SessionManager().HandleHello(hellopkt, pkt.TptSrc, pkt.TptLocal)
 		if err != nil {
 			return err
 		}
 		resppkt.TptDst = hellopkt.TptSrc
+		resppkt.TptLocal = hellopkt.TptLocal
 		return node.sendTransport(resppkt.Packet)
 	case session.ResponseMsgType:
 		resppkt := session.NewResponsePacket(pkt, rovy.LowerOffset, rovy.LowerPadding)
-		resppkt, peerid, err := node.SessionManager().HandleResponse(resppkt, pkt.TptSrc)
+		resppkt, peerid, err := node.SessionManager().HandleResponse(resppkt, pkt.TptSrc, pkt.TptLocal)
 		if err != nil {
 			return err
 		}
@@ -69,7 +71,7 @@ func (node *Node) doUpperHelloRecv(pkt rovy.Packet) error {
 	case session.HelloMsgType:
 		hellopkt := session.NewHelloPacket(upkt.Packet, rovy.UpperOffset, rovy.UpperPadding)
 
-		resppkt, err := node.SessionManager().HandleHello(hellopkt, rovy.Multiaddr{})
+		resppkt, err := node.SessionManager().HandleHello(hellopkt, rovy.Multiaddr{}, rovy.Multiaddr{})
 		if err != nil {
 			return err
 		}
@@ -84,7 +86,7 @@ func (node *Node) doUpperHelloRecv(pkt rovy.Packet) error {
 		return nil
 	case session.ResponseMsgType:
 		resppkt := session.NewResponsePacket(upkt.Packet, rovy.UpperOffset, rovy.UpperPadding)
-		resppkt, peerid, err := node.SessionManager().HandleResponse(resppkt, rovy.Multiaddr{})
+		resppkt, peerid, err := node.SessionManager().HandleResponse(resppkt, rovy.Multiaddr{}, rovy.Multiaddr{})
 		if err != nil {
 			return err
 		}
@@ -99,6 +101,8 @@ func (node *Node) doUpperHelloRecv(pkt rovy.Packet) error {
 // lower recv
 
 func (node *Node) lowerRecvRoutine() {
+	defer node.routines.Done()
+
 	for {
 		select {
 		case <-node.running:
@@ -109,13 +113,13 @@ func (node *Node) lowerRecvRoutine() {
 			case session.DataMsgType:
 				err := node.doLowerRecv(pkt)
 				if err != nil {
-					node.Log().Printf("lowerRecvRoutine: %s", err)
+					node.log.Warn("lowerRecvRoutine", "err", err)
 					continue
 				}
 			case session.HelloMsgType, session.ResponseMsgType:
 				node.helloRecvQ.Put(pkt)
 			default:
-				node.Log().Printf("lowerRecvRoutine: dropping packet with unknown MsgType 0x%x", msgtype)
+				node.log.Warn("lowerRecvRoutine: dropping packet with unknown MsgType", "msgtype", fmt.Sprintf("0x%x", msgtype))
 			}
 		}
 	}
@@ -124,6 +128,17 @@ func (node *Node) lowerRecvRoutine() {
 func (node *Node) doLowerRecv(pkt rovy.Packet) error {
 	datapkt := session.NewDataPacket(pkt, rovy.LowerOffset, rovy.LowerPadding)
 
+	s, present := node.SessionManager().Get(datapkt.SessionIndex())
+	if !present {
+		return session.UnknownIndexError
+	}
+	return node.lowerDecrypt.put(s.RemotePeerID(), pkt)
+}
+
+// doLowerDecrypt runs on the crypto workers.
+func (node *Node) doLowerDecrypt(pkt *rovy.Packet) error {
+	datapkt := session.NewDataPacket(*pkt, rovy.LowerOffset, rovy.LowerPadding)
+
 	peerid, firstdata, err := node.SessionManager().HandleData(datapkt)
 	if err != nil {
 		return err
@@ -133,14 +148,21 @@ func (node *Node) doLowerRecv(pkt rovy.Packet) error {
 		node.connectedCallback(peerid, true)
 	}
 
-	datapkt.LowerSrc = peerid
-	node.lowerMuxQ.Put(datapkt.Packet)
+	pkt.LowerSrc = peerid
+	return nil
+}
+
+// doLowerRecvFinish runs in order per peer, after doLowerDecrypt.
+func (node *Node) doLowerRecvFinish(pkt rovy.Packet) error {
+	node.lowerMuxQ.Put(pkt)
 	return nil
 }
 
 // lower mux
 
 func (node *Node) lowerMuxRoutine() {
+	defer node.routines.Done()
+
 	for {
 		select {
 		case <-node.running:
@@ -148,12 +170,12 @@ func (node *Node) lowerMuxRoutine() {
 		case pkt := <-node.lowerMuxQ.Channel():
 
 			if pkt.LowerSrc.Empty() {
-				node.Log().Printf("lowerMuxRoutine: dropping packet without LowerSrc")
+				node.log.Warn("lowerMuxRoutine: dropping packet without LowerSrc")
 				continue
 			}
 
 			if err := node.doLowerMux(pkt); err != nil {
-				node.Log().Printf("lowerMuxRoutine: %s", err)
+				node.log.Warn("lowerMuxRoutine", "err", err)
 				continue
 			}
 		}
@@ -168,8 +190,13 @@ func (node *Node) doLowerMux(pkt rovy.Packet) error {
 		return fmt.Errorf("codec: %s", err)
 	}
 
-	if codec == forwarder.DataMulticodec {
+	switch codec {
+	case forwarder.DataMulticodec:
 		return node.Forwarder().HandlePacket(lowpkt)
+	case forwarder.TooBigMulticodec:
+		return node.Forwarder().HandleTooBig(lowpkt)
+	case forwarder.ProbeMulticodec:
+		return node.Forwarder().HandleProbe(lowpkt)
 	}
 
 	if codec == DirectUpperCodec {
@@ -178,8 +205,11 @@ func (node *Node) doLowerMux(pkt rovy.Packet) error {
 		return nil
 	}
 
+	node.handlersLock.RLock()
 	cb, present := node.lowerHandlers[codec]
+	node.handlersLock.RUnlock()
 	if !present {
+		lowpkt.Release()
 		return fmt.Errorf("dropping packet with unknown lower codec 0x%x from %s", codec, lowpkt.LowerSrc)
 	}
 
@@ -189,6 +219,8 @@ func (node *Node) doLowerMux(pkt rovy.Packet) error {
 // upper recv
 
 func (node *Node) upperRecvRoutine() {
+	defer node.routines.Done()
+
 	for {
 		select {
 		case <-node.running:
@@ -199,21 +231,31 @@ func (node *Node) upperRecvRoutine() {
 			case session.DataMsgType:
 				err := node.doUpperRecv(pkt)
 				if err != nil {
-					node.Log().Printf("upperRecvRoutine: %s", err)
+					node.log.Warn("upperRecvRoutine", "err", err)
 					continue
 				}
 			case session.HelloMsgType, session.ResponseMsgType:
 				node.helloRecvQ.Put(pkt)
 			default:
-				node.Log().Printf("upperRecvRoutine: dropping packet with unknown MsgType 0x%x", msgtype)
+				node.log.Warn("upperRecvRoutine: dropping packet with unknown MsgType", "msgtype", fmt.Sprintf("0x%x", msgtype))
 			}
 		}
 	}
 }
 
 func (node *Node) doUpperRecv(pkt rovy.Packet) error {
-	upkt := rovy.NewUpperPacket(pkt)
-	datapkt := session.NewDataPacket(upkt.Packet, rovy.UpperOffset, rovy.UpperPadding)
+	datapkt := session.NewDataPacket(pkt, rovy.UpperOffset, rovy.UpperPadding)
+
+	s, present := node.SessionManager().Get(datapkt.SessionIndex())
+	if !present {
+		return session.UnknownIndexError
+	}
+	return node.upperDecrypt.put(s.RemotePeerID(), pkt)
+}
+
+// doUpperDecrypt runs on the crypto workers.
+func (node *Node) doUpperDecrypt(pkt *rovy.Packet) error {
+	datapkt := session.NewDataPacket(*pkt, rovy.UpperOffset, rovy.UpperPadding)
 
 	peerid, firstdata, err := node.SessionManager().HandleData(datapkt)
 	if err != nil {
@@ -224,16 +266,24 @@ func (node *Node) doUpperRecv(pkt rovy.Packet) error {
 		node.connectedCallback(peerid, false)
 	}
 
-	datapkt.UpperSrc = peerid
-	node.Routing().AddRoute(datapkt.UpperSrc, upkt.Route().Reverse()) // XXX slowness
+	pkt.UpperSrc = peerid
+	return nil
+}
 
-	node.upperMuxQ.Put(datapkt.Packet)
+// doUpperRecvFinish runs in order per peer, after doUpperDecrypt.
+func (node *Node) doUpperRecvFinish(pkt rovy.Packet) error {
+	upkt := rovy.NewUpperPacket(pkt)
+	node.Routing().AddRoute(upkt.UpperSrc, upkt.Route().Reverse()) // XXX slowness
+
+	node.upperMuxQ.Put(upkt.Packet)
 	return nil
 }
 
 // upper mux
 
 func (node *Node) upperMuxRoutine() {
+	defer node.routines.Done()
+
 	for {
 		select {
 		case <-node.running:
@@ -241,7 +291,7 @@ func (node *Node) upperMuxRoutine() {
 		case pkt := <-node.upperMuxQ.Channel():
 
 			if err := node.doUpperMux(pkt); err != nil {
-				node.Log().Printf("upperMuxRoutine: %s", err)
+				node.log.Warn("upperMuxRoutine", "err", err)
 				continue
 			}
 		}
@@ -249,6 +299,8 @@ func (node *Node) upperMuxRoutine() {
 }
 
 func (node *Node) doUpperMux(pkt rovy.Packet) error {
+	defer pkt.Release()
+
 	upkt := rovy.NewUpperPacket(pkt)
 
 	codec, err := upkt.Codec()
@@ -256,10 +308,17 @@ func (node *Node) doUpperMux(pkt rovy.Packet) error {
 		return fmt.Errorf("codec: %s", err)
 	}
 
+	node.handlersLock.RLock()
 	cb, present := node.upperHandlers[codec]
+	node.handlersLock.RUnlock()
 	if !present {
 		return fmt.Errorf("dropping packet with unknown upper codec 0x%x from %s", codec, upkt.LowerSrc)
 	}
 
+	if upkt.UpperSrc != node.peerid && !node.policies.AllowCodec(upkt.UpperSrc, codec) {
+		node.log.Debug("upperMux: dropping packet denied by policy", "codec", policy.CodecString(codec), "src", upkt.UpperSrc)
+		return nil
+	}
+
 	return cb(upkt)
 }
@@ -0,0 +1,174 @@
+package node
+
+import (
+	"sync"
+
+	rovy "go.rovy.net"
+)
+
+// TransportRegistry keeps track of a node's transports,
+// and picks the transport for sending a packet to a given address.
+//
+// Besides the listeners, it holds dial-only transports for connection-oriented
+// transport kinds which we have no listener for. These are created on demand.
+type TransportRegistry struct {
+	sync.RWMutex
+	transports []Transport
+	dialers    map[string]Transport
+}
+
+func NewTransportRegistry() *TransportRegistry {
+	return &TransportRegistry{dialers: map[string]Transport{}}
+}
+
+// transportKind returns the protocols of a transport address without their values,
+// e.g. ip6/udp. Only transports of the same kind can talk to each other.
+func transportKind(ma rovy.Multiaddr) string {
+	if ma.Ifname != "" {
+		return "ethif/" + ma.Ifname
+	}
+	if ma.Memory != "" {
+		return "memory"
+	}
+	if !ma.IP.IsValid() {
+		return ""
+	}
+
+	kind := "ip6"
+	if ma.IP.Unmap().Is4() {
+		kind = "ip4"
+	}
+	switch ma.Tpt {
+	case 0:
+		return kind + "/udp"
+	case rovy.TCPMultiaddrCodec:
+		return kind + "/tcp"
+	case rovy.TLSMultiaddrCodec:
+		return kind + "/tcp/tls"
+	case rovy.WSMultiaddrCodec:
+		return kind + "/tcp/ws"
+	case rovy.WSSMultiaddrCodec:
+		return kind + "/tcp/wss"
+	default:
+		return ""
+	}
+}
+
+// Add registers a transport, unless there's already one with the same listen address.
+func (reg *TransportRegistry) Add(tpt Transport) error {
+	reg.Lock()
+	defer reg.Unlock()
+
+	for _, tpt2 := range reg.transports {
+		if tpt2.ListenMultiaddr() == tpt.ListenMultiaddr() {
+			return ErrDuplicateListener
+		}
+	}
+	reg.transports = append(reg.transports, tpt)
+	return nil
+}
+
+// Remove unregisters and returns the transport with the given listen address.
+func (reg *TransportRegistry) Remove(lisaddr rovy.Multiaddr) (Transport, error) {
+	reg.Lock()
+	defer reg.Unlock()
+
+	for i, tpt := range reg.transports {
+		if tpt.ListenMultiaddr() == lisaddr {
+			reg.transports = append(reg.transports[:i], reg.transports[i+1:]...)
+			return tpt, nil
+		}
+	}
+	return nil, ErrUnknownListener
+}
+
+// Get returns the transport with the given listen address.
+func (reg *TransportRegistry) Get(lisaddr rovy.Multiaddr) (Transport, bool) {
+	reg.RLock()
+	defer reg.RUnlock()
+
+	for _, tpt := range reg.transports {
+		if tpt.ListenMultiaddr() == lisaddr {
+			return tpt, true
+		}
+	}
+	return nil, false
+}
+
+// All returns a snapshot of the registered listeners, in the order they were added.
+func (reg *TransportRegistry) All() []Transport {
+	reg.RLock()
+	defer reg.RUnlock()
+
+	return append([]Transport{}, reg.transports...)
+}
+
+// Select picks the transport for sending to raddr.
+// If laddr is set, the packet belongs to a session pinned to the transport
+// bound to that address, and we stick to it as long as it's running.
+// Otherwise it's the first running listener of the same kind as raddr,
+// or the dialer for that kind.
+func (reg *TransportRegistry) Select(raddr, laddr rovy.Multiaddr) (Transport, error) {
+	reg.RLock()
+	defer reg.RUnlock()
+
+	if !laddr.Empty() {
+		for _, tpt := range reg.transports {
+			if tpt.Running() && tpt.LocalMultiaddr() == laddr {
+				return tpt, nil
+			}
+		}
+		if tpt, present := reg.dialers[transportKind(laddr)]; present && tpt.Running() && tpt.LocalMultiaddr() == laddr {
+			return tpt, nil
+		}
+	}
+
+	kind := transportKind(raddr)
+	if kind == "" {
+		return nil, ErrNoTransport
+	}
+	for _, tpt := range reg.transports {
+		if tpt.Running() && transportKind(tpt.ListenMultiaddr()) == kind {
+			return tpt, nil
+		}
+	}
+	if tpt, present := reg.dialers[kind]; present && tpt.Running() {
+		return tpt, nil
+	}
+	return nil, ErrNoTransport
+}
+
+// Dialer returns the running dialer for addresses of raddr's kind.
+// If there is none, it is created and started by calling create.
+func (reg *TransportRegistry) Dialer(raddr rovy.Multiaddr, create func() (Transport, error)) (Transport, error) {
+	kind := transportKind(raddr)
+	if kind == "" {
+		return nil, ErrNoTransport
+	}
+
+	reg.Lock()
+	defer reg.Unlock()
+
+	if tpt, present := reg.dialers[kind]; present && tpt.Running() {
+		return tpt, nil
+	}
+	tpt, err := create()
+	if err != nil {
+		return nil, err
+	}
+	reg.dialers[kind] = tpt
+	return tpt, nil
+}
+
+// RemoveDialers unregisters and returns all dialers.
+func (reg *TransportRegistry) RemoveDialers() []Transport {
+	reg.Lock()
+	defer reg.Unlock()
+
+	var dialers []Transport
+	for kind, tpt := range reg.dialers {
+		dialers = append(dialers, tpt)
+		delete(reg.dialers, kind)
+	}
+	return dialers
+}
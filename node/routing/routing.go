@@ -5,30 +5,84 @@ import (
 	"log"
 	"net/netip"
 	"sync"
+	"time"
 
 	rovy "go.rovy.net"
+	logging "go.rovy.net/node/util/logging"
 )
 
 var (
 	ErrUnknownPeerID = errors.New("no routes for this PeerID")
 )
 
+// PathMTUTimeout is how long a learned path MTU is good for. After that,
+// the path is assumed to carry TptMTU again until it's probed, like in RFC 8201.
+const PathMTUTimeout = 10 * time.Minute
+
+type pathMTU struct {
+	mtu     int
+	expires time.Time
+}
+
 // TODO: is rovy.PeerID okay as a map index type?
 type Routing struct {
 	sync.RWMutex
 	table  map[rovy.PeerID][]rovy.Route
 	ipv6   map[netip.Addr]rovy.PeerID
-	logger *log.Logger
+	pmtu   map[string]pathMTU // by route bytes
+	logger *logging.Logger
 }
 
-func NewRouting(logger *log.Logger) *Routing {
+func NewRouting(logger *logging.Logger) *Routing {
 	return &Routing{
 		table:  make(map[rovy.PeerID][]rovy.Route),
 		ipv6:   make(map[netip.Addr]rovy.PeerID),
+		pmtu:   make(map[string]pathMTU),
 		logger: logger,
 	}
 }
 
+// SetPathMTU records the MTU in bytes of lower packet for the route,
+// and thereby for all longer routes starting with it.
+func (r *Routing) SetPathMTU(route rovy.Route, mtu int) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	for k, pm := range r.pmtu {
+		if now.After(pm.expires) {
+			delete(r.pmtu, k)
+		}
+	}
+	r.pmtu[string(route.Bytes())] = pathMTU{mtu, now.Add(PathMTUTimeout)}
+	r.logger.Debug("path mtu", "route", route, "mtu", mtu)
+}
+
+// PathMTU returns the smallest MTU known for the route or any of its prefixes,
+// or TptMTU if there's none. It also reports whether the route itself
+// has been probed, or has otherwise been given an MTU with SetPathMTU.
+func (r *Routing) PathMTU(route rovy.Route) (mtu int, known bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	now := time.Now()
+	mtu = rovy.TptMTU
+	b := route.Bytes()
+	for i := 1; i <= len(b); i++ {
+		pm, present := r.pmtu[string(b[:i])]
+		if !present || now.After(pm.expires) {
+			continue
+		}
+		if pm.mtu < mtu {
+			mtu = pm.mtu
+		}
+		if i == len(b) {
+			known = true
+		}
+	}
+	return mtu, known
+}
+
 func (r *Routing) AddRoute(peerid rovy.PeerID, route rovy.Route) {
 	r.Lock()
 	defer r.Unlock()
@@ -48,6 +102,41 @@ func (r *Routing) AddRoute(peerid rovy.PeerID, route rovy.Route) {
 	r.ipv6[peerid.PublicKey().IPAddr()] = peerid
 }
 
+// RemovePeer forgets all routes to the given PeerID, as well as all routes
+// to other peers which start with the given slot, i.e. which go via that peer.
+// The path MTUs via the slot are forgotten too, since it's reused for other peers.
+func (r *Routing) RemovePeer(peerid rovy.PeerID, slot rovy.Route) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.table, peerid)
+	delete(r.ipv6, peerid.PublicKey().IPAddr())
+
+	if slot.Empty() {
+		return
+	}
+	for pid, routes := range r.table {
+		var keep []rovy.Route
+		for _, l := range routes {
+			if l.Len() > 0 && l.Bytes()[0] == slot.Bytes()[0] {
+				continue
+			}
+			keep = append(keep, l)
+		}
+		if len(keep) == 0 {
+			delete(r.table, pid)
+			delete(r.ipv6, pid.PublicKey().IPAddr())
+		} else {
+			r.table[pid] = keep
+		}
+	}
+	for k := range r.pmtu {
+		if len(k) > 0 && k[0] == slot.Bytes()[0] {
+			delete(r.pmtu, k)
+		}
+	}
+}
+
 func (r *Routing) GetRoute(peerid rovy.PeerID) (rovy.Route, error) {
 	r.RLock()
 	defer r.RUnlock()
@@ -76,6 +165,17 @@ func (r *Routing) LookupIPv6(ipaddr netip.Addr) (rovy.PeerID, error) {
 	return pid, nil
 }
 
+// Size returns the number of peers we have routes to, and the number of routes.
+func (r *Routing) Size() (peers int, routes int) {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, rts := range r.table {
+		routes += len(rts)
+	}
+	return len(r.table), routes
+}
+
 func (r *Routing) PrintTable(out *log.Logger) {
 	for peerid, routes := range r.table {
 		out.Printf("/rovy/%s", peerid)
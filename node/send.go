@@ -11,6 +11,8 @@ import (
 // hello send
 
 func (node *Node) helloSendRoutine() {
+	defer node.routines.Done()
+
 	for {
 		select {
 		case <-node.running:
@@ -18,24 +20,24 @@ func (node *Node) helloSendRoutine() {
 		case pkt := <-node.helloSendQ.Channel():
 			if pkt.LowerDst.Empty() {
 				if pkt.UpperDst.Empty() {
-					node.Log().Printf("helloSendRoutine: upper packet without UpperDst")
+					node.log.Warn("helloSendRoutine: upper packet without UpperDst")
 					continue
 				}
 				if err := node.doUpperHelloSend(pkt); err != nil {
-					node.Log().Printf("helloSendRoutine: %s", err)
+					node.log.Warn("helloSendRoutine", "err", err)
 					continue
 				}
 			} else {
 				if pkt.TptDst.Empty() {
-					node.Log().Printf("helloSendRoutine: lower packet without TptDst")
+					node.log.Warn("helloSendRoutine: lower packet without TptDst")
 					continue
 				}
 				if pkt.LowerDst.Empty() {
-					node.Log().Printf("helloSendRoutine: lower packet without LowerDst")
+					node.log.Warn("helloSendRoutine: lower packet without LowerDst")
 					continue
 				}
 				if err := node.doLowerHelloSend(pkt); err != nil {
-					node.Log().Printf("helloSendRoutine: %s", err)
+					node.log.Warn("helloSendRoutine", "err", err)
 					continue
 				}
 			}
@@ -44,18 +46,23 @@ func (node *Node) helloSendRoutine() {
 }
 
 func (node *Node) doLowerHelloSend(pkt rovy.Packet) error {
+	tpt, err := node.selectTransport(pkt.TptDst, rovy.Multiaddr{})
+	if err != nil {
+		return err
+	}
+
 	hellopkt := session.NewHelloPacket(pkt, rovy.LowerOffset, rovy.LowerPadding)
-	hellopkt, err := node.SessionManager().CreateHello(hellopkt, pkt.LowerDst, pkt.TptDst)
+	hellopkt, err = node.SessionManager().CreateHello(hellopkt, pkt.LowerDst, pkt.TptDst, tpt.LocalMultiaddr())
 	if err != nil {
 		return err
 	}
 
-	return node.sendTransport(hellopkt.Packet)
+	return tpt.Send(hellopkt.Packet)
 }
 
 func (node *Node) doUpperHelloSend(pkt rovy.Packet) error {
 	hellopkt := session.NewHelloPacket(pkt, rovy.UpperOffset, rovy.UpperPadding)
-	hellopkt, err := node.SessionManager().CreateHello(hellopkt, pkt.UpperDst, rovy.Multiaddr{})
+	hellopkt, err := node.SessionManager().CreateHello(hellopkt, pkt.UpperDst, rovy.Multiaddr{}, rovy.Multiaddr{})
 	if err != nil {
 		return err
 	}
@@ -77,38 +84,44 @@ func (node *Node) doUpperHelloSend(pkt rovy.Packet) error {
 // lower send
 
 func (node *Node) lowerSendRoutine() {
+	defer node.routines.Done()
+
 	for {
 		select {
 		case <-node.running:
 			return
 		case pkt := <-node.lowerSendQ.Channel():
 			if pkt.LowerDst.Empty() {
-				node.Log().Printf("lowerSendRoutine: dropping packet without LowerDst")
+				node.log.Warn("lowerSendRoutine: dropping packet without LowerDst")
 				continue
 			}
-			if err := node.doLowerSend(pkt); err != nil {
-				node.Log().Printf("lowerSendRoutine: %s", err)
+			if err := node.lowerEncrypt.put(pkt.LowerDst, pkt); err != nil {
+				node.log.Warn("lowerSendRoutine", "err", err)
 				continue
 			}
 		}
 	}
 }
 
-func (node *Node) doLowerSend(pkt rovy.Packet) error {
-	datapkt := session.NewDataPacket(pkt, rovy.LowerOffset, rovy.LowerPadding)
+// doLowerEncrypt runs on the crypto workers, lowerEncrypt's finish is sendTransport.
+func (node *Node) doLowerEncrypt(pkt *rovy.Packet) error {
+	datapkt := session.NewDataPacket(*pkt, rovy.LowerOffset, rovy.LowerPadding)
 
-	raddr, err := node.SessionManager().CreateData(datapkt, datapkt.LowerDst)
+	raddr, laddr, err := node.SessionManager().CreateData(datapkt, datapkt.LowerDst)
 	if err != nil {
 		return err
 	}
 
-	datapkt.TptDst = raddr
-	return node.sendTransport(datapkt.Packet)
+	pkt.TptDst = raddr
+	pkt.TptLocal = laddr
+	return nil
 }
 
 // upper send
 
 func (node *Node) upperSendRoutine() {
+	defer node.routines.Done()
+
 	for {
 		select {
 		case <-node.running:
@@ -116,12 +129,12 @@ func (node *Node) upperSendRoutine() {
 		case pkt := <-node.upperSendQ.Channel():
 
 			if pkt.UpperDst.Empty() {
-				node.Log().Printf("upperSendRoutine: packet without UpperDst")
+				node.log.Warn("upperSendRoutine: packet without UpperDst")
 				continue
 			}
 
 			if err := node.doUpperSend(pkt); err != nil {
-				node.Log().Printf("upperSendRoutine: %s", err)
+				node.log.Warn("upperSendRoutine", "err", err)
 				continue
 			}
 		}
@@ -132,20 +145,29 @@ func (node *Node) doUpperSend(pkt rovy.Packet) error {
 	upkt := rovy.NewUpperPacket(pkt)
 
 	if upkt.RouteLen() == forwarder.HopLength {
+		if err := node.forwarder.CheckMTU(upkt.Route(), upkt.Length); err != nil {
+			upkt.Release()
+			return err
+		}
 		lpkt := rovy.NewLowerPacket(upkt.Packet)
 		lpkt.SetCodec(DirectUpperCodec)
 		lpkt.LowerDst = upkt.UpperDst
-		node.lowerSendQ.PutWithBackpressure(lpkt.Packet)
-		return nil
+		return node.putLowerSend(lpkt.Packet)
 	}
 
-	datapkt := session.NewDataPacket(upkt.Packet, rovy.UpperOffset, rovy.UpperPadding)
-	_, err := node.SessionManager().CreateData(datapkt, datapkt.UpperDst)
-	if err != nil {
-		return err
-	}
+	return node.upperEncrypt.put(upkt.UpperDst, upkt.Packet)
+}
 
-	if err = node.Forwarder().SendPacket(upkt); err != nil {
+// doUpperEncrypt runs on the crypto workers.
+func (node *Node) doUpperEncrypt(pkt *rovy.Packet) error {
+	datapkt := session.NewDataPacket(*pkt, rovy.UpperOffset, rovy.UpperPadding)
+	_, _, err := node.SessionManager().CreateData(datapkt, datapkt.UpperDst)
+	return err
+}
+
+// doUpperSendFinish runs in order per peer, after doUpperEncrypt.
+func (node *Node) doUpperSendFinish(pkt rovy.Packet) error {
+	if err := node.Forwarder().SendPacket(rovy.NewUpperPacket(pkt)); err != nil {
 		return fmt.Errorf("forwarder: %s", err)
 	}
 	return nil
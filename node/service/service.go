@@ -2,7 +2,8 @@ package rservice
 
 import (
 	"errors"
-	"log"
+
+	logging "go.rovy.net/node/util/logging"
 )
 
 type Service interface {
@@ -16,10 +17,10 @@ var ErrServiceNotRunning = errors.New("service not running")
 
 type ServiceManager struct {
 	services map[string]Service
-	logger   *log.Logger
+	logger   *logging.Logger
 }
 
-func NewServiceManager(logger *log.Logger) *ServiceManager {
+func NewServiceManager(logger *logging.Logger) *ServiceManager {
 	sm := &ServiceManager{
 		services: make(map[string]Service),
 		logger:   logger,
@@ -4,6 +4,8 @@ import (
 	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"golang.org/x/crypto/blake2s"
 	"golang.org/x/crypto/chacha20poly1305"
@@ -334,7 +336,15 @@ func (hs *Handshake) ConsumeResponse(hdr ResponseHeader, payload2 []byte) ([]byt
 	return payload, nil
 }
 
-func (hs *Handshake) MakeMessage(payload []byte) (hdr MessageHeader, payload2 []byte, err error) {
+// nonces are AEAD nonce buffers, which would otherwise escape to the heap
+// on every message. Their first 4 bytes always stay zero.
+var nonces = sync.Pool{
+	New: func() any { return new([chacha20poly1305.NonceSize]byte) },
+}
+
+// MakeMessage encrypts payload and appends the ciphertext to dst.
+// Like with cipher.AEAD, payload[:0] as dst encrypts in place.
+func (hs *Handshake) MakeMessage(dst, payload []byte) (hdr MessageHeader, payload2 []byte, err error) {
 	if hs.send == nil {
 		return hdr, payload2, fmt.Errorf("handshake not finished yet with %s", rovy.NewPeerID(hs.RemotePublicKey()))
 	}
@@ -345,12 +355,13 @@ func (hs *Handshake) MakeMessage(payload []byte) (hdr MessageHeader, payload2 []
 	// 	payload = append(payload, 0x0)
 	// }
 
-	hs.sendNonce += 1
-	binary.BigEndian.PutUint64(hdr.Nonce[:], hs.sendNonce)
+	// messages can be encrypted in parallel, each of them gets its own nonce
+	binary.BigEndian.PutUint64(hdr.Nonce[:], atomic.AddUint64(&hs.sendNonce, 1))
 
 	// XXX: why leave the first 4 bytes zero instead of some other part?
 	// TODO: use copy(nonce[4:], hdr.Nonce) instead
-	var nonce [chacha20poly1305.NonceSize]byte
+	nonce := nonces.Get().(*[chacha20poly1305.NonceSize]byte)
+	defer nonces.Put(nonce)
 	nonce[0x4] = hdr.Nonce[0x0]
 	nonce[0x5] = hdr.Nonce[0x1]
 	nonce[0x6] = hdr.Nonce[0x2]
@@ -361,18 +372,21 @@ func (hs *Handshake) MakeMessage(payload []byte) (hdr MessageHeader, payload2 []
 	nonce[0xb] = hdr.Nonce[0x7]
 	// fmt.Printf("MakeMessage: hs=%#v\n", hs)
 	// fmt.Printf("MakeMessage: nonce=%#v payload=%#v\n", nonce, payload)
-	payload2 = hs.send.Seal(payload2[:0], nonce[:], payload, nil)
+	payload2 = hs.send.Seal(dst, nonce[:], payload, nil)
 
 	return
 }
 
+// ConsumeMessage decrypts payload and appends the plaintext to dst.
+// Like with cipher.AEAD, payload[:0] as dst decrypts in place.
 // TODO: why is first byte of ciphertext always 0x15
-func (hs *Handshake) ConsumeMessage(hdr MessageHeader, payload []byte) (payload2 []byte, err error) {
+func (hs *Handshake) ConsumeMessage(dst []byte, hdr MessageHeader, payload []byte) (payload2 []byte, err error) {
 	if hs.receive == nil {
 		return payload2, fmt.Errorf("handshake not finished yet with %s", rovy.NewPeerID(hs.RemotePublicKey()))
 	}
 
-	var nonce [chacha20poly1305.NonceSize]byte
+	nonce := nonces.Get().(*[chacha20poly1305.NonceSize]byte)
+	defer nonces.Put(nonce)
 	nonce[0x4] = hdr.Nonce[0x0]
 	nonce[0x5] = hdr.Nonce[0x1]
 	nonce[0x6] = hdr.Nonce[0x2]
@@ -383,7 +397,7 @@ func (hs *Handshake) ConsumeMessage(hdr MessageHeader, payload []byte) (payload2
 	nonce[0xb] = hdr.Nonce[0x7]
 	// fmt.Printf("ConsumeMessage: hs=%#v\n", hs)
 	// fmt.Printf("ConsumeMessage: nonce=%#v payload=%#v\n", nonce, payload)
-	payload2, err = hs.receive.Open(payload2[:0], nonce[:], payload, nil)
+	payload2, err = hs.receive.Open(dst, nonce[:], payload, nil)
 
 	return
 }
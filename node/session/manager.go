@@ -1,15 +1,17 @@
 package session
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	rovy "go.rovy.net"
 	ikpsk2 "go.rovy.net/node/session/ikpsk2"
+	logging "go.rovy.net/node/util/logging"
 )
 
 // TODO: make sure indexes from remote don't overwrite other sessions
@@ -19,16 +21,85 @@ type SessionManager struct {
 	pubkey  rovy.PublicKey
 	peerid  rovy.PeerID
 	store   map[uint32]*Session
-	logger  *log.Logger
+	removed map[rovy.PeerID]PeerTraffic // totals of the sessions which are gone
+	logger  *logging.Logger
+
+	handshakeSuccesses atomic.Uint64
+	handshakeFailures  atomic.Uint64
+	decryptFailures    atomic.Uint64
+}
+
+// Counters are the SessionManager's totals since it was created.
+type Counters struct {
+	HandshakeSuccesses uint64 // sessions which got established
+	HandshakeFailures  uint64 // hellos and responses which we couldn't handle
+	DecryptFailures    uint64 // data packets which we couldn't decrypt
+}
+
+func (sm *SessionManager) Counters() Counters {
+	return Counters{
+		HandshakeSuccesses: sm.handshakeSuccesses.Load(),
+		HandshakeFailures:  sm.handshakeFailures.Load(),
+		DecryptFailures:    sm.decryptFailures.Load(),
+	}
+}
+
+// PeerTraffic are the totals of all sessions with a peer, including removed ones,
+// so that they only ever go up.
+type PeerTraffic struct {
+	RxBytes   uint64
+	RxPackets uint64
+	TxBytes   uint64
+	TxPackets uint64
+}
+
+func (pt *PeerTraffic) add(st SessionStats) {
+	pt.RxBytes += st.RxBytes
+	pt.RxPackets += st.RxPackets
+	pt.TxBytes += st.TxBytes
+	pt.TxPackets += st.TxPackets
+}
+
+// PeerTraffic returns the traffic totals by peer, since the SessionManager was created.
+func (sm *SessionManager) PeerTraffic() map[rovy.PeerID]PeerTraffic {
+	sm.RLock()
+	defer sm.RUnlock()
+
+	out := make(map[rovy.PeerID]PeerTraffic, len(sm.removed))
+	for pid, pt := range sm.removed {
+		out[pid] = pt
+	}
+	for _, s := range sm.store {
+		if s.remotePeerID.Empty() {
+			continue
+		}
+		pt := out[s.remotePeerID]
+		pt.add(s.Stats())
+		out[s.remotePeerID] = pt
+	}
+	return out
 }
 
-func NewSessionManager(privkey rovy.PrivateKey, logger *log.Logger) *SessionManager {
+// forget removes a session, and keeps its traffic in the peer's totals.
+// The caller holds the write lock.
+func (sm *SessionManager) forget(idx uint32, s *Session) {
+	delete(sm.store, idx)
+	if s.remotePeerID.Empty() {
+		return
+	}
+	pt := sm.removed[s.remotePeerID]
+	pt.add(s.Stats())
+	sm.removed[s.remotePeerID] = pt
+}
+
+func NewSessionManager(privkey rovy.PrivateKey, logger *logging.Logger) *SessionManager {
 	pubkey := privkey.PublicKey()
 	sm := &SessionManager{
 		privkey: privkey,
 		pubkey:  pubkey,
 		peerid:  rovy.NewPeerID(pubkey),
 		store:   make(map[uint32]*Session),
+		removed: make(map[rovy.PeerID]PeerTraffic),
 		logger:  logger,
 	}
 	return sm
@@ -38,7 +109,7 @@ func (sm *SessionManager) randUint32() uint32 {
 	var integer [4]byte
 	for {
 		if _, err := rand.Read(integer[:]); err != nil {
-			sm.logger.Printf("can't read from crypto/rand: %s", err)
+			sm.logger.Error("can't read from crypto/rand", "err", err)
 			time.Sleep(1 * time.Second)
 		} else {
 			return binary.LittleEndian.Uint32(integer[:])
@@ -71,16 +142,35 @@ func (sm *SessionManager) Get(idx uint32) (s *Session, present bool) {
 	return
 }
 
+// Find returns a session with the given PeerID, preferring established sessions
+// over those which are still in the middle of their handshake.
 func (sm *SessionManager) Find(peerid rovy.PeerID) (*Session, uint32, bool) {
 	sm.RLock()
 	defer sm.RUnlock()
 
+	var found *Session
+	var foundidx uint32
 	for idx, s := range sm.store {
 		if s.remotePeerID == peerid {
-			return s, idx, true
+			if s.Stage() == EstablishedStage {
+				return s, idx, true
+			}
+			found, foundidx = s, idx
 		}
 	}
-	return nil, 0, false
+	return found, foundidx, found != nil
+}
+
+// Sessions returns a snapshot of all sessions, including those still in the handshake.
+func (sm *SessionManager) Sessions() []*Session {
+	sm.RLock()
+	defer sm.RUnlock()
+
+	ss := make([]*Session, 0, len(sm.store))
+	for _, s := range sm.store {
+		ss = append(ss, s)
+	}
+	return ss
 }
 
 func (sm *SessionManager) Swap(idx1, idx2 uint32) {
@@ -101,13 +191,51 @@ func (sm *SessionManager) Remove(idx uint32) {
 	sm.Lock()
 	defer sm.Unlock()
 
-	_, present := sm.store[idx]
+	s, present := sm.store[idx]
 	if present {
-		delete(sm.store, idx)
+		sm.forget(idx, s)
+	}
+}
+
+// RemovePeer removes all sessions with the given PeerID and returns how many there were.
+func (sm *SessionManager) RemovePeer(peerid rovy.PeerID) int {
+	sm.Lock()
+	defer sm.Unlock()
+
+	var n int
+	for idx, s := range sm.store {
+		if s.remotePeerID == peerid {
+			sm.forget(idx, s)
+			n += 1
+		}
 	}
+	return n
 }
 
-func (sm *SessionManager) CreateHello(pkt HelloPacket, peerid rovy.PeerID, raddr rovy.Multiaddr) (HelloPacket, error) {
+// RemovePeerAddr removes the sessions with the given PeerID over the given remote address,
+// ignoring its /rovy part, and returns how many there were, and how many sessions with the peer are left.
+func (sm *SessionManager) RemovePeerAddr(peerid rovy.PeerID, raddr rovy.Multiaddr) (removed int, left int) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	raddr.PeerID = rovy.PeerID{}
+	for idx, s := range sm.store {
+		if s.remotePeerID != peerid {
+			continue
+		}
+		ra := s.RemoteAddr()
+		ra.PeerID = rovy.PeerID{}
+		if !ra.Empty() && bytes.Equal(ra.Bytes(), raddr.Bytes()) {
+			sm.forget(idx, s)
+			removed += 1
+		} else {
+			left += 1
+		}
+	}
+	return removed, left
+}
+
+func (sm *SessionManager) CreateHello(pkt HelloPacket, peerid rovy.PeerID, raddr, laddr rovy.Multiaddr) (HelloPacket, error) {
 	hs, err := ikpsk2.NewHandshakeInitiator(sm.privkey, peerid.PublicKey())
 	if err != nil {
 		return pkt, err
@@ -120,11 +248,12 @@ func (sm *SessionManager) CreateHello(pkt HelloPacket, peerid rovy.PeerID, raddr
 
 	if !raddr.Empty() {
 		s.SetRemoteAddr(raddr)
+		s.SetLocalAddr(laddr)
 	}
 	return s.CreateHello(pkt)
 }
 
-func (sm *SessionManager) HandleHello(pkt HelloPacket, raddr rovy.Multiaddr) (ResponsePacket, error) {
+func (sm *SessionManager) HandleHello(pkt HelloPacket, raddr, laddr rovy.Multiaddr) (ResponsePacket, error) {
 	var pkt2 ResponsePacket
 
 	hs, err := ikpsk2.NewHandshakeResponder(sm.privkey)
@@ -136,10 +265,11 @@ func (sm *SessionManager) HandleHello(pkt HelloPacket, raddr rovy.Multiaddr) (Re
 
 	pkt, err = s.HandleHello(pkt)
 	if err != nil {
+		sm.handshakeFailures.Add(1)
 		return pkt2, fmt.Errorf("HandleHello: %s", err)
 	}
 
-	pkt2 = NewResponsePacket(rovy.NewPacket(make([]byte, rovy.TptMTU)), pkt.Offset, pkt.Padding)
+	pkt2 = NewResponsePacket(rovy.AllocPacket(), pkt.Offset, pkt.Padding)
 	pkt2.SetSenderIndex(pkt.SenderIndex())
 
 	pkt2, err = s.CreateResponse(pkt2)
@@ -152,48 +282,57 @@ func (sm *SessionManager) HandleHello(pkt HelloPacket, raddr rovy.Multiaddr) (Re
 
 	if !raddr.Empty() {
 		s.SetRemoteAddr(raddr)
+		s.SetLocalAddr(laddr)
 	}
 
 	return pkt2, nil
 }
 
-func (sm *SessionManager) HandleResponse(pkt ResponsePacket, raddr rovy.Multiaddr) (ResponsePacket, rovy.PeerID, error) {
+func (sm *SessionManager) HandleResponse(pkt ResponsePacket, raddr, laddr rovy.Multiaddr) (ResponsePacket, rovy.PeerID, error) {
 	s, present := sm.Get(pkt.SenderIndex())
 	if !present {
+		sm.handshakeFailures.Add(1)
 		return pkt, rovy.PeerID{}, UnknownIndexError
 	}
 
 	pkt, err := s.HandleHelloResponse(pkt)
 	if err != nil {
+		sm.handshakeFailures.Add(1)
 		return pkt, rovy.PeerID{}, err
 	}
+	sm.handshakeSuccesses.Add(1)
 
 	sm.Swap(pkt.SenderIndex(), pkt.SessionIndex())
 
 	if !raddr.Empty() {
 		s.SetRemoteAddr(raddr)
+		s.SetLocalAddr(laddr)
 	}
 
 	return pkt, s.remotePeerID, nil
 }
 
-func (sm *SessionManager) CreateData(pkt DataPacket, peerid rovy.PeerID) (rovy.Multiaddr, error) {
+// CreateData encrypts the packet for the given peer, and returns the session's
+// remote address, and the local address of the transport the session is pinned to.
+func (sm *SessionManager) CreateData(pkt DataPacket, peerid rovy.PeerID) (rovy.Multiaddr, rovy.Multiaddr, error) {
 	s, idx, present := sm.Find(peerid)
 	if !present {
-		return rovy.Multiaddr{}, fmt.Errorf("no session for %s", peerid)
+		return rovy.Multiaddr{}, rovy.Multiaddr{}, fmt.Errorf("no session for %s", peerid)
 	}
 
-	hdr, ct, err := s.handshake.MakeMessage(pkt.Plaintext())
+	pt := pkt.Plaintext()
+	hdr, ct, err := s.handshake.MakeMessage(pt[:0], pt)
 	if err != nil {
-		return rovy.Multiaddr{}, err
+		return rovy.Multiaddr{}, rovy.Multiaddr{}, err
 	}
 
 	pkt.SetMsgType(DataMsgType)
 	pkt.SetSessionIndex(idx)
 	pkt.SetNonce(hdr.Nonce)
 	pkt = pkt.SetCiphertext(ct)
+	s.countTx(16 + len(ct)) // msgtype, index, nonce
 
-	return s.remoteAddr, nil
+	return s.remoteAddr, s.localAddr, nil
 }
 
 func (sm *SessionManager) HandleData(pkt DataPacket) (rovy.PeerID, bool, error) {
@@ -203,23 +342,29 @@ func (sm *SessionManager) HandleData(pkt DataPacket) (rovy.PeerID, bool, error)
 	if !present {
 		return rovy.PeerID{}, firstdata, UnknownIndexError
 	}
-	stage := s.stage
+	stage := s.Stage()
 
+	ct := pkt.Ciphertext()
 	hdr := ikpsk2.MessageHeader{Nonce: pkt.Nonce()}
-	payloadPlain, err := s.handshake.ConsumeMessage(hdr, pkt.Ciphertext())
+	payloadPlain, err := s.handshake.ConsumeMessage(ct[:0], hdr, ct)
 	if err != nil {
+		sm.decryptFailures.Add(1)
 		return rovy.PeerID{}, firstdata, err
 	}
+	s.countRx(16 + len(ct))
 
-	// TODO: instead aead.Open should reuse storage
 	// XXX: why are we discarding the returned Packet?
 	pkt = pkt.SetPlaintext(payloadPlain)
 
-	if stage == 0x03 {
+	if stage == EstablishedStage {
 		return s.remotePeerID, firstdata, nil
 	}
 
-	s.stage = 0x03
-	firstdata = true
+	// packets are decrypted in parallel, only one of them is the first
+	if s.stage.CompareAndSwap(int32(stage), EstablishedStage) {
+		s.established.Store(time.Now().UnixNano())
+		sm.handshakeSuccesses.Add(1)
+		firstdata = true
+	}
 	return s.remotePeerID, firstdata, nil
 }
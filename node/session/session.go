@@ -3,6 +3,8 @@ package session
 import (
 	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	rovy "go.rovy.net"
 	ikpsk2 "go.rovy.net/node/session/ikpsk2"
@@ -20,36 +22,107 @@ const (
 	PlaintextMsgType = 0x5
 )
 
+const (
+	HelloStage       = 0x01
+	ResponseStage    = 0x02
+	EstablishedStage = 0x03
+)
+
+func StageString(stage int) string {
+	switch stage {
+	case HelloStage:
+		return "handshake-hello"
+	case ResponseStage:
+		return "handshake-response"
+	case EstablishedStage:
+		return "established"
+	default:
+		return fmt.Sprintf("unknown-stage-0x%x", stage)
+	}
+}
+
 type Session struct {
 	initiator    bool
-	stage        int
+	stage        atomic.Int32
 	waiters      []chan error
 	handshake    *ikpsk2.Handshake
 	remoteAddr   rovy.Multiaddr
+	localAddr    rovy.Multiaddr
 	remotePeerID rovy.PeerID
+	established  atomic.Int64 // unix nanoseconds
+	lastRecv     atomic.Int64 // unix nanoseconds
+	rxBytes      atomic.Uint64
+	rxPackets    atomic.Uint64
+	txBytes      atomic.Uint64
+	txPackets    atomic.Uint64
+}
+
+// SessionStats is a snapshot of a session's traffic counters.
+// Byte counts are of data packets, including the session header and authentication tag,
+// but not of handshake packets.
+type SessionStats struct {
+	Established time.Time
+	LastRecv    time.Time
+	RxBytes     uint64
+	RxPackets   uint64
+	TxBytes     uint64
+	TxPackets   uint64
 }
 
 func newSession(peerid rovy.PeerID, hs *ikpsk2.Handshake) *Session {
-	return &Session{
+	s := &Session{
 		initiator:    true,
-		stage:        0x01,
 		handshake:    hs,
 		remotePeerID: peerid,
 	}
+	s.stage.Store(HelloStage)
+	return s
 }
 
 func newSessionIncoming(hs *ikpsk2.Handshake) *Session {
-	return &Session{
+	s := &Session{
 		initiator: false,
-		stage:     0x02,
 		handshake: hs,
 	}
+	s.stage.Store(ResponseStage)
+	return s
 }
 
 func (s *Session) RemotePeerID() rovy.PeerID {
 	return s.remotePeerID
 }
 
+func (s *Session) Stage() int {
+	return int(s.stage.Load())
+}
+
+func (s *Session) Stats() SessionStats {
+	st := SessionStats{
+		RxBytes:   s.rxBytes.Load(),
+		RxPackets: s.rxPackets.Load(),
+		TxBytes:   s.txBytes.Load(),
+		TxPackets: s.txPackets.Load(),
+	}
+	if ns := s.established.Load(); ns > 0 {
+		st.Established = time.Unix(0, ns)
+	}
+	if ns := s.lastRecv.Load(); ns > 0 {
+		st.LastRecv = time.Unix(0, ns)
+	}
+	return st
+}
+
+func (s *Session) countRx(n int) {
+	s.rxBytes.Add(uint64(n))
+	s.rxPackets.Add(1)
+	s.lastRecv.Store(time.Now().UnixNano())
+}
+
+func (s *Session) countTx(n int) {
+	s.txBytes.Add(uint64(n))
+	s.txPackets.Add(1)
+}
+
 func (s *Session) RemoteAddr() rovy.Multiaddr {
 	return s.remoteAddr
 }
@@ -58,6 +131,15 @@ func (s *Session) SetRemoteAddr(raddr rovy.Multiaddr) {
 	s.remoteAddr = raddr
 }
 
+// LocalAddr is the address of the transport this session is pinned to.
+func (s *Session) LocalAddr() rovy.Multiaddr {
+	return s.localAddr
+}
+
+func (s *Session) SetLocalAddr(laddr rovy.Multiaddr) {
+	s.localAddr = laddr
+}
+
 func (s *Session) CreateHello(pkt HelloPacket) (HelloPacket, error) {
 	if !s.initiator {
 		return pkt, SessionStateError
@@ -118,7 +200,7 @@ func (s *Session) CreateResponse(pkt ResponsePacket) (ResponsePacket, error) {
 }
 
 func (s *Session) HandleHelloResponse(pkt ResponsePacket) (ResponsePacket, error) {
-	if !s.initiator || s.stage != 0x01 {
+	if !s.initiator || s.Stage() != HelloStage {
 		return pkt, SessionStateError
 	}
 
@@ -143,7 +225,8 @@ func (s *Session) HandleHelloResponse(pkt ResponsePacket) (ResponsePacket, error
 		return pkt, err
 	}
 
-	s.stage = 0x03
+	s.stage.Store(EstablishedStage)
+	s.established.Store(time.Now().UnixNano())
 
 	for _, waiter := range s.waiters {
 		waiter <- nil
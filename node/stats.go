@@ -0,0 +1,81 @@
+package node
+
+import (
+	"fmt"
+
+	rapi "go.rovy.net/api"
+	ringbuf "go.rovy.net/node/util/ringbuf"
+)
+
+// namedQueue is one of the node's queues, as referred to by SetQueueSize and StatsAPI.
+type namedQueue struct {
+	name string
+	rb   **ringbuf.RingBuffer
+}
+
+// CryptoQueueName is the queue in front of the crypto workers.
+// The others are named after the routines which drain them.
+const CryptoQueueName = "crypto"
+
+func (node *Node) queues() []namedQueue {
+	return []namedQueue{
+		{"helloSend", &node.helloSendQ},
+		{"lowerSend", &node.lowerSendQ},
+		{"upperSend", &node.upperSendQ},
+		{"helloRecv", &node.helloRecvQ},
+		{"lowerRecv", &node.lowerRecvQ},
+		{"lowerMux", &node.lowerMuxQ},
+		{"upperRecv", &node.upperRecvQ},
+		{"upperMux", &node.upperMuxQ},
+	}
+}
+
+// SetQueueSize changes the capacity of one of the node's queues,
+// which otherwise is DefaultQueueSize. It can only be done while the node is stopped.
+func (node *Node) SetQueueSize(name string, size int) error {
+	if node.Running() {
+		return ErrRunning
+	}
+	if size < 1 {
+		return fmt.Errorf("invalid size for queue %s: %d", name, size)
+	}
+
+	if name == CryptoQueueName {
+		node.cryptoQ = make(chan *cryptoJob, size)
+		node.cryptoHighWater = ringbuf.HighWater{}
+		return nil
+	}
+	for _, q := range node.queues() {
+		if q.name == name {
+			*q.rb = ringbuf.NewRingBuffer(size)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown queue: %s", name)
+}
+
+type StatsAPI Node
+
+// Queues returns a snapshot of the counters of all of the node's queues.
+func (c *StatsAPI) Queues() ([]rapi.QueueStats, error) {
+	node := (*Node)(c)
+
+	var qs []rapi.QueueStats
+	for _, q := range node.queues() {
+		st := (*q.rb).Stats()
+		qs = append(qs, rapi.QueueStats{
+			Name:      q.name,
+			Length:    st.Length,
+			Capacity:  st.Capacity,
+			HighWater: st.HighWater,
+			Dropped:   st.Dropped,
+		})
+	}
+	qs = append(qs, rapi.QueueStats{
+		Name:      CryptoQueueName,
+		Length:    len(node.cryptoQ),
+		Capacity:  cap(node.cryptoQ),
+		HighWater: node.cryptoHighWater.Load(),
+	})
+	return qs, nil
+}
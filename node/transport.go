@@ -1,12 +1,17 @@
 package node
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/netip"
+	"os"
+	"sync"
+	"time"
 
 	rovy "go.rovy.net"
+	routing "go.rovy.net/node/routing"
+	logging "go.rovy.net/node/util/logging"
 	ringbuf "go.rovy.net/node/util/ringbuf"
 )
 
@@ -19,135 +24,188 @@ import (
 
 const TransportBufferSize = 1024
 
-type Transport struct {
-	conn       *net.UDPConn
-	listenAddr rovy.Multiaddr
-	running    chan int
-	sendQ      *ringbuf.RingBuffer
-	logger     *log.Logger
+// EthernetEtherType is the EtherType of Rovy lower packets on Ethernet,
+// from the range reserved for local experimental use.
+const EthernetEtherType = 0x88b5
+
+// UDP backends, see Node.SetUDPBackend.
+const (
+	UDPBackendStd     = "std"      // UDPTransport
+	UDPBackendIOUring = "io_uring" // IOUringTransport, Linux 6.0 or later
+)
+
+var ErrPacketTooLarge = errors.New("packet exceeds the link MTU")
+
+// PacketTooLargeError is returned by Transport.Send for packets larger than the link MTU,
+// which the node then uses for path MTU discovery.
+type PacketTooLargeError struct {
+	MTU int
 }
 
-func NewTransport(lisaddr rovy.Multiaddr, logger *log.Logger) (*Transport, error) {
-	var network string
-	protos := lisaddr.Protocols()
-	if len(protos) != 2 || protos[1].Code != rovy.UDPMultiaddrCodec {
-		return nil, fmt.Errorf("can't listen on %s", lisaddr)
+func (e PacketTooLargeError) Error() string {
+	return fmt.Sprintf("%s of %d bytes", ErrPacketTooLarge, e.MTU)
+}
+
+func (e PacketTooLargeError) Is(target error) bool {
+	return target == ErrPacketTooLarge
+}
+
+// Transport moves lower packets between the node and the network.
+// Received packets are put on the ring buffer passed to Start,
+// with TptSrc set to the sender's address and TptLocal set to LocalMultiaddr.
+type Transport interface {
+	Start(next *ringbuf.RingBuffer) error
+	Stop() error
+	Running() bool
+	Send(pkt rovy.Packet) error
+
+	// ListenMultiaddr is the address the transport was created with.
+	ListenMultiaddr() rovy.Multiaddr
+	// LocalMultiaddr is the address the transport is bound to,
+	// or the listen address if it isn't running.
+	LocalMultiaddr() rovy.Multiaddr
+	// EffectiveMultiaddrs returns the addresses we're actually reachable at.
+	EffectiveMultiaddrs() ([]rovy.Multiaddr, error)
+}
+
+var _ Transport = &UDPTransport{}
+var _ Transport = &StreamTransport{}
+var _ Transport = &MemoryTransport{}
+
+// NewTransport creates a transport listening on the given address.
+func NewTransport(lisaddr rovy.Multiaddr, logger *logging.Logger) (Transport, error) {
+	if lisaddr.Ifname != "" {
+		return newEthernetTransport(lisaddr, logger)
 	}
-	switch protos[0].Code {
-	case rovy.IP6MultiaddrCodec:
-		network = "udp6"
-	case rovy.IP4MultiaddrCodec:
-		network = "udp4"
-	default:
-		return nil, fmt.Errorf("can't listen on %s", lisaddr)
+	if lisaddr.Memory != "" && !lisaddr.IP.IsValid() && lisaddr.More == nil {
+		return DefaultMemoryNetwork.NewTransport(lisaddr.Memory, logger), nil
 	}
 
-	udpaddr := net.UDPAddrFromAddrPort(lisaddr.AddrPort())
-	conn, err := net.ListenUDP(network, udpaddr)
-	if err != nil {
-		return nil, err
+	switch lisaddr.Tpt {
+	case 0:
+		return NewUDPTransport(lisaddr, logger)
+	case rovy.TCPMultiaddrCodec, rovy.TLSMultiaddrCodec, rovy.WSMultiaddrCodec, rovy.WSSMultiaddrCodec:
+		return NewStreamTransport(lisaddr, logger)
+	default:
+		return nil, fmt.Errorf("can't listen on %s", lisaddr)
 	}
+}
 
-	tpt := &Transport{
-		conn:       conn,
-		listenAddr: lisaddr,
-		sendQ:      ringbuf.NewRingBuffer(TransportBufferSize),
-		logger:     logger,
+// NewDialer creates a transport which doesn't listen,
+// but can connect to addresses of the same kind as raddr.
+// Only connection-oriented transports can do that.
+func NewDialer(raddr rovy.Multiaddr, logger *logging.Logger) (Transport, error) {
+	switch raddr.Tpt {
+	case rovy.TCPMultiaddrCodec, rovy.TLSMultiaddrCodec, rovy.WSMultiaddrCodec, rovy.WSSMultiaddrCodec:
+		return NewStreamDialer(raddr, logger)
+	default:
+		return nil, ErrNoTransport
 	}
-
-	return tpt, nil
 }
 
-// TODO: move ListenUDP call here so we can close the socket in Stop
-func (tpt *Transport) Start(next *ringbuf.RingBuffer) error {
-	if tpt.Running() {
-		return ErrRunning
+// effectiveMultiaddrs expands an address bound to the unspecified IP address
+// to the addresses of all interfaces of the respective address family.
+func effectiveMultiaddrs(laddr rovy.Multiaddr) ([]rovy.Multiaddr, error) {
+	if laddr.Empty() {
+		return nil, nil
+	}
+	if !laddr.IP.IsUnspecified() {
+		return []rovy.Multiaddr{laddr}, nil
 	}
 
-	tpt.running = make(chan int)
-	go tpt.SendRoutine()
-	go tpt.RecvRoutine(next)
-
-	return nil
-}
+	ifaddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
 
-func (tpt *Transport) Stop() error {
-	if !tpt.Running() {
-		return ErrNotRunning
+	var addrs []rovy.Multiaddr
+	for _, ifaddr := range ifaddrs {
+		pref, err := netip.ParsePrefix(ifaddr.String())
+		if err != nil {
+			continue
+		}
+		ip := pref.Addr()
+		if ip.Is4() != laddr.IP.Is4() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		addrs = append(addrs, rovy.Multiaddr{IP: ip, Port: laddr.Port, Tpt: laddr.Tpt})
 	}
+	return addrs, nil
+}
 
-	close(tpt.running)
+// linkMTUs remembers the MTUs of destinations for which the kernel refused a packet
+// with EMSGSIZE. Sending happens asynchronously, so the error can't be returned
+// by Send, but further packets that are too large are rejected with PacketTooLargeError.
+// The MTUs expire like path MTUs, in case they go up again.
+type linkMTUs struct {
+	sync.RWMutex
+	m    map[netip.AddrPort]linkMTU
+	sock *os.File // for udpPathMTU, only used by the send routine
+}
 
-	return nil
+type linkMTU struct {
+	mtu     int
+	expires time.Time
 }
 
-func (tpt *Transport) Running() bool {
-	if tpt.running != nil {
-		select {
-		case <-tpt.running:
-			// we're not running anymore, channel is closed.
-			// the channel is unbuffered, so if we never write anything to it,
-			// then the only way to reach here is if the channel is closed.
-			return false
-		default:
-			return true
+func (lm *linkMTUs) set(dst netip.AddrPort, mtu int) {
+	lm.Lock()
+	defer lm.Unlock()
+
+	now := time.Now()
+	if lm.m == nil {
+		lm.m = map[netip.AddrPort]linkMTU{}
+	}
+	for k, l := range lm.m {
+		if now.After(l.expires) {
+			delete(lm.m, k)
 		}
 	}
-	return false
+	lm.m[dst] = linkMTU{mtu, now.Add(routing.PathMTUTimeout)}
 }
 
-func (tpt *Transport) LocalMultiaddr() rovy.Multiaddr {
-	ip := netip.MustParseAddrPort(tpt.conn.LocalAddr().String())
-	return rovy.Multiaddr{IP: ip.Addr(), Port: ip.Port()}
-}
-
-// TODO can only be stopped by closing the socket
-func (tpt *Transport) RecvRoutine(next *ringbuf.RingBuffer) {
-	for {
-		pkt := rovy.NewPacket(make([]byte, rovy.TptMTU))
+// check returns PacketTooLargeError if the packet exceeds its destination's MTU.
+func (lm *linkMTUs) check(pkt rovy.Packet) error {
+	lm.RLock()
+	defer lm.RUnlock()
 
-		n, raddr, err := tpt.conn.ReadFromUDPAddrPort(pkt.Bytes())
-		if err == net.ErrClosed {
-			tpt.logger.Printf("closing transport recv routine, socket is closed")
-			return
-		}
-		if err != nil {
-			tpt.logger.Printf("RecvRoutine: %s", err)
-			continue
-		}
+	if len(lm.m) == 0 {
+		return nil
+	}
+	l, present := lm.m[pkt.TptDst.AddrPort()]
+	if present && pkt.Length > l.mtu && time.Now().Before(l.expires) {
+		return PacketTooLargeError{MTU: l.mtu}
+	}
+	return nil
+}
 
-		pkt.Length = n
-		pkt.TptSrc = rovy.Multiaddr{IP: raddr.Addr(), Port: raddr.Port()}
-		next.Put(pkt)
+// openUDP creates the socket for learnUDP. It has to happen along with
+// the transport's socket, so that they're in the same network namespace.
+func (lm *linkMTUs) openUDP(network string, logger *logging.Logger) {
+	sock, err := newUDPMTUSocket(network)
+	if err != nil {
+		logger.Warn("Start: can't create socket for path MTUs", "err", err)
 	}
+	lm.sock = sock
 }
 
-func (tpt *Transport) SendRoutine() {
-	for {
-		select {
-		case <-tpt.running:
-			return
-		case pkt := <-tpt.sendQ.Channel():
-			if pkt.TptDst.Empty() {
-				tpt.logger.Printf("SendRoutine: dropping packet without TptSrc")
-				continue
-			}
-
-			// tpt.logger.Printf("SendRoutine: writeTo: TptDst=%+v LowerDst=%+v UpperDst=%+v", pkt.TptDst, pkt.LowerDst, pkt.UpperDst)
-
-			_, err := tpt.conn.WriteToUDPAddrPort(pkt.Bytes(), pkt.TptDst.AddrPort())
-			if err == net.ErrClosed {
-				tpt.logger.Printf("closing transport send routine, socket is closed")
-				return
-			}
-			if err != nil {
-				tpt.logger.Printf("SendRoutine: %s", err)
-			}
-		}
+func (lm *linkMTUs) close() {
+	if lm.sock != nil {
+		lm.sock.Close()
+		lm.sock = nil
 	}
 }
 
-func (tpt *Transport) Send(pkt rovy.Packet) error {
-	tpt.sendQ.PutWithBackpressure(pkt)
-	return nil
+// learnUDP asks the kernel for the path MTU to dst after a send failed with EMSGSIZE.
+func (lm *linkMTUs) learnUDP(dst netip.AddrPort, logger *logging.Logger) {
+	if lm.sock == nil {
+		return
+	}
+	mtu, err := udpPathMTU(lm.sock, dst)
+	if err != nil {
+		logger.Warn("SendRoutine: can't get path MTU", "dst", dst, "err", err)
+		return
+	}
+	lm.set(dst, mtu)
+	logger.Debug("SendRoutine: packet too large", "dst", dst, "mtu", mtu)
 }
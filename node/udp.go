@@ -0,0 +1,480 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	ipv4 "golang.org/x/net/ipv4"
+	ipv6 "golang.org/x/net/ipv6"
+
+	rovy "go.rovy.net"
+	logging "go.rovy.net/node/util/logging"
+	ringbuf "go.rovy.net/node/util/ringbuf"
+)
+
+// UDPBatchSize is the maximum number of messages read or written per syscall.
+const UDPBatchSize = 64
+
+// UDPSocketBufferSize is what we ask the kernel for as the socket's
+// send and receive buffer, so that bursts don't overflow the defaults.
+// It's capped at net.core.rmem_max and wmem_max.
+const UDPSocketBufferSize = 4 << 20
+
+// udpMaxGSOSegments is the kernel's UDP_MAX_SEGMENTS, and udpMaxGSOSize
+// stays clear of the maximum UDP payload for both IPv4 and IPv6.
+const udpMaxGSOSegments = 64
+const udpMaxGSOSize = 65000
+
+// UDPTransport sends and receives packets on a UDP socket.
+//
+// On Linux, it reads and writes in batches using recvmmsg and sendmmsg.
+// If the kernel supports it, consecutive packets to the same destination are
+// handed over as one large message which the kernel segments (UDP GSO),
+// and received datagrams from the same sender can arrive coalesced (UDP GRO).
+// Elsewhere, or if GSO fails for a route, it falls back to one packet per syscall.
+//
+// On Linux, packets aren't fragmented. If one exceeds the path MTU that the kernel knows of,
+// further packets to that destination which are too large fail with PacketTooLargeError.
+type UDPTransport struct {
+	sync.Mutex
+	conn       *net.UDPConn
+	network    string
+	listenAddr rovy.Multiaddr
+	localAddr  rovy.Multiaddr
+	running    chan int
+	routines   sync.WaitGroup
+	sendQ      *ringbuf.RingBuffer
+	logger     *logging.Logger
+	batchSize  int
+	mtus       linkMTUs
+}
+
+// NewUDPTransport only checks the listen address,
+// the socket is bound once the transport is started.
+func NewUDPTransport(lisaddr rovy.Multiaddr, logger *logging.Logger) (*UDPTransport, error) {
+	network, err := udpNetwork(lisaddr)
+	if err != nil {
+		return nil, err
+	}
+
+	tpt := &UDPTransport{
+		network:    network,
+		listenAddr: lisaddr,
+		sendQ:      ringbuf.NewRingBuffer(TransportBufferSize),
+		logger:     logger,
+		batchSize:  UDPBatchSize,
+	}
+
+	return tpt, nil
+}
+
+// udpNetwork checks that lisaddr is an /ip4/.../udp/... or /ip6/.../udp/... address.
+func udpNetwork(lisaddr rovy.Multiaddr) (string, error) {
+	protos := lisaddr.Protocols()
+	if len(protos) != 2 || protos[1].Code != rovy.UDPMultiaddrCodec {
+		return "", fmt.Errorf("can't listen on %s", lisaddr)
+	}
+	switch protos[0].Code {
+	case rovy.IP6MultiaddrCodec:
+		return "udp6", nil
+	case rovy.IP4MultiaddrCodec:
+		return "udp4", nil
+	default:
+		return "", fmt.Errorf("can't listen on %s", lisaddr)
+	}
+}
+
+// listenUDP binds the socket, and returns the address it's bound to.
+func listenUDP(network string, lisaddr rovy.Multiaddr, logger *logging.Logger) (*net.UDPConn, rovy.Multiaddr, error) {
+	udpaddr := net.UDPAddrFromAddrPort(lisaddr.AddrPort())
+	conn, err := net.ListenUDP(network, udpaddr)
+	if err != nil {
+		return nil, rovy.Multiaddr{}, err
+	}
+	laddr := rovy.FromAddrPort(netip.MustParseAddrPort(conn.LocalAddr().String()))
+
+	if err := conn.SetReadBuffer(UDPSocketBufferSize); err != nil {
+		logger.Warn("Start: SetReadBuffer", "err", err)
+	}
+	if err := conn.SetWriteBuffer(UDPSocketBufferSize); err != nil {
+		logger.Warn("Start: SetWriteBuffer", "err", err)
+	}
+	if err := udpSetDontFragment(conn, network); err != nil {
+		logger.Warn("Start: don't fragment", "err", err)
+	}
+	return conn, laddr, nil
+}
+
+// SetBatchSize limits the number of messages per syscall, at most UDPBatchSize.
+// With 1, every packet gets its own syscall, and there's no GSO or GRO.
+// It takes effect the next time the transport is started.
+func (tpt *UDPTransport) SetBatchSize(n int) error {
+	if n < 1 || n > UDPBatchSize {
+		return fmt.Errorf("batch size out of range: %d", n)
+	}
+	tpt.Lock()
+	defer tpt.Unlock()
+	tpt.batchSize = n
+	return nil
+}
+
+func (tpt *UDPTransport) Start(next *ringbuf.RingBuffer) error {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if tpt.Running() {
+		return ErrRunning
+	}
+
+	conn, laddr, err := listenUDP(tpt.network, tpt.listenAddr, tpt.logger)
+	if err != nil {
+		return err
+	}
+	tpt.conn = conn
+	tpt.localAddr = laddr
+	tpt.mtus.openUDP(tpt.network, tpt.logger)
+
+	gso, gro := false, false
+	if tpt.batchSize > 1 {
+		gso = udpGSOSupported(conn)
+		gro = udpEnableGRO(conn)
+	}
+
+	tpt.running = make(chan int)
+	tpt.routines.Add(2)
+	go tpt.SendRoutine(conn, gso, tpt.batchSize)
+	go tpt.RecvRoutine(conn, gro, tpt.localAddr, next, tpt.batchSize)
+
+	return nil
+}
+
+// Stop closes the socket and waits for the send and receive routines to return.
+func (tpt *UDPTransport) Stop() error {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if !tpt.Running() {
+		return ErrNotRunning
+	}
+
+	close(tpt.running)
+	err := tpt.conn.Close()
+	tpt.routines.Wait()
+	tpt.mtus.close()
+
+	tpt.conn = nil
+	tpt.localAddr = rovy.Multiaddr{}
+	return err
+}
+
+func (tpt *UDPTransport) Running() bool {
+	if tpt.running != nil {
+		select {
+		case <-tpt.running:
+			// we're not running anymore, channel is closed.
+			// the channel is unbuffered, so if we never write anything to it,
+			// then the only way to reach here is if the channel is closed.
+			return false
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (tpt *UDPTransport) ListenMultiaddr() rovy.Multiaddr {
+	return tpt.listenAddr
+}
+
+// LocalMultiaddr returns the address of the bound socket,
+// or the listen address if the transport isn't running.
+func (tpt *UDPTransport) LocalMultiaddr() rovy.Multiaddr {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if tpt.localAddr.Empty() {
+		return tpt.listenAddr
+	}
+	return tpt.localAddr
+}
+
+func (tpt *UDPTransport) EffectiveMultiaddrs() ([]rovy.Multiaddr, error) {
+	tpt.Lock()
+	laddr := tpt.localAddr
+	tpt.Unlock()
+
+	return effectiveMultiaddrs(laddr)
+}
+
+// batchConn reads and writes multiple messages at once.
+// Both ipv4.PacketConn and ipv6.PacketConn implement it, their Message types are the same.
+type batchConn interface {
+	batchReader
+	WriteBatch(ms []ipv6.Message, flags int) (int, error)
+}
+
+// batchReader is the reading half of batchConn. On some platforms,
+// newBatchReader has its own implementation which doesn't allocate.
+type batchReader interface {
+	ReadBatch(ms []ipv6.Message, flags int) (int, error)
+}
+
+func (tpt *UDPTransport) batchConn(conn *net.UDPConn) batchConn {
+	if tpt.network == "udp4" {
+		return ipv4.NewPacketConn(conn)
+	}
+	return ipv6.NewPacketConn(conn)
+}
+
+func (tpt *UDPTransport) RecvRoutine(conn *net.UDPConn, gro bool, laddr rovy.Multiaddr, next *ringbuf.RingBuffer, batchSize int) {
+	defer tpt.routines.Done()
+
+	bconn := newBatchReader(conn, tpt.batchConn(conn))
+
+	// with GRO, a message can hold several datagrams of the same size
+	bufsize := rovy.TptMTU
+	if gro {
+		bufsize = udpMaxGSOSize
+	}
+	msgs := make([]ipv6.Message, batchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, bufsize)}
+		if gro {
+			msgs[i].OOB = make([]byte, udpControlSize)
+		}
+	}
+
+	for {
+		n, err := bconn.ReadBatch(msgs, 0)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			tpt.logger.Warn("RecvRoutine", "err", err)
+			continue
+		}
+
+		for _, msg := range msgs[:n] {
+			uaddr, ok := msg.Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			raddr := uaddr.AddrPort()
+			tptsrc := rovy.Multiaddr{IP: raddr.Addr().Unmap(), Port: raddr.Port()}
+
+			buf := msg.Buffers[0][:msg.N]
+			segsize := len(buf)
+			if gro {
+				if size := udpGROSegmentSize(msg.OOB[:msg.NN]); size > 0 {
+					segsize = size
+				}
+			}
+
+			for len(buf) > 0 {
+				seglen := segsize
+				if seglen > len(buf) {
+					seglen = len(buf)
+				}
+				seg := buf[:seglen]
+				buf = buf[seglen:]
+
+				if seglen > rovy.TptMTU {
+					tpt.logger.Warn("RecvRoutine: dropping oversized datagram", "src", tptsrc)
+					continue
+				}
+
+				pkt := rovy.AllocPacket()
+				pkt.Length = copy(pkt.Buf, seg)
+				pkt.TptSrc = tptsrc
+				pkt.TptLocal = laddr
+				next.Put(pkt)
+			}
+		}
+	}
+}
+
+// udpSendBatch holds SendRoutine's buffers, which are reused for every batch.
+type udpSendBatch struct {
+	pkts  []rovy.Packet
+	msgs  []ipv6.Message
+	addrs []net.UDPAddr
+	oobs  [][]byte
+}
+
+func newUDPSendBatch() *udpSendBatch {
+	b := &udpSendBatch{
+		pkts:  make([]rovy.Packet, 0, UDPBatchSize),
+		msgs:  make([]ipv6.Message, UDPBatchSize),
+		addrs: make([]net.UDPAddr, UDPBatchSize),
+		oobs:  make([][]byte, UDPBatchSize),
+	}
+	for i := range b.addrs {
+		b.addrs[i].IP = make(net.IP, 0, net.IPv6len)
+		b.oobs[i] = make([]byte, udpControlSize)
+	}
+	return b
+}
+
+// setAddr is net.UDPAddrFromAddrPort, but reuses the i-th address.
+func (b *udpSendBatch) setAddr(i int, ap netip.AddrPort) *net.UDPAddr {
+	ua := &b.addrs[i]
+	if ap.Addr().Is4() {
+		ip := ap.Addr().As4()
+		ua.IP = append(ua.IP[:0], ip[:]...)
+	} else {
+		ip := ap.Addr().As16()
+		ua.IP = append(ua.IP[:0], ip[:]...)
+	}
+	ua.Port = int(ap.Port())
+	ua.Zone = ap.Addr().Zone()
+	return ua
+}
+
+func (tpt *UDPTransport) SendRoutine(conn *net.UDPConn, gso bool, batchSize int) {
+	defer tpt.routines.Done()
+
+	bconn := tpt.batchConn(conn)
+	b := newUDPSendBatch()
+
+	for {
+		select {
+		case <-tpt.running:
+			return
+		case pkt := <-tpt.sendQ.Channel():
+			b.pkts = append(b.pkts[:0], pkt)
+		}
+
+		// take whatever else is queued already, without waiting for more
+	drain:
+		for len(b.pkts) < batchSize {
+			select {
+			case pkt := <-tpt.sendQ.Channel():
+				b.pkts = append(b.pkts, pkt)
+			default:
+				break drain
+			}
+		}
+
+		batch := tpt.batchMessages(b, gso)
+		gsoFailed, err := tpt.writeBatch(conn, bconn, batch)
+		for _, pkt := range b.pkts {
+			pkt.Release()
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if gsoFailed {
+			tpt.logger.Info("SendRoutine: disabling GSO")
+			gso = false
+		}
+	}
+}
+
+// batchMessages turns the packets into messages. With GSO, consecutive packets
+// to the same destination go into one message, as long as they have the size
+// of the message's first packet. Only the last one may be shorter.
+func (tpt *UDPTransport) batchMessages(b *udpSendBatch, gso bool) []ipv6.Message {
+	var dst rovy.Multiaddr
+	var segsize, total int
+	batch := b.msgs[:0]
+
+	for _, pkt := range b.pkts {
+		if pkt.TptDst.Empty() {
+			tpt.logger.Warn("SendRoutine: dropping packet without TptDst")
+			continue
+		}
+
+		if gso && len(batch) > 0 && pkt.TptDst == dst {
+			msg := &batch[len(batch)-1]
+			segs := len(msg.Buffers)
+			lastlen := len(msg.Buffers[segs-1])
+			if lastlen == segsize && pkt.Length <= segsize && segs < udpMaxGSOSegments && total+pkt.Length <= udpMaxGSOSize {
+				msg.Buffers = append(msg.Buffers, pkt.Bytes())
+				msg.OOB = udpGSOControl(b.oobs[len(batch)-1], segsize)
+				total += pkt.Length
+				continue
+			}
+		}
+
+		dst = pkt.TptDst
+		segsize = pkt.Length
+		total = pkt.Length
+		i := len(batch)
+		batch = batch[:i+1]
+		batch[i] = ipv6.Message{
+			Buffers: append(batch[i].Buffers[:0], pkt.Bytes()),
+			Addr:    b.setAddr(i, pkt.TptDst.AddrPort()),
+		}
+	}
+	return batch
+}
+
+// writeBatch writes all messages, skipping those that fail.
+// If a GSO message fails, its packets are resent one by one, and gsoFailed
+// tells whether it's because the kernel can't do GSO on this socket at all.
+// The only error returned is net.ErrClosed.
+func (tpt *UDPTransport) writeBatch(conn *net.UDPConn, bconn batchConn, batch []ipv6.Message) (gsoFailed bool, _ error) {
+	for len(batch) > 0 {
+		n, err := bconn.WriteBatch(batch, 0)
+		if errors.Is(err, net.ErrClosed) {
+			return gsoFailed, err
+		}
+		if n < 0 {
+			n = 0
+		}
+		if err == nil {
+			batch = batch[n:]
+			continue
+		}
+
+		msg := batch[n]
+		batch = batch[n+1:]
+		if len(msg.Buffers) == 1 {
+			tpt.sendFailed(msg.Addr, err)
+			continue
+		}
+
+		if udpGSOFailed(err) {
+			gsoFailed = true
+		}
+		for _, buf := range msg.Buffers {
+			_, err := conn.WriteTo(buf, msg.Addr)
+			if errors.Is(err, net.ErrClosed) {
+				return gsoFailed, err
+			}
+			if err != nil {
+				tpt.sendFailed(msg.Addr, err)
+			}
+		}
+	}
+	return gsoFailed, nil
+}
+
+// sendFailed learns the path MTU if the packet was too large, and logs other errors.
+func (tpt *UDPTransport) sendFailed(addr net.Addr, err error) {
+	if ua, ok := addr.(*net.UDPAddr); ok && isEMSGSIZE(err) {
+		tpt.mtus.learnUDP(ua.AddrPort(), tpt.logger)
+		return
+	}
+	tpt.logger.Warn("SendRoutine", "err", err)
+}
+
+func (tpt *UDPTransport) Send(pkt rovy.Packet) error {
+	if err := tpt.mtus.check(pkt); err != nil {
+		pkt.Release()
+		return err
+	}
+
+	tpt.Lock()
+	running := tpt.running
+	tpt.Unlock()
+
+	if !tpt.Running() || !tpt.sendQ.PutWithBackpressureUntil(pkt, running) {
+		pkt.Release()
+		return ErrNotRunning
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package node
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// UDP_SEGMENT and UDP_GRO from linux/udp.h
+const (
+	udpSegment = 103
+	udpGRO     = 104
+)
+
+// udpControlSize fits one UDP_SEGMENT or UDP_GRO control message.
+var udpControlSize = unix.CmsgSpace(4)
+
+// udpGSOSupported tells whether the kernel knows UDP_SEGMENT, i.e. Linux 4.18 and later.
+func udpGSOSupported(conn *net.UDPConn) bool {
+	rawconn, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var serr error
+	err = rawconn.Control(func(fd uintptr) {
+		_, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, udpSegment)
+	})
+	return err == nil && serr == nil
+}
+
+// udpEnableGRO lets the kernel coalesce received datagrams, on Linux 5.0 and later.
+func udpEnableGRO(conn *net.UDPConn) bool {
+	rawconn, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var serr error
+	err = rawconn.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_UDP, udpGRO, 1)
+	})
+	return err == nil && serr == nil
+}
+
+// udpGSOControl writes the UDP_SEGMENT control message into oob.
+func udpGSOControl(oob []byte, segsize int) []byte {
+	oob = oob[:unix.CmsgSpace(2)]
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	hdr.Level = unix.IPPROTO_UDP
+	hdr.Type = udpSegment
+	hdr.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&oob[unix.CmsgLen(0)])) = uint16(segsize)
+	return oob
+}
+
+// udpGROSegmentSize returns the size of the datagrams coalesced into a message,
+// or 0 if it's just a single datagram.
+func udpGROSegmentSize(oob []byte) int {
+	cmsgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, cmsg := range cmsgs {
+		if cmsg.Header.Level == unix.IPPROTO_UDP && cmsg.Header.Type == udpGRO && len(cmsg.Data) >= 4 {
+			return int(*(*int32)(unsafe.Pointer(&cmsg.Data[0])))
+		}
+	}
+	return 0
+}
+
+// udpGSOFailed tells whether the kernel refused a GSO send because the
+// outgoing device can't do checksum offloading, which affects all further sends.
+func udpGSOFailed(err error) bool {
+	var serr *os.SyscallError
+	return errors.As(err, &serr) && serr.Err == unix.EIO
+}
+
+// udpSetDontFragment makes the kernel refuse packets that exceed the path MTU
+// with EMSGSIZE, instead of fragmenting them, so that we learn about the MTU.
+func udpSetDontFragment(conn *net.UDPConn, network string) error {
+	rawconn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rawconn.Control(func(fd uintptr) {
+		if network == "udp4" {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_DO)
+		} else {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_DONTFRAG, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// newUDPMTUSocket returns an unbound socket for udpPathMTU.
+func newUDPMTUSocket(network string) (*os.File, error) {
+	family := unix.AF_INET6
+	if network == "udp4" {
+		family = unix.AF_INET
+	}
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), network+"-mtu"), nil
+}
+
+// udpPathMTU returns the kernel's path MTU to dst, in bytes of UDP payload.
+// It connects the socket to dst first, because an unconnected socket doesn't have a path.
+func udpPathMTU(sock *os.File, dst netip.AddrPort) (int, error) {
+	rawconn, err := sock.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var mtu int
+	var serr error
+	err = rawconn.Control(func(fd uintptr) {
+		if dst.Addr().Is4() {
+			sa := &unix.SockaddrInet4{Port: int(dst.Port()), Addr: dst.Addr().As4()}
+			if serr = unix.Connect(int(fd), sa); serr == nil {
+				mtu, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU)
+				mtu -= 20 + 8
+			}
+			return
+		}
+		sa := &unix.SockaddrInet6{Port: int(dst.Port()), Addr: dst.Addr().As16()}
+		if zone := dst.Addr().Zone(); zone != "" {
+			if iface, err := net.InterfaceByName(zone); err == nil {
+				sa.ZoneId = uint32(iface.Index)
+			}
+		}
+		if serr = unix.Connect(int(fd), sa); serr == nil {
+			mtu, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU)
+			mtu -= 40 + 8
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return mtu, serr
+}
+
+// isEMSGSIZE tells whether the kernel refused a packet for exceeding the path MTU.
+func isEMSGSIZE(err error) bool {
+	return errors.Is(err, unix.EMSGSIZE)
+}
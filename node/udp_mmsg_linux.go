@@ -0,0 +1,146 @@
+//go:build linux && (amd64 || arm64)
+
+package node
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	ipv6 "golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr is struct mmsghdr from sys/socket.h
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// udpBatchReader calls recvmmsg by itself, since x/net allocates a new address
+// for every message it reads. The addresses it hands out are only valid
+// until the next ReadBatch call.
+type udpBatchReader struct {
+	rawconn syscall.RawConn
+	hdrs    []mmsghdr
+	iovs    []unix.Iovec
+	names   []unix.RawSockaddrInet6
+	addrs   []net.UDPAddr
+	zones   map[uint32]string
+
+	// state of the recvmmsg call, so that recvmmsgF doesn't need a closure
+	readF func(fd uintptr) bool
+	count int
+	flags int
+	n     int
+	err   error
+}
+
+func newBatchReader(conn *net.UDPConn, bconn batchConn) batchReader {
+	rawconn, err := conn.SyscallConn()
+	if err != nil {
+		return bconn
+	}
+
+	r := &udpBatchReader{
+		rawconn: rawconn,
+		hdrs:    make([]mmsghdr, UDPBatchSize),
+		iovs:    make([]unix.Iovec, UDPBatchSize),
+		names:   make([]unix.RawSockaddrInet6, UDPBatchSize),
+		addrs:   make([]net.UDPAddr, UDPBatchSize),
+		zones:   map[uint32]string{},
+	}
+	for i := range r.addrs {
+		r.addrs[i].IP = make(net.IP, 0, net.IPv6len)
+	}
+	r.readF = r.recvmmsgF
+	return r
+}
+
+func (r *udpBatchReader) recvmmsgF(fd uintptr) bool {
+	n, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&r.hdrs[0])), uintptr(r.count), uintptr(r.flags), 0, 0)
+	if errno == unix.EAGAIN || errno == unix.EINTR {
+		return false
+	}
+	r.n, r.err = int(n), nil
+	if errno != 0 {
+		r.n, r.err = 0, os.NewSyscallError("recvmmsg", errno)
+	}
+	return true
+}
+
+func (r *udpBatchReader) ReadBatch(ms []ipv6.Message, flags int) (int, error) {
+	if len(ms) > len(r.hdrs) {
+		ms = ms[:len(r.hdrs)]
+	}
+	for i := range ms {
+		buf := ms[i].Buffers[0]
+		r.iovs[i].Base = &buf[0]
+		r.iovs[i].SetLen(len(buf))
+
+		hdr := &r.hdrs[i].hdr
+		*hdr = unix.Msghdr{
+			Name:    (*byte)(unsafe.Pointer(&r.names[i])),
+			Namelen: unix.SizeofSockaddrInet6,
+			Iov:     &r.iovs[i],
+		}
+		hdr.SetIovlen(1)
+		if len(ms[i].OOB) > 0 {
+			hdr.Control = &ms[i].OOB[0]
+			hdr.SetControllen(len(ms[i].OOB))
+		}
+	}
+
+	r.count, r.flags = len(ms), flags
+	if err := r.rawconn.Read(r.readF); err != nil {
+		return 0, err
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	for i := 0; i < r.n; i++ {
+		ms[i].N = int(r.hdrs[i].len)
+		ms[i].NN = int(r.hdrs[i].hdr.Controllen)
+		ms[i].Flags = int(r.hdrs[i].hdr.Flags)
+		ms[i].Addr = r.parseAddr(i)
+	}
+	return r.n, nil
+}
+
+// parseAddr fills the i-th address from the i-th sockaddr.
+func (r *udpBatchReader) parseAddr(i int) *net.UDPAddr {
+	ua := &r.addrs[i]
+	name := &r.names[i]
+	port := (*[2]byte)(unsafe.Pointer(&name.Port))
+	ua.Port = int(binary.BigEndian.Uint16(port[:]))
+	ua.Zone = ""
+
+	switch name.Family {
+	case unix.AF_INET:
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(name))
+		ua.IP = append(ua.IP[:0], sa4.Addr[:]...)
+	case unix.AF_INET6:
+		ua.IP = append(ua.IP[:0], name.Addr[:]...)
+		if name.Scope_id != 0 {
+			ua.Zone = r.zone(name.Scope_id)
+		}
+	default:
+		ua.IP = ua.IP[:0]
+	}
+	return ua
+}
+
+func (r *udpBatchReader) zone(index uint32) string {
+	if zone, present := r.zones[index]; present {
+		return zone
+	}
+	zone := ""
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		zone = iface.Name
+	}
+	r.zones[index] = zone
+	return zone
+}
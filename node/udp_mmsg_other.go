@@ -0,0 +1,11 @@
+//go:build !linux || !(amd64 || arm64)
+
+package node
+
+import (
+	"net"
+)
+
+func newBatchReader(conn *net.UDPConn, bconn batchConn) batchReader {
+	return bconn
+}
@@ -0,0 +1,48 @@
+//go:build !linux
+
+package node
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+)
+
+var udpControlSize = 0
+
+func udpGSOSupported(conn *net.UDPConn) bool {
+	return false
+}
+
+func udpEnableGRO(conn *net.UDPConn) bool {
+	return false
+}
+
+func udpGSOControl(oob []byte, segsize int) []byte {
+	return nil
+}
+
+func udpGROSegmentSize(oob []byte) int {
+	return 0
+}
+
+func udpGSOFailed(err error) bool {
+	return false
+}
+
+func udpSetDontFragment(conn *net.UDPConn, network string) error {
+	return nil
+}
+
+func newUDPMTUSocket(network string) (*os.File, error) {
+	return nil, errors.New("not supported")
+}
+
+func udpPathMTU(sock *os.File, dst netip.AddrPort) (int, error) {
+	return 0, errors.New("not supported")
+}
+
+func isEMSGSIZE(err error) bool {
+	return false
+}
@@ -0,0 +1,579 @@
+//go:build linux && (amd64 || arm64)
+
+package node
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	rovy "go.rovy.net"
+	logging "go.rovy.net/node/util/logging"
+	ringbuf "go.rovy.net/node/util/ringbuf"
+)
+
+var _ Transport = &IOUringTransport{}
+
+func newIOUringTransport(lisaddr rovy.Multiaddr, logger *logging.Logger) (Transport, error) {
+	return NewIOUringTransport(lisaddr, logger)
+}
+
+// uringRecvBuffers is the number of buffers in the receive buffer ring,
+// which is how many datagrams can be pending before we process them.
+const uringRecvBuffers = 256
+
+// uringNameLen is the name area in each receive buffer, large enough for IPv6.
+const uringNameLen = unix.SizeofSockaddrInet6
+
+// uringRecvOffset is where the name area starts in a receive buffer,
+// after the recvmsg header. The payload follows the name area.
+const uringRecvOffset = int(unsafe.Sizeof(uringRecvmsgOut{}))
+
+// The recvmsg and its cancellation are told apart by their user data.
+const (
+	uringRecvUserData   = 1
+	uringCancelUserData = 2
+)
+
+// uringRecvTimeout is how long RecvRoutine waits for completions
+// before checking whether the transport got stopped.
+const uringRecvTimeout = 100 * time.Millisecond
+
+// IOUringTransport sends and receives packets on a UDP socket, like UDPTransport,
+// but does the socket I/O through io_uring instead of the runtime's netpoller.
+//
+// Receiving is a single multishot recvmsg, which keeps completing for every
+// datagram into buffers picked from a ring registered with the kernel,
+// so there's no syscall per packet or batch of packets, only for waiting.
+// Sending submits one sendmsg per packet, a batch at a time.
+//
+// It needs Linux 6.0 or later, see IOUringSupported.
+type IOUringTransport struct {
+	sync.Mutex
+	conn       *net.UDPConn
+	network    string
+	listenAddr rovy.Multiaddr
+	localAddr  rovy.Multiaddr
+	running    chan int
+	routines   sync.WaitGroup
+	sendQ      *ringbuf.RingBuffer
+	logger     *logging.Logger
+	mtus       linkMTUs
+}
+
+// NewIOUringTransport only checks the listen address,
+// the socket is bound once the transport is started.
+func NewIOUringTransport(lisaddr rovy.Multiaddr, logger *logging.Logger) (*IOUringTransport, error) {
+	network, err := udpNetwork(lisaddr)
+	if err != nil {
+		return nil, err
+	}
+
+	tpt := &IOUringTransport{
+		network:    network,
+		listenAddr: lisaddr,
+		sendQ:      ringbuf.NewRingBuffer(TransportBufferSize),
+		logger:     logger,
+	}
+	return tpt, nil
+}
+
+// Start binds the socket and sets up one io_uring for receiving and one for sending,
+// each of which is used only by its routine.
+func (tpt *IOUringTransport) Start(next *ringbuf.RingBuffer) error {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if tpt.Running() {
+		return ErrRunning
+	}
+	if err := IOUringSupported(); err != nil {
+		return err
+	}
+
+	conn, laddr, err := listenUDP(tpt.network, tpt.listenAddr, tpt.logger)
+	if err != nil {
+		return err
+	}
+	fd, err := socketFd(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	recv, err := newUringReceiver(fd)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	send, err := newUringSender(fd, tpt.network)
+	if err != nil {
+		recv.close()
+		conn.Close()
+		return err
+	}
+
+	tpt.conn = conn
+	tpt.localAddr = laddr
+	tpt.mtus.openUDP(tpt.network, tpt.logger)
+
+	tpt.running = make(chan int)
+	tpt.routines.Add(2)
+	go tpt.SendRoutine(send)
+	go tpt.RecvRoutine(recv, laddr, next)
+
+	return nil
+}
+
+func socketFd(conn *net.UDPConn) (int, error) {
+	rawconn, err := conn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var fd int
+	err = rawconn.Control(func(fdptr uintptr) {
+		fd = int(fdptr)
+	})
+	return fd, err
+}
+
+// Stop waits for the send and receive routines to return, and then closes the socket.
+// The socket stays open until then, because the rings still refer to its fd.
+func (tpt *IOUringTransport) Stop() error {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if !tpt.Running() {
+		return ErrNotRunning
+	}
+
+	close(tpt.running)
+	tpt.routines.Wait()
+	tpt.mtus.close()
+	err := tpt.conn.Close()
+
+	tpt.conn = nil
+	tpt.localAddr = rovy.Multiaddr{}
+	return err
+}
+
+func (tpt *IOUringTransport) Running() bool {
+	if tpt.running != nil {
+		select {
+		case <-tpt.running:
+			// we're not running anymore, channel is closed.
+			// the channel is unbuffered, so if we never write anything to it,
+			// then the only way to reach here is if the channel is closed.
+			return false
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (tpt *IOUringTransport) ListenMultiaddr() rovy.Multiaddr {
+	return tpt.listenAddr
+}
+
+// LocalMultiaddr returns the address of the bound socket,
+// or the listen address if the transport isn't running.
+func (tpt *IOUringTransport) LocalMultiaddr() rovy.Multiaddr {
+	tpt.Lock()
+	defer tpt.Unlock()
+
+	if tpt.localAddr.Empty() {
+		return tpt.listenAddr
+	}
+	return tpt.localAddr
+}
+
+func (tpt *IOUringTransport) EffectiveMultiaddrs() ([]rovy.Multiaddr, error) {
+	tpt.Lock()
+	laddr := tpt.localAddr
+	tpt.Unlock()
+
+	return effectiveMultiaddrs(laddr)
+}
+
+func (tpt *IOUringTransport) Send(pkt rovy.Packet) error {
+	if err := tpt.mtus.check(pkt); err != nil {
+		pkt.Release()
+		return err
+	}
+
+	tpt.Lock()
+	running := tpt.running
+	tpt.Unlock()
+
+	if !tpt.Running() || !tpt.sendQ.PutWithBackpressureUntil(pkt, running) {
+		pkt.Release()
+		return ErrNotRunning
+	}
+	return nil
+}
+
+// uringReceiver holds the receive ring, its buffers, and the multishot recvmsg's msghdr.
+type uringReceiver struct {
+	ring  *uring
+	bufs  *uringBufRing
+	fd    int
+	msg   unix.Msghdr
+	zones map[uint32]string
+}
+
+func newUringReceiver(fd int) (*uringReceiver, error) {
+	// multishot completions pile up while we're busy, so the completion queue,
+	// which is twice the size of the submission queue, fits one for every buffer
+	ring, err := newUring(uringRecvBuffers / 2)
+	if err != nil {
+		return nil, err
+	}
+	bufs, err := newUringBufRing(ring, 0, uringRecvBuffers, uringRecvOffset+uringNameLen+rovy.TptMTU)
+	if err != nil {
+		ring.close()
+		return nil, err
+	}
+
+	r := &uringReceiver{ring: ring, bufs: bufs, fd: fd, zones: map[uint32]string{}}
+	r.msg.Namelen = uringNameLen
+	return r, nil
+}
+
+func (r *uringReceiver) close() {
+	r.ring.close()
+	r.bufs.close()
+}
+
+// arm submits the multishot recvmsg. It needs rearming whenever
+// a completion comes without uringCQEFMore, e.g. if we ran out of buffers.
+func (r *uringReceiver) arm() {
+	sqe := r.ring.sqe()
+	sqe.opcode = uringOpRecvmsg
+	sqe.fd = int32(r.fd)
+	sqe.addr = uint64(uintptr(unsafe.Pointer(&r.msg)))
+	sqe.len = 1
+	sqe.flags = uringSQEBufferSelect
+	sqe.ioprio = uringRecvMultishot
+	sqe.bufGroup = r.bufs.bgid
+	sqe.userData = uringRecvUserData
+}
+
+// cancel stops the multishot recvmsg, and waits for its last completion.
+// Closing the ring would do that too, but in the background, and the socket
+// can't be bound again until the request lets go of it.
+func (r *uringReceiver) cancel() {
+	sqe := r.ring.sqe()
+	if sqe == nil {
+		return
+	}
+	sqe.opcode = uringOpAsyncCancel
+	sqe.addr = uringRecvUserData
+	sqe.userData = uringCancelUserData
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if err := r.ring.enter(1, uringRecvTimeout); err != nil {
+			return
+		}
+		for cqe := r.ring.cqe(); cqe != nil; cqe = r.ring.cqe() {
+			done := cqe.userData == uringRecvUserData && cqe.flags&uringCQEFMore == 0
+			r.ring.seen()
+			if done {
+				return
+			}
+		}
+	}
+}
+
+// parseAddr reads the sender's address from the name area of a receive buffer.
+func (r *uringReceiver) parseAddr(name []byte) (rovy.Multiaddr, bool) {
+	switch binary.LittleEndian.Uint16(name[0:2]) {
+	case unix.AF_INET:
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&name[0]))
+		port := binary.BigEndian.Uint16(name[2:4])
+		return rovy.Multiaddr{IP: netip.AddrFrom4(sa.Addr), Port: port}, true
+	case unix.AF_INET6:
+		sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(&name[0]))
+		port := binary.BigEndian.Uint16(name[2:4])
+		ip := netip.AddrFrom16(sa.Addr).Unmap()
+		if sa.Scope_id != 0 {
+			ip = ip.WithZone(r.zone(sa.Scope_id))
+		}
+		return rovy.Multiaddr{IP: ip, Port: port}, true
+	default:
+		return rovy.Multiaddr{}, false
+	}
+}
+
+func (r *uringReceiver) zone(index uint32) string {
+	if zone, present := r.zones[index]; present {
+		return zone
+	}
+	zone := ""
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		zone = iface.Name
+	}
+	r.zones[index] = zone
+	return zone
+}
+
+func (tpt *IOUringTransport) RecvRoutine(r *uringReceiver, laddr rovy.Multiaddr, next *ringbuf.RingBuffer) {
+	defer tpt.routines.Done()
+	defer r.close()
+
+	r.arm()
+	for {
+		if err := r.ring.enter(1, uringRecvTimeout); err != nil {
+			tpt.logger.Warn("RecvRoutine", "err", err)
+		}
+		if !tpt.Running() {
+			r.cancel()
+			return
+		}
+
+		rearm := false
+		returned := false
+		for cqe := r.ring.cqe(); cqe != nil; cqe = r.ring.cqe() {
+			res, flags, userData := cqe.res, cqe.flags, cqe.userData
+			r.ring.seen()
+			if userData != uringRecvUserData {
+				continue
+			}
+
+			if flags&uringCQEFMore == 0 {
+				rearm = true
+			}
+			if res < 0 {
+				// ENOBUFS just means we were too slow handing back buffers
+				if errno := unix.Errno(-res); errno != unix.ENOBUFS {
+					tpt.logger.Warn("RecvRoutine", "err", errno)
+				}
+				continue
+			}
+			if flags&uringCQEFBuffer == 0 {
+				continue
+			}
+
+			bid := uint16(flags >> uringCQEBufferShift)
+			tpt.handleRecv(r, r.bufs.buf(bid)[:res], laddr, next)
+			r.bufs.add(bid)
+			returned = true
+		}
+
+		if returned {
+			r.bufs.publish()
+		}
+		if rearm {
+			r.arm()
+		}
+	}
+}
+
+func (tpt *IOUringTransport) handleRecv(r *uringReceiver, buf []byte, laddr rovy.Multiaddr, next *ringbuf.RingBuffer) {
+	if len(buf) < uringRecvOffset+uringNameLen {
+		return
+	}
+	out := (*uringRecvmsgOut)(unsafe.Pointer(&buf[0]))
+	if out.flags&unix.MSG_TRUNC != 0 {
+		tpt.logger.Warn("RecvRoutine: dropping oversized datagram")
+		return
+	}
+
+	tptsrc, ok := r.parseAddr(buf[uringRecvOffset : uringRecvOffset+uringNameLen])
+	if !ok {
+		return
+	}
+	payload := buf[uringRecvOffset+uringNameLen:]
+	if int(out.payloadlen) < len(payload) {
+		payload = payload[:out.payloadlen]
+	}
+
+	pkt := rovy.AllocPacket()
+	pkt.Length = copy(pkt.Buf, payload)
+	pkt.TptSrc = tptsrc
+	pkt.TptLocal = laddr
+	next.Put(pkt)
+}
+
+// uringSender holds the send ring, and the msghdrs, iovecs, and addresses
+// of the batch in flight. The kernel gets pointers to them, so they're on the heap.
+type uringSender struct {
+	ring    *uring
+	fd      int
+	network string
+	pkts    []rovy.Packet
+	msgs    [UDPBatchSize]unix.Msghdr
+	iovs    [UDPBatchSize]unix.Iovec
+	names   [UDPBatchSize]unix.RawSockaddrInet6
+}
+
+func newUringSender(fd int, network string) (*uringSender, error) {
+	ring, err := newUring(UDPBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	s := &uringSender{
+		ring:    ring,
+		fd:      fd,
+		network: network,
+		pkts:    make([]rovy.Packet, 0, UDPBatchSize),
+	}
+	return s, nil
+}
+
+func (s *uringSender) close() {
+	s.ring.close()
+}
+
+// setAddr writes the destination into the i-th name, in the socket's address family.
+func (s *uringSender) setAddr(i int, dst rovy.Multiaddr) (uint32, bool) {
+	name := (*[unix.SizeofSockaddrInet6]byte)(unsafe.Pointer(&s.names[i]))
+	ip := dst.IP
+	if s.network == "udp4" {
+		if !ip.Unmap().Is4() {
+			return 0, false
+		}
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&s.names[i]))
+		*sa = unix.RawSockaddrInet4{Family: unix.AF_INET, Addr: ip.Unmap().As4()}
+		binary.BigEndian.PutUint16(name[2:4], dst.Port)
+		return unix.SizeofSockaddrInet4, true
+	}
+
+	sa := &s.names[i]
+	*sa = unix.RawSockaddrInet6{Family: unix.AF_INET6, Addr: ip.As16()}
+	if zone := ip.Zone(); zone != "" {
+		if iface, err := net.InterfaceByName(zone); err == nil {
+			sa.Scope_id = uint32(iface.Index)
+		}
+	}
+	binary.BigEndian.PutUint16(name[2:4], dst.Port)
+	return unix.SizeofSockaddrInet6, true
+}
+
+func (tpt *IOUringTransport) SendRoutine(s *uringSender) {
+	defer tpt.routines.Done()
+
+	// senders whose sendmsgs might still be in flight. their rings, msghdrs,
+	// and packets are left alone until the socket is closed.
+	var abandoned []*uringSender
+	defer func() {
+		s.close()
+		for _, s2 := range abandoned {
+			s2.close()
+		}
+	}()
+
+	for {
+		select {
+		case <-tpt.running:
+			return
+		case pkt := <-tpt.sendQ.Channel():
+			s.pkts = append(s.pkts[:0], pkt)
+		}
+
+		// take whatever else is queued already, without waiting for more
+	drain:
+		for len(s.pkts) < UDPBatchSize {
+			select {
+			case pkt := <-tpt.sendQ.Channel():
+				s.pkts = append(s.pkts, pkt)
+			default:
+				break drain
+			}
+		}
+
+		if !tpt.submitBatch(s) {
+			abandoned = append(abandoned, s)
+			s2, err := newUringSender(s.fd, s.network)
+			if err != nil {
+				tpt.logger.Error("SendRoutine: giving up", "err", err)
+				return
+			}
+			s = s2
+			continue
+		}
+		for _, pkt := range s.pkts {
+			pkt.Release()
+		}
+	}
+}
+
+// uringSendRetries is how often submitBatch retries io_uring_enter after
+// an error, before it gives up on the ring.
+const uringSendRetries = 10
+
+// submitBatch submits a sendmsg for each packet, and waits for all of them to complete.
+// It returns false if that's impossible, in which case the sendmsgs might still be
+// in flight, and the sender and its packets can't be reused or released.
+func (tpt *IOUringTransport) submitBatch(s *uringSender) bool {
+	var n uint32
+	for i, pkt := range s.pkts {
+		if pkt.TptDst.Empty() {
+			tpt.logger.Warn("SendRoutine: dropping packet without TptDst")
+			continue
+		}
+		if pkt.Length == 0 {
+			continue
+		}
+		namelen, ok := s.setAddr(i, pkt.TptDst)
+		if !ok {
+			tpt.logger.Warn("SendRoutine: destination doesn't match socket", "dst", pkt.TptDst, "network", tpt.network)
+			continue
+		}
+
+		buf := pkt.Bytes()
+		s.iovs[i] = unix.Iovec{Base: &buf[0]}
+		s.iovs[i].SetLen(len(buf))
+		s.msgs[i] = unix.Msghdr{
+			Name:    (*byte)(unsafe.Pointer(&s.names[i])),
+			Namelen: namelen,
+			Iov:     &s.iovs[i],
+		}
+		s.msgs[i].SetIovlen(1)
+
+		sqe := s.ring.sqe()
+		sqe.opcode = uringOpSendmsg
+		sqe.fd = int32(s.fd)
+		sqe.addr = uint64(uintptr(unsafe.Pointer(&s.msgs[i])))
+		sqe.len = 1
+		sqe.userData = uint64(i)
+		n++
+	}
+	if n == 0 {
+		return true
+	}
+
+	// every completion has to be consumed before the buffers are released,
+	// or they'd be counted by the next batch instead.
+	var retries int
+	for done := uint32(0); done < n; {
+		cqe := s.ring.cqe()
+		if cqe == nil {
+			// submits what the kernel hasn't consumed yet, e.g. after an error
+			if err := s.ring.enter(n-done, 0); err != nil {
+				retries++
+				if retries > uringSendRetries {
+					tpt.logger.Error("SendRoutine: abandoning ring", "err", err, "inflight", n-done)
+					return false
+				}
+				tpt.logger.Warn("SendRoutine", "err", err)
+				time.Sleep(time.Millisecond)
+			}
+			continue
+		}
+		if cqe.res == -int32(unix.EMSGSIZE) {
+			tpt.mtus.learnUDP(s.pkts[cqe.userData].TptDst.AddrPort(), tpt.logger)
+		} else if cqe.res < 0 {
+			tpt.logger.Warn("SendRoutine", "dst", s.pkts[cqe.userData].TptDst, "err", unix.Errno(-cqe.res))
+		}
+		s.ring.seen()
+		done++
+	}
+	return true
+}
@@ -0,0 +1,380 @@
+//go:build linux && (amd64 || arm64)
+
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The subset of linux/io_uring.h that we need.
+const (
+	uringOpSendmsg     = 9
+	uringOpRecvmsg     = 10
+	uringOpAsyncCancel = 14
+
+	uringSQEBufferSelect = 1 << 5
+	uringRecvMultishot   = 1 << 1
+
+	uringCQEFBuffer     = 1 << 0
+	uringCQEFMore       = 1 << 1
+	uringCQEBufferShift = 16
+
+	uringEnterGetEvents = 1 << 0
+	uringEnterExtArg    = 1 << 3
+
+	uringFeatSingleMmap = 1 << 0
+	uringFeatExtArg     = 1 << 8
+
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringRegisterPbufRing = 22
+)
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufGroup    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type uringSQRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQRingOffsets
+	cqOff                                                                  uringCQRingOffsets
+}
+
+type uringGeteventsArg struct {
+	sigmask   uint64
+	sigmaskSz uint32
+	pad       uint32
+	ts        uint64
+}
+
+type uringBufReg struct {
+	ringAddr    uint64
+	ringEntries uint32
+	bgid        uint16
+	pad         uint16
+	resv        [3]uint64
+}
+
+// uringRecvmsgOut prefixes every buffer filled by a multishot recvmsg,
+// followed by the name and control areas, and then the payload.
+type uringRecvmsgOut struct {
+	namelen    uint32
+	controllen uint32
+	payloadlen uint32
+	flags      uint32
+}
+
+// uring is an io_uring instance, used by a single goroutine.
+type uring struct {
+	fd      int
+	ringMem []byte
+	sqeMem  []byte
+
+	sqHead    *uint32
+	sqTail    *uint32
+	sqMask    uint32
+	sqEntries uint32
+	sqArray   []uint32
+	sqes      []uringSQE
+	sqLocal   uint32 // our tail, published in submit
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []uringCQE
+
+	// the kernel gets pointers to these, so they mustn't live on the stack
+	arg uringGeteventsArg
+	ts  unix.Timespec
+}
+
+func newUring(entries uint32) (*uring, error) {
+	var p uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+	r := &uring{fd: int(fd)}
+
+	if p.features&uringFeatSingleMmap == 0 || p.features&uringFeatExtArg == 0 {
+		r.close()
+		return nil, errors.New("io_uring: kernel is too old")
+	}
+
+	size := p.sqOff.array + p.sqEntries*4
+	if cqsize := p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})); cqsize > size {
+		size = cqsize
+	}
+	var err error
+	r.ringMem, err = unix.Mmap(r.fd, uringOffSQRing, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("io_uring: mmap: %s", err)
+	}
+	r.sqeMem, err = unix.Mmap(r.fd, uringOffSQEs, int(p.sqEntries)*int(unsafe.Sizeof(uringSQE{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("io_uring: mmap: %s", err)
+	}
+
+	ptr := func(off uint32) unsafe.Pointer { return unsafe.Pointer(&r.ringMem[off]) }
+	r.sqHead = (*uint32)(ptr(p.sqOff.head))
+	r.sqTail = (*uint32)(ptr(p.sqOff.tail))
+	r.sqMask = *(*uint32)(ptr(p.sqOff.ringMask))
+	r.sqEntries = p.sqEntries
+	r.sqArray = unsafe.Slice((*uint32)(ptr(p.sqOff.array)), p.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&r.sqeMem[0])), p.sqEntries)
+	r.sqLocal = atomic.LoadUint32(r.sqTail)
+
+	r.cqHead = (*uint32)(ptr(p.cqOff.head))
+	r.cqTail = (*uint32)(ptr(p.cqOff.tail))
+	r.cqMask = *(*uint32)(ptr(p.cqOff.ringMask))
+	r.cqes = unsafe.Slice((*uringCQE)(ptr(p.cqOff.cqes)), p.cqEntries)
+
+	return r, nil
+}
+
+func (r *uring) close() {
+	if r.sqeMem != nil {
+		unix.Munmap(r.sqeMem)
+	}
+	if r.ringMem != nil {
+		unix.Munmap(r.ringMem)
+	}
+	unix.Close(r.fd)
+}
+
+// sqe returns a zeroed submission queue entry, or nil if the queue is full.
+func (r *uring) sqe() *uringSQE {
+	if r.sqLocal-atomic.LoadUint32(r.sqHead) >= r.sqEntries {
+		return nil
+	}
+	idx := r.sqLocal & r.sqMask
+	r.sqArray[idx] = idx
+	r.sqLocal++
+
+	sqe := &r.sqes[idx]
+	*sqe = uringSQE{}
+	return sqe
+}
+
+// enter submits the pending entries, and waits until at least
+// waitNr completions are available, or the timeout is reached.
+// Timeouts and interruptions aren't errors. Entries that the kernel
+// didn't consume because of an error are submitted again by the next call.
+func (r *uring) enter(waitNr uint32, timeout time.Duration) error {
+	atomic.StoreUint32(r.sqTail, r.sqLocal)
+	submit := r.sqLocal - atomic.LoadUint32(r.sqHead)
+
+	var flags uintptr
+	if waitNr > 0 {
+		flags |= uringEnterGetEvents
+	}
+	r.arg = uringGeteventsArg{}
+	if timeout > 0 {
+		r.ts = unix.NsecToTimespec(int64(timeout))
+		r.arg.ts = uint64(uintptr(unsafe.Pointer(&r.ts)))
+		flags |= uringEnterExtArg
+	}
+
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(submit), uintptr(waitNr), flags, uintptr(unsafe.Pointer(&r.arg)), unsafe.Sizeof(r.arg))
+	switch errno {
+	case 0, unix.ETIME, unix.EINTR:
+		return nil
+	default:
+		return os.NewSyscallError("io_uring_enter", errno)
+	}
+}
+
+// cqe returns the next completion without consuming it, or nil if there's none.
+func (r *uring) cqe() *uringCQE {
+	head := atomic.LoadUint32(r.cqHead)
+	if head == atomic.LoadUint32(r.cqTail) {
+		return nil
+	}
+	return &r.cqes[head&r.cqMask]
+}
+
+// seen consumes the completion returned by cqe.
+func (r *uring) seen() {
+	atomic.AddUint32(r.cqHead, 1)
+}
+
+// uringBufRing is a ring of buffers registered with an io_uring, which the kernel
+// picks from for requests with uringSQEBufferSelect. Buffers are handed back with add.
+type uringBufRing struct {
+	ringMem []byte
+	bufMem  []byte
+	entries uint16
+	size    int
+	tail    uint16
+	bgid    uint16
+}
+
+func newUringBufRing(r *uring, bgid, entries uint16, size int) (*uringBufRing, error) {
+	br := &uringBufRing{entries: entries, size: size, bgid: bgid}
+
+	var err error
+	br.ringMem, err = unix.Mmap(-1, 0, int(entries)*16, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANONYMOUS|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("io_uring: mmap: %s", err)
+	}
+	br.bufMem, err = unix.Mmap(-1, 0, int(entries)*size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANONYMOUS|unix.MAP_PRIVATE)
+	if err != nil {
+		br.close()
+		return nil, fmt.Errorf("io_uring: mmap: %s", err)
+	}
+
+	reg := uringBufReg{
+		ringAddr:    uint64(uintptr(unsafe.Pointer(&br.ringMem[0]))),
+		ringEntries: uint32(entries),
+		bgid:        bgid,
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(r.fd), uringRegisterPbufRing, uintptr(unsafe.Pointer(&reg)), 1, 0, 0)
+	if errno != 0 {
+		br.close()
+		return nil, os.NewSyscallError("io_uring_register", errno)
+	}
+
+	for bid := uint16(0); bid < entries; bid++ {
+		br.add(bid)
+	}
+	br.publish()
+	return br, nil
+}
+
+// close frees the memory, the ring is unregistered when the io_uring is closed.
+func (br *uringBufRing) close() {
+	if br.bufMem != nil {
+		unix.Munmap(br.bufMem)
+	}
+	if br.ringMem != nil {
+		unix.Munmap(br.ringMem)
+	}
+}
+
+func (br *uringBufRing) buf(bid uint16) []byte {
+	return br.bufMem[int(bid)*br.size : int(bid+1)*br.size]
+}
+
+// add queues a buffer for handing back to the kernel, see publish.
+func (br *uringBufRing) add(bid uint16) {
+	entry := br.ringMem[int(br.tail&(br.entries-1))*16:]
+	*(*uint64)(unsafe.Pointer(&entry[0])) = uint64(uintptr(unsafe.Pointer(&br.buf(bid)[0])))
+	*(*uint32)(unsafe.Pointer(&entry[8])) = uint32(br.size)
+	*(*uint16)(unsafe.Pointer(&entry[12])) = bid
+	br.tail++
+}
+
+// publish makes the added buffers visible to the kernel. The tail lives in
+// the upper half of the 32-bit word which the first entry's bid is in.
+func (br *uringBufRing) publish() {
+	word := (*uint32)(unsafe.Pointer(&br.ringMem[12]))
+	bid0 := *(*uint16)(unsafe.Pointer(&br.ringMem[12]))
+	atomic.StoreUint32(word, uint32(bid0)|uint32(br.tail)<<16)
+}
+
+var uringProbe struct {
+	sync.Once
+	err error
+	msg unix.Msghdr
+}
+
+// IOUringSupported tells whether the kernel can do everything the io_uring
+// transport needs, i.e. registered buffer rings and multishot recvmsg,
+// which means Linux 6.0 or later. It tries it out once, on a socketpair.
+func IOUringSupported() error {
+	uringProbe.Do(func() {
+		uringProbe.err = probeUring()
+	})
+	return uringProbe.err
+}
+
+func probeUring() error {
+	r, err := newUring(4)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	br, err := newUringBufRing(r, 0, 2, 64)
+	if err != nil {
+		return err
+	}
+	defer br.close()
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	sqe := r.sqe()
+	sqe.opcode = uringOpRecvmsg
+	sqe.fd = int32(fds[0])
+	sqe.addr = uint64(uintptr(unsafe.Pointer(&uringProbe.msg)))
+	sqe.len = 1
+	sqe.flags = uringSQEBufferSelect
+	sqe.ioprio = uringRecvMultishot
+	sqe.bufGroup = br.bgid
+	if _, err := unix.Write(fds[1], []byte{0x42}); err != nil {
+		return err
+	}
+	if err := r.enter(1, time.Second); err != nil {
+		return err
+	}
+
+	cqe := r.cqe()
+	if cqe == nil {
+		return errors.New("io_uring: probe timed out")
+	}
+	defer r.seen()
+	if cqe.res < 0 {
+		return fmt.Errorf("io_uring: multishot recvmsg: %s", unix.Errno(-cqe.res))
+	}
+	if cqe.flags&uringCQEFBuffer == 0 || cqe.flags&uringCQEFMore == 0 {
+		return errors.New("io_uring: multishot recvmsg not supported")
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux || !(amd64 || arm64)
+
+package node
+
+import (
+	"errors"
+
+	rovy "go.rovy.net"
+	logging "go.rovy.net/node/util/logging"
+)
+
+// IOUringSupported tells whether the io_uring UDP transport can be used.
+func IOUringSupported() error {
+	return errors.New("io_uring is only supported on linux/amd64 and linux/arm64")
+}
+
+func newIOUringTransport(lisaddr rovy.Multiaddr, logger *logging.Logger) (Transport, error) {
+	return nil, IOUringSupported()
+}
@@ -0,0 +1,269 @@
+// Package logging provides leveled, structured loggers, one per subsystem,
+// which all write to the same *log.Logger.
+//
+// Lines are written as key=value pairs, e.g.:
+//
+//	level=warn subsys=node msg="lowerRecvRoutine: dropping packet with unknown MsgType" msgtype=0x7
+//
+// Repeated messages are rate-limited per subsystem, so that per-packet errors
+// can't flood the log. Once a message is allowed again, the line gets
+// a suppressed=N field with the number of lines that were left out.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+const DefaultLevel = InfoLevel
+
+func (lvl Level) String() string {
+	switch lvl {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("level%d", int32(lvl))
+	}
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	default:
+		return 0, fmt.Errorf("unknown log level: %s", s)
+	}
+}
+
+// Each message is written at most RateBurst times per RateInterval.
+const (
+	RateBurst    = 10
+	RateInterval = time.Second
+)
+
+// limiterMaxMessages bounds the limiter's memory,
+// in case a caller puts variable data in the message.
+const limiterMaxMessages = 1024
+
+// Logger is the logger of one subsystem.
+type Logger struct {
+	subsys  string
+	out     *log.Logger
+	level   atomic.Int32
+	limiter limiter
+}
+
+// New returns a standalone Logger. Use Loggers instead
+// for a set of subsystems whose levels can be listed and changed.
+func New(subsys string, out *log.Logger) *Logger {
+	l := &Logger{subsys: subsys, out: out}
+	l.level.Store(int32(DefaultLevel))
+	return l
+}
+
+func (l *Logger) Subsystem() string {
+	return l.subsys
+}
+
+func (l *Logger) Level() Level {
+	return Level(l.level.Load())
+}
+
+func (l *Logger) SetLevel(lvl Level) {
+	l.level.Store(int32(lvl))
+}
+
+// Enabled reports whether lines of the given level are written,
+// so that callers can skip preparing expensive fields.
+func (l *Logger) Enabled(lvl Level) bool {
+	return lvl >= l.Level()
+}
+
+// Std returns a *log.Logger which writes through this Logger at the given level,
+// for APIs that want one, like http.Server.ErrorLog.
+func (l *Logger) Std(lvl Level) *log.Logger {
+	return log.New(stdWriter{l, lvl}, "", 0)
+}
+
+// Debug, Info, Warn, and Error write msg with fields given as
+// alternating keys and values, e.g. l.Warn("tun read", "err", err).
+func (l *Logger) Debug(msg string, kv ...any) { l.output(DebugLevel, msg, kv) }
+func (l *Logger) Info(msg string, kv ...any)  { l.output(InfoLevel, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...any)  { l.output(WarnLevel, msg, kv) }
+func (l *Logger) Error(msg string, kv ...any) { l.output(ErrorLevel, msg, kv) }
+
+func (l *Logger) output(lvl Level, msg string, kv []any) {
+	if !l.Enabled(lvl) {
+		return
+	}
+	allowed, suppressed := l.limiter.allow(msg, time.Now())
+	if !allowed {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("level=")
+	b.WriteString(lvl.String())
+	b.WriteString(" subsys=")
+	writeValue(&b, l.subsys)
+	b.WriteString(" msg=")
+	writeValue(&b, msg)
+	for i := 0; i < len(kv); i += 2 {
+		b.WriteByte(' ')
+		if i+1 == len(kv) {
+			b.WriteString("!BADKEY=")
+			writeValue(&b, fmt.Sprint(kv[i]))
+			break
+		}
+		b.WriteString(fmt.Sprint(kv[i]))
+		b.WriteByte('=')
+		writeValue(&b, fmt.Sprint(kv[i+1]))
+	}
+	if suppressed > 0 {
+		b.WriteString(" suppressed=")
+		b.WriteString(strconv.Itoa(suppressed))
+	}
+
+	// calldepth 3 points Lshortfile at the caller of Debug, Info, etc.
+	_ = l.out.Output(3, b.String())
+}
+
+func writeValue(b *strings.Builder, v string) {
+	if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+		b.WriteString(strconv.Quote(v))
+		return
+	}
+	b.WriteString(v)
+}
+
+// limiter counts the lines per message in the current interval.
+type limiter struct {
+	sync.Mutex
+	messages map[string]*limiterEntry
+}
+
+type limiterEntry struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+// allow reports whether msg may be written now, and how many lines
+// were suppressed since the last one that was written.
+func (lim *limiter) allow(msg string, now time.Time) (bool, int) {
+	lim.Lock()
+	defer lim.Unlock()
+
+	if lim.messages == nil || len(lim.messages) >= limiterMaxMessages {
+		lim.messages = map[string]*limiterEntry{}
+	}
+	e, present := lim.messages[msg]
+	if !present {
+		e = &limiterEntry{start: now}
+		lim.messages[msg] = e
+	}
+	if now.Sub(e.start) >= RateInterval {
+		e.start = now
+		e.count = 0
+	}
+	if e.count >= RateBurst {
+		e.suppressed += 1
+		return false, 0
+	}
+	e.count += 1
+	suppressed := e.suppressed
+	e.suppressed = 0
+	return true, suppressed
+}
+
+type stdWriter struct {
+	l   *Logger
+	lvl Level
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.l.output(w.lvl, strings.TrimSuffix(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+// Loggers is a set of subsystem loggers which write to the same output.
+type Loggers struct {
+	sync.Mutex
+	out        *log.Logger
+	subsystems map[string]*Logger
+}
+
+// NewLoggers returns a set with a logger for each of the given subsystems.
+func NewLoggers(out *log.Logger, subsystems ...string) *Loggers {
+	ls := &Loggers{out: out, subsystems: map[string]*Logger{}}
+	for _, subsys := range subsystems {
+		ls.subsystems[subsys] = New(subsys, out)
+	}
+	return ls
+}
+
+// Get returns the subsystem's logger, and adds it if it's new.
+func (ls *Loggers) Get(subsys string) *Logger {
+	ls.Lock()
+	defer ls.Unlock()
+
+	l, present := ls.subsystems[subsys]
+	if !present {
+		l = New(subsys, ls.out)
+		ls.subsystems[subsys] = l
+	}
+	return l
+}
+
+// SetLevel changes the level of a subsystem which already exists.
+func (ls *Loggers) SetLevel(subsys string, lvl Level) error {
+	ls.Lock()
+	defer ls.Unlock()
+
+	l, present := ls.subsystems[subsys]
+	if !present {
+		return fmt.Errorf("unknown log subsystem: %s", subsys)
+	}
+	l.SetLevel(lvl)
+	return nil
+}
+
+// All returns the loggers of all subsystems, sorted by subsystem name.
+func (ls *Loggers) All() []*Logger {
+	ls.Lock()
+	defer ls.Unlock()
+
+	out := make([]*Logger, 0, len(ls.subsystems))
+	for _, l := range ls.subsystems {
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].subsys < out[j].subsys })
+	return out
+}
@@ -1,12 +1,23 @@
 package ringbuf
 
 import (
+	"sync/atomic"
+
 	rovy "go.rovy.net"
 )
 
 type RingBuffer struct {
-	ch      chan rovy.Packet
-	dropped uint64
+	ch        chan rovy.Packet
+	dropped   atomic.Uint64
+	highWater HighWater
+}
+
+// Stats is a snapshot of a RingBuffer's counters.
+type Stats struct {
+	Length    int
+	Capacity  int
+	HighWater int    // the largest Length seen so far
+	Dropped   uint64 // packets dropped by Put to make space
 }
 
 func NewRingBuffer(capacity int) *RingBuffer {
@@ -14,19 +25,41 @@ func NewRingBuffer(capacity int) *RingBuffer {
 	return rb
 }
 
+// Put enqueues the packet, dropping the oldest one if the buffer is full.
 func (rb *RingBuffer) Put(pkt rovy.Packet) {
-	select {
-	case rb.ch <- pkt:
-		// ok
-	default:
-		<-rb.ch // drop oldest packet to make space
-		rb.dropped += 1
-		rb.ch <- pkt
+	for {
+		select {
+		case rb.ch <- pkt:
+			rb.highWater.Mark(len(rb.ch))
+			return
+		default:
+		}
+
+		// drop oldest packet to make space, unless someone else got to it first
+		select {
+		case old := <-rb.ch:
+			old.Release()
+			rb.dropped.Add(1)
+		default:
+		}
 	}
 }
 
 func (rb *RingBuffer) PutWithBackpressure(pkt rovy.Packet) {
 	rb.ch <- pkt
+	rb.highWater.Mark(len(rb.ch))
+}
+
+// PutWithBackpressureUntil blocks like PutWithBackpressure, but gives up
+// and returns false once the done channel is closed.
+func (rb *RingBuffer) PutWithBackpressureUntil(pkt rovy.Packet, done <-chan int) bool {
+	select {
+	case rb.ch <- pkt:
+		rb.highWater.Mark(len(rb.ch))
+		return true
+	case <-done:
+		return false
+	}
 }
 
 func (rb *RingBuffer) Get() rovy.Packet {
@@ -42,9 +75,38 @@ func (rb *RingBuffer) Length() int {
 }
 
 func (rb *RingBuffer) Dropped() uint64 {
-	return rb.dropped
+	return rb.dropped.Load()
+}
+
+func (rb *RingBuffer) Stats() Stats {
+	return Stats{
+		Length:    len(rb.ch),
+		Capacity:  cap(rb.ch),
+		HighWater: rb.highWater.Load(),
+		Dropped:   rb.dropped.Load(),
+	}
 }
 
 func (rb *RingBuffer) Channel() chan rovy.Packet {
 	return rb.ch
 }
+
+// HighWater tracks the largest length of a queue.
+// It's for queues which aren't a RingBuffer, e.g. plain channels.
+type HighWater struct {
+	v atomic.Int64
+}
+
+// Mark records the queue's current length.
+func (hw *HighWater) Mark(n int) {
+	for {
+		old := hw.v.Load()
+		if int64(n) <= old || hw.v.CompareAndSwap(old, int64(n)) {
+			return
+		}
+	}
+}
+
+func (hw *HighWater) Load() int {
+	return int(hw.v.Load())
+}
@@ -0,0 +1,136 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	websocket "golang.org/x/net/websocket"
+
+	rovy "go.rovy.net"
+	logging "go.rovy.net/node/util/logging"
+)
+
+// NewWebSocketHandler creates a WebSocket transport which doesn't listen by itself.
+// Instead it's an http.Handler to be mounted into an existing HTTP server,
+// e.g. behind a reverse proxy. The address is where that server can be reached.
+func NewWebSocketHandler(addr rovy.Multiaddr, logger *logging.Logger) (*StreamTransport, error) {
+	tpt, err := NewStreamTransport(addr, logger)
+	if err != nil {
+		return nil, err
+	}
+	if !tpt.ws {
+		return nil, fmt.Errorf("not a websocket address: %s", addr)
+	}
+
+	tpt.external = true
+	return tpt, nil
+}
+
+func (tpt *StreamTransport) ServeRoutine(srv *http.Server, lis net.Listener) {
+	defer tpt.routines.Done()
+
+	if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		tpt.logger.Warn("ServeRoutine", "err", err)
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection,
+// and carries packets over it until either side closes it.
+func (tpt *StreamTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !tpt.ws || !tpt.Running() {
+		http.Error(w, ErrNotRunning.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// any origin is fine, peers are authenticated by the session handshake
+	srv := websocket.Server{Handler: tpt.handleWebSocket}
+	srv.ServeHTTP(w, r)
+}
+
+func (tpt *StreamTransport) handleWebSocket(ws *websocket.Conn) {
+	ap, err := netip.ParseAddrPort(ws.Request().RemoteAddr)
+	if err != nil {
+		tpt.logger.Warn("handleWebSocket", "err", err)
+		return
+	}
+
+	tpt.Lock()
+	laddr := tpt.localAddr
+	tpt.Unlock()
+
+	raddr := rovy.FromAddrPort(ap)
+	raddr.Tpt = laddr.Tpt
+
+	sc, ok := tpt.addConn(raddr, newWebSocketConn(ws))
+	if !ok {
+		return
+	}
+
+	// the HTTP server closes the connection once we return
+	tpt.ConnRoutine(sc, laddr)
+}
+
+// dialWebSocket performs the WebSocket handshake on an established connection.
+func dialWebSocket(conn net.Conn, raddr rovy.Multiaddr, deadline time.Time) (packetConn, error) {
+	scheme := "ws"
+	if raddr.Tpt == rovy.WSSMultiaddrCodec {
+		scheme = "wss"
+	}
+	url := scheme + "://" + raddr.AddrPort().String() + "/"
+
+	cfg, err := websocket.NewConfig(url, url)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: %s", err)
+	}
+
+	conn.SetDeadline(deadline)
+	ws, err := websocket.NewClient(cfg, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: %s", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return newWebSocketConn(ws), nil
+}
+
+// webSocketConn sends each packet as one binary message.
+type webSocketConn struct {
+	ws *websocket.Conn
+}
+
+func newWebSocketConn(ws *websocket.Conn) *webSocketConn {
+	ws.PayloadType = websocket.BinaryFrame
+	ws.MaxPayloadBytes = rovy.TptMTU
+	return &webSocketConn{ws: ws}
+}
+
+func (wc *webSocketConn) ReadPacket(buf []byte) (int, error) {
+	var data []byte
+	if err := websocket.Message.Receive(wc.ws, &data); err != nil {
+		if errors.Is(err, websocket.ErrFrameTooLarge) {
+			return 0, ErrFrameTooLarge
+		}
+		return 0, err
+	}
+	if len(data) > len(buf) {
+		return 0, ErrFrameTooLarge
+	}
+	return copy(buf, data), nil
+}
+
+func (wc *webSocketConn) WritePacket(p []byte) error {
+	if len(p) > rovy.TptMTU {
+		return ErrFrameTooLarge
+	}
+	return websocket.Message.Send(wc.ws, p)
+}
+
+func (wc *webSocketConn) Close() error {
+	return wc.ws.Close()
+}
@@ -3,6 +3,7 @@ package rovy
 import (
 	"encoding/binary"
 	"log"
+	"sync"
 
 	varint "github.com/multiformats/go-varint"
 )
@@ -26,6 +27,7 @@ type Packet struct {
 	Length   int
 	TptSrc   Multiaddr
 	TptDst   Multiaddr
+	TptLocal Multiaddr // the local transport address the packet was received on or is sent from
 	LowerSrc PeerID
 	LowerDst PeerID
 	UpperSrc PeerID
@@ -39,6 +41,32 @@ func NewPacket(buf []byte) Packet {
 	}
 }
 
+var packetPool = sync.Pool{
+	New: func() any { return new([TptMTU]byte) },
+}
+
+// AllocPacket returns a zeroed TptMTU-sized packet from the packet pool.
+//
+// A packet is owned by whoever holds it, and ownership moves along with it:
+// putting it on a queue or passing it to Transport.Send hands it over, even if
+// that fails. The last owner calls Release, e.g. the transport once the packet
+// is written, or the node once a handler has returned. After that the buffer
+// must not be touched anymore, so handlers copy what they want to keep.
+// Not releasing a packet is fine, its buffer is just left to the garbage collector.
+func AllocPacket() Packet {
+	buf := packetPool.Get().(*[TptMTU]byte)
+	*buf = [TptMTU]byte{}
+	return NewPacket(buf[:])
+}
+
+// Release returns the packet's buffer to the packet pool.
+func (pkt Packet) Release() {
+	if cap(pkt.Buf) < TptMTU {
+		return
+	}
+	packetPool.Put((*[TptMTU]byte)(pkt.Buf[:TptMTU]))
+}
+
 func (pkt Packet) Bytes() []byte {
 	return pkt.Buf[:pkt.Length]
 }
@@ -97,11 +125,10 @@ func (pkt UpperPacket) Codec() (uint64, error) {
 
 func (pkt UpperPacket) SetCodec(codec uint64) {
 	o := pkt.Offset + 0
-	buf := varint.ToUvarint(codec)
-	if len(buf) > 4 {
-		log.Panicf("varint too large for 4 bytes: %#v", buf)
+	if varint.UvarintSize(codec) > 4 {
+		log.Panicf("varint too large for 4 bytes: %#v", varint.ToUvarint(codec))
 	}
-	copy(pkt.Buf[o+0:o+4], buf)
+	varint.PutUvarint(pkt.Buf[o+0:o+4], codec)
 }
 
 func (pkt UpperPacket) Payload() []byte {
@@ -133,11 +160,10 @@ func (pkt LowerPacket) Codec() (uint64, error) {
 
 func (pkt LowerPacket) SetCodec(codec uint64) {
 	o := pkt.Offset + 0
-	buf := varint.ToUvarint(codec)
-	if len(buf) > 4 {
-		log.Panicf("varint too large for 4 bytes: %#v", buf)
+	if varint.UvarintSize(codec) > 4 {
+		log.Panicf("varint too large for 4 bytes: %#v", varint.ToUvarint(codec))
 	}
-	copy(pkt.Buf[o+0:o+4], buf)
+	varint.PutUvarint(pkt.Buf[o+0:o+4], codec)
 }
 
 func (pkt LowerPacket) Payload() []byte {
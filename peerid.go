@@ -161,6 +161,28 @@ func (pid *PeerID) UnmarshalBinary(b []byte) error {
 	return nil
 }
 
+// ParsePeerID parses the string form of a PeerID, i.e. a base32 CID.
+func ParsePeerID(s string) (PeerID, error) {
+	c, err := cid.Parse(s)
+	if err != nil {
+		return PeerID{}, fmt.Errorf("cid: %s", err)
+	}
+	return PeerIDFromCid(c)
+}
+
+func (pid PeerID) MarshalText() ([]byte, error) {
+	return []byte(pid.String()), nil
+}
+
+func (pid *PeerID) UnmarshalText(b []byte) error {
+	pid2, err := ParsePeerID(string(b))
+	if err != nil {
+		return err
+	}
+	*pid = pid2
+	return nil
+}
+
 func (pid PeerID) MarshalJSON() ([]byte, error) {
 	return json.Marshal(pid.String())
 }
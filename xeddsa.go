@@ -0,0 +1,105 @@
+package rovy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+
+	edwards25519 "filippo.io/edwards25519"
+	field "filippo.io/edwards25519/field"
+)
+
+// XEdDSA signatures, made with the X25519 static keys we already have,
+// and checked like Ed25519 signatures, see https://signal.org/docs/specifications/xeddsa/
+//
+// Signing is constant-time, the curve arithmetic is done by filippo.io/edwards25519.
+
+const SignatureSize = ed25519.SignatureSize
+
+var ErrBadSignature = errors.New("bad signature")
+
+// xeddsaPrefix is hash_1 from the spec, 0xfe followed by 31 times 0xff.
+var xeddsaPrefix = func() []byte {
+	p := make([]byte, 32)
+	p[0] = 0xfe
+	for i := 1; i < len(p); i++ {
+		p[i] = 0xff
+	}
+	return p
+}()
+
+func hashScalar(parts ...[]byte) *edwards25519.Scalar {
+	h := sha512.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	s, err := edwards25519.NewScalar().SetUniformBytes(h.Sum(nil))
+	if err != nil {
+		panic(err) // the hash is always 64 bytes
+	}
+	return s
+}
+
+// Sign returns an XEdDSA signature of msg, which PublicKey.Verify checks.
+func (privkey PrivateKey) Sign(msg []byte) ([]byte, error) {
+	var z [64]byte
+	if _, err := rand.Read(z[:]); err != nil {
+		return nil, err
+	}
+	return privkey.sign(msg, z[:])
+}
+
+// sign is Sign with the given 64 random bytes.
+func (privkey PrivateKey) sign(msg []byte, z []byte) ([]byte, error) {
+	a, err := edwards25519.NewScalar().SetBytesWithClamping(privkey.bytes[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the public key's Edwards form always has sign 0, so we might have to negate the scalar
+	pub := new(edwards25519.Point).ScalarBaseMult(a).Bytes()
+	abytes := a.Bytes()
+	subtle.ConstantTimeCopy(int(pub[31]>>7), abytes, edwards25519.NewScalar().Negate(a).Bytes())
+	pub[31] &= 0x7f
+	if _, err := a.SetCanonicalBytes(abytes); err != nil {
+		return nil, err
+	}
+
+	r := hashScalar(xeddsaPrefix, abytes, msg, z)
+	rbytes := new(edwards25519.Point).ScalarBaseMult(r).Bytes()
+
+	h := hashScalar(rbytes, pub, msg)
+	s := edwards25519.NewScalar().MultiplyAdd(h, a, r)
+
+	sig := make([]byte, 0, SignatureSize)
+	sig = append(sig, rbytes...)
+	sig = append(sig, s.Bytes()...)
+	return sig, nil
+}
+
+// Verify checks an XEdDSA signature made with the corresponding PrivateKey.
+func (pubkey PublicKey) Verify(msg []byte, sig []byte) error {
+	if len(sig) != SignatureSize {
+		return ErrBadSignature
+	}
+
+	// Montgomery u to Edwards y = (u - 1) / (u + 1), with sign 0
+	u, err := new(field.Element).SetBytes(pubkey.bytes[:])
+	if err != nil || subtle.ConstantTimeCompare(u.Bytes(), pubkey.bytes[:]) != 1 {
+		return ErrBadSignature // not canonical
+	}
+	one := new(field.Element).One()
+	den := new(field.Element).Add(u, one)
+	if den.Equal(new(field.Element).Zero()) == 1 {
+		return ErrBadSignature
+	}
+	y := new(field.Element).Subtract(u, one)
+	y.Multiply(y, den.Invert(den))
+
+	if !ed25519.Verify(y.Bytes(), msg, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}